@@ -0,0 +1,324 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type benchOpts struct {
+	Server       string        `long:"server" env:"MLRUN_DBPATH" default:"http://localhost:8080" description:"Controller URL"`
+	Token        string        `long:"token" env:"MLRUN_TOKEN" description:"Bearer token"`
+	Project      string        `long:"project" default:"bench" description:"Project the synthetic objects are stored in"`
+	Concurrency  int           `long:"concurrency" short:"c" default:"8" description:"Concurrent clients"`
+	Duration     time.Duration `long:"duration" short:"d" default:"30s" description:"Length of the run"`
+	Requests     int           `long:"requests" short:"n" description:"Stop after this many requests (default: run for the duration)"`
+	RunSize      int           `long:"run-size" default:"2048" description:"Approximate size of run bodies in bytes"`
+	ArtifactSize int           `long:"artifact-size" default:"1024" description:"Approximate size of artifact bodies in bytes"`
+	LogSize      int           `long:"log-size" default:"4096" description:"Size of the log chunks in bytes"`
+	Endpoints    []string      `long:"endpoint" description:"Endpoints to exercise, repeated (default: all)"`
+	Timeout      time.Duration `long:"timeout" default:"30s" description:"Request timeout"`
+}
+
+// An operation is one request of an endpoint, the clients cycle through them
+type operation struct {
+	name string
+	run  func(b *bench, client int, seq int) (*http.Request, error)
+}
+
+var operations = []operation{
+	{"storeRun", func(b *bench, client, seq int) (*http.Request, error) {
+		uid := b.runUID(client, seq)
+		return b.request("POST", "/run/"+b.opts.Project+"/"+uid, b.runBody(uid))
+	}},
+	{"readRun", func(b *bench, client, seq int) (*http.Request, error) {
+		return b.request("GET", "/run/"+b.opts.Project+"/"+b.runUID(client, seq), nil)
+	}},
+	{"listRuns", func(b *bench, client, seq int) (*http.Request, error) {
+		return b.request("GET", "/runs?project="+b.opts.Project+"&name=bench&last=50", nil)
+	}},
+	{"storeArtifact", func(b *bench, client, seq int) (*http.Request, error) {
+		query := url.Values{"key": {b.artifactKey(client)}, "tag": {"latest"}}
+		return b.request("POST", "/artifact/"+b.opts.Project+"/"+b.runUID(client, seq)+"?"+query.Encode(), b.artifactBody(client))
+	}},
+	{"getArtifact", func(b *bench, client, seq int) (*http.Request, error) {
+		query := url.Values{"key": {b.artifactKey(client)}, "tag": {"latest"}}
+		return b.request("GET", "/artifact/"+b.opts.Project+"?"+query.Encode(), nil)
+	}},
+	{"listArtifacts", func(b *bench, client, seq int) (*http.Request, error) {
+		return b.request("GET", "/artifacts?project="+b.opts.Project+"&tag=latest", nil)
+	}},
+	{"storeLog", func(b *bench, client, seq int) (*http.Request, error) {
+		return b.request("POST", "/log/"+b.opts.Project+"/"+b.runUID(client, 0), b.logChunk)
+	}},
+	{"getLog", func(b *bench, client, seq int) (*http.Request, error) {
+		return b.request("GET", "/log/"+b.opts.Project+"/"+b.runUID(client, 0), nil)
+	}},
+}
+
+type endpointStats struct {
+	latencies []time.Duration
+	errors    int
+	lastError string
+	bytes     int64
+}
+
+type bench struct {
+	opts     *benchOpts
+	client   *http.Client
+	id       string
+	padding  string
+	logChunk []byte
+	lock     sync.Mutex
+	stats    map[string]*endpointStats
+}
+
+func (b *bench) runUID(client, seq int) string {
+	return fmt.Sprintf("%s%02d%06d", b.id, client, seq)
+}
+
+func (b *bench) artifactKey(client int) string {
+	return fmt.Sprintf("bench-%s-%d", b.id, client)
+}
+
+func padding(text string, size int) string {
+	if size <= 0 {
+		return ""
+	}
+	return strings.Repeat(text, size/len(text)+1)[:size]
+}
+
+func (b *bench) runBody(uid string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"uid": uid, "name": "bench", "project": b.opts.Project,
+			"labels": map[string]string{"bench": b.id}},
+		"spec":   map[string]interface{}{"parameters": map[string]string{"padding": padding(b.padding, b.opts.RunSize)}},
+		"status": map[string]interface{}{"state": "completed", "start_time": time.Now().UTC().Format(time.RFC3339Nano)},
+	})
+	return body
+}
+
+func (b *bench) artifactBody(client int) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"key": b.artifactKey(client), "kind": "", "labels": map[string]string{"bench": b.id},
+		"description": padding(b.padding, b.opts.ArtifactSize),
+	})
+	return body
+}
+
+func (b *bench) request(method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(b.opts.Server, "/")+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if b.opts.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+b.opts.Token)
+	}
+	return request, nil
+}
+
+func (b *bench) record(name string, latency time.Duration, size int64, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stats, ok := b.stats[name]
+	if !ok {
+		stats = &endpointStats{}
+		b.stats[name] = stats
+	}
+	if err != nil {
+		stats.errors++
+		stats.lastError = err.Error()
+		return
+	}
+	stats.latencies = append(stats.latencies, latency)
+	stats.bytes += size
+}
+
+// do runs an operation, the latency includes reading the response body
+func (b *bench) do(op operation, client, seq int) {
+	request, err := op.run(b, client, seq)
+	if err != nil {
+		b.record(op.name, 0, 0, err)
+		return
+	}
+	start := time.Now()
+	response, err := b.client.Do(request)
+	if err != nil {
+		b.record(op.name, 0, 0, err)
+		return
+	}
+	size, err := io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	latency := time.Since(start)
+	if err == nil && response.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("%s %s: %s", request.Method, request.URL.Path, response.Status)
+	}
+	b.record(op.name, latency, size, err)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+func (b *bench) report(out io.Writer, elapsed time.Duration, ops []operation) {
+	fmt.Fprintf(out, "%-14s %8s %7s %8s %10s %10s %10s %10s %10s\n", "endpoint", "requests", "errors", "req/s", "p50", "p90", "p99", "max", "KB/s")
+	total, errors := 0, 0
+	for _, op := range ops {
+		stats, ok := b.stats[op.name]
+		if !ok {
+			continue
+		}
+		latencies := stats.latencies
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		count := len(latencies) + stats.errors
+		total, errors = total+count, errors+stats.errors
+		fmt.Fprintf(out, "%-14s %8d %7d %8.1f %10s %10s %10s %10s %10.1f\n", op.name, count, stats.errors,
+			float64(count)/elapsed.Seconds(), roundLatency(percentile(latencies, 0.5)), roundLatency(percentile(latencies, 0.9)),
+			roundLatency(percentile(latencies, 0.99)), roundLatency(percentile(latencies, 1)), float64(stats.bytes)/1024/elapsed.Seconds())
+	}
+	fmt.Fprintf(out, "%d requests, %d errors in %s (%.1f req/s)\n", total, errors, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+	for _, op := range ops {
+		if stats, ok := b.stats[op.name]; ok && stats.lastError != "" {
+			fmt.Fprintf(out, "last %s error: %s\n", op.name, stats.lastError)
+		}
+	}
+}
+
+func roundLatency(latency time.Duration) time.Duration {
+	return latency.Round(10 * time.Microsecond)
+}
+
+// selectOperations returns the operations of the endpoint names, all when empty
+func selectOperations(names []string) ([]operation, error) {
+	if len(names) == 0 {
+		return operations, nil
+	}
+	var selected []operation
+	for _, name := range names {
+		found := false
+		for _, op := range operations {
+			if op.name == name {
+				selected, found = append(selected, op), true
+			}
+		}
+		if !found {
+			var known []string
+			for _, op := range operations {
+				known = append(known, op.name)
+			}
+			return nil, fmt.Errorf("Unknown endpoint %s, expecting one of %s", name, strings.Join(known, ", "))
+		}
+	}
+	return selected, nil
+}
+
+func runBench(opts *benchOpts) error {
+	if opts.Concurrency < 1 {
+		return fmt.Errorf("Concurrency must be at least 1")
+	}
+	ops, err := selectOperations(opts.Endpoints)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 4)
+	rand.Read(id)
+	b := bench{
+		opts: opts,
+		client: &http.Client{Timeout: opts.Timeout, Transport: &http.Transport{
+			MaxIdleConnsPerHost: opts.Concurrency, MaxIdleConns: opts.Concurrency}},
+		id:       hex.EncodeToString(id),
+		padding:  "mlrun benchmark payload ",
+		logChunk: []byte(padding("benchmark log line\n", opts.LogSize)),
+		stats:    map[string]*endpointStats{},
+	}
+	fmt.Printf("Benchmarking %s with %d clients, project %s, label bench=%s\n", opts.Server, opts.Concurrency, opts.Project, b.id)
+
+	deadline := time.Now().Add(opts.Duration)
+	var issued int64
+	var issuedLock sync.Mutex
+	next := func() bool {
+		if opts.Requests > 0 {
+			issuedLock.Lock()
+			defer issuedLock.Unlock()
+			issued++
+			return issued <= int64(opts.Requests)
+		}
+		return time.Now().Before(deadline)
+	}
+	start := time.Now()
+	var wait sync.WaitGroup
+	for client := 0; client < opts.Concurrency; client++ {
+		wait.Add(1)
+		go func(client int) {
+			defer wait.Done()
+			// Every client stores before reading, so reads hit existing objects
+			for seq := 0; next(); seq++ {
+				b.do(ops[seq%len(ops)], client, seq/len(ops))
+			}
+		}(client)
+	}
+	wait.Wait()
+	b.report(os.Stdout, time.Since(start), ops)
+	return nil
+}
+
+func main() {
+	var opts benchOpts
+	if _, err := flags.Parse(&opts); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	if err := runBench(&opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
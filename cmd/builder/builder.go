@@ -22,6 +22,7 @@ package main
 import (
 	"github.com/jessevdk/go-flags"
 	"github.com/mlrun/controller/pkg/builder"
+	"github.com/mlrun/controller/pkg/server"
 )
 
 func main() {
@@ -32,7 +33,14 @@ func main() {
 		panic(err)
 	}
 
-	err = builder.InitBuildCtx(opts)
+	if err = builder.InstallSourceTLS(opts.InsecureGit, opts.GitCAFile); err != nil {
+		panic(err)
+	}
+	if opts.Serve {
+		err = server.StartBuilder(&opts)
+	} else {
+		err = builder.InitBuildCtx(opts)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options of all commands
+type globalOpts struct {
+	Server string `long:"server" env:"MLRUN_DBPATH" default:"http://localhost:8080" description:"Controller URL"`
+	Token  string `long:"token" env:"MLRUN_TOKEN" description:"Bearer token"`
+}
+
+type fsckCommand struct {
+	global  *globalOpts
+	Project string `long:"project" description:"Project to check (default: all projects)"`
+	Repair  bool   `long:"repair" description:"Reindex attributes and fix dangling tags (admin role)"`
+	JSON    bool   `long:"json" description:"Print the report as JSON"`
+}
+
+type fsckIssue struct {
+	Kind       string `json:"kind"`
+	Project    string `json:"project"`
+	Name       string `json:"name"`
+	Problem    string `json:"problem"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+	Error      string `json:"error"`
+}
+
+type fsckReport struct {
+	Duration string         `json:"duration"`
+	Scanned  map[string]int `json:"scanned"`
+	Issues   []fsckIssue    `json:"issues"`
+}
+
+// Execute checks the controller objects, it fails when issues remain
+func (c *fsckCommand) Execute(args []string) error {
+	query := url.Values{}
+	if c.Project != "" {
+		query.Set("project", c.Project)
+	}
+	if c.Repair {
+		query.Set("repair", "true")
+	}
+	body, err := c.global.post("/admin/fsck?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		fmt.Println(string(body))
+	}
+	report := fsckReport{}
+	if err = json.Unmarshal(body, &report); err != nil {
+		return fmt.Errorf("Bad fsck report: %s", err)
+	}
+	remaining := 0
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			remaining++
+		}
+		if c.JSON {
+			continue
+		}
+		state := "report only"
+		if issue.Repaired {
+			state = "repaired"
+		} else if issue.Error != "" {
+			state = "repair failed: " + issue.Error
+		} else if issue.Repairable {
+			state = "repairable"
+		}
+		fmt.Printf("%s %s/%s: %s, %s (%s)\n", issue.Kind, issue.Project, issue.Name, issue.Problem, issue.Detail, state)
+	}
+	if !c.JSON {
+		fmt.Printf("Scanned %d runs, %d artifacts and %d logs in %s, %d issues, %d remaining\n",
+			report.Scanned["run"], report.Scanned["artifact"], report.Scanned["log"], report.Duration, len(report.Issues), remaining)
+	}
+	if remaining > 0 {
+		os.Exit(2)
+	}
+	return nil
+}
+
+func (g *globalOpts) post(path string) ([]byte, error) {
+	request, err := http.NewRequest("POST", strings.TrimSuffix(g.Server, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	client := http.Client{Timeout: 30 * time.Minute}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func main() {
+	var opts globalOpts
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.AddCommand("fsck", "Check the stored objects",
+		"Check that run and artifact bodies parse and match their indexed attributes, that tags resolve and logs have runs",
+		&fsckCommand{global: &opts})
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
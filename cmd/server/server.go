@@ -20,8 +20,10 @@ such restriction.
 package main
 
 import (
+	"fmt"
 	"github.com/jessevdk/go-flags"
 	"github.com/mlrun/controller/pkg/server"
+	"os"
 )
 
 func main() {
@@ -29,11 +31,15 @@ func main() {
 	_, err := flags.Parse(&opts)
 
 	if err != nil {
-		panic(err)
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
 	}
 
 	err = server.StartServer(&opts)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -9,11 +9,14 @@ require (
 	github.com/nuclio/logger v0.0.1
 	github.com/nuclio/zap v0.0.2
 	github.com/philhofer/fwd v1.0.0 // indirect
-	github.com/tidwall/gjson v1.3.2 // indirect
+	github.com/tidwall/gjson v1.3.2
 	github.com/tidwall/sjson v1.0.4
-	github.com/tinylib/msgp v1.1.0 // indirect
+	github.com/tinylib/msgp v1.1.0
 	github.com/v3io/v3io-go v0.0.0-20190804122140-7a7baa9fe04ff8591cb4b22270d598b36fc0d49a
 	github.com/v3io/xcp v0.2.5
 	github.com/valyala/fasthttp v1.4.0
+	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
 	gopkg.in/src-d/go-git.v4 v4.13.1
+	k8s.io/apimachinery v0.17.0
+	k8s.io/client-go v0.17.0
 )
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+const openAPIVersion = "3.0.2"
+
+type OpenAPI struct {
+	OpenAPI string                          `json:"openapi"`
+	Info    Info                            `json:"info"`
+	Paths   map[string]map[string]Operation `json:"paths"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Explode     *bool   `json:"explode,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Type    string   `json:"type,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Enum    []string `json:"enum,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Items   *Schema  `json:"items,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string `json:"description"`
+}
+
+func NewOpenAPI(title, version string, routes []Route) *OpenAPI {
+	spec := OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]map[string]Operation{},
+	}
+	for _, route := range routes {
+		path := openAPIPath(route.Path)
+		if _, ok := spec.Paths[path]; !ok {
+			spec.Paths[path] = map[string]Operation{}
+		}
+		spec.Paths[path][strings.ToLower(route.Method)] = newOperation(&route)
+	}
+	return &spec
+}
+
+func newOperation(route *Route) Operation {
+	op := Operation{
+		OperationID: route.Name,
+		Summary:     route.Summary,
+		Responses: map[string]Response{
+			"200": {Description: "Success"},
+			"400": {Description: "Invalid request"},
+		},
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	for _, param := range append(route.PathParams(), route.Params...) {
+		op.Parameters = append(op.Parameters, newParameter(&param))
+	}
+
+	switch route.Body {
+	case ObjectBody:
+		objectSchema := &Schema{Type: "object"}
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+			"application/json":   {Schema: objectSchema},
+			"application/x-yaml": {Schema: objectSchema},
+		}}
+	case RawBody:
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+			"application/octet-stream": {Schema: &Schema{Type: "string", Format: "binary"}},
+		}}
+	}
+	return op
+}
+
+func newParameter(param *Param) Parameter {
+	schema := &Schema{Type: param.Type, Enum: param.Enum}
+	if param.Identifier {
+		schema.Pattern = IdentifierPattern
+	}
+	parameter := Parameter{
+		Name:        param.Name,
+		In:          param.In,
+		Description: param.Description,
+		Required:    param.Required,
+		Schema:      schema,
+	}
+	if param.Multi {
+		explode := true
+		parameter.Schema = &Schema{Type: "array", Items: schema}
+		parameter.Explode = &explode
+	}
+	return parameter
+}
+
+// openAPIPath converts router path syntax (/run/:project) to OpenAPI syntax (/run/{project})
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func SpecHandler(spec *OpenAPI) fasthttp.RequestHandler {
+	body, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal OpenAPI spec: %s", err))
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("application/json")
+		ctx.Response.SetBody(body)
+	}
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>%s</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@3/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@3/swagger-ui-bundle.js"></script>
+  <script>
+    SwaggerUIBundle({url: "%s", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+func SwaggerUIHandler(title, specURL string) fasthttp.RequestHandler {
+	page := []byte(fmt.Sprintf(swaggerUIPage, title, specURL))
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.Response.SetBody(page)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/buaazp/fasthttprouter"
+	"github.com/ghodss/yaml"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	InPath  = "path"
+	InQuery = "query"
+
+	String  = "string"
+	Integer = "integer"
+	Boolean = "boolean"
+)
+
+// Identifiers are the project, uid, key, tag and name values that end up in
+// storage paths, they can not contain / or start with .
+const (
+	IdentifierPattern   = `^[A-Za-z0-9][A-Za-z0-9_.-]*$`
+	maxIdentifierLength = 253
+)
+
+var identifierRegex = regexp.MustCompile(IdentifierPattern)
+
+// ValidateIdentifier checks the value of an identifier parameter
+func ValidateIdentifier(name, value string) error {
+	if len(value) > maxIdentifierLength || !identifierRegex.MatchString(value) {
+		return fmt.Errorf("Invalid %s '%s', expecting up to %d letters, digits, '_', '.' or '-' starting with a letter or digit",
+			name, value, maxIdentifierLength)
+	}
+	return nil
+}
+
+// BodyKind describes what a route expects in the request body
+type BodyKind int
+
+const (
+	NoBody BodyKind = iota
+	RawBody
+	ObjectBody
+)
+
+type Param struct {
+	Name        string
+	In          string
+	Type        string
+	Required    bool
+	Multi       bool
+	Enum        []string
+	Description string
+	// Identifier values are validated with ValidateIdentifier, path params
+	// always are
+	Identifier bool
+}
+
+func QueryParam(name, paramType string, required bool, description string) Param {
+	return Param{Name: name, In: InQuery, Type: paramType, Required: required, Description: description}
+}
+
+func IdentifierParam(name string, required bool, description string) Param {
+	return Param{Name: name, In: InQuery, Type: String, Required: required, Identifier: true, Description: description}
+}
+
+func MultiQueryParam(name, description string) Param {
+	return Param{Name: name, In: InQuery, Type: String, Multi: true, Description: description}
+}
+
+// Route is the single description of an endpoint, used both to register it on
+// the router and to document it in the OpenAPI spec
+type Route struct {
+	Method  string
+	Path    string
+	Name    string
+	Summary string
+	Tag     string
+	Params  []Param
+	Body    BodyKind
+	Handler fasthttp.RequestHandler
+}
+
+func (r *Route) PathParams() []Param {
+	var params []Param
+	for _, segment := range strings.Split(r.Path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, Param{Name: segment[1:], In: InPath, Type: String, Required: true, Identifier: true})
+		}
+	}
+	return params
+}
+
+func (r *Route) Validate(ctx *fasthttp.RequestCtx) error {
+	for _, param := range r.PathParams() {
+		value, _ := ctx.UserValue(param.Name).(string)
+		if err := ValidateIdentifier(param.Name, value); err != nil {
+			return err
+		}
+	}
+	args := ctx.QueryArgs()
+	for _, param := range r.Params {
+		values := args.PeekMulti(param.Name)
+		if len(values) == 0 {
+			if param.Required {
+				return fmt.Errorf("Expecting '%s' parameter", param.Name)
+			}
+			continue
+		}
+		if len(values) > 1 && !param.Multi {
+			return fmt.Errorf("Parameter '%s' may only be specified once", param.Name)
+		}
+		if param.Type == Boolean {
+			value, err := strconv.ParseBool(string(values[0]))
+			if err != nil {
+				return fmt.Errorf("Parameter '%s' must be a boolean, got '%s'", param.Name, values[0])
+			}
+			ctx.SetUserValue(boolParamKey(param.Name), value)
+			continue
+		}
+		for _, value := range values {
+			if err := param.validateValue(string(value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	body := ctx.Request.Body()
+	switch r.Body {
+	case ObjectBody:
+		if len(body) == 0 {
+			return fmt.Errorf("Expecting a request body")
+		}
+		if bytes.HasPrefix(body, []byte("---")) {
+			if _, err := yaml.YAMLToJSON(body); err != nil {
+				return fmt.Errorf("Invalid YAML body: %s", err)
+			}
+		} else if !json.Valid(body) {
+			return fmt.Errorf("Invalid JSON body")
+		}
+	}
+	return nil
+}
+
+func boolParamKey(name string) string {
+	return "api.bool." + name
+}
+
+// QueryBool returns a Boolean query parameter, defaultValue when it is missing.
+// Validate parses it, without validation values that are not Booleans are the
+// default too
+func QueryBool(ctx *fasthttp.RequestCtx, name string, defaultValue bool) bool {
+	if value, ok := ctx.UserValue(boolParamKey(name)).(bool); ok {
+		return value
+	}
+	if value, err := strconv.ParseBool(string(ctx.QueryArgs().Peek(name))); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func (p *Param) validateValue(value string) error {
+	if p.Identifier {
+		if err := ValidateIdentifier(p.Name, value); err != nil {
+			return err
+		}
+	}
+	switch p.Type {
+	case Integer:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Parameter '%s' must be an integer, got '%s'", p.Name, value)
+		}
+	}
+	if len(p.Enum) > 0 {
+		for _, allowed := range p.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("Parameter '%s' must be one of %s, got '%s'", p.Name, strings.Join(p.Enum, ", "), value)
+	}
+	return nil
+}
+
+func (r Route) validatingHandler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if err := r.Validate(ctx); err != nil {
+			WriteError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		r.Handler(ctx)
+	}
+}
+
+func WriteError(ctx *fasthttp.RequestCtx, statusCode int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	ctx.Response.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
+
+func Register(router *fasthttprouter.Router, routes []Route, validate bool) {
+	for _, route := range routes {
+		handler := route.Handler
+		if validate {
+			handler = route.validatingHandler()
+		}
+		router.Handle(route.Method, route.Path, handler)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package api
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+// Log bodies may be empty, Boolean parameters are read as validated
+func TestValidateBoolParams(t *testing.T) {
+	route := Route{Method: "POST", Path: "/log", Body: RawBody,
+		Params: []Param{QueryParam("force", Boolean, false, ""), QueryParam("logs", Boolean, false, "")}}
+	for _, test := range []struct {
+		query       string
+		valid       bool
+		force, logs bool
+	}{
+		{"", true, false, true},
+		{"force=true", true, true, true},
+		{"force=1&logs=False", true, true, false},
+		{"force=T&logs=0", true, true, false},
+		{"force=yes", false, false, true},
+		{"force=true&force=false", false, false, true},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/log?" + test.query)
+		if err := route.Validate(ctx); (err == nil) != test.valid {
+			t.Errorf("%q: validation error %v", test.query, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if force, logs := QueryBool(ctx, "force", false), QueryBool(ctx, "logs", true); force != test.force || logs != test.logs {
+			t.Errorf("%q: force %v and logs %v, expected %v and %v", test.query, force, logs, test.force, test.logs)
+		}
+	}
+}
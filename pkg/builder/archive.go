@@ -0,0 +1,396 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var archiveSuffixes = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// maxExtractSize is the most bytes extracted from an archive, against
+// decompression bombs
+const maxExtractSize = 8 << 30
+
+// archiveSource is a tar, tar.gz or zip archive of the code, a local path or
+// an http(s) URL, extracted into the local path
+type archiveSource struct {
+	cfg      *SourceConfig
+	suffix   string
+	codePath string
+	digest   string
+}
+
+// archiveSuffix returns the archive suffix of a source, ignoring the URL query
+// and fragment, or an empty string for other sources
+func archiveSuffix(source string) string {
+	if u, err := url.Parse(source); err == nil && u.Scheme != "" {
+		source = u.Path
+	}
+	source = strings.ToLower(source)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(source, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+func newArchiveSource(cfg *SourceConfig) (SourceRepo, error) {
+	if strings.Contains(cfg.Source, "://") {
+		u, err := url.Parse(cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("Archive sources must be local paths or http(s) URLs, not %s", u.Scheme)
+		}
+	}
+	return &archiveSource{cfg: cfg, suffix: archiveSuffix(cfg.Source)}, nil
+}
+
+func (s *archiveSource) CodePath() string {
+	return s.codePath
+}
+
+func (s *archiveSource) FileDigest() string {
+	return s.digest
+}
+
+func (s *archiveSource) Download() error {
+	path := s.cfg.Source
+	if strings.Contains(path, "://") {
+		file, err := ioutil.TempFile("", "source-*"+s.suffix)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+		err = downloadFile(s.cfg, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		path = file.Name()
+	}
+	var err error
+	if s.digest, err = fileSHA256(path); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(s.cfg.LocalPath, 0755); err != nil {
+		return err
+	}
+	if s.suffix == ".zip" {
+		err = extractZip(path, s.cfg.LocalPath)
+	} else {
+		err = extractTar(path, s.cfg.LocalPath, s.suffix != ".tar")
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to extract %s: %s", s.cfg.Source, err)
+	}
+	s.codePath = archiveRoot(s.cfg.LocalPath)
+	fmt.Fprintf(s.cfg.out(), "extracted archive %s\n", s.cfg.Source)
+	return nil
+}
+
+// archiveRoot returns the single top level directory archives are often
+// packed with (e.g. repository snapshots), or dir itself
+func archiveRoot(dir string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// within reports if path is dir or under it
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// resolveExisting resolves the links of the existing part of path, the
+// missing part is kept as is
+func resolveExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil || !os.IsNotExist(err) {
+		return resolved, err
+	}
+	if _, err = os.Lstat(path); err == nil {
+		return "", fmt.Errorf("Broken link %s", path)
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	if parent, err = resolveExisting(parent); err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// extractPath returns where an archive entry is extracted under root (with
+// its links resolved). Entries may not escape root, by name or through the
+// links extracted before them, and replace the links of the same name
+func extractPath(root, name string) (string, error) {
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if !within(root, path) {
+		return "", fmt.Errorf("Archive entry %s is outside the archive", name)
+	}
+	if path == root {
+		return path, nil
+	}
+	parent, err := resolveExisting(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	if !within(root, parent) {
+		return "", fmt.Errorf("Archive entry %s is outside the archive", name)
+	}
+	path = filepath.Join(parent, filepath.Base(path))
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err = os.Remove(path); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// linkInside reports if a link at path points inside root, by name and
+// through the existing links it resolves to. Both root and the directory of
+// path have their links resolved
+func linkInside(root, path, link string) bool {
+	if filepath.IsAbs(link) {
+		return false
+	}
+	target := filepath.Join(filepath.Dir(path), link)
+	if !within(root, target) {
+		return false
+	}
+	resolved, err := resolveExisting(target)
+	return err == nil && within(root, resolved)
+}
+
+// extractLink creates a symbolic link entry, links are kept only when they
+// point inside the archive
+func extractLink(root, name, link, path string) error {
+	if !linkInside(root, path, link) {
+		return fmt.Errorf("Archive link %s points outside the archive", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(link, path)
+}
+
+// extractFile writes an archive entry, taking its size from the bytes left
+// to extract
+func extractFile(path string, mode os.FileMode, in io.Reader, left *int64) error {
+	counted := &countingReader{reader: io.LimitReader(in, *left+1)}
+	err := writeFile(path, mode, counted)
+	*left -= counted.count
+	if err == nil && *left < 0 {
+		err = fmt.Errorf("Archive is larger than %d bytes when extracted", int64(maxExtractSize))
+	}
+	return err
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(data []byte) (int, error) {
+	n, err := r.reader.Read(data)
+	r.count += int64(n)
+	return n, err
+}
+
+func writeFile(path string, mode os.FileMode, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, in)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// extractTar extracts a tar archive, the gzip and tar readers fail on
+// truncated or corrupt archives
+func extractTar(archive, dir string, gzipped bool) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var in io.Reader = file
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		in = gzipReader
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	left := int64(maxExtractSize)
+	reader := tar.NewReader(in)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path, err := extractPath(root, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(path, os.FileMode(header.Mode), reader, &left)
+		case tar.TypeSymlink:
+			err = extractLink(root, header.Name, header.Linkname, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts a zip archive, entries are checked against their CRC
+func extractZip(archive, dir string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	left := int64(maxExtractSize)
+	for _, entry := range reader.File {
+		path, err := extractPath(root, entry.Name)
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		in, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		if entry.Mode()&os.ModeSymlink != 0 {
+			var link []byte
+			if link, err = ioutil.ReadAll(io.LimitReader(in, 4096)); err == nil {
+				err = extractLink(root, entry.Name, string(link), path)
+			}
+		} else {
+			err = extractFile(path, entry.Mode(), in, &left)
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteContextArchive packages the build context directory as a tar.gz at
+// path, for builds outside the builder
+func WriteContextArchive(codePath, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gzipWriter := gzip.NewWriter(file)
+	writer := tar.NewWriter(gzipWriter)
+	root := filepath.Clean(codePath)
+	archivePath, _ := filepath.Abs(path)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		// The archive may be written inside the context
+		if abs, _ := filepath.Abs(path); abs == archivePath {
+			return nil
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = writer.WriteHeader(header); err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, in)
+		in.Close()
+		return err
+	})
+	for _, closer := range []io.Closer{writer, gzipWriter, file} {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultExecutor = "/kaniko/executor"
+
+// Config holds the server side defaults for building function images
+type Config struct {
+	// Image build command, called with kaniko style --context/--dockerfile/--destination flags
+	Executor string
+	// Registry prefixed to generated image names
+	Registry string
+	// Template of generated image names, see ImageName
+	ImageTemplate string
+	// Parent directory of the temporary build contexts
+	WorkDir string
+	// Engine is kaniko (the executor command, default), docker (the Docker
+	// Engine API at DockerHost, default: DOCKER_HOST or the local socket) or
+	// buildkit (a buildkitd through buildctl)
+	Engine     string
+	DockerHost string
+	Buildkit   BuildkitOptions
+	// Default platforms of function images, more than one builds multi-arch
+	// images with buildkit (default: the Buildkit platforms with buildkit, the
+	// engine default otherwise)
+	Platforms []string
+	// Trivy command scanning the images of functions with a build.scan spec,
+	// as a client of the trivy server at ScannerServer when set
+	Scanner       string
+	ScannerServer string
+	// Images are signed with the cosign command and key (a key file, KMS URI or
+	// env://VAR) when set
+	Signer     string
+	SigningKey string
+	// Retries of failed source downloads, see SourceConfig
+	SourceRetries    int
+	SourceRetryDelay time.Duration
+	// Directory caching git clones and object store copies, see SourceConfig
+	SourceCacheDir string
+	// Builds of remote callers refuse local sources and send the server
+	// source credentials only to SourceCredentialHosts, see SourceConfig
+	RemoteSources         bool
+	SourceCredentialHosts []string
+}
+
+// BuildOptions are the inputs of a build besides the function and the server
+// configuration
+type BuildOptions struct {
+	WithMLRun bool
+	// Secret values, passed to the kaniko executor or buildctl in their environment
+	Secrets map[string]string
+	// Registry credentials as a docker config.json, e.g. from the function
+	// build secret (nil: the server docker config)
+	DockerConfig []byte
+}
+
+// BuildResult is the outcome of a successful build
+type BuildResult struct {
+	// The pushed image reference, pinned to its digest when the engine reports it
+	Image string
+	// Where the code came from, nil for builds with no source
+	Provenance *Provenance
+	// The image vulnerability scan, nil when not scanned
+	Scan *ScanReport
+	// The image signature, nil when not signed
+	Signature *Signature
+}
+
+// BuildFunction fetches the function source, writes its Dockerfile and builds
+// and pushes the image, the executor output is written to out. Images passing
+// their scan are signed when the configuration has a signing key. Images
+// failing their scan or signing are pushed, the result is returned along with
+// the error
+func BuildFunction(function *common.Function, cfg *Config, opts *BuildOptions, out io.Writer) (*BuildResult, error) {
+	dir, err := ioutil.TempDir(cfg.WorkDir, "build-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	codePath := dir
+	result := BuildResult{}
+	build := function.Spec.Build
+	if build.Scan != nil {
+		if err = validateScan(build.Scan); err != nil {
+			return nil, err
+		}
+	}
+	if build.Source != "" {
+		fmt.Fprintf(out, "Fetching source %s\n", build.Source)
+		sourceCfg := SourceConfig{Source: build.Source, LocalPath: filepath.Join(dir, "src"),
+			Token: opts.Secrets[SourceTokenEnv], SSHKey: opts.Secrets[SSHKeyEnv],
+			Retries: cfg.SourceRetries, RetryDelay: cfg.SourceRetryDelay, CacheDir: cfg.SourceCacheDir, Out: out,
+			Remote: cfg.RemoteSources, CredentialHosts: cfg.SourceCredentialHosts}
+		repo, err := GetSourceRepo(&sourceCfg)
+		if err != nil {
+			return nil, err
+		}
+		if err = repo.Download(); err != nil {
+			return nil, err
+		}
+		codePath = repo.CodePath()
+		if result.Provenance, err = SourceProvenance(repo, &sourceCfg, &build); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Source digest %s\n", result.Provenance.Digest)
+		if err = WriteProvenance(codePath, result.Provenance); err != nil {
+			return nil, err
+		}
+	} else if build.SourceSHA256 != "" || build.SourceCommit != "" {
+		return nil, fmt.Errorf("Function %s pins a source but has none", function.Metadata.Name)
+	}
+	if err = writeFunctionCode(codePath, function); err != nil {
+		return nil, err
+	}
+	args, err := buildArgs(function, nil)
+	if err != nil {
+		return nil, err
+	}
+	imageOpts := ImageOptions{BuildArgs: args, Labels: imageLabels(function, result.Provenance, time.Now())}
+	if imageOpts.Platforms, err = imagePlatforms(function, cfg.Platforms); err != nil {
+		return nil, err
+	}
+	if err = writeDockerfile(codePath, function, opts.WithMLRun, imageOpts.Labels); err != nil {
+		return nil, err
+	}
+	pruned, err := PruneContext(codePath, function)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Pruned %d ignored paths from the build context\n", pruned)
+
+	image := ImageName(function, cfg)
+	var auth *dockerConfig
+	if opts.DockerConfig != nil {
+		if auth, err = ParseDockerConfig(opts.DockerConfig); err != nil {
+			return nil, err
+		}
+	}
+	// The executors and the scanner read the registry credentials from
+	// DOCKER_CONFIG, kept out of the build context
+	var registryEnv []string
+	if auth != nil {
+		configDir := filepath.Join(dir, "docker-config")
+		if err = writeDockerConfig(configDir, auth); err != nil {
+			return nil, err
+		}
+		registryEnv = append(os.Environ(), "DOCKER_CONFIG="+configDir)
+	}
+	fmt.Fprintf(out, "Building image %s\n", image)
+	var digest string
+	if cfg.Engine == EngineDocker {
+		digest, err = DockerBuild(cfg.DockerHost, codePath, image, &imageOpts, true, auth, out)
+	} else {
+		env := registryEnv
+		if len(opts.Secrets) > 0 && env == nil {
+			env = os.Environ()
+		}
+		for key, value := range opts.Secrets {
+			// The executor runs in the server, secrets may not take it over
+			if common.ProcessEnvVar(key) {
+				fmt.Fprintf(out, "Skipping secret %s, it controls the build process\n", key)
+				continue
+			}
+			env = append(env, key+"="+value)
+		}
+		if cfg.Engine == EngineBuildkit {
+			digest, err = BuildkitBuild(cfg.Buildkit.withImageOptions(&imageOpts), codePath, image, true, env, out)
+		} else {
+			digest, err = kanikoBuild(setFrom(cfg.Executor, defaultExecutor), codePath, image, &imageOpts, filepath.Join(dir, "digest"), env, out)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Image = imageReference(image, digest)
+	fmt.Fprintf(out, "Pushed image %s\n", result.Image)
+
+	if build.Scan != nil {
+		fmt.Fprintf(out, "Scanning image %s\n", result.Image)
+		if result.Scan, err = ScanImage(cfg.Scanner, cfg.ScannerServer, result.Image, build.Scan, registryEnv, out); err != nil {
+			return &result, err
+		}
+		if err = checkScan(result.Scan, build.Scan, out); err != nil {
+			return &result, err
+		}
+	}
+	if cfg.SigningKey != "" {
+		fmt.Fprintf(out, "Signing image %s\n", result.Image)
+		if result.Signature, err = SignImage(cfg.Signer, cfg.SigningKey, result.Image, imageOpts.Labels, registryEnv, out); err != nil {
+			return &result, err
+		}
+		fmt.Fprintf(out, "Signed image %s, signature %s\n", result.Image, result.Signature.Reference)
+	}
+	return &result, nil
+}
+
+func kanikoBuild(executor, contextDir, image string, opts *ImageOptions, digestFile string, env []string, out io.Writer) (string, error) {
+	platform, err := singlePlatform("kaniko", opts.Platforms)
+	if err != nil {
+		return "", err
+	}
+	args := []string{
+		"--context", contextDir,
+		"--dockerfile", filepath.Join(contextDir, "Dockerfile"),
+		"--destination", image,
+		"--digest-file", digestFile,
+	}
+	if platform != "" {
+		args = append(args, "--custom-platform", platform)
+	}
+	for _, key := range sortedKeys(opts.BuildArgs) {
+		args = append(args, "--build-arg", key+"="+opts.BuildArgs[key])
+	}
+	for _, key := range sortedKeys(opts.Labels) {
+		args = append(args, "--label", key+"="+opts.Labels[key])
+	}
+	cmd := exec.Command(executor, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Image build failed: %s", err)
+	}
+	digest, _ := ioutil.ReadFile(digestFile)
+	return strings.TrimSpace(string(digest)), nil
+}
+
+// imageReference pins image to its digest, when known
+func imageReference(image, digest string) string {
+	if digest == "" {
+		return image
+	}
+	return image + "@" + digest
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	EngineBuildkit = "buildkit"
+
+	defaultBuildctl = "buildctl"
+)
+
+// BuildkitOptions configure builds with a remote buildkitd through buildctl
+type BuildkitOptions struct {
+	// buildkitd address, e.g. tcp://buildkitd:1234 (default: the buildctl default)
+	Addr string
+	// buildctl command
+	Command string
+	// Target platforms, more than one builds a multi-platform image
+	Platforms []string
+	// Extra dockerfile frontend attributes as key=value, e.g.
+	// build-arg:PIP_INDEX_URL=...
+	FrontendAttrs []string
+}
+
+// withImageOptions returns a copy of the options passing the build arguments,
+// labels and platforms
+func (o BuildkitOptions) withImageOptions(image *ImageOptions) *BuildkitOptions {
+	if len(image.Platforms) > 0 {
+		o.Platforms = image.Platforms
+	}
+	o.FrontendAttrs = append([]string{}, o.FrontendAttrs...)
+	for _, key := range sortedKeys(image.BuildArgs) {
+		o.FrontendAttrs = append(o.FrontendAttrs, "build-arg:"+key+"="+image.BuildArgs[key])
+	}
+	for _, key := range sortedKeys(image.Labels) {
+		o.FrontendAttrs = append(o.FrontendAttrs, "label:"+key+"="+image.Labels[key])
+	}
+	return &o
+}
+
+// buildctlArgs returns the buildctl arguments building the context directory
+// into image. The build cache is exported inline with the image and imported
+// from its previous push
+func buildctlArgs(opts *BuildkitOptions, contextDir, image string, push bool) []string {
+	var args []string
+	if opts.Addr != "" {
+		args = append(args, "--addr", opts.Addr)
+	}
+	args = append(args, "build",
+		"--frontend", "dockerfile.v0",
+		"--local", "context="+contextDir,
+		"--local", "dockerfile="+contextDir,
+		"--opt", "filename=Dockerfile")
+	if len(opts.Platforms) > 0 {
+		args = append(args, "--opt", "platform="+strings.Join(opts.Platforms, ","))
+	}
+	for _, attr := range opts.FrontendAttrs {
+		args = append(args, "--opt", attr)
+	}
+	args = append(args,
+		"--output", fmt.Sprintf("type=image,name=%s,push=%t", image, push),
+		"--export-cache", "type=inline")
+	if push {
+		args = append(args, "--import-cache", "type=registry,ref="+image)
+	}
+	return args
+}
+
+// BuildkitBuild builds the context directory with buildkitd and returns the
+// image digest, the build output and env are those of buildctl
+func BuildkitBuild(opts *BuildkitOptions, contextDir, image string, push bool, env []string, out io.Writer) (string, error) {
+	metadataFile, err := ioutil.TempFile("", "buildctl-metadata-")
+	if err != nil {
+		return "", err
+	}
+	metadataFile.Close()
+	defer os.Remove(metadataFile.Name())
+
+	args := append(buildctlArgs(opts, contextDir, image, push), "--metadata-file", metadataFile.Name())
+	cmd := exec.Command(setFrom(opts.Command, defaultBuildctl), args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Image build failed: %s", err)
+	}
+	metadata := map[string]interface{}{}
+	if data, err := ioutil.ReadFile(metadataFile.Name()); err == nil {
+		json.Unmarshal(data, &metadata)
+	}
+	digest, _ := metadata["containerimage.digest"].(string)
+	return digest, nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/v3io/xcp/common"
+	"github.com/v3io/xcp/operators"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheLocks serializes the builds using the same cache entry
+var cacheLocks sync.Map
+
+// cacheEntry returns the cache directory of a source and ref, and locks it
+// until unlock is called
+func cacheEntry(cacheDir, kind, source, ref string) (dir string, unlock func()) {
+	sum := sha256.Sum256([]byte(source + "#" + ref))
+	dir = filepath.Join(cacheDir, kind, hex.EncodeToString(sum[:]))
+	lock, _ := cacheLocks.LoadOrStore(dir, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return dir, lock.(*sync.Mutex).Unlock
+}
+
+// downloadCached fetches the ref into the cached clone of the repo, cloned on
+// first use, and writes the commit tree (or its sub path) to the local path
+func (g *GitSource) downloadCached(auth transport.AuthMethod) error {
+	dir, unlock := cacheEntry(g.cfg.CacheDir, "git", g.url, g.branch)
+	defer unlock()
+
+	var hash plumbing.Hash
+	err := retryDownload(g.cfg, g.url, func() error {
+		r, err := openCachedRepo(dir, g.url)
+		if err != nil {
+			return permanent(err)
+		}
+		if hash, err = g.fetch(r, auth); err != nil {
+			if permanentErr, ok := gitError(err).(*permanentError); ok {
+				return permanentErr
+			}
+			// A broken cache entry is cloned again
+			os.RemoveAll(dir)
+			return err
+		}
+		return permanent(checkoutSubpath(r, hash, g.subpath, g.codePath))
+	})
+	if err != nil {
+		return err
+	}
+	g.commit = hash.String()
+	fmt.Fprintf(g.cfg.out(), "fetched repo %s into cache, %s\n", g.branch, hash)
+	return nil
+}
+
+// openCachedRepo opens the cached clone in dir, or initializes it
+func openCachedRepo(dir, url string) (*git.Repository, error) {
+	r, err := git.PlainOpen(dir)
+	if err == nil {
+		return r, nil
+	}
+	if err != git.ErrRepositoryNotExists {
+		return nil, err
+	}
+	if r, err = git.PlainInit(dir, true); err != nil {
+		return nil, err
+	}
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	return r, err
+}
+
+// fetch updates the cached clone with the source ref and returns its commit,
+// commit SHAs are looked up in the fetched branches
+func (g *GitSource) fetch(r *git.Repository, auth transport.AuthMethod) (plumbing.Hash, error) {
+	ref := g.referenceName()
+	remoteRef := plumbing.ReferenceName("refs/remotes/origin/" + strings.TrimPrefix(ref.String(), "refs/heads/"))
+	if !ref.IsBranch() {
+		remoteRef = ref
+	}
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", ref, remoteRef))
+	sha := commitSHA.MatchString(g.branch)
+	if sha {
+		refSpec = "+refs/heads/*:refs/remotes/origin/*"
+	}
+	err := r.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{refSpec},
+		Auth:     auth,
+		Progress: g.cfg.out(),
+		Tags:     git.NoTags,
+		Force:    true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, err
+	}
+	if sha {
+		hash := plumbing.NewHash(g.branch)
+		_, err = r.CommitObject(hash)
+		return hash, err
+	}
+	resolved, err := r.Reference(remoteRef, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	// Annotated tags point at a tag object
+	if tag, err := r.TagObject(resolved.Hash()); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return commit.Hash, nil
+	}
+	return resolved.Hash(), nil
+}
+
+// syncedSuffix names the file next to a cached copy holding its sync time
+const syncedSuffix = ".synced"
+
+// downloadCached copies the objects modified since the last sync into the
+// cached copy of the source, then copies it to the local path. Objects
+// deleted from the source stay in the cache
+func (s *xcpSource) downloadCached() error {
+	dir, unlock := cacheEntry(s.cfg.CacheDir, "xcp", s.cfg.Source, "")
+	defer unlock()
+
+	// The cache holds the whole source, the include patterns apply to the copy
+	task := *s.lsTask
+	task.Filter = ""
+	if data, err := ioutil.ReadFile(dir + syncedSuffix); err == nil {
+		if synced, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err == nil && synced.After(task.Since) {
+			task.Since = synced
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	started := time.Now()
+	dst, _ := common.UrlParse(dir, true)
+	err := retryDownload(s.cfg, s.cfg.Source, func() error {
+		return operators.CopyDir(&task, dst, s.cfg.logger, s.workers)
+	})
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(dir+syncedSuffix, []byte(started.Format(time.RFC3339Nano)), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.cfg.out(), "synced %s into cache since %s\n", s.cfg.Source, task.Since.Format(time.RFC3339))
+
+	cached := *s.cfg
+	cached.Source = dir
+	return (&FileSource{fullpath: dir, cfg: &cached}).Download()
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EngineKaniko = "kaniko"
+	EngineDocker = "docker"
+
+	defaultDockerHost = "unix:///var/run/docker.sock"
+)
+
+// dockerClient talks to the Docker Engine API of a local (unix socket) or
+// remote (tcp) daemon, unversioned paths use the daemon API version
+type dockerClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+// dockerMessage is a line of the build and push progress streams
+type dockerMessage struct {
+	Stream string    `json:"stream"`
+	Status string    `json:"status"`
+	ID     string    `json:"id"`
+	Error  string    `json:"error"`
+	Aux    dockerAux `json:"aux"`
+}
+
+// dockerAux carries the built image id or the pushed image digest
+type dockerAux struct {
+	ID     string `json:"ID"`
+	Digest string `json:"Digest"`
+}
+
+func newDockerClient(host string) (*dockerClient, error) {
+	host = setFrom(host, setFrom(os.Getenv("DOCKER_HOST"), defaultDockerHost))
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("Bad docker host %s: %s", host, err)
+	}
+	transport := &http.Transport{}
+	client := dockerClient{http: &http.Client{Transport: transport}}
+	switch hostURL.Scheme {
+	case "unix":
+		socket := hostURL.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		client.baseURL = "http://docker"
+	case "tcp", "http":
+		client.baseURL = "http://" + hostURL.Host
+	default:
+		return nil, fmt.Errorf("Unsupported docker host %s, use unix:// or tcp://", host)
+	}
+	return &client, nil
+}
+
+// stream sends a request and copies the progress stream to out, it fails on
+// the first error message
+func (c *dockerClient) stream(method, path string, query url.Values, body io.Reader, header http.Header, out io.Writer) (*dockerAux, error) {
+	request, err := http.NewRequest(method, c.baseURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		data, _ := ioutil.ReadAll(response.Body)
+		return nil, fmt.Errorf("Docker API error %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	aux := dockerAux{}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		message := dockerMessage{}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return &aux, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case message.Error != "":
+			return nil, fmt.Errorf("%s", message.Error)
+		case message.Stream != "":
+			fmt.Fprint(out, message.Stream)
+			// Daemons older than API 1.30 only report the image id in the log
+			if strings.HasPrefix(message.Stream, "Successfully built ") {
+				aux.ID = strings.TrimSpace(strings.TrimPrefix(message.Stream, "Successfully built "))
+			}
+		case message.Status != "":
+			fmt.Fprintln(out, strings.TrimSpace(message.ID+" "+message.Status))
+		}
+		aux.ID = setFrom(message.Aux.ID, aux.ID)
+		aux.Digest = setFrom(message.Aux.Digest, aux.Digest)
+	}
+}
+
+// splitImage splits an image name into its repository and tag
+func splitImage(image string) (string, string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// DockerBuild builds the context directory with the Docker daemon and the
+// build arguments and labels of opts, tags the result as image and pushes it
+// when push is set, with the credentials of the image registry in auth (nil:
+// the user docker config). It returns the pushed image digest
+func DockerBuild(host, contextDir, image string, opts *ImageOptions, push bool, auth *dockerConfig, out io.Writer) (string, error) {
+	platform, err := singlePlatform(EngineDocker, opts.Platforms)
+	if err != nil {
+		return "", err
+	}
+	client, err := newDockerClient(host)
+	if err != nil {
+		return "", err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(contextDir, writer))
+	}()
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	query := url.Values{"dockerfile": {"Dockerfile"}, "rm": {"1"}}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	if len(opts.BuildArgs) > 0 {
+		encoded, _ := json.Marshal(opts.BuildArgs)
+		query.Set("buildargs", string(encoded))
+	}
+	if len(opts.Labels) > 0 {
+		encoded, _ := json.Marshal(opts.Labels)
+		query.Set("labels", string(encoded))
+	}
+	built, err := client.stream("POST", "/build", query, reader, header, out)
+	reader.Close()
+	if err != nil {
+		return "", fmt.Errorf("Image build failed: %s", err)
+	}
+	if built.ID == "" {
+		return "", fmt.Errorf("Image build failed: the daemon did not return an image id")
+	}
+
+	repository, tag := splitImage(image)
+	fmt.Fprintf(out, "Tagging %s as %s\n", built.ID, image)
+	query = url.Values{"repo": {repository}, "tag": {tag}}
+	if _, err = client.stream("POST", "/images/"+built.ID+"/tag", query, nil, nil, ioutil.Discard); err != nil {
+		return "", fmt.Errorf("Image tag failed: %s", err)
+	}
+	if !push {
+		return "", nil
+	}
+
+	if auth == nil {
+		if auth, err = defaultDockerConfig(); err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintf(out, "Pushing %s\n", image)
+	header = http.Header{"X-Registry-Auth": {auth.registryAuthHeader(image)}}
+	pushed, err := client.stream("POST", "/images/"+repository+"/push", url.Values{"tag": {tag}}, nil, header, out)
+	if err != nil {
+		return "", fmt.Errorf("Image push failed: %s", err)
+	}
+	return pushed.Digest, nil
+}
+
+// writeTar writes the files under dir as a tar stream, the build context
+func writeTar(dir string, out io.Writer) error {
+	archive := tar.NewWriter(out)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
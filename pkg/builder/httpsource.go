@@ -0,0 +1,201 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// SourceTokenEnv holds the bearer token of http(s) sources, e.g. injected
+	// from a project secret
+	SourceTokenEnv = "MLRUN_SOURCE_TOKEN"
+
+	downloadTimeout = 10 * time.Minute
+)
+
+var checksumHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// httpSource is a single file on an http(s) server (e.g. a raw GitHub URL),
+// downloaded into the local path
+type httpSource struct {
+	cfg    *SourceConfig
+	name   string
+	digest string
+}
+
+func newHTTPSource(u *url.URL, cfg *SourceConfig) (SourceRepo, error) {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return nil, fmt.Errorf("Source URL %s does not name a file", cfg.Source)
+	}
+	return &httpSource{cfg: cfg, name: name}, nil
+}
+
+func (s *httpSource) CodePath() string {
+	return s.cfg.LocalPath
+}
+
+func (s *httpSource) FileDigest() string {
+	return s.digest
+}
+
+func (s *httpSource) Download() error {
+	if err := os.MkdirAll(s.cfg.LocalPath, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.cfg.LocalPath, s.name)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = downloadFile(s.cfg, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if s.digest, err = fileSHA256(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.cfg.out(), "downloaded %s\n", s.name)
+	return nil
+}
+
+// downloadFile downloads an http(s) source into file with its basic or token
+// auth, a #sha256=<hex> (or md5, sha1, sha512) fragment is verified against the
+// downloaded file
+func downloadFile(cfg *SourceConfig, file *os.File) error {
+	u, err := url.Parse(cfg.Source)
+	if err != nil {
+		return err
+	}
+	var newHash func() hash.Hash
+	var expected string
+	if u.Fragment != "" {
+		parts := strings.SplitN(u.Fragment, "=", 2)
+		newHash = checksumHashes[strings.ToLower(parts[0])]
+		if len(parts) != 2 || newHash == nil {
+			return fmt.Errorf("Bad source checksum '%s', use <md5|sha1|sha256|sha512>=<hex>", u.Fragment)
+		}
+		expected = strings.ToLower(parts[1])
+	}
+	u.Fragment, u.User = "", nil
+	source := u.String()
+
+	// Retries continue from the bytes already written when the server accepts
+	// ranges, and start over otherwise
+	resumable := false
+	err = retryDownload(cfg, source, func() error {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return permanent(err)
+		}
+		if offset > 0 && !resumable {
+			if offset, err = restartFile(file); err != nil {
+				return permanent(err)
+			}
+		}
+		request, err := http.NewRequest("GET", source, nil)
+		if err != nil {
+			return permanent(err)
+		}
+		if cfg.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+cfg.Token)
+		} else if cfg.User != "" {
+			request.SetBasicAuth(cfg.User, cfg.Password)
+		}
+		if offset > 0 {
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		client := http.Client{
+			Timeout:   downloadTimeout,
+			Transport: &http.Transport{TLSClientConfig: sourceTLSConfig, Proxy: http.ProxyFromEnvironment},
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		switch {
+		case offset > 0 && response.StatusCode == http.StatusPartialContent:
+			fmt.Fprintf(cfg.out(), "Resuming download of %s at %d bytes\n", source, offset)
+		case response.StatusCode == http.StatusOK:
+			if offset > 0 {
+				if _, err = restartFile(file); err != nil {
+					return permanent(err)
+				}
+			}
+			resumable = response.Header.Get("Accept-Ranges") == "bytes"
+		default:
+			err = fmt.Errorf("Failed to download %s: %s", source, response.Status)
+			if response.StatusCode >= 400 && response.StatusCode < 500 &&
+				response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+				return permanent(err)
+			}
+			return err
+		}
+		_, err = io.Copy(file, response.Body)
+		return err
+	})
+	if err != nil || newHash == nil {
+		return err
+	}
+
+	checksum := newHash()
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(checksum, file); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(checksum.Sum(nil)); actual != expected {
+		return fmt.Errorf("Checksum mismatch for %s: expected %s, got %s", source, expected, actual)
+	}
+	return nil
+}
+
+// restartFile empties a partially downloaded file
+func restartFile(file *os.File) (int64, error) {
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekStart)
+}
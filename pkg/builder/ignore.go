@@ -0,0 +1,153 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"github.com/mlrun/controller/pkg/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dockerignoreFile = ".dockerignore"
+
+// Paths never needed in a function image, build.ignore adds to these (e.g.
+// data directories of the repo)
+var defaultIgnore = []string{
+	".git",
+	"**/.ipynb_checkpoints",
+	"**/__pycache__",
+	"**/*.pyc",
+}
+
+// The build files themselves are never pruned, even when ignored
+var keepInContext = map[string]bool{"Dockerfile": true, dockerignoreFile: true}
+
+// ignoreMatch matches a slash separated path of the context against a
+// .dockerignore pattern, **/ matches at any depth
+func ignoreMatch(pattern, path string) bool {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/")
+	if strings.HasPrefix(pattern, "**/") {
+		parts := strings.Split(path, "/")
+		for i := range parts {
+			if matched, _ := filepath.Match(pattern[3:], strings.Join(parts[i:], "/")); matched {
+				return true
+			}
+		}
+		return false
+	}
+	matched, _ := filepath.Match(pattern, path)
+	return matched
+}
+
+// contextPatterns returns the ignore patterns of the build context, the
+// default and function ones followed by those of the source .dockerignore
+func contextPatterns(codePath string, function *common.Function) []string {
+	// The source patterns come last, so their ! patterns win as in docker
+	patterns := append([]string{}, defaultIgnore...)
+	patterns = append(patterns, function.Spec.Build.Ignore...)
+	if data, err := ioutil.ReadFile(filepath.Join(codePath, dockerignoreFile)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+	return patterns
+}
+
+// walkContext calls visit with the slash separated names of the context paths
+// not ignored by the patterns, ignored directories are not walked
+func walkContext(codePath string, patterns []string, visit func(name string, info os.FileInfo) error, ignored func(path string, info os.FileInfo) error) error {
+	// Paths re-included by ! patterns are kept, without evaluating the order
+	var ignore, include []string
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			include = append(include, pattern[1:])
+		} else {
+			ignore = append(ignore, pattern)
+		}
+	}
+	matchesAny := func(patterns []string, path string) bool {
+		for _, pattern := range patterns {
+			if ignoreMatch(pattern, path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return filepath.Walk(codePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(codePath, path)
+		if err != nil || name == "." {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if keepInContext[name] || !matchesAny(ignore, name) || matchesAny(include, name) {
+			return visit(name, info)
+		}
+		if err = ignored(path, info); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// PruneContext writes the .dockerignore of the build context, adding the
+// default and function ignore patterns to those of the source, and removes
+// the ignored paths so they are not sent to the builder. It returns the number
+// of removed paths
+func PruneContext(codePath string, function *common.Function) (int, error) {
+	patterns := contextPatterns(codePath, function)
+	path := filepath.Join(codePath, dockerignoreFile)
+	if err := ioutil.WriteFile(path, []byte(strings.Join(patterns, "\n")+"\n"), 0644); err != nil {
+		return 0, err
+	}
+	removed := 0
+	err := walkContext(codePath, patterns, func(string, os.FileInfo) error {
+		return nil
+	}, func(path string, info os.FileInfo) error {
+		removed++
+		return os.RemoveAll(path)
+	})
+	return removed, err
+}
+
+// ContextManifest returns the files of the build context that are not
+// ignored, without changing it
+func ContextManifest(codePath string, function *common.Function) ([]string, error) {
+	var files []string
+	err := walkContext(codePath, contextPatterns(codePath, function), func(name string, info os.FileInfo) error {
+		if !info.IsDir() {
+			files = append(files, name)
+		}
+		return nil
+	}, func(string, os.FileInfo) error {
+		return nil
+	})
+	return files, err
+}
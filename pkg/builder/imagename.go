@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"regexp"
+	"strings"
+)
+
+// DefaultImageTemplate names the images of functions with no build.image, the
+// registry prefix is dropped when there is no registry
+const DefaultImageTemplate = "{registry}/mlrun/func-{project}-{name}:{tag}"
+
+var (
+	imagePlaceholder  = regexp.MustCompile(`\{[a-z]+\}`)
+	imagePlaceholders = map[string]bool{"{registry}": true, "{project}": true, "{name}": true, "{tag}": true, "{hash}": true}
+
+	// Characters not allowed in image path components and tags
+	invalidPathChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+	invalidTagChars  = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// Image path components are kept short, tags have a 128 characters limit
+const (
+	maxImageComponent = 63
+	maxImageTag       = 128
+)
+
+// ValidateImageTemplate checks the placeholders of an image name template:
+// {registry}, {project}, {name}, {tag} and {hash}, a short hash of the
+// project and function name
+func ValidateImageTemplate(template string) error {
+	for _, placeholder := range imagePlaceholder.FindAllString(template, -1) {
+		if !imagePlaceholders[placeholder] {
+			return fmt.Errorf("Unknown image template placeholder %s, use {registry}, {project}, {name}, {tag} or {hash}", placeholder)
+		}
+	}
+	if !strings.Contains(template, "{name}") && !strings.Contains(template, "{hash}") {
+		return fmt.Errorf("Image template %s must contain {name} or {hash}", template)
+	}
+	return nil
+}
+
+// shortHash returns the first 8 hex digits of the sha256 of the values
+func shortHash(values ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(values, "/")))
+	return hex.EncodeToString(sum[:4])
+}
+
+// sanitize fits value to an image reference part, values that had to be
+// changed get a hash of the original so that distinct values stay distinct
+func sanitize(value string, invalid *regexp.Regexp, maxLength int) string {
+	clean := strings.Trim(invalid.ReplaceAllString(value, "-"), "-._")
+	if clean == value && len(clean) <= maxLength {
+		return value
+	}
+	suffix := "-" + shortHash(value)
+	if len(clean) > maxLength-len(suffix) {
+		clean = clean[:maxLength-len(suffix)]
+	}
+	return strings.Trim(clean, "-._") + suffix
+}
+
+// ImageName returns the image a function is built into, its build.image or
+// the image template (default: DefaultImageTemplate) filled from the function
+// metadata
+func ImageName(function *common.Function, cfg *Config) string {
+	build := function.Spec.Build
+	if build.Image != "" {
+		return build.Image
+	}
+	project := setFrom(function.Metadata.Project, "default")
+	name := function.Metadata.Name
+	registry := strings.TrimSuffix(setFrom(build.Registry, cfg.Registry), "/")
+	values := map[string]string{
+		"{registry}": registry,
+		"{project}":  sanitize(strings.ToLower(project), invalidPathChars, maxImageComponent),
+		"{name}":     sanitize(strings.ToLower(name), invalidPathChars, maxImageComponent),
+		"{tag}":      sanitize(setFrom(function.Metadata.Tag, "latest"), invalidTagChars, maxImageTag),
+		"{hash}":     shortHash(project, name),
+	}
+	image := imagePlaceholder.ReplaceAllStringFunc(setFrom(cfg.ImageTemplate, DefaultImageTemplate), func(placeholder string) string {
+		return values[placeholder]
+	})
+	if registry == "" {
+		image = strings.TrimPrefix(image, "/")
+	}
+	return image
+}
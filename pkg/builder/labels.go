@@ -0,0 +1,68 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"github.com/mlrun/controller/pkg/common"
+	"time"
+)
+
+// Labels images are stamped with, the OCI annotation keys where one fits, so
+// registry images can be traced back to their function
+const (
+	LabelCreated   = "org.opencontainers.image.created"
+	LabelSource    = "org.opencontainers.image.source"
+	LabelRevision  = "org.opencontainers.image.revision"
+	LabelProject   = "mlrun.project"
+	LabelFunction  = "mlrun.function"
+	LabelTag       = "mlrun.tag"
+	LabelSourceRef = "mlrun.source-ref"
+)
+
+// ImageOptions are passed to the build engines along with the Dockerfile, so
+// they also apply to the Dockerfiles of the sources
+type ImageOptions struct {
+	BuildArgs map[string]string
+	Labels    map[string]string
+	// More than one platform builds a multi-platform image (buildkit only),
+	// none the engine default
+	Platforms []string
+}
+
+// imageLabels returns the labels of the image of a function built from the
+// source of provenance (nil for builds with no source)
+func imageLabels(function *common.Function, provenance *Provenance, created time.Time) map[string]string {
+	labels := map[string]string{
+		LabelCreated:  created.UTC().Format(time.RFC3339),
+		LabelProject:  setFrom(function.Metadata.Project, "default"),
+		LabelFunction: function.Metadata.Name,
+		LabelTag:      setFrom(function.Metadata.Tag, "latest"),
+	}
+	if provenance != nil {
+		labels[LabelSource] = provenance.Source
+		if provenance.Ref != "" {
+			labels[LabelSourceRef] = provenance.Ref
+		}
+		if provenance.Commit != "" {
+			labels[LabelRevision] = provenance.Commit
+		}
+	}
+	return labels
+}
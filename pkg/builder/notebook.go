@@ -0,0 +1,183 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Notebook cells with an ignore tag (or an ignore marker line) are left out of
+// the script, and with start/end markers only the code between them is kept
+var (
+	notebookIgnoreTags = map[string]bool{"mlrun-ignore": true, "nuclio-ignore": true, "skip": true}
+	notebookMarkers    = []string{"# mlrun:", "# nuclio:"}
+)
+
+type notebook struct {
+	NBFormat int             `json:"nbformat"`
+	Cells    []notebookCell  `json:"cells"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+type notebookCell struct {
+	CellType string          `json:"cell_type"`
+	Source   json.RawMessage `json:"source"`
+	Metadata struct {
+		Tags []string `json:"tags"`
+	} `json:"metadata"`
+}
+
+// lines returns the cell source, a string or a list of lines
+func (c *notebookCell) lines() []string {
+	var source string
+	var parts []string
+	if err := json.Unmarshal(c.Source, &parts); err == nil {
+		source = strings.Join(parts, "")
+	} else {
+		json.Unmarshal(c.Source, &source)
+	}
+	return strings.Split(source, "\n")
+}
+
+// isNotebook reports if code is a Jupyter notebook document
+func isNotebook(code []byte) bool {
+	nb := notebook{}
+	return json.Unmarshal(code, &nb) == nil && nb.NBFormat > 0 && nb.Cells != nil
+}
+
+// NotebookToScript converts the code cells of a notebook to a python module.
+// Cell magics (%%) drop the cell, line magics and shell (!) lines are
+// commented out
+func NotebookToScript(data []byte) ([]byte, error) {
+	nb := notebook{}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, fmt.Errorf("Bad notebook: %s", err)
+	}
+	var cells []string
+	for _, cell := range nb.Cells {
+		if cell.CellType != "code" || ignoredCell(&cell) {
+			continue
+		}
+		lines := cell.lines()
+		if strings.HasPrefix(strings.TrimSpace(lines[0]), "%%") {
+			continue
+		}
+		switch notebookMarker(lines) {
+		case "ignore":
+			continue
+		case "start-code":
+			// Code before the start marker is dropped
+			cells = nil
+			continue
+		case "end-code":
+			return scriptOf(cells), nil
+		}
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!") {
+				lines[i] = strings.Replace(line, trimmed, "# "+trimmed, 1)
+			}
+		}
+		cells = append(cells, strings.TrimRight(strings.Join(lines, "\n"), "\n"))
+	}
+	return scriptOf(cells), nil
+}
+
+func ignoredCell(cell *notebookCell) bool {
+	for _, tag := range cell.Metadata.Tags {
+		if notebookIgnoreTags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// notebookMarker returns the mlrun (or nuclio) marker of a cell, e.g. ignore
+// for a "# mlrun: ignore" line
+func notebookMarker(lines []string) string {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		for _, marker := range notebookMarkers {
+			if strings.HasPrefix(line, marker) {
+				return strings.TrimSpace(strings.TrimPrefix(line, marker))
+			}
+		}
+	}
+	return ""
+}
+
+func scriptOf(cells []string) []byte {
+	return []byte(strings.Join(cells, "\n\n") + "\n")
+}
+
+// writeFunctionCode writes the inline source code of a function to the build
+// context and converts its notebooks: an inline notebook, the spec command
+// notebook (the command is changed to the script) or, with no code file, the
+// single notebook in the context root
+func writeFunctionCode(codePath string, function *common.Function) error {
+	codeFile := filepath.Join(codePath, CodeFile(function))
+	if code := function.Spec.Build.FunctionSourceCode; len(code) > 0 {
+		if isNotebook(code) {
+			script, err := NotebookToScript(code)
+			if err != nil {
+				return err
+			}
+			code = script
+		}
+		return ioutil.WriteFile(codeFile, code, 0644)
+	}
+	runtime, err := functionRuntime(codePath, function)
+	if err != nil || runtime != RuntimePython {
+		return err
+	}
+	if command := function.Spec.Command; strings.HasSuffix(command, ".ipynb") && !filepath.IsAbs(command) {
+		script := strings.TrimSuffix(command, ".ipynb") + ".py"
+		if err = convertNotebook(filepath.Join(codePath, command), filepath.Join(codePath, script)); err != nil {
+			return err
+		}
+		function.Spec.Command = script
+		return nil
+	}
+	if common.FileExists(codeFile) {
+		return nil
+	}
+	notebooks, _ := filepath.Glob(filepath.Join(codePath, "*.ipynb"))
+	if len(notebooks) != 1 {
+		return nil
+	}
+	return convertNotebook(notebooks[0], codeFile)
+}
+
+func convertNotebook(path, scriptPath string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	script, err := NotebookToScript(data)
+	if err != nil {
+		return fmt.Errorf("Failed to convert %s: %s", filepath.Base(path), err)
+	}
+	return ioutil.WriteFile(scriptPath, script, 0644)
+}
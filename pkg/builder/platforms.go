@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"regexp"
+	"strings"
+)
+
+// platformName matches os/arch[/variant] platforms, e.g. linux/arm64/v8
+var platformName = regexp.MustCompile("^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$")
+
+// splitPlatforms splits comma separated platform lists
+func splitPlatforms(values ...string) []string {
+	var platforms []string
+	for _, value := range values {
+		for _, platform := range strings.Split(value, ",") {
+			if platform = strings.TrimSpace(platform); platform != "" {
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+	return platforms
+}
+
+// imagePlatforms returns the platforms a function image is built for, those of
+// the function build.platforms or the defaults, none for the engine default
+func imagePlatforms(function *common.Function, defaults []string) ([]string, error) {
+	values := function.Spec.Build.Platforms
+	if len(values) == 0 {
+		values = defaults
+	}
+	var platforms []string
+	seen := map[string]bool{}
+	for _, platform := range splitPlatforms(values...) {
+		if !platformName.MatchString(platform) {
+			return nil, fmt.Errorf("Bad platform '%s', expecting os/arch[/variant], e.g. linux/arm64", platform)
+		}
+		if !seen[platform] {
+			seen[platform] = true
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms, nil
+}
+
+// singlePlatform returns the platform of engines building one platform per
+// image, multi-platform images (with a manifest list) need buildkit
+func singlePlatform(engine string, platforms []string) (string, error) {
+	if len(platforms) > 1 {
+		return "", fmt.Errorf("The %s engine builds a single platform, use buildkit for multi-platform images (%s)",
+			engine, strings.Join(platforms, ","))
+	}
+	if len(platforms) == 0 {
+		return "", nil
+	}
+	return platforms[0], nil
+}
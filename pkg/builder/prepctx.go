@@ -24,28 +24,164 @@ import (
 	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/mlrun/controller/pkg/common"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	functionEnvVar   = "MLRUN_FUNCTION_SPEC"
 	defaultBaseImage = "python:3.6"
 	mlrunPackage     = "mlrun"
+
+	// Conda builds create this environment and put it first in the PATH, conda
+	// base images keep their installation in /opt/conda
+	condaBaseImage = "condaforge/mambaforge"
+	condaEnvName   = "mlrun"
+	condaEnvFile   = "conda-environment.yaml"
 )
 
+var condaEnvFiles = []string{"environment.yaml", "environment.yml"}
+
 type Opts struct {
-	Verbose   []bool `short:"v" long:"verbose" description:"Show verbose debug information"`
-	Source    string `short:"s" long:"source" description:"Source repo/path"`
-	LocalPath string `short:"l" long:"local" description:"Local target path" required:"true"`
+	Verbose     []bool        `short:"v" long:"verbose" description:"Show verbose debug information"`
+	Source      string        `short:"s" long:"source" description:"Source repo/path"`
+	LocalPath   string        `short:"l" long:"local" description:"Local target path, required unless --serve"`
+	Include     []string      `short:"i" long:"include" description:"Pattern of the paths copied from a local or object store source (default: all), e.g. src/**"`
+	Exclude     []string      `short:"x" long:"exclude" description:"Pattern of paths not copied from a local or object store source, e.g. data/**"`
+	Workers     int           `long:"workers" default:"8" description:"Parallel copies from an s3 or v3io source"`
+	Since       string        `long:"since" description:"Copy the s3 or v3io source objects modified since this RFC 3339 time only"`
+	CacheDir    string        `long:"cache-dir" env:"MLRUN_SOURCE_CACHE_DIR" description:"Directory caching git clones and s3/v3io copies across builds, updated incrementally"`
+	Retries     int           `long:"retries" env:"MLRUN_SOURCE_RETRIES" default:"3" description:"Retries of failed source downloads, with exponential backoff"`
+	RetryDelay  time.Duration `long:"retry-delay" env:"MLRUN_SOURCE_RETRY_DELAY" default:"1s" description:"Delay before the first download retry, doubled on each retry"`
+	InsecureGit bool          `long:"insecure-git" env:"MLRUN_INSECURE_GIT" description:"Skip the certificate verification of https git and http(s) source servers"`
+	GitCAFile   string        `long:"git-ca-file" env:"MLRUN_GIT_CA_FILE" description:"PEM CA bundle trusted for https git and http(s) source servers, on top of the system roots"`
+	// The function spec is read from the source function.yaml unless given a
+	// URL, MLRUN_FUNCTION_SPEC overrides its fields
+	FunctionURL string `long:"function-url" env:"MLRUN_FUNCTION_URL" description:"Function spec URL (http(s), s3 or v3io) or path, instead of the source function.yaml"`
+
+	// --dry-run only resolves the build, --output exports the prepared context
+	DryRun bool   `long:"dry-run" description:"Print the Dockerfile and the context files without changing the context or building it"`
+	Output string `short:"o" long:"output" description:"Package the prepared build context as this tar.gz file, e.g. context.tar.gz"`
+
+	// With the docker and buildkit engines the context is also built, for use
+	// without Kaniko
+	Engine        string   `long:"engine" choice:"kaniko" choice:"docker" choice:"buildkit" default:"kaniko" description:"kaniko only prepares the build context, docker and buildkit also build it"`
+	Image         string   `long:"image" description:"Image to build with --engine docker or buildkit (default: from the function)"`
+	ImageTemplate string   `long:"image-template" env:"MLRUN_IMAGE_TEMPLATE" description:"Image name of functions with no build.image, from {registry}, {project}, {name}, {tag} and {hash} (default: {registry}/mlrun/func-{project}-{name}:{tag})"`
+	DockerHost    string   `long:"docker-host" env:"DOCKER_HOST" description:"Docker daemon address (default: unix:///var/run/docker.sock)"`
+	BuildkitAddr  string   `long:"buildkit-addr" env:"BUILDKIT_HOST" description:"buildkitd address, e.g. tcp://buildkitd:1234"`
+	Platforms     []string `long:"platform" description:"Target platform, repeat for multi-platform images (--engine buildkit)"`
+	PlatformList  string   `long:"platforms" env:"MLRUN_BUILD_PLATFORMS" description:"Comma separated target platforms, e.g. linux/amd64,linux/arm64 builds a multi-arch image with --engine buildkit"`
+	FrontendAttrs []string `long:"frontend-opt" description:"Dockerfile frontend attribute key=value of --engine buildkit builds, e.g. build-arg:PIP_INDEX_URL=..."`
+	Push          bool     `long:"push" description:"Push the image built with --engine docker or buildkit"`
+	BuildArgs     []string `long:"build-arg" description:"Build argument KEY=VALUE of --engine docker or buildkit builds, on top of the function build.args"`
+	Scanner       string   `long:"scanner" env:"MLRUN_IMAGE_SCANNER" default:"trivy" description:"Trivy command scanning pushed images of functions with a build.scan spec"`
+	ScannerServer string   `long:"scanner-server" env:"MLRUN_IMAGE_SCANNER_SERVER" description:"Trivy server the scanner runs as a client of (default: standalone scans)"`
+	Signer        string   `long:"signer" env:"MLRUN_IMAGE_SIGNER" default:"cosign" description:"Cosign command signing pushed images with --signing-key"`
+	SigningKey    string   `long:"signing-key" env:"MLRUN_IMAGE_SIGNING_KEY" description:"Cosign key (file, KMS URI or env://VAR) signing pushed images, the password is read from COSIGN_PASSWORD"`
+
+	// --serve runs the builder as a service, building the functions posted to
+	// /build with the engine flags above
+	Serve      bool     `long:"serve" description:"Serve POST /build and GET /build/:id instead of preparing a single context"`
+	Addr       []string `short:"a" long:"addr" env:"MLRUN_BUILDER_ADDR" env-delim:"," default:":8080" description:"Address (host:port or unix:///path) to serve on with --serve, may be repeated"`
+	Executor   string   `long:"executor" env:"MLRUN_BUILD_EXECUTOR" default:"/kaniko/executor" description:"Image build command of --engine kaniko builds with --serve"`
+	Registry   string   `long:"registry" env:"DEFAULT_DOCKER_REGISTRY" description:"Registry for function images that do not name one"`
+	WorkDir    string   `long:"workdir" env:"MLRUN_BUILD_WORKDIR" description:"Directory for temporary build contexts (default: system temp dir)"`
+	ConfigFile string   `long:"config" env:"MLRUN_CONFIG" description:"YAML/JSON file with log level, rate limit and auth tokens, reloaded on change or SIGHUP"`
+	MaxBuilds  int      `long:"max-builds" env:"MLRUN_MAX_BUILDS" default:"4" description:"Concurrent builds with --serve, pending builds are queued"`
+	StateDir   string   `long:"state-dir" env:"MLRUN_BUILDER_STATE_DIR" description:"Directory persisting the queued builds of --serve across restarts"`
+	// --serve accepts remote sources only, the server credentials are sent to
+	// the listed hosts
+	CredentialHosts []string `long:"source-credential-host" env:"MLRUN_SOURCE_CREDENTIAL_HOSTS" env-delim:"," description:"Source host the server source token and ssh key are sent to with --serve, may be repeated (default: none)"`
+
+	// The build output is also stored in the controller log store, --serve
+	// builds under build-<id>
+	DBPath     string `long:"dbpath" env:"MLRUN_DBPATH" description:"Controller URL to store the build log in, read with GET /log/<project>/<uid>"`
+	DBToken    string `long:"db-token" env:"MLRUN_DB_TOKEN" description:"Bearer token of the controller API"`
+	LogProject string `long:"log-project" default:"default" description:"Project of the stored build log"`
+	LogUID     string `long:"log-uid" description:"UID of the stored build log, the log is stored when set with --dbpath"`
+}
+
+// ServiceOptions returns the queueing options of --serve
+func (o *Opts) ServiceOptions() *ServiceOptions {
+	return &ServiceOptions{MaxBuilds: o.MaxBuilds, StateDir: o.StateDir, DBPath: o.DBPath, DBToken: o.DBToken}
+}
+
+// platforms returns the --platform and --platforms platforms
+func (o *Opts) platforms() []string {
+	return splitPlatforms(append(append([]string{}, o.Platforms...), o.PlatformList)...)
+}
+
+// ServiceConfig returns the build configuration of --serve
+func (o *Opts) ServiceConfig() *Config {
+	return &Config{
+		Executor: o.Executor,
+		Registry: o.Registry,
+
+		ImageTemplate: o.ImageTemplate,
+		WorkDir:       o.WorkDir,
+		Engine:        o.Engine,
+		DockerHost:    o.DockerHost,
+		Buildkit:      BuildkitOptions{Addr: o.BuildkitAddr, FrontendAttrs: o.FrontendAttrs},
+		Platforms:     o.platforms(),
+
+		Scanner:       o.Scanner,
+		ScannerServer: o.ScannerServer,
+		Signer:        o.Signer,
+		SigningKey:    o.SigningKey,
+
+		SourceRetries:    o.Retries,
+		SourceRetryDelay: o.RetryDelay,
+		SourceCacheDir:   o.CacheDir,
+
+		RemoteSources:         true,
+		SourceCredentialHosts: o.CredentialHosts,
+	}
 }
 
 func InitBuildCtx(opts Opts) error {
-	cfg := SourceConfig{Source: opts.Source, LocalPath: opts.LocalPath}
+	if opts.LocalPath == "" {
+		return fmt.Errorf("--local is required")
+	}
+	if opts.DBPath == "" || opts.LogUID == "" {
+		return initBuildCtx(&opts, os.Stdout)
+	}
+	log := NewRemoteLog(opts.DBPath, opts.DBToken, opts.LogProject, opts.LogUID)
+	defer log.Close()
+	err := initBuildCtx(&opts, io.MultiWriter(os.Stdout, log))
+	if err != nil {
+		fmt.Fprintf(log, "Build failed: %s\n", err)
+	}
+	return err
+}
+
+func initBuildCtx(opts *Opts, out io.Writer) error {
+	if opts.ImageTemplate != "" {
+		if err := ValidateImageTemplate(opts.ImageTemplate); err != nil {
+			return err
+		}
+	}
+	cfg := SourceConfig{Source: opts.Source, LocalPath: opts.LocalPath, Include: opts.Include, Exclude: opts.Exclude,
+		Workers: opts.Workers, CacheDir: opts.CacheDir, Retries: opts.Retries, RetryDelay: opts.RetryDelay, Out: out}
+	if opts.Since != "" {
+		since, err := time.Parse(time.RFC3339, opts.Since)
+		if err != nil {
+			return fmt.Errorf("Bad --since time '%s', expecting RFC 3339: %s", opts.Since, err)
+		}
+		cfg.Since = since
+	}
 	codePath := opts.LocalPath
+	var repo SourceRepo
 	if opts.Source != "" {
-		repo, err := GetSourceRepo(&cfg)
+		var err error
+		repo, err = GetSourceRepo(&cfg)
 		if err != nil {
 			return err
 		}
@@ -56,54 +192,318 @@ func InitBuildCtx(opts Opts) error {
 		codePath = repo.CodePath()
 	}
 
-	function, err := getFunction(codePath)
+	var functionSource *SourceConfig
+	if opts.FunctionURL != "" {
+		functionSource = &SourceConfig{Source: opts.FunctionURL, Token: os.Getenv(SourceTokenEnv),
+			Retries: opts.Retries, RetryDelay: opts.RetryDelay, Out: out}
+	}
+	function, err := getFunction(codePath, functionSource)
 	if err != nil {
 		return err
 	}
+	var provenance *Provenance
+	if opts.Source != "" {
+		if provenance, err = SourceProvenance(repo, &cfg, &function.Spec.Build); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Source digest %s\n", provenance.Digest)
+		if opts.DryRun {
+			return dryRun(codePath, function, provenance, opts.BuildArgs, out)
+		}
+		if err = WriteProvenance(codePath, provenance); err != nil {
+			return err
+		}
+	} else if opts.DryRun {
+		return dryRun(codePath, function, nil, opts.BuildArgs, out)
+	}
 	fmt.Printf("F: %+v\n", function)
-	code := function.Spec.Build.FunctionSourceCode
-	if len(code) > 0 {
-		funcFilePath := filepath.Join(codePath, "main.py")
-		err = ioutil.WriteFile(funcFilePath, code, 0644)
+	if err = writeFunctionCode(codePath, function); err != nil {
+		fmt.Fprintf(out, "failed to write code: %+v\n", err)
+	}
+
+	args, err := buildArgs(function, opts.BuildArgs)
+	if err != nil {
+		return err
+	}
+	imageOpts := ImageOptions{BuildArgs: args, Labels: imageLabels(function, provenance, time.Now())}
+	if imageOpts.Platforms, err = imagePlatforms(function, opts.platforms()); err != nil {
+		return err
+	}
+	err = writeDockerfile(codePath, function, true, imageOpts.Labels)
+	if err != nil {
+		return err
+	}
+	pruned, err := PruneContext(codePath, function)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Pruned %d ignored paths from the build context\n", pruned)
+	if opts.Output != "" {
+		if err = WriteContextArchive(codePath, opts.Output); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote the build context to %s\n", opts.Output)
+	}
+	if opts.Engine != EngineDocker && opts.Engine != EngineBuildkit {
+		return nil
+	}
+	image := setFrom(opts.Image, ImageName(function, &Config{Registry: opts.Registry, ImageTemplate: opts.ImageTemplate}))
+	fmt.Fprintf(out, "Building image %s\n", image)
+	var digest string
+	if opts.Engine == EngineBuildkit {
+		buildkit := BuildkitOptions{Addr: opts.BuildkitAddr, FrontendAttrs: opts.FrontendAttrs}
+		digest, err = BuildkitBuild(buildkit.withImageOptions(&imageOpts), codePath, image, opts.Push, nil, out)
+	} else {
+		digest, err = DockerBuild(opts.DockerHost, codePath, image, &imageOpts, opts.Push, nil, out)
+	}
+	if err != nil || !opts.Push {
+		return err
+	}
+	reference := imageReference(image, digest)
+	fmt.Fprintf(out, "Pushed image %s\n", reference)
+	if scan := function.Spec.Build.Scan; scan != nil {
+		if err = validateScan(scan); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Scanning image %s\n", reference)
+		report, err := ScanImage(opts.Scanner, opts.ScannerServer, reference, scan, nil, out)
+		if err != nil {
+			return err
+		}
+		if err = checkScan(report, scan, out); err != nil {
+			return err
+		}
+	}
+	if opts.SigningKey != "" {
+		fmt.Fprintf(out, "Signing image %s\n", reference)
+		signature, err := SignImage(opts.Signer, opts.SigningKey, reference, imageOpts.Labels, nil, out)
 		if err != nil {
-			fmt.Printf("failed to write code: %+v\n", err)
+			return err
 		}
+		fmt.Fprintf(out, "Signed image %s, signature %s\n", reference, signature.Reference)
 	}
+	return nil
+}
 
-	err = writeDockerfile(codePath, function)
-	return err
+// dryRun prints the Dockerfile and the files of the build context, including
+// those the build would add, leaving the context as it is
+func dryRun(codePath string, function *common.Function, provenance *Provenance, extraArgs []string, out io.Writer) error {
+	if _, err := buildArgs(function, extraArgs); err != nil {
+		return err
+	}
+	dock, err := renderDockerfile(codePath, function, true, imageLabels(function, provenance, time.Now()))
+	if err != nil {
+		return err
+	}
+	files, err := ContextManifest(codePath, function)
+	if err != nil {
+		return err
+	}
+	added := []string{"Dockerfile", dockerignoreFile}
+	if len(function.Spec.Build.FunctionSourceCode) > 0 {
+		added = append(added, CodeFile(function))
+	}
+	if function.Spec.Build.CondaEnv != "" {
+		added = append(added, condaEnvFile)
+	}
+	if provenance != nil {
+		added = append(added, ProvenanceFile)
+	}
+	for _, name := range added {
+		found := false
+		for _, file := range files {
+			found = found || file == name
+		}
+		if !found {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	fmt.Fprintf(out, "Dockerfile:\n%s\nBuild context %s:\n", dock, codePath)
+	for _, file := range files {
+		fmt.Fprintf(out, "  %s\n", file)
+	}
+	return nil
 }
 
-func writeDockerfile(codePath string, function *common.Function) error {
+func writeDockerfile(codePath string, function *common.Function, withMLRun bool, labels map[string]string) error {
 	dockerfilePath := filepath.Join(codePath, "Dockerfile")
 	if common.FileExists(dockerfilePath) {
 		fmt.Println("Found Dockerfile")
-		return nil
+		return checkTestCommand(function)
 	}
+	dock, err := renderDockerfile(codePath, function, withMLRun, labels)
+	if err != nil {
+		return err
+	}
+	// The spec conda environment takes precedence over the source one
+	if condaEnv := function.Spec.Build.CondaEnv; condaEnv != "" {
+		if err = ioutil.WriteFile(filepath.Join(codePath, condaEnvFile), []byte(condaEnv), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Println(dock)
+	return ioutil.WriteFile(dockerfilePath, []byte(dock), 0644)
+}
+
+// Stages of generated Dockerfiles with a build test command
+const (
+	imageStage     = "image"
+	testStage      = "test"
+	testPassedFile = "/tmp/.mlrun-test-passed"
+)
+
+// testStages returns the Dockerfile stages running the test command in the
+// image stage. The final image copies a file from the test stage, so engines
+// that skip unused stages run it too, and the build fails with the tests
+func testStages(command string) string {
+	return fmt.Sprintf("FROM %s AS %s\nRUN %s\nRUN touch %s\n", imageStage, testStage, command, testPassedFile) +
+		fmt.Sprintf("FROM %s\nCOPY --from=%s %s %s\n", imageStage, testStage, testPassedFile, testPassedFile)
+}
+
+// checkTestCommand fails builds with a test command and a source Dockerfile,
+// the test stage is only added to generated Dockerfiles
+func checkTestCommand(function *common.Function) error {
+	if function.Spec.Build.TestCommand != "" {
+		return fmt.Errorf("The build test_command needs a generated Dockerfile, the source has one")
+	}
+	return nil
+}
 
+// renderDockerfile returns the Dockerfile generated for the build context,
+// or the context one when it has one
+func renderDockerfile(codePath string, function *common.Function, withMLRun bool, labels map[string]string) (string, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(codePath, "Dockerfile")); err == nil {
+		return string(data), checkTestCommand(function)
+	}
 	build := function.Spec.Build
-	image := defaultBaseImage
+	if err := validateBuildVars(&build); err != nil {
+		return "", err
+	}
+	runtime, err := functionRuntime(codePath, function)
+	if err != nil {
+		return "", err
+	}
+	workDir, err := imageWorkDir(function)
+	if err != nil {
+		return "", err
+	}
+	template := runtimeTemplates[runtime]
+	condaEnv := findCondaEnv(codePath, build.CondaEnv)
+	image := template.baseImage
+	if condaEnv != "" {
+		image = condaBaseImage
+	}
 	if build.BaseImage != "" {
 		image = build.BaseImage
 	}
 	cmds := build.Commands
-	pkgPath, valid := os.LookupEnv("MLRUN_PACKAGE_PATH")
-	if !valid {
-		pkgPath = mlrunPackage
-	}
-	cmds = append(cmds, "pip install "+pkgPath)
-	dock := fmt.Sprintf("FROM %s\nWORKDIR /run\n", image)
-	dock += fmt.Sprintf("ADD %s /run\n", codePath)
-	for _, cmd := range cmds {
+	// The mlrun package is a python package, other runtimes run as they are
+	if withMLRun && runtime == RuntimePython {
+		pkgPath, valid := os.LookupEnv("MLRUN_PACKAGE_PATH")
+		if !valid {
+			pkgPath = mlrunPackage
+		}
+		cmds = append(cmds, "pip install "+pkgPath)
+	}
+	testCommand := build.TestCommand
+	if strings.ContainsAny(testCommand, "\r\n") {
+		return "", fmt.Errorf("The build test_command must be a single line")
+	}
+	dock := fmt.Sprintf("FROM %s\n", image)
+	if testCommand != "" {
+		dock = fmt.Sprintf("FROM %s AS %s\n", image, imageStage)
+	}
+	for _, key := range sortedKeys(build.Args) {
+		dock += fmt.Sprintf("ARG %s=%s\n", key, strconv.Quote(build.Args[key]))
+	}
+	dock += fmt.Sprintf("WORKDIR %s\n", workDir)
+	for _, key := range sortedKeys(build.Env) {
+		dock += fmt.Sprintf("ENV %s=%s\n", key, strconv.Quote(build.Env[key]))
+	}
+	if condaEnv != "" {
+		dock += fmt.Sprintf("COPY %s /tmp/environment.yaml\n", condaEnv)
+		dock += fmt.Sprintf("RUN if command -v mamba > /dev/null; then CONDA=mamba; else CONDA=conda; fi && "+
+			"$CONDA env create -n %s -f /tmp/environment.yaml && conda clean -afy && rm /tmp/environment.yaml\n", condaEnvName)
+		dock += fmt.Sprintf("ENV PATH /opt/conda/envs/%s/bin:$PATH\nENV CONDA_DEFAULT_ENV %s\n", condaEnvName, condaEnvName)
+	}
+	// The Dockerfile is at the context root, the code is copied relative to it
+	dock += fmt.Sprintf("COPY . %s\n", workDir)
+	for _, cmd := range append(cmds, template.build...) {
 		dock += fmt.Sprintf("RUN %s\n", cmd)
 	}
-	dock += "ENV PYTHONPATH /run\n"
-	fmt.Println(dock)
-	err := ioutil.WriteFile(dockerfilePath, []byte(dock), 0644)
-	return err
+	for _, env := range template.env {
+		dock += fmt.Sprintf("ENV %s\n", strings.Replace(env, workDirVar, workDir, -1))
+	}
+	if template.entrypoint != "" {
+		dock += fmt.Sprintf("ENTRYPOINT %s\n", strings.Replace(template.entrypoint, workDirVar, workDir, -1))
+	}
+	for _, key := range sortedKeys(labels) {
+		dock += fmt.Sprintf("LABEL %s=%s\n", strconv.Quote(key), strconv.Quote(labels[key]))
+	}
+	if testCommand != "" {
+		dock += testStages(testCommand)
+	}
+	return dock, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// buildArgs returns the build arguments of a function, with the extra
+// KEY=VALUE arguments on top
+func buildArgs(function *common.Function, extra []string) (map[string]string, error) {
+	args := map[string]string{}
+	for key, value := range function.Spec.Build.Args {
+		args[key] = value
+	}
+	for _, arg := range extra {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || !buildVarName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("Bad build arg '%s', expecting KEY=VALUE", arg)
+		}
+		args[parts[0]] = parts[1]
+	}
+	return args, nil
+}
+
+var buildVarName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// validateBuildVars checks the build.args and build.env names
+func validateBuildVars(build *common.ImageBuilder) error {
+	for kind, values := range map[string]map[string]string{"arg": build.Args, "env": build.Env} {
+		for key := range values {
+			if !buildVarName.MatchString(key) {
+				return fmt.Errorf("Bad build %s name '%s'", kind, key)
+			}
+		}
+	}
+	return nil
+}
+
+// findCondaEnv returns the conda environment file of the build context, the
+// spec environment is written to condaEnvFile and takes precedence
+func findCondaEnv(codePath, specEnv string) string {
+	if specEnv != "" {
+		return condaEnvFile
+	}
+	for _, name := range condaEnvFiles {
+		if common.FileExists(filepath.Join(codePath, name)) {
+			return name
+		}
+	}
+	return ""
 }
 
-func getFunction(codePath string) (*common.Function, error) {
+// getFunction reads the function spec from the code function.yaml, or from
+// functionSource when set, with the MLRUN_FUNCTION_SPEC fields on top
+func getFunction(codePath string, functionSource *SourceConfig) (*common.Function, error) {
 
 	var envFunc, repoFunc common.Function
 
@@ -122,6 +522,17 @@ func getFunction(codePath string) (*common.Function, error) {
 	}
 
 	yamlPath := filepath.Join(codePath, "function.yaml")
+	if functionSource != nil {
+		dir, err := ioutil.TempDir("", "function-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		functionSource.LocalPath = dir
+		if yamlPath, err = DownloadFile(functionSource); err != nil {
+			return nil, fmt.Errorf("Failed to fetch function %s: %s", functionSource.Source, err)
+		}
+	}
 	if common.FileExists(yamlPath) {
 		data, err := ioutil.ReadFile(yamlPath)
 		if err != nil {
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/nuclio/logger"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const remoteLogFlushInterval = 2 * time.Second
+
+// out returns where the source download progress is written
+func (cfg *SourceConfig) out() io.Writer {
+	if cfg.Out == nil {
+		return os.Stdout
+	}
+	return cfg.Out
+}
+
+// writerLogger writes the xcp copy log lines to the build output
+type writerLogger struct {
+	out io.Writer
+}
+
+func (l *writerLogger) log(level string, format interface{}, vars []interface{}) {
+	message, ok := format.(string)
+	if !ok {
+		message = fmt.Sprint(format)
+	} else if len(vars) > 0 {
+		message = fmt.Sprintf(message, vars...)
+	}
+	fmt.Fprintf(l.out, "%s %s\n", level, message)
+}
+
+// logWith writes the key value pairs of the *With calls
+func (l *writerLogger) logWith(level string, format interface{}, vars []interface{}) {
+	parts := []string{fmt.Sprint(format)}
+	for i := 0; i+1 < len(vars); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", vars[i], vars[i+1]))
+	}
+	fmt.Fprintf(l.out, "%s %s\n", level, strings.Join(parts, " "))
+}
+
+func (l *writerLogger) Error(format interface{}, vars ...interface{}) { l.log("ERROR", format, vars) }
+func (l *writerLogger) Warn(format interface{}, vars ...interface{})  { l.log("WARN", format, vars) }
+func (l *writerLogger) Info(format interface{}, vars ...interface{})  { l.log("INFO", format, vars) }
+func (l *writerLogger) Debug(format interface{}, vars ...interface{}) { l.log("DEBUG", format, vars) }
+func (l *writerLogger) ErrorWith(format interface{}, vars ...interface{}) {
+	l.logWith("ERROR", format, vars)
+}
+func (l *writerLogger) WarnWith(format interface{}, vars ...interface{}) {
+	l.logWith("WARN", format, vars)
+}
+func (l *writerLogger) InfoWith(format interface{}, vars ...interface{}) {
+	l.logWith("INFO", format, vars)
+}
+func (l *writerLogger) DebugWith(format interface{}, vars ...interface{}) {
+	l.logWith("DEBUG", format, vars)
+}
+func (l *writerLogger) Flush()                             {}
+func (l *writerLogger) GetChild(name string) logger.Logger { return l }
+
+// RemoteLog stores the output of a build in the controller log store (POST
+// /log/<project>/<uid>), so it is tailed like a run log. The whole log is
+// stored on every flush, as the log API replaces the object
+type RemoteLog struct {
+	url     string
+	token   string
+	lock    sync.Mutex
+	buffer  bytes.Buffer
+	flushed int
+	done    chan struct{}
+	closed  chan struct{}
+}
+
+// NewRemoteLog returns a log flushed to the controller at dbPath every few
+// seconds and on Close, token is sent as a bearer token when set
+func NewRemoteLog(dbPath, token, project, uid string) *RemoteLog {
+	l := &RemoteLog{
+		url:    fmt.Sprintf("%s/log/%s/%s", strings.TrimSuffix(dbPath, "/"), project, uid),
+		token:  token,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *RemoteLog) Write(data []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buffer.Write(data)
+}
+
+// Close stores the remaining output
+func (l *RemoteLog) Close() error {
+	close(l.done)
+	<-l.closed
+	return nil
+}
+
+func (l *RemoteLog) run() {
+	defer close(l.closed)
+	ticker := time.NewTicker(remoteLogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.flush()
+		case <-l.done:
+			l.flush()
+			return
+		}
+	}
+}
+
+func (l *RemoteLog) flush() {
+	l.lock.Lock()
+	body := append([]byte(nil), l.buffer.Bytes()...)
+	l.lock.Unlock()
+	if len(body) == l.flushed {
+		return
+	}
+
+	request, err := http.NewRequest("POST", l.url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Failed to store log %s: %s\n", l.url, err)
+		return
+	}
+	if l.token != "" {
+		request.Header.Set("Authorization", "Bearer "+l.token)
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err == nil {
+		response.Body.Close()
+		if response.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("%s", response.Status)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed to store log %s: %s\n", l.url, err)
+		return
+	}
+	l.flushed = len(body)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProvenanceFile is written into the build context
+const ProvenanceFile = "mlrun-provenance.json"
+
+// Provenance records where the code of a build came from, for reproducibility
+// audits. The digest is the sha256 of the downloaded file for archive and
+// http sources, and of the code tree (see treeDigest) otherwise
+type Provenance struct {
+	Source    string `json:"source"`
+	Ref       string `json:"ref,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+	Digest    string `json:"digest"`
+	Timestamp string `json:"timestamp"`
+}
+
+// fileDigester is implemented by sources downloaded as a single file
+type fileDigester interface {
+	FileDigest() string
+}
+
+// SourceProvenance returns the provenance of a downloaded source and verifies
+// it against the source_sha256 and source_commit pins of the build spec
+func SourceProvenance(repo SourceRepo, cfg *SourceConfig, build *common.ImageBuilder) (*Provenance, error) {
+	provenance := Provenance{Source: redactSource(cfg.Source), Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	if g, ok := repo.(*GitSource); ok {
+		provenance.Ref, provenance.Commit = g.branch, g.commit
+	}
+	if digester, ok := repo.(fileDigester); ok {
+		provenance.Digest = digester.FileDigest()
+	} else {
+		var err error
+		if provenance.Digest, err = treeDigest(repo.CodePath()); err != nil {
+			return nil, err
+		}
+	}
+
+	if pinned := strings.ToLower(build.SourceCommit); pinned != "" {
+		if provenance.Commit == "" {
+			return nil, fmt.Errorf("source_commit is set but %s is not a git source", provenance.Source)
+		}
+		if !strings.HasPrefix(provenance.Commit, pinned) {
+			return nil, fmt.Errorf("Source commit mismatch: expected %s, got %s", pinned, provenance.Commit)
+		}
+	}
+	if pinned := strings.ToLower(strings.TrimPrefix(build.SourceSHA256, "sha256:")); pinned != "" {
+		if "sha256:"+pinned != provenance.Digest {
+			return nil, fmt.Errorf("Source checksum mismatch: expected sha256:%s, got %s", pinned, provenance.Digest)
+		}
+	}
+	return &provenance, nil
+}
+
+// WriteProvenance writes the provenance record into the build context
+func WriteProvenance(codePath string, provenance *Provenance) error {
+	data, err := json.MarshalIndent(provenance, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(codePath, ProvenanceFile), append(data, '\n'), 0644)
+}
+
+// redactSource drops the password of a source URL
+func redactSource(source string) string {
+	u, err := url.Parse(source)
+	if err != nil || u.User == nil {
+		return source
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
+
+// fileSHA256 returns the sha256:<hex> digest of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	checksum := sha256.New()
+	if _, err = io.Copy(checksum, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+// treeDigest returns the sha256 of the "<path>\0<sha256 of content>\n" lines
+// of the files under dir in lexical order (symbolic links hash their target),
+// .git directories are skipped
+func treeDigest(dir string) (string, error) {
+	digest := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var content string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			content = "link:" + link
+		case info.Mode().IsRegular():
+			if content, err = fileSHA256(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		fmt.Fprintf(digest, "%s\x00%s\n", filepath.ToSlash(name), content)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(digest.Sum(nil)), nil
+}
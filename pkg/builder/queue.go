@@ -0,0 +1,235 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LabelBuildPriority orders the builds of the service, higher first (default 0)
+const LabelBuildPriority = "mlrun/build-priority"
+
+// queuedBuild is a pending build of the service, as persisted in the state
+// directory
+type queuedBuild struct {
+	ID       string         `json:"id"`
+	Project  string         `json:"project"`
+	Priority int            `json:"priority"`
+	Created  time.Time      `json:"created"`
+	Request  serviceRequest `json:"request"`
+}
+
+// buildQueue hands pending builds to at most slots concurrent builds, by
+// priority, then by project (the project with the fewest running builds, ties
+// to the least recently served) so one project can't starve the others, then
+// in arrival order. Pending builds are persisted in stateDir (when set) so a
+// restart resumes them, with their secrets, readable by the owner only
+type buildQueue struct {
+	lock     sync.Mutex
+	ready    *sync.Cond
+	slots    int
+	active   int
+	pending  []*queuedBuild
+	running  map[string]int
+	served   map[string]time.Time
+	stateDir string
+}
+
+func newBuildQueue(slots int, stateDir string) (*buildQueue, error) {
+	if slots <= 0 {
+		slots = maxServiceBuilds
+	}
+	q := buildQueue{slots: slots, running: map[string]int{}, served: map[string]time.Time{}, stateDir: stateDir}
+	q.ready = sync.NewCond(&q.lock)
+	if stateDir != "" {
+		if err := os.MkdirAll(stateDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &q, nil
+}
+
+// restore returns the builds persisted by a previous run, in arrival order
+func (q *buildQueue) restore() ([]*queuedBuild, error) {
+	if q.stateDir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(q.stateDir)
+	if err != nil {
+		return nil, err
+	}
+	var builds []*queuedBuild
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(q.stateDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		build := queuedBuild{}
+		if err = json.Unmarshal(data, &build); err != nil {
+			fmt.Printf("Skipping bad queued build %s: %s\n", file.Name(), err)
+			continue
+		}
+		builds = append(builds, &build)
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, build := range builds {
+		q.insert(build)
+	}
+	q.ready.Broadcast()
+	return q.pending, nil
+}
+
+// push persists and queues a build
+func (q *buildQueue) push(build *queuedBuild) error {
+	if q.stateDir != "" {
+		data, err := json.Marshal(build)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(q.statePath(build.ID), data, 0600); err != nil {
+			return err
+		}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.insert(build)
+	q.ready.Broadcast()
+	return nil
+}
+
+// insert keeps pending in arrival order, the lock is held
+func (q *buildQueue) insert(build *queuedBuild) {
+	i := len(q.pending)
+	for i > 0 && q.pending[i-1].Created.After(build.Created) {
+		i--
+	}
+	q.pending = append(q.pending, nil)
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = build
+}
+
+// next waits for a free slot and a pending build and returns the build to
+// start, it keeps its state file until done
+func (q *buildQueue) next() *queuedBuild {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.active >= q.slots || len(q.pending) == 0 {
+		q.ready.Wait()
+	}
+	best := 0
+	for i, build := range q.pending[1:] {
+		if q.before(build, q.pending[best]) {
+			best = i + 1
+		}
+	}
+	build := q.pending[best]
+	q.pending = append(q.pending[:best], q.pending[best+1:]...)
+	q.active++
+	q.running[build.Project]++
+	q.served[build.Project] = time.Now()
+	return build
+}
+
+// before returns true if a should start before b, the lock is held
+func (q *buildQueue) before(a, b *queuedBuild) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Project != b.Project {
+		if q.running[a.Project] != q.running[b.Project] {
+			return q.running[a.Project] < q.running[b.Project]
+		}
+		if servedA, servedB := q.served[a.Project], q.served[b.Project]; !servedA.Equal(servedB) {
+			return servedA.Before(servedB)
+		}
+	}
+	return a.Created.Before(b.Created)
+}
+
+// done frees the slot of a finished build and forgets it
+func (q *buildQueue) done(build *queuedBuild) {
+	if q.stateDir != "" {
+		if err := os.Remove(q.statePath(build.ID)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove queued build %s: %s\n", build.ID, err)
+		}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.active--
+	if q.running[build.Project]--; q.running[build.Project] <= 0 {
+		delete(q.running, build.Project)
+	}
+	q.ready.Broadcast()
+}
+
+// position returns the number of builds that start before a pending build
+// given the current state, or -1 when it is not pending
+func (q *buildQueue) position(id string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, build := range q.pending {
+		if build.ID != id {
+			continue
+		}
+		position := 0
+		for _, other := range q.pending {
+			if other != build && q.before(other, build) {
+				position++
+			}
+		}
+		return position
+	}
+	return -1
+}
+
+func (q *buildQueue) statePath(id string) string {
+	return filepath.Join(q.stateDir, id+".json")
+}
+
+// buildPriority returns the priority of a build request, the request field
+// wins over the function label. Only admins raise builds above the default,
+// others would skip the project fairness of the queue
+func buildPriority(request *serviceRequest, labels map[string]string, admin bool) (int, error) {
+	priority := 0
+	if request.Priority != nil {
+		priority = *request.Priority
+	} else if value, ok := labels[LabelBuildPriority]; ok {
+		var err error
+		if priority, err = strconv.Atoi(value); err != nil {
+			return 0, fmt.Errorf("Bad %s label '%s', expecting an integer", LabelBuildPriority, value)
+		}
+	}
+	if priority > 0 && !admin {
+		priority = 0
+	}
+	return priority, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dockerHubRegistry = "docker.io"
+
+// dockerConfig is a docker config.json, only the credentials are used
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// ParseDockerConfig parses a config.json (.dockerconfigjson secrets) or the
+// legacy .dockercfg format which holds just the auths, credential helpers are
+// not supported
+func ParseDockerConfig(data []byte) (*dockerConfig, error) {
+	config := dockerConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Bad docker config: %s", err)
+	}
+	if config.Auths == nil {
+		legacy := map[string]dockerAuth{}
+		if json.Unmarshal(data, &legacy) == nil {
+			config.Auths = legacy
+		}
+	}
+	return &config, nil
+}
+
+// defaultDockerConfig reads the config.json of the user (DOCKER_CONFIG or
+// ~/.docker), a missing file means no credentials
+func defaultDockerConfig() (*dockerConfig, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return &dockerConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseDockerConfig(data)
+}
+
+// registryHost returns the registry of an image, images with no registry
+// component are on Docker Hub
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return dockerHubRegistry
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// credentials returns the credentials of the registry of image, config keys
+// may be plain hosts or URLs
+func (c *dockerConfig) credentials(image string) (dockerAuth, string, bool) {
+	host := registryHost(image)
+	candidates := []string{host, "https://" + host, "http://" + host}
+	if host == dockerHubRegistry {
+		candidates = append(candidates, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, key := range candidates {
+		if auth, ok := c.Auths[key]; ok {
+			if auth.Auth != "" && auth.Username == "" {
+				if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+					parts := strings.SplitN(string(decoded), ":", 2)
+					if len(parts) == 2 {
+						auth.Username, auth.Password = parts[0], parts[1]
+					}
+				}
+			}
+			return auth, key, true
+		}
+	}
+	return dockerAuth{}, "", false
+}
+
+// registryAuthHeader returns the X-Registry-Auth header pushing image with
+// the Docker Engine API, an empty config pushes without credentials
+func (c *dockerConfig) registryAuthHeader(image string) string {
+	header := map[string]string{}
+	if auth, server, ok := c.credentials(image); ok {
+		if auth.IdentityToken != "" {
+			header["identitytoken"] = auth.IdentityToken
+		} else {
+			header["username"], header["password"] = auth.Username, auth.Password
+		}
+		header["serveraddress"] = server
+	}
+	data, _ := json.Marshal(header)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// writeDockerConfig writes the registry credentials as config.json in dir,
+// for the executors that read DOCKER_CONFIG
+func writeDockerConfig(dir string, config *dockerConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"time"
+)
+
+const (
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+// permanentError marks download errors retrying can't fix, e.g. bad
+// credentials or a missing file
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// permanent wraps err so it is not retried
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// retryDownload runs download up to cfg.Retries more times on failure, the
+// delay starts at cfg.RetryDelay and doubles up to maxRetryDelay
+func retryDownload(cfg *SourceConfig, what string, download func() error) error {
+	delay := cfg.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		err := download()
+		if err == nil {
+			return nil
+		}
+		if permanentErr, ok := err.(*permanentError); ok {
+			return permanentErr.err
+		}
+		if attempt >= cfg.Retries {
+			return err
+		}
+		fmt.Fprintf(cfg.out(), "Failed to download %s: %s, retrying in %s\n", what, err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// gitError marks the clone errors that retrying can't fix as permanent
+func gitError(err error) error {
+	switch err {
+	case transport.ErrAuthenticationRequired, transport.ErrAuthorizationFailed,
+		transport.ErrRepositoryNotFound, transport.ErrEmptyRemoteRepository, transport.ErrInvalidAuthMethod,
+		plumbing.ErrReferenceNotFound, git.ErrRepositoryAlreadyExists:
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Function runtimes (spec.runtime) the builder prepares images for, non
+// python functions run their image entrypoint unless they have a command
+const (
+	RuntimePython = "python"
+	RuntimeGo     = "go"
+	RuntimeJava   = "java"
+	RuntimeR      = "r"
+)
+
+// defaultWorkDir is the in-image code directory of functions with no
+// build.workdir, workDirVar stands for it in the runtime templates
+const (
+	defaultWorkDir = "/run"
+	workDirVar     = "{workdir}"
+)
+
+// runtimeTemplate describes the image of a runtime, the build commands run
+// after the function build commands
+type runtimeTemplate struct {
+	baseImage  string
+	codeFile   string
+	build      []string
+	env        []string
+	entrypoint string
+}
+
+var runtimeTemplates = map[string]*runtimeTemplate{
+	RuntimePython: {
+		baseImage: defaultBaseImage,
+		codeFile:  "main.py",
+		env:       []string{"PYTHONPATH " + workDirVar},
+	},
+	RuntimeGo: {
+		baseImage:  "golang:1.12",
+		codeFile:   "main.go",
+		build:      []string{"go build -o /usr/local/bin/function ."},
+		entrypoint: `["/usr/local/bin/function"]`,
+	},
+	RuntimeJava: {
+		baseImage: "maven:3-jdk-8",
+		codeFile:  "Main.java",
+		build: []string{"if [ -f pom.xml ]; then mvn -q -DskipTests package && cp target/*.jar function.jar; " +
+			"else javac *.java && jar cfe function.jar Main *.class; fi"},
+		entrypoint: `["java", "-jar", "` + workDirVar + `/function.jar"]`,
+	},
+	RuntimeR: {
+		baseImage:  "r-base",
+		codeFile:   "main.R",
+		build:      []string{`if [ -f DESCRIPTION ]; then Rscript -e 'install.packages("remotes"); remotes::install_deps(".")'; fi`},
+		entrypoint: `["Rscript", "` + workDirVar + `/main.R"]`,
+	},
+}
+
+// Files that identify the runtime of a source tree with no spec runtime
+var runtimeMarkers = []struct {
+	file    string
+	runtime string
+}{
+	{"go.mod", RuntimeGo},
+	{"main.go", RuntimeGo},
+	{"pom.xml", RuntimeJava},
+	{"build.gradle", RuntimeJava},
+	{"Main.java", RuntimeJava},
+	{"DESCRIPTION", RuntimeR},
+	{"main.R", RuntimeR},
+}
+
+// functionRuntime returns the function runtime, detected from the code when
+// the spec has none (python by default)
+func functionRuntime(codePath string, function *common.Function) (string, error) {
+	runtime := function.Spec.Runtime
+	if runtime != "" {
+		if runtimeTemplates[runtime] == nil {
+			return "", fmt.Errorf("Unknown runtime '%s', use python, go, java or r", runtime)
+		}
+		return runtime, nil
+	}
+	for _, marker := range runtimeMarkers {
+		if common.FileExists(filepath.Join(codePath, marker.file)) {
+			return marker.runtime, nil
+		}
+	}
+	return RuntimePython, nil
+}
+
+// imageWorkDir returns the in-image code directory of a function, an absolute
+// path
+func imageWorkDir(function *common.Function) (string, error) {
+	workDir := function.Spec.Build.WorkDir
+	if workDir == "" {
+		return defaultWorkDir, nil
+	}
+	if !path.IsAbs(workDir) || strings.ContainsAny(workDir, "\"\n\\") {
+		return "", fmt.Errorf("Bad build workdir '%s', expecting an absolute path", workDir)
+	}
+	return path.Clean(workDir), nil
+}
+
+// CodeFile returns the file the inline source code of a function is written to
+func CodeFile(function *common.Function) string {
+	if template := runtimeTemplates[function.Spec.Runtime]; template != nil {
+		return template.codeFile
+	}
+	return runtimeTemplates[RuntimePython].codeFile
+}
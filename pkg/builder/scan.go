@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	defaultScanner = "trivy"
+
+	// Findings kept in a report, the counts cover all of them
+	maxScanFindings = 100
+)
+
+var severityLevels = map[string]int{"UNKNOWN": 0, "LOW": 1, "MEDIUM": 2, "HIGH": 3, "CRITICAL": 4}
+
+// ScanFinding is a vulnerability at or above the threshold
+type ScanFinding struct {
+	ID               string `json:"id"`
+	Severity         string `json:"severity"`
+	Package          string `json:"package"`
+	InstalledVersion string `json:"installed_version,omitempty"`
+	FixedVersion     string `json:"fixed_version,omitempty"`
+	Target           string `json:"target,omitempty"`
+}
+
+// ScanReport is the outcome of an image scan, recorded with the build
+type ScanReport struct {
+	Image     string `json:"image"`
+	Threshold string `json:"threshold"`
+	// Vulnerabilities by severity, and at or above the threshold
+	Counts  map[string]int `json:"counts"`
+	Failing int            `json:"failing"`
+	// Vulnerabilities at or above the threshold, the first maxScanFindings
+	Findings []ScanFinding `json:"findings,omitempty"`
+	Passed   bool          `json:"passed"`
+}
+
+// trivyResult is a scanned target of the trivy JSON report, older versions
+// report a list of these and newer ones wrap it in Results
+type trivyResult struct {
+	Target          string `json:"Target"`
+	Vulnerabilities []struct {
+		VulnerabilityID  string `json:"VulnerabilityID"`
+		PkgName          string `json:"PkgName"`
+		InstalledVersion string `json:"InstalledVersion"`
+		FixedVersion     string `json:"FixedVersion"`
+		Severity         string `json:"Severity"`
+	} `json:"Vulnerabilities"`
+}
+
+// validateScan checks the scan spec of a build
+func validateScan(scan *common.ImageScan) error {
+	if _, ok := severityLevels[strings.ToUpper(setFrom(scan.Severity, "HIGH"))]; !ok {
+		return fmt.Errorf("Bad scan severity '%s', use LOW, MEDIUM, HIGH or CRITICAL", scan.Severity)
+	}
+	if scan.Action != "" && scan.Action != common.ScanFail && scan.Action != common.ScanWarn {
+		return fmt.Errorf("Bad scan action '%s', use %s or %s", scan.Action, common.ScanFail, common.ScanWarn)
+	}
+	return nil
+}
+
+// ScanImage scans a built image with the trivy CLI (standalone, or as a client
+// of a trivy server when server is set), env holds the registry credentials.
+// The report fails when vulnerabilities reach the scan severity (default HIGH)
+func ScanImage(scanner, server, image string, scan *common.ImageScan, env []string, out io.Writer) (*ScanReport, error) {
+	args := []string{"image", "--format", "json", "--quiet"}
+	if server != "" {
+		args = append(args, "--server", server)
+	}
+	if scan.IgnoreUnfixed {
+		args = append(args, "--ignore-unfixed")
+	}
+	cmd := exec.Command(setFrom(scanner, defaultScanner), append(args, image)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = out
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Image scan failed: %s", err)
+	}
+
+	var results []trivyResult
+	data := bytes.TrimSpace(stdout.Bytes())
+	if bytes.HasPrefix(data, []byte("{")) {
+		report := struct {
+			Results []trivyResult `json:"Results"`
+		}{}
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("Bad scan report: %s", err)
+		}
+		results = report.Results
+	} else if len(data) > 0 {
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("Bad scan report: %s", err)
+		}
+	}
+
+	threshold := strings.ToUpper(setFrom(scan.Severity, "HIGH"))
+	report := ScanReport{Image: image, Threshold: threshold, Counts: map[string]int{}, Passed: true}
+	for _, result := range results {
+		for _, vulnerability := range result.Vulnerabilities {
+			severity := strings.ToUpper(vulnerability.Severity)
+			report.Counts[severity]++
+			if severityLevels[severity] < severityLevels[threshold] {
+				continue
+			}
+			report.Passed = false
+			report.Failing++
+			if len(report.Findings) < maxScanFindings {
+				report.Findings = append(report.Findings, ScanFinding{
+					ID:               vulnerability.VulnerabilityID,
+					Severity:         severity,
+					Package:          vulnerability.PkgName,
+					InstalledVersion: vulnerability.InstalledVersion,
+					FixedVersion:     vulnerability.FixedVersion,
+					Target:           result.Target,
+				})
+			}
+		}
+	}
+	return &report, nil
+}
+
+// checkScan fails images with vulnerabilities at or above the threshold,
+// unless the scan action is warn
+func checkScan(report *ScanReport, scan *common.ImageScan, out io.Writer) error {
+	fmt.Fprintf(out, "Scan found %s\n", report.summary())
+	if report.Passed {
+		return nil
+	}
+	message := fmt.Sprintf("Image %s has %d vulnerabilities of severity %s or above", report.Image, report.Failing, report.Threshold)
+	if scan.Action == common.ScanWarn {
+		fmt.Fprintf(out, "Warning: %s\n", message)
+		return nil
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// summary returns the vulnerability counts of a report for the build log
+func (r *ScanReport) summary() string {
+	var parts []string
+	for _, severity := range []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"} {
+		if count := r.Counts[severity]; count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, severity))
+		}
+	}
+	if len(parts) == 0 {
+		return "no vulnerabilities"
+	}
+	return strings.Join(parts, ", ")
+}
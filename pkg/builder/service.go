@@ -0,0 +1,327 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/defaults"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	serviceTag = "build"
+
+	// Finished builds are kept for status queries, then forgotten
+	serviceRetention = time.Hour
+	maxServiceBuilds = 4
+)
+
+// Build states of the service, as in the DB build records
+const (
+	BuildPending = "pending"
+	BuildRunning = "running"
+	BuildReady   = "ready"
+	BuildError   = "error"
+)
+
+// serviceRequest is the body of POST /build
+type serviceRequest struct {
+	Function  json.RawMessage   `json:"function"`
+	WithMLRun *bool             `json:"with_mlrun"`
+	Secrets   map[string]string `json:"secrets"`
+	// Registry credentials as a docker config.json object
+	DockerConfig json.RawMessage `json:"docker_config"`
+	// Higher first, defaults to the LabelBuildPriority function label. Only
+	// admins set priorities above 0
+	Priority *int `json:"priority"`
+}
+
+// serviceBuild is a build of the service, its output is kept in memory
+type serviceBuild struct {
+	lock       sync.Mutex
+	id         string
+	state      string
+	image      string
+	err        string
+	provenance *Provenance
+	scan       *ScanReport
+	signature  *Signature
+	log        bytes.Buffer
+	created    time.Time
+	finished   time.Time
+}
+
+func (b *serviceBuild) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.log.Write(data)
+}
+
+// BuildService runs builds for remote callers, it is the builder run as a
+// cluster service (cmd/builder --serve) instead of a one-shot CLI
+type BuildService struct {
+	cfg    *Config
+	opts   *ServiceOptions
+	lock   sync.Mutex
+	builds map[string]*serviceBuild
+	queue  *buildQueue
+	// Functions are built with the package defaults
+	defaults defaults.Config
+}
+
+// ServiceOptions are the queueing options of the build service
+type ServiceOptions struct {
+	// Concurrent builds (default: 4)
+	MaxBuilds int
+	// Directory the pending builds are persisted in (default: not persisted)
+	StateDir string
+	// Controller the build logs are stored in, under build-<id>
+	DBPath  string
+	DBToken string
+}
+
+// NewBuildService returns a service resuming the builds left pending in the
+// state directory
+func NewBuildService(cfg *Config, opts *ServiceOptions) (*BuildService, error) {
+	if cfg.ImageTemplate != "" {
+		if err := ValidateImageTemplate(cfg.ImageTemplate); err != nil {
+			return nil, err
+		}
+	}
+	queue, err := newBuildQueue(opts.MaxBuilds, opts.StateDir)
+	if err != nil {
+		return nil, err
+	}
+	s := BuildService{cfg: cfg, opts: opts, builds: map[string]*serviceBuild{}, queue: queue}
+	restored, err := queue.restore()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to restore the build queue: %s", err)
+	}
+	for _, queued := range restored {
+		build := &serviceBuild{id: queued.ID, state: BuildPending, created: queued.Created}
+		fmt.Fprintf(build, "Resumed after a builder restart\n")
+		s.builds[build.id] = build
+	}
+	if len(restored) > 0 {
+		fmt.Printf("Resumed %d queued builds\n", len(restored))
+	}
+	go s.dispatch()
+	return &s, nil
+}
+
+// Routes returns the service endpoints
+func (s *BuildService) Routes() []api.Route {
+	return []api.Route{
+		{Method: "POST", Path: "/build", Name: "startBuild", Summary: "Build a function image", Tag: serviceTag,
+			Body: api.ObjectBody, Handler: s.buildHandler},
+		{Method: "GET", Path: "/build/:id", Name: "getBuild", Summary: "Get build state and log", Tag: serviceTag,
+			Params:  []api.Param{api.QueryParam("offset", api.Integer, false, "Log offset to return the log from")},
+			Handler: s.statusHandler},
+	}
+}
+
+func (s *BuildService) buildHandler(ctx *fasthttp.RequestCtx) {
+	request := serviceRequest{}
+	if err := json.Unmarshal(ctx.Request.Body(), &request); err != nil || len(request.Function) == 0 {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON body with a function"))
+		return
+	}
+	function := common.Function{}
+	var err error
+	if request.Function, err = s.defaults.Function(request.Function, "", time.Now()); err == nil {
+		err = json.Unmarshal(request.Function, &function)
+	}
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if function.Metadata.Name == "" {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Function name is required"))
+		return
+	}
+	priority, err := buildPriority(&request, function.Metadata.Labels, api.IsAdmin(ctx))
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	queued := &queuedBuild{
+		ID:       hex.EncodeToString(id),
+		Project:  setFrom(function.Metadata.Project, "default"),
+		Priority: priority,
+		Created:  time.Now(),
+		Request:  request,
+	}
+	build := &serviceBuild{id: queued.ID, state: BuildPending, created: queued.Created}
+	s.lock.Lock()
+	s.prune()
+	s.builds[build.id] = build
+	s.lock.Unlock()
+	if err = s.queue.push(queued); err != nil {
+		s.lock.Lock()
+		delete(s.builds, build.id)
+		s.lock.Unlock()
+		api.WriteError(ctx, http.StatusInternalServerError, fmt.Errorf("Failed to queue build: %s", err))
+		return
+	}
+	writeServiceJSON(ctx, map[string]interface{}{"id": build.id, "state": BuildPending})
+}
+
+// dispatch starts the queued builds as build slots free up
+func (s *BuildService) dispatch() {
+	for {
+		queued := s.queue.next()
+		s.lock.Lock()
+		build, ok := s.builds[queued.ID]
+		s.lock.Unlock()
+		if !ok {
+			s.queue.done(queued)
+			continue
+		}
+		go func() {
+			defer s.queue.done(queued)
+			s.run(build, queued)
+		}()
+	}
+}
+
+// run builds the function of a queued build
+func (s *BuildService) run(build *serviceBuild, queued *queuedBuild) {
+	request := queued.Request
+	function := &common.Function{}
+	opts := &BuildOptions{WithMLRun: request.WithMLRun == nil || *request.WithMLRun, Secrets: request.Secrets}
+	if len(request.DockerConfig) > 0 {
+		opts.DockerConfig = request.DockerConfig
+	}
+
+	build.lock.Lock()
+	build.state = BuildRunning
+	build.lock.Unlock()
+	var out io.Writer = build
+	if s.opts.DBPath != "" {
+		log := NewRemoteLog(s.opts.DBPath, s.opts.DBToken, queued.Project, "build-"+build.id)
+		defer log.Close()
+		out = io.MultiWriter(build, log)
+	}
+	var result *BuildResult
+	err := json.Unmarshal(request.Function, function)
+	if err == nil {
+		result, err = BuildFunction(function, s.cfg, opts, out)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Build failed: %s\n", err)
+	}
+
+	build.lock.Lock()
+	defer build.lock.Unlock()
+	build.state, build.finished = BuildReady, time.Now()
+	if result != nil {
+		build.image, build.provenance, build.scan = result.Image, result.Provenance, result.Scan
+		build.signature = result.Signature
+	}
+	if err != nil {
+		fmt.Printf("Build %s of function %s failed: %s\n", build.id, function.Metadata.Name, err)
+		build.state, build.err = BuildError, err.Error()
+	}
+}
+
+// prune forgets builds that finished more than serviceRetention ago, the
+// service lock is held
+func (s *BuildService) prune() {
+	for id, build := range s.builds {
+		build.lock.Lock()
+		expired := !build.finished.IsZero() && time.Since(build.finished) > serviceRetention
+		build.lock.Unlock()
+		if expired {
+			delete(s.builds, id)
+		}
+	}
+}
+
+// statusHandler returns the build state, image and the log from offset
+func (s *BuildService) statusHandler(ctx *fasthttp.RequestCtx) {
+	id, _ := ctx.UserValue("id").(string)
+	s.lock.Lock()
+	build, ok := s.builds[id]
+	s.lock.Unlock()
+	if !ok {
+		api.WriteError(ctx, http.StatusNotFound, fmt.Errorf("Build %s not found", id))
+		return
+	}
+	offset := ctx.QueryArgs().GetUintOrZero("offset")
+
+	build.lock.Lock()
+	defer build.lock.Unlock()
+	log := build.log.Bytes()
+	if offset > len(log) {
+		offset = len(log)
+	}
+	status := map[string]interface{}{
+		"id":      build.id,
+		"state":   build.state,
+		"created": build.created.UTC().Format(time.RFC3339),
+		"log":     string(log[offset:]),
+	}
+	if build.image != "" {
+		status["image"] = build.image
+	}
+	if build.err != "" {
+		status["error"] = build.err
+	}
+	if build.provenance != nil {
+		status["provenance"] = build.provenance
+	}
+	if build.scan != nil {
+		status["scan"] = build.scan
+	}
+	if build.signature != nil {
+		status["signature"] = build.signature
+	}
+	// Builds that start before this one, for pending builds
+	if build.state == BuildPending {
+		status["position"] = s.queue.position(build.id)
+	}
+	if !build.finished.IsZero() {
+		status["finished"] = build.finished.UTC().Format(time.RFC3339)
+	}
+	writeServiceJSON(ctx, status)
+}
+
+func writeServiceJSON(ctx *fasthttp.RequestCtx, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		api.WriteError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
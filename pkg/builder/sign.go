@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const defaultSigner = "cosign"
+
+// Signature is the cosign signature of a pushed image, recorded with the build
+type Signature struct {
+	Image string `json:"image"`
+	// The signature image cosign pushed next to the signed one
+	Reference string `json:"reference"`
+	Key       string `json:"key"`
+}
+
+// signatureReference returns where cosign stores the signature of an image
+// pinned to its digest, repo:sha256-<hex>.sig
+func signatureReference(image string) (string, error) {
+	parts := strings.SplitN(image, "@", 2)
+	if len(parts) != 2 || !strings.Contains(parts[1], ":") {
+		return "", fmt.Errorf("Images are signed by digest, the digest of %s is unknown", image)
+	}
+	return parts[0] + ":" + strings.Replace(parts[1], ":", "-", 1) + ".sig", nil
+}
+
+// SignImage signs a pushed image with cosign and key, a key file, a KMS URI
+// (e.g. awskms:///alias/mlrun) or env://VAR. The labels tracing the image to
+// its function are added as signature annotations, the key password is read
+// by cosign from COSIGN_PASSWORD
+func SignImage(signer, key, image string, labels map[string]string, env []string, out io.Writer) (*Signature, error) {
+	reference, err := signatureReference(image)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"sign", "--yes", "--key", key}
+	for _, label := range []string{LabelProject, LabelFunction, LabelTag, LabelSource, LabelRevision} {
+		if value := labels[label]; value != "" {
+			args = append(args, "-a", label+"="+value)
+		}
+	}
+	cmd := exec.Command(setFrom(signer, defaultSigner), append(args, image)...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Image signing failed: %s", err)
+	}
+	return &Signature{Image: image, Reference: reference, Key: key}, nil
+}
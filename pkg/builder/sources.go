@@ -21,6 +21,7 @@ package builder
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/nuclio/logger"
 	"github.com/v3io/xcp/backends"
@@ -28,19 +29,42 @@ import (
 	"github.com/v3io/xcp/operators"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
 func GetSourceRepo(cfg *SourceConfig) (SourceRepo, error) {
-	cfg.logger, _ = common.NewLogger("info")
+	if cfg.Out != nil {
+		cfg.logger = &writerLogger{out: cfg.Out}
+	} else {
+		cfg.logger, _ = common.NewLogger("info")
+	}
+	if cfg.Remote && !strings.Contains(cfg.Source, "://") && !scpLikeURL.MatchString(cfg.Source) {
+		return nil, fmt.Errorf("Local source %s is not accepted, use a remote source", cfg.Source)
+	}
+	if cfg.serverCredentials() {
+		cfg.Token = setFrom(cfg.Token, os.Getenv(SourceTokenEnv))
+	}
+	if scpLikeURL.MatchString(cfg.Source) {
+		return newSSHGitSource(cfg)
+	}
 	if !strings.Contains(cfg.Source, "://") {
+		if archiveSuffix(cfg.Source) != "" {
+			return newArchiveSource(cfg)
+		}
 		return NewFileSource(cfg)
 	}
 
@@ -56,14 +80,21 @@ func GetSourceRepo(cfg *SourceConfig) (SourceRepo, error) {
 	if u.User.Username() != "" {
 		cfg.User = u.User.Username()
 	}
+	if archiveSuffix(cfg.Source) != "" {
+		return newArchiveSource(cfg)
+	}
 
 	switch strings.ToLower(u.Scheme) {
 	case "git":
 		return NewGitSource(u, cfg)
+	case "ssh", "git+ssh":
+		return newSSHGitSource(cfg)
 	case "s3", "v3io", "v3ios":
 		return newXcpSource(u, cfg)
+	case "http", "https":
+		return newHTTPSource(u, cfg)
 	default:
-		return nil, fmt.Errorf("Unknown backend (%s) use s3, v3io or git", u.Scheme)
+		return nil, fmt.Errorf("Unknown backend (%s) use s3, v3io, git or http(s)", u.Scheme)
 	}
 }
 
@@ -72,7 +103,66 @@ type SourceConfig struct {
 	LocalPath string
 	User      string
 	Password  string
-	logger    logger.Logger
+	// Bearer token of http(s) sources (default: the SourceTokenEnv variable)
+	Token string
+	// Private key (PEM) of ssh git sources, or its path (default: the SSH env
+	// variables, then ~/.ssh/id_rsa), host keys are checked against the
+	// known_hosts files unless InsecureHostKey is set
+	SSHKey          string
+	SSHKeyPath      string
+	InsecureHostKey bool
+	// .dockerignore style patterns of the paths copied from local and object
+	// store sources (default: all) and of those not copied
+	Include []string
+	Exclude []string
+	// Object store (s3, v3io) copy workers (default: 8) and the modification
+	// time objects are copied since (default: all)
+	Workers int
+	Since   time.Time
+	// Directory caching git clones (fetched on reuse) and object store copies
+	// (synced since their last copy) across builds, no cache when empty
+	CacheDir string
+	// Remote downloads are retried Retries times, waiting RetryDelay (default:
+	// 1s) doubled on each attempt. Interrupted http(s) downloads are resumed
+	// when the server supports ranges
+	Retries    int
+	RetryDelay time.Duration
+	// Download progress output (default: stdout)
+	Out    io.Writer
+	logger logger.Logger
+	// Remote is set for the sources of remote callers: local paths are refused
+	// and the server token and ssh key (SourceTokenEnv, the SSH env variables
+	// and ~/.ssh) are only sent to the CredentialHosts
+	Remote          bool
+	CredentialHosts []string
+}
+
+// serverCredentials tells if the token and ssh key of the server may be sent
+// to the source host
+func (cfg *SourceConfig) serverCredentials() bool {
+	if !cfg.Remote {
+		return true
+	}
+	host := sourceHost(cfg.Source)
+	for _, allowed := range cfg.CredentialHosts {
+		if host != "" && strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceHost returns the host of a remote source, git@host:repo included
+func sourceHost(source string) string {
+	if scpLikeURL.MatchString(source) {
+		host := source[strings.Index(source, "@")+1:]
+		return host[:strings.Index(host, ":")]
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
 }
 
 type SourceRepo interface {
@@ -89,20 +179,105 @@ func setFrom(a, b string) string {
 
 type FileSource struct {
 	fullpath string
+	cfg      *SourceConfig
 }
 
 func NewFileSource(cfg *SourceConfig) (SourceRepo, error) {
-	return &FileSource{fullpath: cfg.Source}, nil
+	return &FileSource{fullpath: cfg.Source, cfg: cfg}, nil
 }
 
 func (s *FileSource) CodePath() string {
-	return s.fullpath
+	if s.cfg.LocalPath == "" {
+		return s.fullpath
+	}
+	return s.cfg.LocalPath
 }
 
+// Download copies the source file or directory into LocalPath, skipping the
+// default ignored and Exclude paths. Symbolic links inside the source are
+// kept, those pointing outside it are copied as their target
 func (s *FileSource) Download() error {
-	return nil //TBD
+	if s.cfg.LocalPath == "" || filepath.Clean(s.cfg.LocalPath) == filepath.Clean(s.fullpath) {
+		return nil
+	}
+	info, err := os.Stat(s.fullpath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(s.fullpath, filepath.Join(s.cfg.LocalPath, filepath.Base(s.fullpath)), info.Mode())
+	}
+
+	root := filepath.Clean(s.fullpath)
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	excluded := append(append([]string{}, defaultIgnore...), s.cfg.Exclude...)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		// LocalPath may be under the source
+		if path == filepath.Clean(s.cfg.LocalPath) {
+			return filepath.SkipDir
+		}
+		for _, pattern := range excluded {
+			if ignoreMatch(pattern, filepath.ToSlash(name)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if len(s.cfg.Include) > 0 && !info.IsDir() && !matchesPath(s.cfg.Include, filepath.ToSlash(name), true) {
+			return nil
+		}
+		target := filepath.Join(s.cfg.LocalPath, name)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if linkInside(resolvedRoot, filepath.Join(resolvedRoot, name), link) {
+				if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return err
+				}
+				return os.Symlink(link, target)
+			}
+			// Links out of the source would dangle in the build context
+			if info, err = os.Stat(path); err != nil {
+				return fmt.Errorf("Broken link %s: %s", path, err)
+			}
+			if info.IsDir() {
+				return fmt.Errorf("Link %s points to a directory outside the source", path)
+			}
+			return copyFile(path, target, info.Mode())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, mode, in)
 }
 
+const defaultXcpWorkers = 8
+
 type xcpSource struct {
 	cfg     *SourceConfig
 	lsTask  *backends.ListDirTask
@@ -115,13 +290,21 @@ func newXcpSource(u *url.URL, cfg *SourceConfig) (SourceRepo, error) {
 		return nil, err
 	}
 
-	newXcpSource := xcpSource{cfg: cfg, workers: 8}
+	newXcpSource := xcpSource{cfg: cfg, workers: defaultXcpWorkers}
+	if cfg.Workers > 0 {
+		newXcpSource.workers = cfg.Workers
+	}
 	newXcpSource.lsTask = &backends.ListDirTask{
 		Source:    src,
-		Since:     time.Time{},
+		Since:     cfg.Since,
 		Recursive: true,
 		InclEmpty: true,
 	}
+	// xcp filters object names with a single glob, other patterns are applied
+	// to the copy
+	if len(cfg.Include) == 1 && !strings.Contains(cfg.Include[0], "/") {
+		newXcpSource.lsTask.Filter = cfg.Include[0]
+	}
 
 	return &newXcpSource, nil
 }
@@ -131,9 +314,118 @@ func (s *xcpSource) CodePath() string {
 }
 
 func (s *xcpSource) Download() error {
+	if s.cfg.CacheDir != "" {
+		return s.downloadCached()
+	}
 	dst, _ := common.UrlParse(s.cfg.LocalPath, true)
-	err := operators.CopyDir(s.lsTask, dst, s.cfg.logger, s.workers)
-	return err
+	err := retryDownload(s.cfg, s.cfg.Source, func() error {
+		return operators.CopyDir(s.lsTask, dst, s.cfg.logger, s.workers)
+	})
+	if err != nil {
+		return err
+	}
+	return pruneCopied(s.cfg.LocalPath, s.cfg.Include, s.cfg.Exclude)
+}
+
+// pruneCopied removes the paths under dir that match an exclude pattern, and
+// with include patterns the files not matching one (or under a directory
+// matching one)
+func pruneCopied(dir string, include, exclude []string) error {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	root := filepath.Clean(dir)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if matchesPath(exclude, name, false) {
+			if err = os.RemoveAll(path); err == nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if len(include) > 0 && !info.IsDir() && !matchesPath(include, name, true) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// matchesPath reports if name matches one of the patterns, or with parents
+// one of its parent directories does
+func matchesPath(patterns []string, name string, parents bool) bool {
+	for _, pattern := range patterns {
+		if ignoreMatch(pattern, name) {
+			return true
+		}
+	}
+	if parents && strings.Contains(name, "/") {
+		return matchesPath(patterns, path.Dir(name), true)
+	}
+	return false
+}
+
+// DownloadFile fetches the single file at cfg.Source, an http(s), s3 or v3io
+// URL or a local path, into LocalPath and returns its local path
+func DownloadFile(cfg *SourceConfig) (string, error) {
+	if !strings.Contains(cfg.Source, "://") {
+		_, err := os.Stat(cfg.Source)
+		return cfg.Source, err
+	}
+	u, err := url.Parse(cfg.Source)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("URL %s does not name a file", cfg.Source)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.User != nil {
+			cfg.User = u.User.Username()
+			cfg.Password, _ = u.User.Password()
+		}
+		repo, err := newHTTPSource(u, cfg)
+		if err != nil {
+			return "", err
+		}
+		if err = repo.Download(); err != nil {
+			return "", err
+		}
+	case "s3", "v3io", "v3ios":
+		// The parent directory is listed for the file name alone
+		dir := *u
+		dir.Path = path.Dir(u.Path) + "/"
+		src, err := common.UrlParse(dir.String(), true)
+		if err != nil {
+			return "", err
+		}
+		if cfg.logger == nil {
+			cfg.logger = &writerLogger{out: cfg.out()}
+		}
+		task := backends.ListDirTask{Source: src, Filter: name}
+		dst, _ := common.UrlParse(cfg.LocalPath, true)
+		err = retryDownload(cfg, cfg.Source, func() error {
+			return operators.CopyDir(&task, dst, cfg.logger, 1)
+		})
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("Unknown backend (%s) use s3, v3io or http(s)", u.Scheme)
+	}
+	localPath := filepath.Join(cfg.LocalPath, name)
+	if _, err = os.Stat(localPath); err != nil {
+		return "", fmt.Errorf("File %s not found", cfg.Source)
+	}
+	return localPath, nil
 }
 
 type GitSource struct {
@@ -142,12 +434,24 @@ type GitSource struct {
 	branch   string
 	subpath  string
 	codePath string
+	ssh      bool
+	commit   string
 }
 
 func NewGitSource(u *url.URL, cfg *SourceConfig) (SourceRepo, error) {
 	g := GitSource{url: "https://" + u.Host + u.Path, cfg: cfg}
-	g.branch = u.Fragment
-	ss := strings.Split(u.Fragment, ":")
+	g.setFragment(u.Fragment)
+	return &g, nil
+}
+
+// commitSHA matches full commit hashes in source fragments
+var commitSHA = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// setFragment sets the reference and sub path of a #ref:subpath fragment, the
+// reference is a branch name, a full ref (e.g. refs/tags/v1.2) or a commit SHA
+func (g *GitSource) setFragment(fragment string) {
+	g.branch = fragment
+	ss := strings.Split(fragment, ":")
 	if len(ss) > 1 {
 		g.branch = ss[0]
 		g.subpath = ss[1]
@@ -155,46 +459,184 @@ func NewGitSource(u *url.URL, cfg *SourceConfig) (SourceRepo, error) {
 	if g.branch == "" {
 		g.branch = "master"
 	}
-	return &g, nil
 }
 
 func (g *GitSource) CodePath() string {
 	return g.codePath
 }
 
+// referenceName returns the ref the source is cloned from
+func (g *GitSource) referenceName() plumbing.ReferenceName {
+	if strings.HasPrefix(g.branch, "refs/") {
+		return plumbing.ReferenceName(g.branch)
+	}
+	return plumbing.NewBranchReferenceName(g.branch)
+}
+
 func (g *GitSource) Download() error {
 	opts := git.CloneOptions{
 		URL:           g.url,
 		Depth:         1,
-		ReferenceName: plumbing.ReferenceName("refs/heads/" + g.branch),
+		ReferenceName: g.referenceName(),
 		SingleBranch:  true,
 		Tags:          git.NoTags,
-		Progress:      os.Stdout,
+		Progress:      g.cfg.out(),
+	}
+	// Commits can't be fetched by hash, the full history is cloned and the
+	// commit checked out
+	sha := commitSHA.MatchString(g.branch)
+	if sha {
+		opts.Depth = 0
+		opts.ReferenceName = ""
+		opts.SingleBranch = false
+		opts.NoCheckout = true
+	}
+	// Only the sub path is written to the work tree, not the whole repo
+	if g.subpath != "" {
+		opts.NoCheckout = true
 	}
 
-	if g.cfg.Password != "" {
-		opts.Auth = &githttp.BasicAuth{Username: g.cfg.User, Password: g.cfg.Password}
+	auth, err := g.auth()
+	if err != nil {
+		return err
 	}
+	opts.Auth = auth
 	g.codePath = filepath.Join(g.cfg.LocalPath, g.subpath)
-	r, err := git.PlainClone(g.cfg.LocalPath, false, &opts)
+	if g.cfg.CacheDir != "" {
+		return g.downloadCached(auth)
+	}
+	// Failed clones are cleaned up by go-git, retries start over
+	var r *git.Repository
+	err = retryDownload(g.cfg, g.url, func() error {
+		var err error
+		r, err = git.PlainClone(g.cfg.LocalPath, false, &opts)
+		return gitError(err)
+	})
 	if err != nil {
 		return err
 	}
 	ref, err := r.Head()
-	fmt.Printf("cloned repo %s, %s\n", ref.Name(), ref.Hash())
-	return err
+	if err != nil {
+		return err
+	}
+	hash := ref.Hash()
+	if sha {
+		hash = plumbing.NewHash(g.branch)
+	}
+	switch {
+	case g.subpath != "":
+		err = checkoutSubpath(r, hash, g.subpath, g.codePath)
+	case sha:
+		var worktree *git.Worktree
+		if worktree, err = r.Worktree(); err == nil {
+			err = worktree.Checkout(&git.CheckoutOptions{Hash: hash})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to checkout %s of commit %s: %s", setFrom(g.subpath, "/"), hash, err)
+	}
+	g.commit = hash.String()
+	fmt.Fprintf(g.cfg.out(), "cloned repo %s, %s\n", ref.Name(), hash)
+	return nil
+}
+
+// auth returns the ssh key or basic auth of the repo, if any
+func (g *GitSource) auth() (transport.AuthMethod, error) {
+	if g.ssh {
+		auth, err := sshAuth(g.cfg, g.url)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	if g.cfg.Password != "" {
+		return &githttp.BasicAuth{Username: g.cfg.User, Password: g.cfg.Password}, nil
+	}
+	return nil, nil
+}
+
+// checkoutSubpath writes the files under subpath in the commit tree to dir
+func checkoutSubpath(r *git.Repository, hash plumbing.Hash, subpath, dir string) error {
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if subpath = strings.Trim(subpath, "/"); subpath != "" {
+		if tree, err = tree.Tree(subpath); err != nil {
+			return fmt.Errorf("Sub path %s not found: %s", subpath, err)
+		}
+	}
+	return tree.Files().ForEach(func(file *object.File) error {
+		path := filepath.Join(dir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if file.Mode == filemode.Symlink {
+			target, err := file.Contents()
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, path)
+		}
+		perm := os.FileMode(0644)
+		if file.Mode == filemode.Executable {
+			perm = 0755
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(out, reader); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
 }
 
-// NewService initializes a new service.
-func NewService() {
+// sourceTLSConfig verifies the https git and http(s) source servers, set by
+// InstallSourceTLS
+var sourceTLSConfig = &tls.Config{}
+
+// InstallSourceTLS configures the verification of https git and http(s) source
+// servers, against the system roots and the PEM certificates in caFile when
+// set. Verification is skipped when insecure
+func InstallSourceTLS(insecure bool, caFile string) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	sourceTLSConfig = tlsConfig
+
 	httpsCli := &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
 		},
 		Timeout: 300 * time.Second,
 	}
-
 	client.InstallProtocol("https", githttp.NewClient(httpsCli))
+	return nil
 }
 
 func SplitUrl(url string) (path, branch, subdir string) {
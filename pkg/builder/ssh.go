@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package builder
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Environment defaults of the ssh git source configuration, the key may be
+// injected from a project secret
+const (
+	SSHKeyEnv           = "MLRUN_GIT_SSH_KEY"
+	SSHKeyPathEnv       = "MLRUN_GIT_SSH_KEY_PATH"
+	SSHKeyPassphraseEnv = "MLRUN_GIT_SSH_KEY_PASSPHRASE"
+	SSHInsecureEnv      = "MLRUN_GIT_SSH_INSECURE"
+)
+
+// scpLikeURL matches git@host:org/repo.git sources
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/]`)
+
+// newSSHGitSource returns a git source cloned over ssh, from a scp like or
+// ssh:// URL with an optional #branch:subpath fragment
+func newSSHGitSource(cfg *SourceConfig) (SourceRepo, error) {
+	source, fragment := cfg.Source, ""
+	if i := strings.Index(source, "#"); i >= 0 {
+		source, fragment = source[:i], source[i+1:]
+	}
+	source = strings.Replace(source, "git+ssh://", "ssh://", 1)
+	g := GitSource{url: source, cfg: cfg, ssh: true}
+	g.setFragment(fragment)
+	return &g, nil
+}
+
+// sshUser returns the user of an ssh git URL, git by default
+func sshUser(repoURL string) string {
+	if strings.HasPrefix(repoURL, "ssh://") {
+		if u, err := url.Parse(repoURL); err == nil && u.User != nil && u.User.Username() != "" {
+			return u.User.Username()
+		}
+		return "git"
+	}
+	if i := strings.Index(repoURL, "@"); i > 0 {
+		return repoURL[:i]
+	}
+	return "git"
+}
+
+// sshAuth returns the public key auth of an ssh git source
+func sshAuth(cfg *SourceConfig, repoURL string) (*gitssh.PublicKeys, error) {
+	user := sshUser(repoURL)
+	if cfg.SSHKey == "" && !cfg.serverCredentials() {
+		return nil, fmt.Errorf("Source %s needs an ssh key, the server key is not used for its host", cfg.Source)
+	}
+	passphrase := os.Getenv(SSHKeyPassphraseEnv)
+	key := setFrom(cfg.SSHKey, os.Getenv(SSHKeyEnv))
+	var auth *gitssh.PublicKeys
+	var err error
+	if key != "" {
+		auth, err = gitssh.NewPublicKeys(user, []byte(key), passphrase)
+	} else {
+		keyPath := setFrom(cfg.SSHKeyPath, os.Getenv(SSHKeyPathEnv))
+		if keyPath == "" {
+			home, _ := os.UserHomeDir()
+			keyPath = filepath.Join(home, ".ssh", "id_rsa")
+		}
+		auth, err = gitssh.NewPublicKeysFromFile(user, keyPath, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the ssh key: %s", err)
+	}
+
+	if cfg.InsecureHostKey || os.Getenv(SSHInsecureEnv) == "true" {
+		auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return auth, nil
+	}
+	// SSH_KNOWN_HOSTS, then ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts
+	if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(); err != nil {
+		return nil, fmt.Errorf("Failed to load known hosts (set %s=true to skip host key checks): %s", SSHInsecureEnv, err)
+	}
+	return auth, nil
+}
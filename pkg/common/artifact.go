@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+// Artifact is an mlrun artifact object, stored by the db under its key and
+// the tree (run uid) or tag. Fields the controller does not use are not
+// modelled
+type Artifact struct {
+	Kind    string `json:"kind,omitempty"`
+	Key     string `json:"key"`
+	Project string `json:"project,omitempty"`
+	// Tree is the uid of the run producing the artifact
+	Tree        string            `json:"tree,omitempty"`
+	Iter        int               `json:"iter,omitempty"`
+	Tag         string            `json:"tag,omitempty"`
+	SrcPath     string            `json:"src_path,omitempty"`
+	TargetPath  string            `json:"target_path,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	Size        int64             `json:"size,omitempty"`
+	Format      string            `json:"format,omitempty"`
+	Viewer      string            `json:"viewer,omitempty"`
+	Description string            `json:"description,omitempty"`
+	DBKey       string            `json:"db_key,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Producer    *ArtifactProducer `json:"producer,omitempty"`
+	Sources     []ArtifactSource  `json:"sources,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+}
+
+// ArtifactProducer is the run or API producing an artifact
+type ArtifactProducer struct {
+	Kind     string `json:"kind,omitempty"`
+	Name     string `json:"name,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Workflow string `json:"workflow,omitempty"`
+}
+
+type ArtifactSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// HashFunction returns the version hash of a function in JSON form, the hex
+// SHA-256 of its canonical JSON (sorted keys, no spaces) without its status,
+// tag and times, which change without changing what runs
+func HashFunction(data []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	function := map[string]interface{}{}
+	if err := decoder.Decode(&function); err != nil {
+		return "", fmt.Errorf("Bad function JSON: %s", err)
+	}
+	delete(function, "status")
+	for _, key := range []string{"metadata", "Metadata"} {
+		if metadata, ok := function[key].(map[string]interface{}); ok {
+			for _, field := range []string{"tag", "created", "updated"} {
+				delete(metadata, field)
+			}
+		}
+	}
+	// Maps are marshalled with sorted keys
+	canonical, err := json.Marshal(function)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Hash returns the version hash of the function, see HashFunction
+func (f *Function) Hash() (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return HashFunction(data)
+}
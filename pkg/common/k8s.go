@@ -0,0 +1,396 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The Kubernetes core/v1 structures of function specs, with the JSON names of
+// the Kubernetes API so specs pass through to pods as they are
+
+type EnvVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+type EnvVarSource struct {
+	SecretKeyRef     *KeySelector           `json:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef  *KeySelector           `json:"configMapKeyRef,omitempty"`
+	FieldRef         *FieldSelector         `json:"fieldRef,omitempty"`
+	ResourceFieldRef *ResourceFieldSelector `json:"resourceFieldRef,omitempty"`
+}
+
+// KeySelector selects a key of a secret or config map
+type KeySelector struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Optional *bool  `json:"optional,omitempty"`
+}
+
+type FieldSelector struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	FieldPath  string `json:"fieldPath"`
+}
+
+type ResourceFieldSelector struct {
+	ContainerName string `json:"containerName,omitempty"`
+	Resource      string `json:"resource"`
+	Divisor       string `json:"divisor,omitempty"`
+}
+
+type VolumeMount struct {
+	Name             string `json:"name"`
+	MountPath        string `json:"mountPath"`
+	SubPath          string `json:"subPath,omitempty"`
+	SubPathExpr      string `json:"subPathExpr,omitempty"`
+	ReadOnly         bool   `json:"readOnly,omitempty"`
+	MountPropagation string `json:"mountPropagation,omitempty"`
+}
+
+// Volume has the common volume sources as fields, other sources (e.g. nfs or
+// csi) are kept as JSON in Other
+type Volume struct {
+	Name                  string                             `json:"name"`
+	HostPath              *HostPathVolumeSource              `json:"hostPath,omitempty"`
+	EmptyDir              *EmptyDirVolumeSource              `json:"emptyDir,omitempty"`
+	Secret                *SecretVolumeSource                `json:"secret,omitempty"`
+	ConfigMap             *ConfigMapVolumeSource             `json:"configMap,omitempty"`
+	PersistentVolumeClaim *PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+	// v3io fuse mounts are flex volumes
+	FlexVolume *FlexVolumeSource          `json:"flexVolume,omitempty"`
+	Other      map[string]json.RawMessage `json:"-"`
+}
+
+type HostPathVolumeSource struct {
+	Path string `json:"path"`
+	Type string `json:"type,omitempty"`
+}
+
+type EmptyDirVolumeSource struct {
+	Medium    string `json:"medium,omitempty"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+}
+
+type KeyToPath struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+	Mode *int32 `json:"mode,omitempty"`
+}
+
+type SecretVolumeSource struct {
+	SecretName  string      `json:"secretName"`
+	Items       []KeyToPath `json:"items,omitempty"`
+	DefaultMode *int32      `json:"defaultMode,omitempty"`
+	Optional    *bool       `json:"optional,omitempty"`
+}
+
+type ConfigMapVolumeSource struct {
+	Name        string      `json:"name"`
+	Items       []KeyToPath `json:"items,omitempty"`
+	DefaultMode *int32      `json:"defaultMode,omitempty"`
+	Optional    *bool       `json:"optional,omitempty"`
+}
+
+type PersistentVolumeClaimVolumeSource struct {
+	ClaimName string `json:"claimName"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+type FlexVolumeSource struct {
+	Driver    string            `json:"driver"`
+	FSType    string            `json:"fsType,omitempty"`
+	SecretRef *LocalObjectRef   `json:"secretRef,omitempty"`
+	ReadOnly  bool              `json:"readOnly,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+}
+
+type LocalObjectRef struct {
+	Name string `json:"name"`
+}
+
+// volumeFields are the Volume JSON fields that are not in Other
+var volumeFields = map[string]bool{"name": true, "hostPath": true, "emptyDir": true, "secret": true,
+	"configMap": true, "persistentVolumeClaim": true, "flexVolume": true}
+
+// volumeJSON has the fields of Volume without its JSON methods
+type volumeJSON Volume
+
+func (v *Volume) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	volume := volumeJSON{}
+	if err := json.Unmarshal(data, &volume); err != nil {
+		return err
+	}
+	volume.Other = otherFields(fields, volumeFields)
+	*v = Volume(volume)
+	return nil
+}
+
+func (v Volume) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(volumeJSON(v))
+	if err != nil {
+		return nil, err
+	}
+	return withOtherFields(data, v.Other, volumeFields)
+}
+
+// sources returns the names of the volume sources that are set
+func (v *Volume) sources() []string {
+	var sources []string
+	for name, set := range map[string]bool{"hostPath": v.HostPath != nil, "emptyDir": v.EmptyDir != nil,
+		"secret": v.Secret != nil, "configMap": v.ConfigMap != nil,
+		"persistentVolumeClaim": v.PersistentVolumeClaim != nil, "flexVolume": v.FlexVolume != nil} {
+		if set {
+			sources = append(sources, name)
+		}
+	}
+	for name := range v.Other {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// ResourceRequirements are quantities by resource name, e.g. cpu: 500m
+type ResourceRequirements struct {
+	Limits   map[string]string `json:"limits,omitempty"`
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+// Request returns the request of a resource, or its limit as Kubernetes does
+// with no request
+func (r *ResourceRequirements) Request(resource string) string {
+	if r == nil {
+		return ""
+	}
+	if request := r.Requests[resource]; request != "" {
+		return request
+	}
+	return r.Limits[resource]
+}
+
+// mergeByKey merges a list by key: the indexes of the elements kept, one per
+// key in the order the keys first appear, each the last element with its key
+func mergeByKey(length int, key func(i int) string) []int {
+	kept := make([]int, 0, length)
+	index := map[string]int{}
+	for i := 0; i < length; i++ {
+		if at, ok := index[key(i)]; ok {
+			kept[at] = i
+			continue
+		}
+		index[key(i)] = len(kept)
+		kept = append(kept, i)
+	}
+	return kept
+}
+
+// MergeEnv returns the env vars of one with those of two set over them by
+// name, new ones are appended in their two order
+func MergeEnv(one, two []EnvVar) []EnvVar {
+	if len(two) == 0 {
+		return one
+	}
+	all := append(append([]EnvVar{}, one...), two...)
+	merged := make([]EnvVar, 0, len(all))
+	for _, i := range mergeByKey(len(all), func(i int) string { return all[i].Name }) {
+		merged = append(merged, all[i])
+	}
+	return merged
+}
+
+// MergeVolumes returns the volumes of one with those of two replacing them
+// by name
+func MergeVolumes(one, two []Volume) []Volume {
+	if len(two) == 0 {
+		return one
+	}
+	all := append(append([]Volume{}, one...), two...)
+	merged := make([]Volume, 0, len(all))
+	for _, i := range mergeByKey(len(all), func(i int) string { return all[i].Name }) {
+		merged = append(merged, all[i])
+	}
+	return merged
+}
+
+// MergeVolumeMounts returns the mounts of one with those of two replacing
+// them by mount path, a path has one mount
+func MergeVolumeMounts(one, two []VolumeMount) []VolumeMount {
+	if len(two) == 0 {
+		return one
+	}
+	all := append(append([]VolumeMount{}, one...), two...)
+	merged := make([]VolumeMount, 0, len(all))
+	for _, i := range mergeByKey(len(all), func(i int) string { return all[i].MountPath }) {
+		merged = append(merged, all[i])
+	}
+	return merged
+}
+
+// EnvFromMap converts name to value maps to env vars, sorted by name
+func EnvFromMap(values map[string]string) []EnvVar {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]EnvVar, 0, len(names))
+	for _, name := range names {
+		env = append(env, EnvVar{Name: name, Value: values[name]})
+	}
+	return env
+}
+
+// EnvMap converts the env vars with values to a name to value map, those
+// referencing secrets or fields are left out
+func EnvMap(env []EnvVar) map[string]string {
+	values := map[string]string{}
+	for _, envVar := range env {
+		if envVar.ValueFrom == nil {
+			values[envVar.Name] = envVar.Value
+		}
+	}
+	return values
+}
+
+// SecretRefs returns the names of the Kubernetes secrets the function pods
+// reference, in env vars, volume sources and the image pull secret
+func (s *FunctionSpec) SecretRefs() []string {
+	var names []string
+	for _, envVar := range s.Env {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+			names = append(names, envVar.ValueFrom.SecretKeyRef.Name)
+		}
+	}
+	for _, volume := range s.Volumes {
+		if volume.Secret != nil {
+			names = append(names, volume.Secret.SecretName)
+		}
+		if volume.FlexVolume != nil && volume.FlexVolume.SecretRef != nil {
+			names = append(names, volume.FlexVolume.SecretRef.Name)
+		}
+		for _, source := range volume.Other {
+			var value interface{}
+			if json.Unmarshal(source, &value) == nil {
+				names = jsonSecretRefs(value, names)
+			}
+		}
+	}
+	if s.Build.Secret != "" {
+		names = append(names, s.Build.Secret)
+	}
+	return names
+}
+
+// jsonSecretRefs adds the secrets referenced in other volume sources, by
+// *secretRef objects (e.g. csi nodePublishSecretRef), secretName fields and
+// the secret sources of projected volumes
+func jsonSecretRefs(value interface{}, names []string) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			object, _ := field.(map[string]interface{})
+			switch {
+			case key == "secretName":
+				if name, ok := field.(string); ok {
+					names = append(names, name)
+				}
+			case (key == "secret" || strings.HasSuffix(strings.ToLower(key), "secretref")) && object != nil:
+				if name, ok := object["name"].(string); ok {
+					names = append(names, name)
+				}
+			}
+			names = jsonSecretRefs(field, names)
+		}
+	case []interface{}:
+		for _, item := range value {
+			names = jsonSecretRefs(item, names)
+		}
+	}
+	return names
+}
+
+var (
+	envVarName   = regexp.MustCompile(`^[-._a-zA-Z][-._a-zA-Z0-9]*$`)
+	resourceName = regexp.MustCompile(`^([a-z0-9.-]+/)?[a-z0-9A-Z._-]+$`)
+	quantity     = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)(m|k|Ki|Mi|Gi|Ti|Pi|Ei|M|G|T|P|E|[eE][+-]?[0-9]+)?$`)
+	volumeName   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// ValidateK8s checks the env, volumes, volume mounts and resources of a
+// function spec as the Kubernetes API would
+func (s *FunctionSpec) ValidateK8s() error {
+	for _, envVar := range s.Env {
+		if !envVarName.MatchString(envVar.Name) {
+			return fmt.Errorf("Bad env var name '%s'", envVar.Name)
+		}
+		if envVar.ValueFrom != nil && envVar.Value != "" {
+			return fmt.Errorf("Env var %s has both a value and a valueFrom", envVar.Name)
+		}
+	}
+	volumes := map[string]bool{}
+	for _, volume := range s.Volumes {
+		if !volumeName.MatchString(volume.Name) || len(volume.Name) > 63 {
+			return fmt.Errorf("Bad volume name '%s'", volume.Name)
+		}
+		if volumes[volume.Name] {
+			return fmt.Errorf("Duplicate volume %s", volume.Name)
+		}
+		volumes[volume.Name] = true
+		if sources := volume.sources(); len(sources) != 1 {
+			return fmt.Errorf("Volume %s must have one source, has %d (%s)", volume.Name, len(sources), strings.Join(sources, ", "))
+		}
+	}
+	mountPaths := map[string]bool{}
+	for _, mount := range s.VolumeMounts {
+		if !volumes[mount.Name] {
+			return fmt.Errorf("Volume mount %s references an unknown volume", mount.Name)
+		}
+		if !strings.HasPrefix(mount.MountPath, "/") {
+			return fmt.Errorf("Volume mount %s path '%s' is not absolute", mount.Name, mount.MountPath)
+		}
+		if mountPaths[mount.MountPath] {
+			return fmt.Errorf("Duplicate mount path %s", mount.MountPath)
+		}
+		mountPaths[mount.MountPath] = true
+	}
+	if s.Resources != nil {
+		for _, quantities := range []map[string]string{s.Resources.Limits, s.Resources.Requests} {
+			for name, value := range quantities {
+				if !resourceName.MatchString(name) {
+					return fmt.Errorf("Bad resource name '%s'", name)
+				}
+				if !quantity.MatchString(value) {
+					return fmt.Errorf("Bad %s quantity '%s'", name, value)
+				}
+			}
+		}
+	}
+	return nil
+}
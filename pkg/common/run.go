@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+import "encoding/json"
+
+// Run is an mlrun run object, as stored by the db and passed to the run pods
+// in MLRUN_EXEC_CONFIG. Fields the controller does not use are not modelled,
+// the db keeps the objects as sent
+type Run struct {
+	Kind     string      `json:"kind,omitempty"`
+	Metadata RunMetadata `json:"metadata"`
+	Spec     RunSpec     `json:"spec"`
+	Status   RunStatus   `json:"status"`
+	// Reviewer notes, kept by the db when the run is stored again
+	Notes []RunNote `json:"notes,omitempty"`
+}
+
+// RunNote is a free text annotation of a run
+type RunNote struct {
+	Text    string `json:"text"`
+	Author  string `json:"author,omitempty"`
+	Created string `json:"created"`
+}
+
+type RunMetadata struct {
+	Name        string            `json:"name,omitempty"`
+	UID         string            `json:"uid,omitempty"`
+	Iteration   int               `json:"iteration,omitempty"`
+	Project     string            `json:"project,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// The run that started this run, e.g. the workflow runner of a step
+	ParentUID string `json:"parent_uid,omitempty"`
+	Workflow  string `json:"workflow,omitempty"`
+}
+
+type RunSpec struct {
+	// Function URI, e.g. db://project/name:tag
+	Function    string                   `json:"function,omitempty"`
+	Handler     string                   `json:"handler,omitempty"`
+	Parameters  map[string]interface{}   `json:"parameters,omitempty"`
+	HyperParams map[string][]interface{} `json:"hyperparams,omitempty"`
+	ParamFile   string                   `json:"param_file,omitempty"`
+	Selector    string                   `json:"selector,omitempty"`
+	Inputs      map[string]string        `json:"inputs,omitempty"`
+	Outputs     []string                 `json:"outputs,omitempty"`
+	OutputPath  string                   `json:"output_path,omitempty"`
+	// Secret sources and data stores are SDK side configuration
+	SecretSources json.RawMessage `json:"secret_sources,omitempty"`
+	DataStores    json.RawMessage `json:"data_stores,omitempty"`
+}
+
+type RunStatus struct {
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+	Host  string `json:"host,omitempty"`
+	// Times in the mlrun layout (2006-01-02 15:04:05.000000) or RFC 3339
+	StartTime  string                 `json:"start_time,omitempty"`
+	LastUpdate string                 `json:"last_update,omitempty"`
+	Results    map[string]interface{} `json:"results,omitempty"`
+	Artifacts  []Artifact             `json:"artifacts,omitempty"`
+	// Results of the child runs of hyper-parameter runs, a table with a header row
+	Iterations [][]interface{} `json:"iterations,omitempty"`
+	UIURL      string          `json:"ui_url,omitempty"`
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of the functions deployed as nuclio functions, not run by the
+// controller but stored and built for the SDK
+const (
+	KindRemote  = "remote"
+	KindNuclio  = "nuclio"
+	KindServing = "serving"
+)
+
+// Graph kinds of serving functions
+const (
+	RouterStep = "router"
+	TaskStep   = "task"
+)
+
+// triggersPrefix is the nuclio config path of the function triggers
+const triggersPrefix = "spec.triggers."
+
+// NuclioTrigger is a nuclio function trigger, e.g. http or v3ioStream
+type NuclioTrigger struct {
+	Kind        string                 `json:"kind"`
+	Name        string                 `json:"name,omitempty"`
+	MaxWorkers  int                    `json:"maxWorkers,omitempty"`
+	URL         string                 `json:"url,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+}
+
+// ServingRoute is a step of a serving graph, routers route requests to their
+// models by name. Fields of other step kinds (e.g. the steps of flows) are
+// kept in Other
+type ServingRoute struct {
+	Kind      string                     `json:"kind,omitempty"`
+	ClassName string                     `json:"class_name,omitempty"`
+	ClassArgs map[string]interface{}     `json:"class_args,omitempty"`
+	Handler   string                     `json:"handler,omitempty"`
+	Routes    map[string]*ServingRoute   `json:"routes,omitempty"`
+	Other     map[string]json.RawMessage `json:"-"`
+}
+
+var servingRouteFields = map[string]bool{"kind": true, "class_name": true, "class_args": true, "handler": true, "routes": true}
+
+type servingRouteJSON ServingRoute
+
+func (r *ServingRoute) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	route := servingRouteJSON{}
+	if err := json.Unmarshal(data, &route); err != nil {
+		return err
+	}
+	route.Other = otherFields(fields, servingRouteFields)
+	*r = ServingRoute(route)
+	return nil
+}
+
+func (r ServingRoute) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(servingRouteJSON(r))
+	if err != nil {
+		return nil, err
+	}
+	return withOtherFields(data, r.Other, servingRouteFields)
+}
+
+// IsNuclio tells if the function kind is deployed as a nuclio function
+func IsNuclio(kind string) bool {
+	return kind == KindRemote || kind == KindNuclio || kind == KindServing
+}
+
+// Triggers returns the triggers of the nuclio config by name
+func (s *FunctionSpec) Triggers() (map[string]*NuclioTrigger, error) {
+	triggers := map[string]*NuclioTrigger{}
+	for key, value := range s.Config {
+		if !strings.HasPrefix(key, triggersPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, triggersPrefix)
+		trigger := &NuclioTrigger{}
+		if err := json.Unmarshal(value, trigger); err != nil {
+			return nil, fmt.Errorf("Bad trigger %s: %s", name, err)
+		}
+		triggers[name] = trigger
+	}
+	return triggers, nil
+}
+
+// Validate checks the function spec for its kind, the Kubernetes structures
+// and replica range of all kinds (dask functions have one too) and the
+// triggers and graph of nuclio functions
+func (f *Function) Validate() error {
+	spec := &f.Spec
+	if err := spec.ValidateK8s(); err != nil {
+		return err
+	}
+	if spec.MinReplicas != nil && *spec.MinReplicas < 0 {
+		return fmt.Errorf("Bad min_replicas %d", *spec.MinReplicas)
+	}
+	if spec.MaxReplicas != nil && *spec.MaxReplicas < 1 {
+		return fmt.Errorf("Bad max_replicas %d", *spec.MaxReplicas)
+	}
+	if spec.MinReplicas != nil && spec.MaxReplicas != nil && *spec.MinReplicas > *spec.MaxReplicas {
+		return fmt.Errorf("min_replicas %d is above max_replicas %d", *spec.MinReplicas, *spec.MaxReplicas)
+	}
+	if spec.Graph != nil && f.Kind != KindServing {
+		return fmt.Errorf("Only serving functions have a graph, not %s functions", setKind(f.Kind))
+	}
+	if !IsNuclio(f.Kind) {
+		return nil
+	}
+	triggers, err := spec.Triggers()
+	if err != nil {
+		return err
+	}
+	for name, trigger := range triggers {
+		if trigger.Kind == "" {
+			return fmt.Errorf("Trigger %s has no kind", name)
+		}
+	}
+	if spec.Graph != nil {
+		return validateRoute("graph", spec.Graph)
+	}
+	return nil
+}
+
+// validateRoute checks that router routes name the model class they serve
+func validateRoute(path string, route *ServingRoute) error {
+	if route.Kind != RouterStep {
+		return nil
+	}
+	names := make([]string, 0, len(route.Routes))
+	for name := range route.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := route.Routes[name]
+		if child == nil {
+			return fmt.Errorf("Route %s.%s is empty", path, name)
+		}
+		if child.Kind != RouterStep && child.ClassName == "" && child.Handler == "" {
+			return fmt.Errorf("Route %s.%s has no class_name or handler", path, name)
+		}
+		if err := validateRoute(path+"."+name, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setKind(kind string) string {
+	if kind == "" {
+		return "job"
+	}
+	return kind
+}
@@ -41,18 +41,47 @@ type FunctionSpec struct {
 	Command     string          `json:"command,omitempty"`
 	Image       string          `json:"image,omitempty"`
 	Mode        string          `json:"mode,omitempty"`
+	Runtime     string          `json:"runtime,omitempty"`
 	Args        []string        `json:"args,omitempty"`
 	Description string          `json:"description,omitempty"`
 	Build       ImageBuilder    `json:"build,omitempty"`
 	EnrtyPoints json.RawMessage `json:"entry_points,omitempty"`
 
-	Env             json.RawMessage `json:"env,omitempty"`
-	Volumes         json.RawMessage `json:"volumes,omitempty"`
-	VolumeMounts    json.RawMessage `json:"volume_mounts,omitempty"`
-	Resources       json.RawMessage `json:"resources,omitempty"`
-	Replicas        int             `json:"replicas,omitempty"`
-	ImagePullPolicy string          `json:"image_pull_policy,omitempty"`
-	ServiceAccount  string          `json:"service_account,omitempty"`
+	// Kubernetes structures of the function pods, see ValidateK8s
+	Env             []EnvVar              `json:"env,omitempty"`
+	Volumes         []Volume              `json:"volumes,omitempty"`
+	VolumeMounts    []VolumeMount         `json:"volume_mounts,omitempty"`
+	Resources       *ResourceRequirements `json:"resources,omitempty"`
+	Replicas        int                   `json:"replicas,omitempty"`
+	ImagePullPolicy string                `json:"image_pull_policy,omitempty"`
+	ServiceAccount  string                `json:"service_account,omitempty"`
+	// Keys of project secrets, injected as environment variables by reference
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Spark functions
+	Deps         json.RawMessage   `json:"deps,omitempty"`
+	SparkConf    map[string]string `json:"spark_conf,omitempty"`
+	SparkVersion string            `json:"spark_version,omitempty"`
+
+	// Nuclio functions (remote, nuclio and serving kinds), the nuclio config is
+	// by nuclio spec path, e.g. spec.triggers.http
+	MinReplicas      *int                       `json:"min_replicas,omitempty"`
+	MaxReplicas      *int                       `json:"max_replicas,omitempty"`
+	Config           map[string]json.RawMessage `json:"config,omitempty"`
+	FunctionKind     string                     `json:"function_kind,omitempty"`
+	FunctionHandler  string                     `json:"function_handler,omitempty"`
+	ReadinessTimeout int                        `json:"readiness_timeout,omitempty"`
+	ServiceType      string                     `json:"service_type,omitempty"`
+	BaseSpec         json.RawMessage            `json:"base_spec,omitempty"`
+
+	// Serving functions, the graph routes requests to the models
+	Graph            *ServingRoute          `json:"graph,omitempty"`
+	DefaultClass     string                 `json:"default_class,omitempty"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+	LoadMode         string                 `json:"load_mode,omitempty"`
+	TrackModels      bool                   `json:"track_models,omitempty"`
+	GraphInitializer string                 `json:"graph_initializer,omitempty"`
+	ErrorStream      string                 `json:"error_stream,omitempty"`
 }
 
 type ImageBuilder struct {
@@ -63,11 +92,54 @@ type ImageBuilder struct {
 	Secret             string   `json:"secret,omitempty"`
 	Source             string   `json:"source,omitempty"`
 	Image              string   `json:"image,omitempty"`
+	// Conda environment.yaml content, for builds from a conda base image
+	CondaEnv string `json:"conda_env,omitempty"`
+	// .dockerignore patterns pruned from the build context, e.g. data dirs
+	Ignore []string `json:"ignore,omitempty"`
+	// Pins verified after the source download, the sha256 of the archive or
+	// file (or of the code tree) and the git commit (or its prefix)
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	SourceCommit string `json:"source_commit,omitempty"`
+	// Build arguments (ARG, also passed to the build engine) and environment
+	// variables (ENV) of the image, e.g. proxy settings
+	Args map[string]string `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+	// Platforms of the image, e.g. linux/amd64 and linux/arm64 for a multi-arch
+	// image (default: the builder default)
+	Platforms []string `json:"platforms,omitempty"`
+	// Directory the code is copied to and run from in the image (default: /run)
+	WorkDir string `json:"workdir,omitempty"`
+	// Command run in the built image before it is pushed, the build fails when
+	// it exits with an error, e.g. python -m pytest tests
+	TestCommand string `json:"test_command,omitempty"`
+	// Vulnerability scan of the pushed image, not scanned when nil
+	Scan *ImageScan `json:"scan,omitempty"`
 }
 
-func MergeMaps(one, two map[string]string) {
+// Actions on images with vulnerabilities at or above the scan severity
+const (
+	ScanFail = "fail"
+	ScanWarn = "warn"
+)
+
+type ImageScan struct {
+	// Threshold severity: LOW, MEDIUM, HIGH (default) or CRITICAL
+	Severity string `json:"severity,omitempty"`
+	// fail (default) fails the build, warn only reports
+	Action        string `json:"action,omitempty"`
+	IgnoreUnfixed bool   `json:"ignore_unfixed,omitempty"`
+}
+
+// MergeMaps sets the values of two in one, allocating one when nil
+func MergeMaps(one *map[string]string, two map[string]string) {
+	if len(two) == 0 {
+		return
+	}
+	if *one == nil {
+		*one = make(map[string]string, len(two))
+	}
 	for k, v := range two {
-		one[k] = v
+		(*one)[k] = v
 	}
 }
 
@@ -87,28 +159,71 @@ func MergeFunctions(one, two *Function) {
 	MergeStrings(&one.Metadata.Project, two.Metadata.Project)
 	MergeStrings(&one.Metadata.Name, two.Metadata.Name)
 	MergeStrings(&one.Metadata.Tag, two.Metadata.Tag)
-	MergeMaps(one.Metadata.Labels, two.Metadata.Labels)
-	MergeMaps(one.Metadata.Annotations, two.Metadata.Annotations)
+	MergeMaps(&one.Metadata.Labels, two.Metadata.Labels)
+	MergeMaps(&one.Metadata.Annotations, two.Metadata.Annotations)
 
 	build := one.Spec.Build
-	if build.BaseImage == "" && len(build.Commands) == 0 && len(build.FunctionSourceCode) == 0 {
+	if build.BaseImage == "" && len(build.Commands) == 0 && len(build.FunctionSourceCode) == 0 && build.CondaEnv == "" {
 		one.Spec.Build = two.Spec.Build
 	}
 	MergeRawJson(&one.Spec.EnrtyPoints, two.Spec.EnrtyPoints)
 	MergeStrings(&one.Spec.Command, two.Spec.Command)
 	MergeStrings(&one.Spec.Image, two.Spec.Image)
 	MergeStrings(&one.Spec.Mode, two.Spec.Mode)
+	MergeStrings(&one.Spec.Runtime, two.Spec.Runtime)
 	MergeStrings(&one.Spec.Description, two.Spec.Description)
 	if len(two.Spec.Args) > 0 {
 		one.Spec.Args = two.Spec.Args
 	}
-	MergeRawJson(&one.Spec.Env, two.Spec.Env)
-	MergeRawJson(&one.Spec.Volumes, two.Spec.Volumes)
-	MergeRawJson(&one.Spec.VolumeMounts, two.Spec.VolumeMounts)
-	MergeRawJson(&one.Spec.Resources, two.Spec.Resources)
+	one.Spec.Env = MergeEnv(one.Spec.Env, two.Spec.Env)
+	one.Spec.Volumes = MergeVolumes(one.Spec.Volumes, two.Spec.Volumes)
+	one.Spec.VolumeMounts = MergeVolumeMounts(one.Spec.VolumeMounts, two.Spec.VolumeMounts)
+	if two.Spec.Resources != nil {
+		one.Spec.Resources = two.Spec.Resources
+	}
 	if two.Spec.Replicas > 0 {
 		one.Spec.Replicas = two.Spec.Replicas
 	}
 	MergeStrings(&one.Spec.ImagePullPolicy, two.Spec.ImagePullPolicy)
 	MergeStrings(&one.Spec.ServiceAccount, two.Spec.ServiceAccount)
+	if len(two.Spec.Secrets) > 0 {
+		one.Spec.Secrets = two.Spec.Secrets
+	}
+	MergeRawJson(&one.Spec.Deps, two.Spec.Deps)
+	MergeMaps(&one.Spec.SparkConf, two.Spec.SparkConf)
+	MergeStrings(&one.Spec.SparkVersion, two.Spec.SparkVersion)
+
+	if two.Spec.MinReplicas != nil {
+		one.Spec.MinReplicas = two.Spec.MinReplicas
+	}
+	if two.Spec.MaxReplicas != nil {
+		one.Spec.MaxReplicas = two.Spec.MaxReplicas
+	}
+	for key, value := range two.Spec.Config {
+		if one.Spec.Config == nil {
+			one.Spec.Config = map[string]json.RawMessage{}
+		}
+		one.Spec.Config[key] = value
+	}
+	MergeStrings(&one.Spec.FunctionKind, two.Spec.FunctionKind)
+	MergeStrings(&one.Spec.FunctionHandler, two.Spec.FunctionHandler)
+	if two.Spec.ReadinessTimeout > 0 {
+		one.Spec.ReadinessTimeout = two.Spec.ReadinessTimeout
+	}
+	MergeStrings(&one.Spec.ServiceType, two.Spec.ServiceType)
+	MergeRawJson(&one.Spec.BaseSpec, two.Spec.BaseSpec)
+	if two.Spec.Graph != nil {
+		one.Spec.Graph = two.Spec.Graph
+	}
+	MergeStrings(&one.Spec.DefaultClass, two.Spec.DefaultClass)
+	for key, value := range two.Spec.Parameters {
+		if one.Spec.Parameters == nil {
+			one.Spec.Parameters = map[string]interface{}{}
+		}
+		one.Spec.Parameters[key] = value
+	}
+	MergeStrings(&one.Spec.LoadMode, two.Spec.LoadMode)
+	one.Spec.TrackModels = one.Spec.TrackModels || two.Spec.TrackModels
+	MergeStrings(&one.Spec.GraphInitializer, two.Spec.GraphInitializer)
+	MergeStrings(&one.Spec.ErrorStream, two.Spec.ErrorStream)
 }
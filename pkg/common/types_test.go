@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeFunctions(t *testing.T) {
+	one := &Function{}
+	one.Spec.Env = []EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}
+	one.Spec.Volumes = []Volume{{Name: "data", EmptyDir: &EmptyDirVolumeSource{}}, {Name: "cache"}}
+	one.Spec.VolumeMounts = []VolumeMount{{Name: "data", MountPath: "/data"}, {Name: "cache", MountPath: "/cache"}}
+	two := &Function{}
+	two.Metadata.Labels = map[string]string{"owner": "me"}
+	two.Metadata.Annotations = map[string]string{"note": "x"}
+	two.Spec.SparkConf = map[string]string{"spark.executor.cores": "2"}
+	two.Spec.Env = []EnvVar{{Name: "C", Value: "3"}, {Name: "A", Value: "4"}}
+	two.Spec.Volumes = []Volume{{Name: "data"}}
+	two.Spec.VolumeMounts = []VolumeMount{{Name: "other", MountPath: "/data"}, {Name: "tmp", MountPath: "/tmp"}}
+
+	// The maps of one are nil
+	MergeFunctions(one, two)
+
+	if expected := []EnvVar{{Name: "A", Value: "4"}, {Name: "B", Value: "2"}, {Name: "C", Value: "3"}}; !reflect.DeepEqual(one.Spec.Env, expected) {
+		t.Errorf("Merged env %v, expected %v", one.Spec.Env, expected)
+	}
+	if expected := []Volume{{Name: "data"}, {Name: "cache"}}; !reflect.DeepEqual(one.Spec.Volumes, expected) {
+		t.Errorf("Merged volumes %v, expected %v", one.Spec.Volumes, expected)
+	}
+	expectedMounts := []VolumeMount{{Name: "other", MountPath: "/data"}, {Name: "cache", MountPath: "/cache"}, {Name: "tmp", MountPath: "/tmp"}}
+	if !reflect.DeepEqual(one.Spec.VolumeMounts, expectedMounts) {
+		t.Errorf("Merged mounts %v, expected %v", one.Spec.VolumeMounts, expectedMounts)
+	}
+	if one.Metadata.Labels["owner"] != "me" || one.Metadata.Annotations["note"] != "x" || one.Spec.SparkConf["spark.executor.cores"] != "2" {
+		t.Errorf("Maps not merged into nil maps: %v %v %v", one.Metadata.Labels, one.Metadata.Annotations, one.Spec.SparkConf)
+	}
+
+	// Empty lists and nil maps of two keep those of one
+	MergeFunctions(one, &Function{})
+	if len(one.Spec.Env) != 3 || len(one.Spec.Volumes) != 2 || len(one.Spec.VolumeMounts) != 3 || len(one.Metadata.Labels) != 1 {
+		t.Errorf("Merging an empty function changed %+v", one)
+	}
+}
+
+func TestMergeEnvCopies(t *testing.T) {
+	one := make([]EnvVar, 1, 4)
+	one[0] = EnvVar{Name: "A", Value: "1"}
+	merged := MergeEnv(one, []EnvVar{{Name: "A", Value: "2"}, {Name: "B"}})
+	if one[0].Value != "1" || one[:2][1].Name != "" {
+		t.Errorf("Merging changed the env of one: %v", one[:2])
+	}
+	if len(merged) != 2 || merged[0].Value != "2" {
+		t.Errorf("Merged env %v", merged)
+	}
+}
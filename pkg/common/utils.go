@@ -19,7 +19,38 @@ such restriction.
 */
 package common
 
-import "os"
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Environment variables that change how processes run: the loader, search
+// paths, proxies, certificates and the configuration of the build tools
+var processEnvVars = map[string]bool{
+	"PATH": true, "HOME": true, "SHELL": true, "IFS": true, "ENV": true, "BASH_ENV": true, "TMPDIR": true,
+	"HTTP_PROXY": true, "HTTPS_PROXY": true, "NO_PROXY": true, "ALL_PROXY": true,
+	"SSL_CERT_FILE": true, "SSL_CERT_DIR": true, "KUBECONFIG": true,
+	"PYTHONPATH": true, "PYTHONHOME": true, "PYTHONSTARTUP": true, "NODE_OPTIONS": true,
+	"GIT_SSH": true, "GIT_SSH_COMMAND": true, "GIT_ASKPASS": true, "GIT_EXEC_PATH": true,
+}
+
+var processEnvPrefixes = []string{"LD_", "DYLD_", "DOCKER_", "BUILDKIT_", "KANIKO_", "GIT_CONFIG"}
+
+// ProcessEnvVar reports if an environment variable changes how processes run,
+// such variables are not taken from user values (e.g. project secrets)
+func ProcessEnvVar(name string) bool {
+	name = strings.ToUpper(name)
+	if processEnvVars[name] {
+		return true
+	}
+	for _, prefix := range processEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
 
 func FileExists(filename string) bool {
 	_, err := os.Stat(filename)
@@ -28,3 +59,35 @@ func FileExists(filename string) bool {
 	}
 	return true
 }
+
+// otherFields returns the fields of a JSON object that are not known, for
+// types keeping the fields they do not model
+func otherFields(fields map[string]json.RawMessage, known map[string]bool) map[string]json.RawMessage {
+	var other map[string]json.RawMessage
+	for key, value := range fields {
+		if !known[key] {
+			if other == nil {
+				other = map[string]json.RawMessage{}
+			}
+			other[key] = value
+		}
+	}
+	return other
+}
+
+// withOtherFields adds the other fields to a JSON object, known fields win
+func withOtherFields(data []byte, other map[string]json.RawMessage, known map[string]bool) ([]byte, error) {
+	if len(other) == 0 {
+		return data, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range other {
+		if _, ok := fields[key]; !ok && !known[key] {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"time"
+)
+
+// Alert rule kinds
+const (
+	// A run (of run_name, or any run) failed
+	AlertRunFailed = "run_failed"
+	// No run of run_name completed in the last period
+	AlertNoSuccess = "no_success"
+)
+
+// AlertRule fires a notification to its targets when its condition is met,
+// no_success rules fire once until a run completes again
+type AlertRule struct {
+	Name      string               `json:"name"`
+	Project   string               `json:"project"`
+	Kind      string               `json:"kind"`
+	RunName   string               `json:"run_name,omitempty"`
+	Period    string               `json:"period,omitempty"`
+	Targets   []NotificationTarget `json:"targets"`
+	Disabled  bool                 `json:"disabled,omitempty"`
+	Firing    bool                 `json:"firing,omitempty"`
+	LastFired string               `json:"last_fired,omitempty"`
+	LastUID   string               `json:"last_uid,omitempty"`
+}
+
+func (r *AlertRule) validate() error {
+	switch r.Kind {
+	case AlertRunFailed:
+	case AlertNoSuccess:
+		if r.RunName == "" {
+			return fmt.Errorf("%s alerts require a run_name", r.Kind)
+		}
+		if period, err := time.ParseDuration(r.Period); err != nil || period <= 0 {
+			return fmt.Errorf("%s alerts require a positive period, e.g. 24h", r.Kind)
+		}
+	default:
+		return fmt.Errorf("Unknown alert kind '%s', use %s or %s", r.Kind, AlertRunFailed, AlertNoSuccess)
+	}
+	if len(r.Targets) == 0 {
+		return fmt.Errorf("Alerts require targets")
+	}
+	return validateTargets(r.Targets)
+}
+
+func (r *AlertRule) period() time.Duration {
+	period, _ := time.ParseDuration(r.Period)
+	return period
+}
+
+func alertPath(project, name interface{}) string {
+	return fmt.Sprintf("/alerts/%s/%s", project, name)
+}
+
+var alertAttributes = []string{"__name", dataAttributeName, "created", "firing", "last_fired", "last_uid"}
+
+func alertFromItem(item v3io.Item) (*AlertRule, error) {
+	data, err := openData(item.GetField(dataAttributeName).([]byte))
+	if err != nil {
+		return nil, err
+	}
+	rule := AlertRule{}
+	if err = json.Unmarshal(data, &rule); err != nil {
+		return nil, err
+	}
+	firing, _ := item.GetField("firing").(bool)
+	lastFired, _ := item.GetFieldInt("last_fired")
+	rule.Firing = firing
+	rule.LastFired = formatScheduleTime(lastFired)
+	rule.LastUID, _ = item.GetFieldString("last_uid")
+	return &rule, nil
+}
+
+func listAlertItems(container v3io.Container, project, filter string) ([]v3io.Item, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/alerts/%s/", project),
+		AttributeNames: alertAttributes,
+		Filter:         filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursor.AllSync()
+}
+
+func storeAlertHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := fmt.Sprint(ctx.UserValue("project"))
+	name := fmt.Sprint(ctx.UserValue("name"))
+	rule := AlertRule{}
+	if err := json.Unmarshal(ctx.Request.Body(), &rule); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	rule.Name, rule.Project = name, project
+	rule.Firing, rule.LastFired, rule.LastUID = false, "", ""
+	data, err := json.Marshal(rule)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	clog.printF("storeAlertHandler : Project %s name %s kind %s\n", project, name, rule.Kind)
+	err = container.PutItemSync(&v3io.PutItemInput{
+		Path: alertPath(project, name),
+		Attributes: map[string]interface{}{
+			"name":            name,
+			"project":         project,
+			"kind":            rule.Kind,
+			"enabled":         !rule.Disabled,
+			"firing":          false,
+			"created":         int(time.Now().UnixNano()),
+			dataAttributeName: sealData(data),
+		},
+	})
+	if err != nil {
+		clog.printF("storeAlertHandler: Failed to store %s/%s: %s\n", project, name, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	writeJSON(ctx, &rule)
+}
+
+func getAlertHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           alertPath(ctx.UserValue("project"), ctx.UserValue("name")),
+		AttributeNames: alertAttributes,
+	})
+	if err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	defer v3ioResponse.Release()
+	rule, err := alertFromItem(v3ioResponse.Output.(*v3io.GetItemOutput).Item)
+	if err != nil {
+		clog.printF("getAlertHandler: %s\n", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, rule)
+}
+
+func deleteAlertHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: alertPath(ctx.UserValue("project"), ctx.UserValue("name"))})
+	setStatusFromError(ctx, err)
+}
+
+func listAlertsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	projects := []string{string(ctx.QueryArgs().Peek("project"))}
+	if projects[0] == "" {
+		var err error
+		if projects, err = listProjectDirs(container, "/alerts/"); err != nil {
+			setStatusFromError(ctx, err)
+			return
+		}
+	}
+	rules := []*AlertRule{}
+	for _, project := range projects {
+		items, err := listAlertItems(container, project, "")
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			setStatusFromError(ctx, err)
+			return
+		}
+		for _, item := range items {
+			rule, err := alertFromItem(item)
+			if err != nil {
+				clog.printF("listAlertsHandler: %s\n", err)
+				continue
+			}
+			rules = append(rules, rule)
+		}
+	}
+	writeJSON(ctx, map[string]interface{}{"alerts": rules})
+}
+
+// fireAlert sends an alert notification to the rule targets
+func fireAlert(rule *AlertRule, notification *runNotification) {
+	notification.Project, notification.Alert = rule.Project, rule.Name
+	notifications.lock.Lock()
+	smtpConfig := notifications.config.SMTP
+	notifications.lock.Unlock()
+	clog.printF("Alert %s/%s fired: %s\n", rule.Project, rule.Name, notification.Message)
+	notifications.queueSends(rule.Targets, notification, &smtpConfig)
+}
+
+// alertRunChanged evaluates the run_failed rules in the background when a
+// run is stored failed
+func alertRunChanged(container v3io.Container, project, uid string, data []byte) {
+	run := common.Run{}
+	if err := json.Unmarshal(data, &run); err != nil || run.Status.State != "error" {
+		return
+	}
+	go runFailedAlerts(container, project, uid, &run)
+}
+
+// runFailedAlerts fires the run_failed rules of the project matching a failed
+// run, once per run
+func runFailedAlerts(container v3io.Container, project, uid string, run *common.Run) {
+	items, err := listAlertItems(container, project, fmt.Sprintf("enabled == true and kind == '%s'", AlertRunFailed))
+	if err != nil {
+		if !isNotFound(err) {
+			fmt.Printf("Failed to read the alerts of project %s: %s\n", project, err)
+		}
+		return
+	}
+	for _, item := range items {
+		rule, err := alertFromItem(item)
+		if err != nil || (rule.RunName != "" && rule.RunName != run.Metadata.Name) {
+			continue
+		}
+		// Claiming the run makes repeated error updates fire the rule once
+		err = container.UpdateItemSync(&v3io.UpdateItemInput{
+			Path:       alertPath(project, rule.Name),
+			Condition:  fmt.Sprintf("not(exists(last_uid)) or last_uid != '%s'", uid),
+			Attributes: map[string]interface{}{"last_uid": uid, "last_fired": int(time.Now().UnixNano())},
+		})
+		if err != nil {
+			if !isConditionFailed(err) {
+				fmt.Printf("Failed to fire alert %s/%s: %s\n", project, rule.Name, err)
+			}
+			continue
+		}
+		fireAlert(rule, &runNotification{Name: run.Metadata.Name, UID: uid, State: run.Status.State,
+			Error: run.Status.Error, Labels: run.Metadata.Labels,
+			Message: fmt.Sprintf("Alert %s in project %s: run %s (%s) failed", rule.Name, project, run.Metadata.Name, uid) +
+				errorSuffix(run.Status.Error)})
+	}
+}
+
+func errorSuffix(message string) string {
+	if message == "" {
+		return ""
+	}
+	return ": " + message
+}
+
+// evaluateAlerts checks the no_success rules, they fire when no run of their
+// run name completed within their period and resolve when one does
+func evaluateAlerts(container v3io.Container, now time.Time) error {
+	projects, err := listProjectDirs(container, "/alerts/")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, project := range projects {
+		items, err := listAlertItems(container, project, fmt.Sprintf("enabled == true and kind == '%s'", AlertNoSuccess))
+		if err != nil {
+			if !isNotFound(err) {
+				lastErr = err
+			}
+			continue
+		}
+		for _, item := range items {
+			if err := evaluateNoSuccess(container, project, item, now); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+func evaluateNoSuccess(container v3io.Container, project string, item v3io.Item, now time.Time) error {
+	rule, err := alertFromItem(item)
+	if err != nil {
+		return err
+	}
+	since := now.Add(-rule.period())
+	// Rules younger than their period have nothing to miss yet
+	if created, _ := item.GetFieldInt("created"); int64(created) > since.UnixNano() {
+		return nil
+	}
+	filterStr, err := buildRunFilterString(nil, rule.RunName, "completed", since.UnixNano())
+	if err != nil {
+		return err
+	}
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/run/%s/", project),
+		AttributeNames: []string{"__name"},
+		Filter:         filterStr,
+	})
+	var completed []v3io.Item
+	if err == nil {
+		completed, err = cursor.AllSync()
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	missing := len(completed) == 0
+	if missing == rule.Firing {
+		return nil
+	}
+	attributes := map[string]interface{}{"firing": missing}
+	if missing {
+		attributes["last_fired"] = int(now.UnixNano())
+	}
+	// Conditioned on the firing state, so the rule fires once per outage
+	err = container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       alertPath(project, rule.Name),
+		Condition:  fmt.Sprintf("firing == %t", rule.Firing),
+		Attributes: attributes,
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return nil
+		}
+		return err
+	}
+	if missing {
+		fireAlert(rule, &runNotification{Name: rule.RunName, State: AlertNoSuccess,
+			Message: fmt.Sprintf("Alert %s in project %s: no successful run of %s in %s", rule.Name, project, rule.RunName, rule.Period)})
+	}
+	return nil
+}
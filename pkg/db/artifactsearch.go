@@ -0,0 +1,199 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Projects searched in parallel
+	searchWorkers     = 8
+	defaultSearchPage = 100
+	maxSearchPage     = 1000
+)
+
+// searchResult is an artifact found by a search, with its project
+type searchResult struct {
+	Project string          `json:"project"`
+	Data    json.RawMessage `json:"data"`
+	name    string
+}
+
+// searchMarker encodes the position after a result, the project and item
+// name, as an opaque page token
+func searchMarker(project, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(project + "/" + name))
+}
+
+func parseSearchMarker(marker string) (string, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(marker)
+	parts := strings.SplitN(string(decoded), "/", 2)
+	if err != nil || len(parts) != 2 {
+		return "", "", v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Invalid marker '%s'", marker), http.StatusBadRequest)
+	}
+	return parts[0], parts[1], nil
+}
+
+// searchProjectArtifacts returns the artifacts of a project matching filter,
+// sorted by item name
+func searchProjectArtifacts(container v3io.Container, project, filter string) ([]searchResult, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifact/%s/", project),
+		AttributeNames: []string{"__name", dataAttributeName},
+		Filter:         filter,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]searchResult, 0, len(items))
+	for _, item := range items {
+		name, _ := item.GetFieldString("__name")
+		data, err := openData(item.GetField(dataAttributeName).([]byte))
+		if err != nil {
+			return nil, fmt.Errorf("Artifact %s/%s: %s", project, name, err)
+		}
+		results = append(results, searchResult{Project: project, Data: data, name: name})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].name < results[j].name })
+	return results, nil
+}
+
+// searchArtifacts returns a page of the artifacts of all projects matching
+// filter, ordered by project and item name, starting after the marker, and
+// the marker of the next page (empty on the last one). Projects are searched
+// searchWorkers at a time, until the page is full
+func searchArtifacts(container v3io.Container, filter, marker string, limit int) ([]searchResult, string, error) {
+	afterProject, afterName := "", ""
+	if marker != "" {
+		var err error
+		if afterProject, afterName, err = parseSearchMarker(marker); err != nil {
+			return nil, "", err
+		}
+	}
+	projects, err := listProjectDirs(container, "/artifact/")
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(projects)
+	start := sort.SearchStrings(projects, afterProject)
+	projects = projects[start:]
+
+	var page []searchResult
+	for len(projects) > 0 && len(page) <= limit {
+		batch := projects
+		if len(batch) > searchWorkers {
+			batch = batch[:searchWorkers]
+		}
+		projects = projects[len(batch):]
+		found := make([][]searchResult, len(batch))
+		errs := make([]error, len(batch))
+		wg := sync.WaitGroup{}
+		for i, project := range batch {
+			wg.Add(1)
+			go func(i int, project string) {
+				defer wg.Done()
+				found[i], errs[i] = searchProjectArtifacts(container, project, filter)
+			}(i, project)
+		}
+		wg.Wait()
+		for i, project := range batch {
+			if errs[i] != nil {
+				return nil, "", errs[i]
+			}
+			for _, result := range found[i] {
+				if project == afterProject && result.name <= afterName {
+					continue
+				}
+				page = append(page, result)
+			}
+		}
+	}
+	if len(page) <= limit {
+		return page, "", nil
+	}
+	page = page[:limit]
+	last := page[limit-1]
+	return page, searchMarker(last.Project, last.name), nil
+}
+
+// searchArtifactsHandler searches the artifacts of all projects, it needs the
+// admin role
+func searchArtifactsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	if !api.IsAdmin(ctx) {
+		api.WriteError(ctx, http.StatusForbidden, fmt.Errorf("Searching all projects needs the admin role"))
+		return
+	}
+	filterStr, err := artifactListFilter(ctx)
+	if err != nil {
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+	if kind := string(ctx.QueryArgs().Peek("kind")); kind != "" {
+		literal, err := filterField{attribute: "kind", kind: stringField}.literal(kind)
+		if err != nil {
+			api.WriteError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		filterStr = joinFilters(filterStr, encodeAttributeName("kind")+" == "+literal)
+	}
+	limit := defaultSearchPage
+	if ctx.QueryArgs().Has("limit") {
+		limit = ctx.QueryArgs().GetUintOrZero("limit")
+		if limit <= 0 || limit > maxSearchPage {
+			api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("The limit must be between 1 and %d", maxSearchPage))
+			return
+		}
+	}
+
+	results, next, err := searchArtifacts(container, filterStr, string(ctx.QueryArgs().Peek("marker")), limit)
+	if err != nil {
+		clog.printF("searchArtifactsHandler: %s\n", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+	for i := range results {
+		results[i].Data = redactBody(ctx, results[i].Data)
+	}
+	response := map[string]interface{}{"artifacts": results}
+	if next != "" {
+		response["next_marker"] = next
+	}
+	writeJSON(ctx, response)
+}
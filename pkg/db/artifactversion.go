@@ -0,0 +1,115 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+)
+
+// Artifact versions (<key>.<uid> items) are immutable, pipelines pin them.
+// Storing a version again is accepted when the body is the same but for
+// these fields, which change with the tag the version is stored under
+var versionVolatileFields = []string{"tag", "updated"}
+
+// artifactHashAttribute holds the artifactVersionHash of a stored version
+const artifactHashAttribute = "version_hash"
+
+// comparableArtifact decodes an artifact body (JSON or YAML) without its
+// volatile fields
+func comparableArtifact(body []byte) (interface{}, error) {
+	JSONBody, err := convertDataToJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(JSONBody))
+	decoder.UseNumber()
+	var artifact interface{}
+	if err = decoder.Decode(&artifact); err != nil {
+		return nil, err
+	}
+	if fields, ok := artifact.(map[string]interface{}); ok {
+		for _, field := range versionVolatileFields {
+			delete(fields, field)
+		}
+	}
+	return artifact, nil
+}
+
+// artifactVersionHash hashes an artifact body without its volatile fields,
+// empty when the body can not be decoded
+func artifactVersionHash(body []byte) string {
+	artifact, err := comparableArtifact(body)
+	if err != nil {
+		return ""
+	}
+	canonical, err := json.Marshal(artifact)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:16])
+}
+
+// checkArtifactVersion fails with 409 when the artifact version at path exists
+// with another body than the one hashed, and returns the condition to store
+// the version with so a version stored meanwhile with another body is kept.
+// Bodies that can not be decoded are left to the store
+func checkArtifactVersion(container v3io.Container, path, hash string) (string, error) {
+	if hash == "" {
+		return "", nil
+	}
+	condition := fmt.Sprintf("not(exists(%s)) or %s == '%s'", artifactHashAttribute, artifactHashAttribute, hash)
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: path,
+		AttributeNames: []string{artifactHashAttribute, dataAttributeName, mtimeAttribute, mtimeNsecsAttribute}})
+	if isNotFound(err) {
+		return condition, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer v3ioResponse.Release()
+	item := v3ioResponse.Output.(*v3io.GetItemOutput).Item
+	stored, err := item.GetFieldString(artifactHashAttribute)
+	if err != nil {
+		// Versions stored before their hash are compared by body and kept
+		// unless unchanged
+		data, ok := item[dataAttributeName].([]byte)
+		if !ok {
+			return "", fmt.Errorf("Artifact version %s has no body", path)
+		}
+		opened, err := openData(data)
+		if err != nil {
+			return "", err
+		}
+		stored, condition = artifactVersionHash(opened), unchangedCondition(item)
+	}
+	if stored != hash {
+		return "", v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Artifact version %s exists with another body, store it under a new uid or with force=true", path),
+			http.StatusConflict)
+	}
+	return condition, nil
+}
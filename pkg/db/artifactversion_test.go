@@ -0,0 +1,54 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"testing"
+)
+
+func TestStoreArtifactVersion(t *testing.T) {
+	mldb := newTestDB(t)
+	first, second := `{"key":"model","tree":"u1","size":1}`, `{"key":"model","tree":"u1","size":2}`
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model&tag=v1", first, 200)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model&tag=v2", first, 200)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model", second, 409)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model&force=true", second, 200)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model", second, 200)
+
+	// Versions stored before their hash are compared by body
+	if err := containers[""].PutItemSync(&v3io.PutItemInput{Path: "/artifact/p1/model.u2", Attributes: map[string]interface{}{
+		"name": "model", dataAttributeName: sealData([]byte(first))}}); err != nil {
+		t.Fatal(err)
+	}
+	mustRequest(t, mldb, "POST", "/artifact/p1/u2?key=model", second, 409)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u2?key=model", first, 200)
+
+	// A version stored with another body after the check is kept
+	original := containers[""]
+	defer func() { containers[""] = original }()
+	containers[""] = &hookContainer{Container: original, afterGet: func(path string) {
+		if path == "/artifact/p1/model.u3" {
+			original.PutItemSync(&v3io.PutItemInput{Path: path, Attributes: map[string]interface{}{
+				"name": "model", artifactHashAttribute: artifactVersionHash([]byte(second))}})
+		}
+	}}
+	mustRequest(t, mldb, "POST", "/artifact/p1/u3?key=model", first, 409)
+}
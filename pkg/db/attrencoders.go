@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// attributeEncoder sets the attributes of a field of an indexed struct, as
+// allowed by the index policy
+type attributeEncoder func(field reflect.Value, policy *indexPolicy, result map[string]interface{}) error
+
+type encodedStruct struct {
+	typ  reflect.Type
+	path string
+}
+
+// The field encoders of the envelope types are compiled once, other types on
+// their first use
+var (
+	attributeEncodersLock sync.RWMutex
+	attributeEncoders     = map[encodedStruct][]attributeEncoder{}
+)
+
+func init() {
+	for _, envelope := range []interface{}{runMetadataEnvelope{}, artifactMetadataEnvelope{}} {
+		structEncoders(reflect.TypeOf(envelope), "")
+	}
+}
+
+// structEncoders returns the encoders of the fields of a struct type, indexed
+// under attributePath
+func structEncoders(st reflect.Type, attributePath string) []attributeEncoder {
+	key := encodedStruct{typ: st, path: attributePath}
+	attributeEncodersLock.RLock()
+	encoders, ok := attributeEncoders[key]
+	attributeEncodersLock.RUnlock()
+	if ok {
+		return encoders
+	}
+	encoders = make([]attributeEncoder, 0, st.NumField())
+	for i := 0; i < st.NumField(); i++ {
+		encoders = append(encoders, fieldEncoder(st.Field(i), i, attributePath))
+	}
+	attributeEncodersLock.Lock()
+	attributeEncoders[key] = encoders
+	attributeEncodersLock.Unlock()
+	return encoders
+}
+
+// fieldEncoder compiles the encoder of a struct field, the attribute names
+// are encoded here instead of on every call
+func fieldEncoder(field reflect.StructField, index int, attributePath string) attributeEncoder {
+	name := attributePath + strings.ToLower(field.Name)
+	encodedName := encodeAttributeName(name)
+	fieldType := field.Type
+	isPtr := fieldType.Kind() == reflect.Ptr
+	if isPtr {
+		fieldType = fieldType.Elem()
+	}
+
+	var encode attributeEncoder
+	switch fieldType.Kind() {
+	case reflect.Struct:
+		nested := structEncoders(fieldType, name+".")
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			for _, encodeNested := range nested {
+				if err := encodeNested(value, policy, result); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			result[encodedName] = value.Int()
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			result[encodedName] = int64(value.Uint())
+			return nil
+		}
+	case reflect.Bool:
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			result[encodedName] = value.Bool()
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			result[encodedName] = value.Float()
+			return nil
+		}
+	case reflect.String:
+		// Time fields are stored in UTC, as Epoch integer and canonical string
+		epochName := encodeAttributeName(name + "Epoch")
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			t, ok := parseRunTime(value.String())
+			if ok {
+				result[encodedName] = canonicalTime(t)
+				result[epochName] = t.UnixNano()
+				return nil
+			}
+			indexed, ok, err := policy.value(name, value.String())
+			if ok {
+				result[encodedName] = indexed
+			}
+			return err
+		}
+	case reflect.Map:
+		// Names are encoded by character, so the prefix is encoded once
+		prefix := encodeAttributeName(name + ".")
+		encode = func(value reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+			labels := value.Interface().(map[string]string)
+			keys, err := policy.labels(name, labels)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				indexed, ok, err := policy.value(name+"."+key, labels[key])
+				if err != nil {
+					return err
+				}
+				if ok {
+					result[prefix+encodeAttributeName(key)] = indexed
+				}
+			}
+			return nil
+		}
+	default:
+		kind := fieldType.Kind()
+		encode = func(reflect.Value, *indexPolicy, map[string]interface{}) error {
+			clog.printF("metadataToV3ioAttributes : usupported type %v for attribute %s\n", kind, name)
+			return nil
+		}
+	}
+
+	return func(sv reflect.Value, policy *indexPolicy, result map[string]interface{}) error {
+		if policy.denyFields[name] {
+			return nil
+		}
+		value := sv.Field(index)
+		if isPtr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		return encode(value, policy, result)
+	}
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/builder"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	buildPending = "pending"
+	buildRunning = "running"
+	buildReady   = "ready"
+	buildError   = "error"
+
+	maxParallelBuilds = 4
+
+	// Pending and running builds are kept alive by their server, builds of a
+	// server that stopped (e.g. restarted) are failed after staleBuildTimeout
+	buildHeartbeatInterval = time.Minute
+	staleBuildTimeout      = 5 * buildHeartbeatInterval
+)
+
+// buildSlots bounds the number of images built at the same time
+var buildSlots = make(chan struct{}, maxParallelBuilds)
+
+type buildRequest struct {
+	Function  json.RawMessage `json:"function"`
+	WithMLRun interface{}     `json:"with_mlrun"`
+}
+
+// withMLRun accepts both booleans and the "true"/"false" strings the SDK sends
+func (r *buildRequest) withMLRun() bool {
+	switch value := r.WithMLRun.(type) {
+	case bool:
+		return value
+	case string:
+		return value != "false"
+	}
+	return true
+}
+
+// The latest build of every function is kept in /build/<project>/<name>.<tag>
+func buildPath(project, name, tag string) string {
+	if tag == "" {
+		tag = "latest"
+	}
+	return fmt.Sprintf("/build/%s/%s.%s", project, name, tag)
+}
+
+// buildLogUID is the log subsystem uid of a build, its log can also be read
+// with GET /log/<project>/build-<id>
+func buildLogUID(id string) string {
+	return "build-" + id
+}
+
+func updateBuild(container v3io.Container, path string, attributes map[string]interface{}) error {
+	return container.UpdateItemSync(&v3io.UpdateItemInput{Path: path, Attributes: attributes})
+}
+
+func (db *MLRunDB) buildFunctionHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	request := buildRequest{}
+	if err := json.Unmarshal(ctx.Request.Body(), &request); err != nil || len(request.Function) == 0 {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON body with a function"))
+		return
+	}
+	function := common.Function{}
+	var err error
+	if request.Function, err = objectDefaults.Function(request.Function, "", time.Now()); err == nil {
+		err = json.Unmarshal(request.Function, &function)
+	}
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if function.Metadata.Name == "" {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Function name is required"))
+		return
+	}
+	if err := function.Validate(); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if function.Metadata.Project == "" {
+		function.Metadata.Project = "default"
+	}
+	if err := validateIdentifiers("project", function.Metadata.Project, "name", function.Metadata.Name,
+		"tag", function.Metadata.Tag); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	id := randomID()
+	path := buildPath(function.Metadata.Project, function.Metadata.Name, function.Metadata.Tag)
+	err = container.PutItemSync(&v3io.PutItemInput{
+		Path: path,
+		Attributes: map[string]interface{}{
+			"id":               id,
+			"state":            buildPending,
+			"created":          time.Now().UnixNano(),
+			heartbeatAttribute: time.Now().UnixNano(),
+			dataAttributeName:  sealData(request.Function),
+		},
+	})
+	if err != nil {
+		clog.printF("buildFunctionHandler: Failed to store build record: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	go db.runBuild(container, path, id, &function, request.withMLRun())
+
+	data, _ := withBuildStatus(request.Function, buildPending, id)
+	writeJSON(ctx, map[string]interface{}{"id": id, "ready": false, "data": data})
+}
+
+func withBuildStatus(function json.RawMessage, state, id string) (json.RawMessage, error) {
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(function, &doc); err != nil {
+		return function, err
+	}
+	status, _ := doc["status"].(map[string]interface{})
+	if status == nil {
+		status = map[string]interface{}{}
+	}
+	status["state"] = state
+	status["build_id"] = id
+	doc["status"] = status
+	return json.Marshal(doc)
+}
+
+// keepBuildAlive updates the build heartbeat until done is closed, unless the
+// function was built again since
+func keepBuildAlive(container v3io.Container, path, id string, done chan struct{}) {
+	ticker := time.NewTicker(buildHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := container.UpdateItemSync(&v3io.UpdateItemInput{
+			Path:       path,
+			Condition:  fmt.Sprintf("id == '%s'", id),
+			Attributes: map[string]interface{}{heartbeatAttribute: time.Now().UnixNano()},
+		})
+		if err != nil && !isConditionFailed(err) {
+			fmt.Printf("Failed to update build %s heartbeat: %s\n", id, err)
+		}
+	}
+}
+
+// StartBuildWatchdog fails the pending and running builds whose server
+// stopped updating them
+func (db *MLRunDB) StartBuildWatchdog() {
+	go func() {
+		for {
+			for _, container := range namespaceContainers() {
+				if err := failStaleBuilds(container, time.Now().Add(-staleBuildTimeout)); err != nil {
+					fmt.Printf("Failed to check build heartbeats: %s\n", err)
+				}
+			}
+			time.Sleep(buildHeartbeatInterval)
+		}
+	}()
+}
+
+// failStaleBuilds sets the pending and running builds without a heartbeat
+// since before to error, builds of older servers without heartbeats by their
+// creation time
+func failStaleBuilds(container v3io.Container, before time.Time) error {
+	projects, err := listProjectDirs(container, "/build/")
+	if err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("(state == '%s' OR state == '%s') AND (%s < %d OR (NOT exists(%s) AND created < %d))",
+		buildPending, buildRunning, heartbeatAttribute, before.UnixNano(), heartbeatAttribute, before.UnixNano())
+	var lastErr error
+	for _, project := range projects {
+		cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+			Path:           fmt.Sprintf("/build/%s/", project),
+			AttributeNames: []string{"__name", "id"},
+			Filter:         filter,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		items, err := cursor.AllSync()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, item := range items {
+			name, _ := item.GetFieldString("__name")
+			id, _ := item.GetFieldString("id")
+			clog.printF("Marking build %s of %s/%s as %s, its server stopped\n", id, project, name, buildError)
+			// The filter is the condition, a heartbeat since the listing keeps the build
+			err := container.UpdateItemSync(&v3io.UpdateItemInput{
+				Path:      fmt.Sprintf("/build/%s/%s", project, name),
+				Condition: filter,
+				Attributes: map[string]interface{}{
+					"state":    buildError,
+					"error":    "The build server stopped during the build",
+					"finished": time.Now().UnixNano(),
+				},
+			})
+			if err != nil && !isConditionFailed(err) {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+func (db *MLRunDB) runBuild(container v3io.Container, path, id string, function *common.Function, withMLRun bool) {
+	done := make(chan struct{})
+	defer close(done)
+	go keepBuildAlive(container, path, id, done)
+
+	buildSlots <- struct{}{}
+	defer func() { <-buildSlots }()
+
+	project := function.Metadata.Project
+	out := newLogWriter(container, project, buildLogUID(id))
+	defer out.Close()
+	if err := updateBuild(container, path, map[string]interface{}{"state": buildRunning, "started": time.Now().UnixNano()}); err != nil {
+		fmt.Printf("Failed to update build %s: %s\n", id, err)
+	}
+	var result *builder.BuildResult
+	var err error
+	opts := builder.BuildOptions{WithMLRun: withMLRun}
+	opts.Secrets, err = db.functionSecrets(function)
+	if err == nil {
+		opts.DockerConfig, err = db.registryCredentials(function)
+	}
+	if err == nil {
+		result, err = builder.BuildFunction(function, &db.cfg.Builder, &opts, out)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Build failed: %s\n", err)
+	}
+	attributes := map[string]interface{}{"state": buildReady, "finished": time.Now().UnixNano()}
+	if result != nil {
+		attributes["image"] = result.Image
+		if result.Provenance != nil {
+			provenance, _ := json.Marshal(result.Provenance)
+			attributes["provenance"] = string(provenance)
+		}
+		if result.Scan != nil {
+			scan, _ := json.Marshal(result.Scan)
+			attributes["scan"] = string(scan)
+		}
+		if result.Signature != nil {
+			signature, _ := json.Marshal(result.Signature)
+			attributes["signature"] = string(signature)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Build %s of function %s failed: %s\n", id, function.Metadata.Name, err)
+		attributes["state"] = buildError
+		attributes["error"] = err.Error()
+	}
+	if err := updateBuild(container, path, attributes); err != nil {
+		fmt.Printf("Failed to update build %s: %s\n", id, err)
+	}
+}
+
+// buildStatusHandler returns the build state and image in the function_status
+// and function_image headers, and the build log from offset as the body
+func buildStatusHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := string(ctx.QueryArgs().Peek("project"))
+	if project == "" {
+		project = "default"
+	}
+	name := string(ctx.QueryArgs().Peek("name"))
+	if name == "" {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Function name is required"))
+		return
+	}
+	tag := string(ctx.QueryArgs().Peek("tag"))
+	if err := validateIdentifiers("project", project, "name", name, "tag", setFrom(tag, "latest")); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	offset := 0
+	if value := ctx.QueryArgs().Peek("offset"); len(value) > 0 {
+		var err error
+		if offset, err = strconv.Atoi(string(value)); err != nil || offset < 0 {
+			api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Bad offset %q", value))
+			return
+		}
+	}
+	clog.printF("buildStatusHandler : Project %s name %s\n", project, name)
+
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           buildPath(project, name, tag),
+		AttributeNames: []string{"id", "state", "image", "error", "provenance", "scan", "signature"},
+	})
+	if err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	item := v3ioResponse.Output.(*v3io.GetItemOutput).Item
+	id, _ := item.GetFieldString("id")
+	state, _ := item.GetFieldString("state")
+	image, _ := item.GetFieldString("image")
+	buildErr, _ := item.GetFieldString("error")
+	provenance, _ := item.GetFieldString("provenance")
+	scan, _ := item.GetFieldString("scan")
+	signature, _ := item.GetFieldString("signature")
+	v3ioResponse.Release()
+
+	ctx.Response.Header.Set("function_status", state)
+	ctx.Response.Header.Set("function_image", image)
+	ctx.Response.Header.Set("build_id", id)
+	if buildErr != "" {
+		ctx.Response.Header.Set("build_error", buildErr)
+	}
+	// The source provenance record, as JSON
+	if provenance != "" {
+		ctx.Response.Header.Set("build_provenance", provenance)
+	}
+	if scan != "" {
+		ctx.Response.Header.Set("build_scan", scanSummary(scan))
+	}
+	if signature != "" {
+		ctx.Response.Header.Set("build_signature", signature)
+	}
+	if !api.QueryBool(ctx, "logs", true) {
+		return
+	}
+
+	v3ioResponse, err = container.GetObjectSync(&v3io.GetObjectInput{Path: fmt.Sprintf("/log/%s-%s", project, buildLogUID(id))})
+	if err != nil {
+		// The log is stored after the first output
+		if !isNotFound(err) {
+			setStatusFromError(ctx, err)
+		}
+		return
+	}
+	body, err := openData(v3ioResponse.Body())
+	v3ioResponse.Release()
+	if err != nil {
+		clog.printF("buildStatusHandler: %s\n", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	if offset > len(body) {
+		offset = len(body)
+	}
+	ctx.Response.SetBody(body[offset:])
+}
+
+// scanSummary returns the stored scan report without its findings, which may
+// not fit a header
+func scanSummary(scan string) string {
+	report := builder.ScanReport{}
+	if err := json.Unmarshal([]byte(scan), &report); err != nil {
+		return scan
+	}
+	report.Findings = nil
+	summary, _ := json.Marshal(report)
+	return string(summary)
+}
+
+// registryCredentials resolves the image pull secret a function names in its
+// build spec into the docker config the image is pushed with
+func (db *MLRunDB) registryCredentials(function *common.Function) ([]byte, error) {
+	secret := function.Spec.Build.Secret
+	if secret == "" {
+		return nil, nil
+	}
+	if db.k8s == nil {
+		return nil, fmt.Errorf("Function %s names the registry secret %s but Kubernetes access is not configured", function.Metadata.Name, secret)
+	}
+	var shared []string
+	if db.cfg.Runtime != nil {
+		shared = db.cfg.Runtime.SharedSecrets
+	}
+	if err := runtime.CheckSecretAccess(db.k8s, shared, function.Metadata.Project, secret); err != nil {
+		return nil, fmt.Errorf("Function %s can not use registry secret %s: %s", function.Metadata.Name, secret, err)
+	}
+	config, err := runtime.DockerConfig(db.k8s, secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read registry secret %s: %s", secret, err)
+	}
+	return config, nil
+}
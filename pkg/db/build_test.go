@@ -0,0 +1,64 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"testing"
+	"time"
+)
+
+func TestFailStaleBuilds(t *testing.T) {
+	container := newMockContainer()
+	now := time.Now()
+	old := now.Add(-2 * staleBuildTimeout).UnixNano()
+	for name, attributes := range map[string]map[string]interface{}{
+		"stale":   {"id": "b1", "state": buildRunning, "created": old, heartbeatAttribute: old},
+		"alive":   {"id": "b2", "state": buildRunning, "created": old, heartbeatAttribute: now.UnixNano()},
+		"waiting": {"id": "b3", "state": buildPending, "created": old, heartbeatAttribute: old},
+		"legacy":  {"id": "b4", "state": buildRunning, "created": old},
+		"built":   {"id": "b5", "state": buildReady, "created": old, heartbeatAttribute: old},
+	} {
+		if err := container.PutItemSync(&v3io.PutItemInput{Path: "/build/p1/" + name + ".latest", Attributes: attributes}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := failStaleBuilds(container, now.Add(-staleBuildTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	for name, state := range map[string]string{
+		"stale":   buildError,
+		"alive":   buildRunning,
+		"waiting": buildError,
+		"legacy":  buildError,
+		"built":   buildReady,
+	} {
+		response, err := container.GetItemSync(&v3io.GetItemInput{Path: "/build/p1/" + name + ".latest", AttributeNames: []string{"state"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := response.Output.(*v3io.GetItemOutput).Item.GetFieldString("state")
+		response.Release()
+		if stored != state {
+			t.Errorf("Build %s is %s, expected %s", name, stored, state)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+)
+
+// What else is removed with a run: its log, or its log and the artifacts
+// stored under the run uid (<key>.<uid>, tags such as latest are kept)
+const (
+	cascadeNone = "none"
+	cascadeLogs = "logs"
+	cascadeAll  = "all"
+)
+
+var cascadeParam = api.Param{Name: "cascade", In: api.InQuery, Type: api.String, Enum: []string{cascadeNone, cascadeLogs, cascadeAll},
+	Description: "Also delete the run logs (logs, default) or logs and uid artifacts (all)"}
+
+func cascadeMode(ctx *fasthttp.RequestCtx) string {
+	if mode := string(ctx.QueryArgs().Peek("cascade")); mode != "" {
+		return mode
+	}
+	return cascadeLogs
+}
+
+func deleteRunResources(container v3io.Container, project, uid interface{}, cascade string) error {
+	if cascade == cascadeNone {
+		return nil
+	}
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: fmt.Sprintf("/log/%s-%s", project, uid)})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if cascade != cascadeAll {
+		return nil
+	}
+
+	filterStr, err := buildArtifactFilterString(nil, "", fmt.Sprintf(".%s", uid))
+	if err != nil {
+		return err
+	}
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifact/%s/", project),
+		AttributeNames: []string{"__name"},
+		Filter:         filterStr,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	if len(items) > 0 {
+		stateOf(container).summaries.invalidate(fmt.Sprint(project))
+	}
+	for _, item := range items {
+		name, _ := item.GetFieldString("__name")
+		clog.printF("Deleting artifact %s of run %s\n", name, uid)
+		if err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: fmt.Sprintf("/artifact/%s/%s", project, name)}); err != nil && !isNotFound(err) {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mtimeAttribute is the v3io modification time of items and objects, the
+// nanoseconds are in mtimeNsecsAttribute
+const (
+	mtimeAttribute      = "__mtime_secs"
+	mtimeNsecsAttribute = "__mtime_nsecs"
+)
+
+// bodyETag is the strong ETag of a response body
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// itemModified returns the modification time of an item read with the
+// mtimeAttribute, zero when unknown
+func itemModified(item v3io.Item) time.Time {
+	secs, err := item.GetFieldInt(mtimeAttribute)
+	if err != nil || secs <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(secs), 0)
+}
+
+// unchangedCondition is a write condition holding while the item is not
+// modified since it was read with the mtime attributes
+func unchangedCondition(item v3io.Item) string {
+	secs, _ := item.GetFieldInt(mtimeAttribute)
+	nsecs, _ := item.GetFieldInt(mtimeNsecsAttribute)
+	return fmt.Sprintf("%s == %d and %s == %d", mtimeAttribute, secs, mtimeNsecsAttribute, nsecs)
+}
+
+// objectModified returns the modification time of a v3io object, zero when
+// unknown
+func objectModified(container v3io.Container, path string) time.Time {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: path, AttributeNames: []string{mtimeAttribute}})
+	if err != nil {
+		return time.Time{}
+	}
+	defer v3ioResponse.Release()
+	return itemModified(v3ioResponse.Output.(*v3io.GetItemOutput).Item)
+}
+
+// writeConditional sets the ETag and Last-Modified headers of a body and writes
+// it, unless the request If-None-Match or (without it) If-Modified-Since
+// headers match and the response is 304 Not Modified. HEAD requests get the
+// headers and Content-Length only, fasthttp skips their body
+func writeConditional(ctx *fasthttp.RequestCtx, body []byte, modified time.Time) {
+	etag := bodyETag(body)
+	ctx.Response.Header.Set("ETag", etag)
+	if !modified.IsZero() {
+		ctx.Response.Header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(ctx, etag, modified) {
+		ctx.Response.SetStatusCode(http.StatusNotModified)
+		ctx.Response.ResetBody()
+		return
+	}
+	ctx.Response.SetBody(body)
+}
+
+func notModified(ctx *fasthttp.RequestCtx, etag string, modified time.Time) bool {
+	if match := string(ctx.Request.Header.Peek("If-None-Match")); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(string(ctx.Request.Header.Peek("If-Modified-Since")))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 10 * time.Second
+	// A session is recreated at most once per reconnectInterval
+	reconnectInterval = 10 * time.Second
+)
+
+// connect creates the v3io context and container and verifies v3io is
+// reachable, retrying with exponential backoff until timeout. Authentication
+// failures are not retried
+func connect(config *DBConfig) (v3io.Context, v3io.Container, error) {
+	context, err := createContext(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	deadline := time.Now().Add(config.ConnectTimeout)
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		newContainer, err := createSessionContainer(context, config)
+		if err == nil {
+			err = probeContainer(newContainer)
+		}
+		if err == nil {
+			return context, newContainer, nil
+		}
+		if isAuthError(err) {
+			return nil, nil, fmt.Errorf("v3io at %s rejected the access key for container %s: %s", config.Endpoint, config.Container, err)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, nil, fmt.Errorf("v3io at %s is unreachable after %d attempts: %s", config.Endpoint, attempt, err)
+		}
+		fmt.Printf("Failed to connect to v3io at %s (attempt %d), retrying in %s: %s\n", config.Endpoint, attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+func probeContainer(c v3io.Container) error {
+	v3ioResponse, err := c.GetContainerContentsSync(&v3io.GetContainerContentsInput{Path: "/", DirectoriesOnly: true, Limit: 1})
+	if err != nil {
+		return err
+	}
+	v3ioResponse.Release()
+	return nil
+}
+
+func isAuthError(err error) bool {
+	errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	return ok && (errWithStatusCode.StatusCode() == http.StatusUnauthorized || errWithStatusCode.StatusCode() == http.StatusForbidden)
+}
+
+// v3io answers an expired session with 401, or a 403 telling so, other 403
+// responses deny the path
+var expiredSessionRegex = regexp.MustCompile(`(?i)session[^"]*(expired|invalid)|(expired|invalid)[^"]*session`)
+
+func isSessionError(err error) bool {
+	errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	if !ok {
+		return false
+	}
+	switch errWithStatusCode.StatusCode() {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusForbidden:
+		return expiredSessionRegex.MatchString(err.Error())
+	}
+	return false
+}
+
+// reconnectingContainer opens a new v3io session, on the same context, when
+// the session expires at runtime and retries the failed call once on it. The
+// container is replaced under the lock, so every method goes through
+// current() and none is promoted from an embedded container
+type reconnectingContainer struct {
+	container     v3io.Container
+	context       v3io.Context
+	config        *DBConfig
+	lock          sync.RWMutex
+	lastReconnect time.Time
+}
+
+var _ v3io.Container = &reconnectingContainer{}
+
+func newReconnectingContainer(config *DBConfig, context v3io.Context, initial v3io.Container) *reconnectingContainer {
+	return &reconnectingContainer{container: initial, context: context, config: config}
+}
+
+func (c *reconnectingContainer) current() v3io.Container {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.container
+}
+
+func (c *reconnectingContainer) reconnect(stale v3io.Container) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.container != stale {
+		// Another request already reconnected
+		return nil
+	}
+	if time.Since(c.lastReconnect) < reconnectInterval {
+		return fmt.Errorf("Reconnected to v3io less than %s ago", reconnectInterval)
+	}
+	c.lastReconnect = time.Now()
+	newContainer, err := createSessionContainer(c.context, c.config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reconnected to v3io at %s\n", c.config.Endpoint)
+	c.container = newContainer
+	return nil
+}
+
+func (c *reconnectingContainer) do(call func(v3io.Container) error) error {
+	used := c.current()
+	err := call(used)
+	if isSessionError(err) {
+		if reconnectErr := c.reconnect(used); reconnectErr == nil {
+			err = call(c.current())
+		}
+	}
+	return err
+}
+
+func (c *reconnectingContainer) GetItemSync(input *v3io.GetItemInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetItemSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) GetItemsSync(input *v3io.GetItemsInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetItemsSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) PutItemSync(input *v3io.PutItemInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.PutItemSync(input)
+	})
+}
+
+func (c *reconnectingContainer) UpdateItemSync(input *v3io.UpdateItemInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.UpdateItemSync(input)
+	})
+}
+
+func (c *reconnectingContainer) GetObjectSync(input *v3io.GetObjectInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetObjectSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) PutObjectSync(input *v3io.PutObjectInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.PutObjectSync(input)
+	})
+}
+
+func (c *reconnectingContainer) DeleteObjectSync(input *v3io.DeleteObjectInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.DeleteObjectSync(input)
+	})
+}
+
+func (c *reconnectingContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetContainerContentsSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) PutItemsSync(input *v3io.PutItemsInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.PutItemsSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) GetContainersSync(input *v3io.GetContainersInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetContainersSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) CreateStreamSync(input *v3io.CreateStreamInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.CreateStreamSync(input)
+	})
+}
+
+func (c *reconnectingContainer) DeleteStreamSync(input *v3io.DeleteStreamInput) error {
+	return c.do(func(container v3io.Container) error {
+		return container.DeleteStreamSync(input)
+	})
+}
+
+func (c *reconnectingContainer) SeekShardSync(input *v3io.SeekShardInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.SeekShardSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) PutRecordsSync(input *v3io.PutRecordsInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.PutRecordsSync(input)
+		return
+	})
+	return
+}
+
+func (c *reconnectingContainer) GetRecordsSync(input *v3io.GetRecordsInput) (response *v3io.Response, err error) {
+	err = c.do(func(container v3io.Container) (callErr error) {
+		response, callErr = container.GetRecordsSync(input)
+		return
+	})
+	return
+}
+
+// The async calls complete on the response channel, so they use the current
+// session without retrying on expiry
+
+func (c *reconnectingContainer) GetItem(input *v3io.GetItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetItem(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) GetItems(input *v3io.GetItemsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetItems(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) PutItem(input *v3io.PutItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().PutItem(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) PutItems(input *v3io.PutItemsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().PutItems(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) UpdateItem(input *v3io.UpdateItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().UpdateItem(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) GetObject(input *v3io.GetObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetObject(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) PutObject(input *v3io.PutObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().PutObject(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) DeleteObject(input *v3io.DeleteObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().DeleteObject(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) GetContainers(input *v3io.GetContainersInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetContainers(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) GetContainerContents(input *v3io.GetContainerContentsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetContainerContents(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) CreateStream(input *v3io.CreateStreamInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().CreateStream(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) DeleteStream(input *v3io.DeleteStreamInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().DeleteStream(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) SeekShard(input *v3io.SeekShardInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().SeekShard(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) PutRecords(input *v3io.PutRecordsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().PutRecords(input, context, responseChan)
+}
+
+func (c *reconnectingContainer) GetRecords(input *v3io.GetRecordsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	return c.current().GetRecords(input, context, responseChan)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+// sessionContext opens sessions whose container fails the item reads with
+// the status of failures, if any
+type sessionContext struct {
+	sessions int
+	failures []error
+}
+
+type failingContainer struct {
+	v3io.Container
+	err error
+}
+
+func (c *failingContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Container.GetItemSync(input)
+}
+
+func (s *sessionContext) NewSession(*v3io.NewSessionInput) (v3io.Session, error) {
+	s.sessions++
+	return s, nil
+}
+
+func (s *sessionContext) NewContainer(*v3io.NewContainerInput) (v3io.Container, error) {
+	var err error
+	if len(s.failures) > 0 {
+		err, s.failures = s.failures[0], s.failures[1:]
+	}
+	return &failingContainer{Container: newMockContainer(), err: err}, nil
+}
+
+func TestReconnectingContainer(t *testing.T) {
+	denied := v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Access to path denied"), http.StatusForbidden)
+	expired := v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Session expired"), http.StatusForbidden)
+	unauthorized := v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Unauthorized"), http.StatusUnauthorized)
+	for _, test := range []struct {
+		err      error
+		sessions int
+	}{
+		{denied, 0},
+		{expired, 1},
+		{unauthorized, 1},
+	} {
+		context := &sessionContext{failures: []error{test.err}}
+		initial, _ := context.NewContainer(nil)
+		context.sessions = 0
+		container := newReconnectingContainer(&DBConfig{}, context, initial)
+		_, err := container.GetItemSync(&v3io.GetItemInput{Path: "/missing"})
+		if context.sessions != test.sessions {
+			t.Errorf("%s: opened %d sessions, expected %d", test.err, context.sessions, test.sessions)
+		}
+		if test.sessions > 0 && !isNotFound(err) {
+			t.Errorf("%s: the retry returned %v, expected not found", test.err, err)
+		}
+
+		// Sessions are reopened once per interval
+		context.failures = []error{test.err}
+		container.current().(*failingContainer).err = test.err
+		container.GetItemSync(&v3io.GetItemInput{Path: "/missing"})
+		if context.sessions != test.sessions {
+			t.Errorf("%s: reopened a session within the interval", test.err)
+		}
+	}
+}
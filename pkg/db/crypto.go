@@ -0,0 +1,113 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Encrypted values are prefixed so plain values written before encryption was
+// enabled are still readable
+var encryptedPrefix = []byte("\x00mlenc1:")
+
+// dataCipher is nil unless an encryption key is configured
+var dataCipher cipher.AEAD
+
+func initEncryption(config *DBConfig) error {
+	key := config.EncryptionKey
+	if config.EncryptionKeyFile != "" {
+		data, err := ioutil.ReadFile(config.EncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read encryption key: %s", err)
+		}
+		key = string(data)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil
+	}
+
+	keyBytes, err := parseEncryptionKey(key)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return err
+	}
+	dataCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// parseEncryptionKey accepts a 16, 24 or 32 byte key in hex or base64
+func parseEncryptionKey(key string) ([]byte, error) {
+	if keyBytes, err := hex.DecodeString(key); err == nil && validKeyLength(keyBytes) {
+		return keyBytes, nil
+	}
+	if keyBytes, err := base64.StdEncoding.DecodeString(key); err == nil && validKeyLength(keyBytes) {
+		return keyBytes, nil
+	}
+	return nil, fmt.Errorf("Encryption key must be a hex or base64 encoded 16, 24 or 32 byte AES key")
+}
+
+func validKeyLength(key []byte) bool {
+	return len(key) == 16 || len(key) == 24 || len(key) == 32
+}
+
+// sealData encrypts a _data_ attribute or log body when encryption is enabled
+func sealData(data []byte) []byte {
+	if dataCipher == nil {
+		return data
+	}
+	nonce := make([]byte, dataCipher.NonceSize())
+	rand.Read(nonce)
+	result := append([]byte{}, encryptedPrefix...)
+	result = append(result, nonce...)
+	return dataCipher.Seal(result, nonce, data, nil)
+}
+
+// openData decrypts values written by sealData and returns other values as
+// is, MessagePack values in JSON form
+func openData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedPrefix) {
+		return unpackData(data)
+	}
+	if dataCipher == nil {
+		return nil, fmt.Errorf("Object is encrypted but no encryption key is configured")
+	}
+	data = data[len(encryptedPrefix):]
+	if len(data) < dataCipher.NonceSize() {
+		return nil, fmt.Errorf("Encrypted object is truncated")
+	}
+	nonce := data[:dataCipher.NonceSize()]
+	plain, err := dataCipher.Open(nil, nonce, data[dataCipher.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt object: %s", err)
+	}
+	return unpackData(plain)
+}
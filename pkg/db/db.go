@@ -20,64 +20,436 @@ such restriction.
 package db
 
 import (
-	"github.com/buaazp/fasthttprouter"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/builder"
+	"github.com/mlrun/controller/pkg/defaults"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/mlrun/controller/pkg/secrets"
 	"github.com/nuclio/zap"
 	"github.com/v3io/v3io-go/pkg/dataplane"
 	"github.com/v3io/v3io-go/pkg/dataplane/http"
+	"github.com/valyala/fasthttp"
+	"net"
+	"time"
 )
 
 type DBConfig struct {
-	Endpoint  string
-	Container string
-	AccessKey string
+	Endpoint       string
+	Container      string
+	AccessKey      string
+	ConnectTimeout time.Duration
+
+	// Zero values keep the v3io library defaults
+	RequestTimeout  time.Duration
+	DialTimeout     time.Duration
+	MaxConnsPerHost int
+	NumWorkers      int
+
+	Retries          int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Hex or base64 AES key for the _data_ attribute and log bodies, the file
+	// (e.g. mounted from a KMS/secrets manager) takes precedence (empty disables)
+	EncryptionKey     string
+	EncryptionKeyFile string
+
+	// Defaults for function image builds
+	Builder builder.Config
+
+	// Defaults of stored functions and runs, the namespace defaults to the
+	// runtime namespace
+	Defaults defaults.Config
+
+	// Kubernetes cluster for running functions and watching run pods (nil disables)
+	Runtime    *runtime.Config
+	LaunchRuns bool
+
+	// Project secrets store (empty provider disables secrets)
+	Secrets secrets.Config
+
+	// KFP API server that /pipelines/kfp proxies to (empty disables)
+	KFPURL string
+
+	// Credentials for presigned artifact download URLs
+	S3 S3Config
+
+	// Stores artifacts are downloaded from, as v3io://container/prefix,
+	// s3://bucket/prefix or https://host/prefix, {project} is replaced by the
+	// artifact project (empty disables downloads)
+	DownloadTargets []string
+
+	// Run/artifact change events, e.g. kafka://broker:9092/topic (empty disables)
+	EventsSink string
+
+	// Namespace segment of the DB paths, /run/<namespace>/<project>/... (empty:
+	// no segment), for requests that name no namespace. Servers of different
+	// namespaces can share a container
+	StorageNamespace string
+	// Other namespaces requests may name, in the NamespaceHeader or the
+	// namespace parameter, their DB paths have their own segment
+	Namespaces []string
+
+	// Keep the DB objects in memory instead of v3io, they are lost on exit
+	MockV3io bool
+
+	// Storage quota of the projects that do not set their own
+	StorageQuota StorageQuota
+
+	// Fields and labels of runs and artifacts indexed as attributes
+	Indexing IndexConfig
 }
 
 func InitDB(config *DBConfig) (*MLRunDB, error) {
-	newContainer, err := createContainer(config)
-	if err != nil {
-		return &MLRunDB{}, nil
+	if err := initEncryption(config); err != nil {
+		return nil, err
+	}
+	if err := config.StorageQuota.validate(); err != nil {
+		return nil, err
+	}
+	storageDefaults = config.StorageQuota
+	if err := config.Indexing.validate(); err != nil {
+		return nil, err
+	}
+	indexing = newIndexPolicy(config.Indexing)
+	var err error
+	var container v3io.Container
+	if config.MockV3io {
+		container = newMockContainer()
+	} else {
+		context, newContainer, err := connect(config)
+		if err != nil {
+			return nil, err
+		}
+		container = newResilientContainer(config, newReconnectingContainer(config, context, newContainer)) // TODO: should use class and container as part of it
+	}
+	if err := initEvents(container, config); err != nil {
+		return nil, err
 	}
-	container = newContainer // TODO: should use class and container as part of it
-	return &MLRunDB{cfg: config, container: newContainer}, nil
+	mldb := MLRunDB{cfg: config, container: container}
+	if err = initContainers(container, config.StorageNamespace, config.Namespaces); err != nil {
+		return nil, err
+	}
+	if config.Runtime != nil {
+		if mldb.k8s, err = runtime.NewClient(config.Runtime); err != nil {
+			return nil, err
+		}
+		if config.LaunchRuns {
+			mldb.launcher = runtime.NewLauncher(mldb.k8s, config.Runtime)
+		}
+	}
+	objectDefaults = config.Defaults
+	if objectDefaults.Namespace == "" && mldb.k8s != nil {
+		objectDefaults.Namespace = mldb.k8s.Namespace()
+	}
+	if config.Secrets.Provider != "" {
+		if mldb.secrets, err = secrets.NewStore(&config.Secrets, mldb.k8s); err != nil {
+			return nil, err
+		}
+		if mldb.launcher != nil {
+			mldb.launcher.SetSecrets(mldb.secrets)
+		}
+	}
+	return &mldb, nil
 }
 
 type MLRunDB struct {
-	cfg       *DBConfig
-	container v3io.Container
+	runRetention     int64 // accessed atomically, kept first for 64-bit alignment
+	heartbeatTimeout int64
+	cfg              *DBConfig
+	container        v3io.Container
+	targets          targetContainers
+	k8s              *runtime.Client
+	launcher         *runtime.Launcher
+	secrets          secrets.Store
 }
 
-func (db *MLRunDB) RegisterHandlers(router *fasthttprouter.Router) {
-	router.POST("/log/:project/:uid", storeLogHandler)
-	router.GET("/log/:project/:uid", getLogHandler)
-	router.POST("/run/:project/:uid", storeRunHandler)
-	router.PATCH("/run/:project/:uid", updateRunHandler)
-	router.GET("/run/:project/:uid", readRunHandler)
-	router.DELETE("/run/:project/:uid", deleteRunHandler)
-	router.GET("/runs", listRunsHandler)
-	router.DELETE("/runs", deleteRunsHandler)
-	router.POST("/artifact/:project/:uid", storeArtifactHandler)
-	router.GET("/artifact/:project", getArtifactHandler)
-	router.DELETE("/artifact/:project", deleteArtifactHandler)
-	router.GET("/artifacts", listArtifactsHandler)
-	router.DELETE("/artifacts", deleteArtifactsHandler)
+func (db *MLRunDB) SetVerbose(verbose bool) {
+	clog.setPrint(verbose)
+}
+
+const (
+	logTag      = "logs"
+	runTag      = "runs"
+	artifactTag = "artifacts"
+	graphqlTag  = "graphql"
+	adminTag    = "admin"
+	queueTag    = "queue"
+	projectTag  = "projects"
+	funcTag     = "functions"
+	buildTag    = "build"
+	submitTag   = "submit"
+	scheduleTag = "schedules"
+	pipelineTag = "pipelines"
+	alertTag    = "alerts"
+)
+
+var (
+	projectParam     = api.IdentifierParam("project", true, "Project name")
+	nameParam        = api.QueryParam("name", api.String, false, "Filter by name")
+	stateParam       = api.QueryParam("state", api.String, false, "Filter by run state")
+	labelParam       = api.MultiQueryParam("label", "Label filter (key, key=value, key!=value or key~=value)")
+	keyParam         = api.IdentifierParam("key", true, "Artifact key")
+	tagParam         = api.IdentifierParam("tag", false, "Artifact tag (default: latest)")
+	tagsParam        = api.QueryParam("tag", api.String, false, "Artifact tag (default: latest, * for all)")
+	functionTagParam = api.IdentifierParam("tag", false, "Function tag (default: latest)")
+	leaseIDParam     = api.QueryParam("id", api.String, true, "Queue item id")
+	leaseParam       = api.QueryParam("lease", api.String, true, "Lease id returned by lease")
+	pageSizeParam    = api.QueryParam("page_size", api.Integer, false, "Items per page (default: 100)")
+	pageTokenParam   = api.QueryParam("page_token", api.String, false, "The next_page_token of the previous page")
+)
+
+func (db *MLRunDB) Routes() []api.Route {
+	return []api.Route{
+		{Method: "POST", Path: "/log/:project/:uid", Name: "storeLog", Summary: "Store run log", Tag: logTag,
+			Body: api.RawBody, Handler: storeLogHandler},
+		{Method: "GET", Path: "/log/:project/:uid", Name: "getLog", Summary: "Get run log, 304 when not modified (If-None-Match/If-Modified-Since)", Tag: logTag,
+			Handler: getLogHandler},
+		{Method: "HEAD", Path: "/log/:project/:uid", Name: "headLog", Summary: "Check a run log exists, its size, ETag and Last-Modified", Tag: logTag,
+			Handler: getLogHandler},
+		{Method: "POST", Path: "/run/:project", Name: "storeNewRun", Summary: "Store a run under a generated uid, returns the uid", Tag: runTag,
+			Body: api.ObjectBody, Handler: storeNewRunHandler},
+		{Method: "POST", Path: "/run/:project/:uid", Name: "storeRun", Summary: "Store run, 409 when a run with another name has the uid", Tag: runTag,
+			Body: api.ObjectBody, Handler: storeRunHandler},
+		{Method: "PATCH", Path: "/run/:project/:uid", Name: "updateRun", Summary: "Update run fields by dot separated path", Tag: runTag,
+			Body: api.ObjectBody, Handler: updateRunHandler},
+		{Method: "POST", Path: "/run/:project/:uid/tag/:tag", Name: "tagRun", Summary: "Tag a run, e.g. as baseline", Tag: runTag,
+			Handler: tagRunHandler},
+		{Method: "DELETE", Path: "/run/:project/:uid/tag/:tag", Name: "untagRun", Summary: "Remove a run tag", Tag: runTag,
+			Handler: untagRunHandler},
+		{Method: "POST", Path: "/run/:project/:uid/notes", Name: "addRunNote", Summary: "Add a note (text and author) to a run, returns the run notes", Tag: runTag,
+			Body: api.ObjectBody, Handler: addRunNoteHandler},
+		{Method: "GET", Path: "/runtags/:project", Name: "listRunTags", Summary: "List the run tags used in a project", Tag: runTag,
+			Handler: listRunTagsHandler},
+		{Method: "POST", Path: "/run/:project/:uid/heartbeat", Name: "runHeartbeat", Summary: "Report that a run is alive", Tag: runTag,
+			Handler: heartbeatHandler},
+		{Method: "GET", Path: "/run/:project/:uid/children", Name: "listRunChildren", Summary: "List the runs started by a run, the whole tree with recursive", Tag: runTag,
+			Params:  []api.Param{api.QueryParam("recursive", api.Boolean, false, "Also list the runs started by the children")},
+			Handler: runChildrenHandler},
+		{Method: "GET", Path: "/run/:project/:uid", Name: "readRun", Summary: "Read run, 304 when not modified (If-None-Match/If-Modified-Since)", Tag: runTag,
+			Handler: readRunHandler},
+		{Method: "HEAD", Path: "/run/:project/:uid", Name: "headRun", Summary: "Check a run exists, its size, ETag and Last-Modified", Tag: runTag,
+			Handler: readRunHandler},
+		{Method: "DELETE", Path: "/run/:project/:uid", Name: "deleteRun", Summary: "Delete run", Tag: runTag,
+			Params:  []api.Param{cascadeParam},
+			Handler: deleteRunHandler},
+		{Method: "GET", Path: "/runs", Name: "listRuns", Summary: "List runs", Tag: runTag,
+			Params: []api.Param{projectParam, nameParam, stateParam, labelParam,
+				api.QueryParam("sort", api.Boolean, false, "Sort by last update time"),
+				api.QueryParam("last", api.Integer, false, "Maximal number of runs to return"),
+				api.IdentifierParam("tag", false, "Filter by run tag"),
+				api.QueryParam("notes", api.Boolean, false, "Only runs with notes"),
+				api.QueryParam("workflow", api.String, false, "Filter by workflow id (metadata.workflow or the workflow label)"),
+				{Name: "partition-by", In: api.InQuery, Type: api.String, Enum: []string{"name"}, Description: "Return the newest runs of each partition, sort and last are ignored"},
+				api.QueryParam("rows-per-partition", api.Integer, false, "Runs per partition (default: 1)"),
+				api.MultiQueryParam("filter", "Indexed numeric parameter or result filter (e.g. param.lr<0.01, result.accuracy>=0.9)")},
+			Handler: listRunsHandler},
+		{Method: "GET", Path: apiV1Prefix + "/runs", Name: "listRunsV1", Summary: "List runs by pages, with the total, filter and server time", Tag: runTag,
+			Params: []api.Param{projectParam, nameParam, stateParam, labelParam,
+				api.IdentifierParam("tag", false, "Filter by run tag"),
+				api.QueryParam("notes", api.Boolean, false, "Only runs with notes"),
+				api.QueryParam("workflow", api.String, false, "Filter by workflow id (metadata.workflow or the workflow label)"),
+				api.MultiQueryParam("filter", "Indexed numeric parameter or result filter (e.g. param.lr<0.01, result.accuracy>=0.9)"),
+				pageSizeParam, pageTokenParam},
+			Handler: listRunsV1Handler},
+		{Method: "DELETE", Path: "/runs", Name: "deleteRuns", Summary: "Delete runs", Tag: runTag,
+			Params:  []api.Param{projectParam, nameParam, stateParam, labelParam, cascadeParam},
+			Handler: deleteRunsHandler},
+		{Method: "POST", Path: "/runs/query", Name: "queryRuns", Summary: "List runs matching a structured filter with and/or/not groups", Tag: runTag,
+			Body: api.ObjectBody, Handler: queryRunsHandler},
+		{Method: "POST", Path: "/artifact/:project/:uid", Name: "storeArtifact", Summary: "Store artifact, 409 when the version (key and uid) exists with another body", Tag: artifactTag,
+			Params: []api.Param{keyParam, tagParam,
+				api.QueryParam("force", api.Boolean, false, "Replace the version even when its body changed")},
+			Body: api.ObjectBody, Handler: storeArtifactHandler},
+		{Method: "PATCH", Path: "/artifact/:project/:uid", Name: "updateArtifact", Summary: "Update artifact fields by dot separated path, and its tag while it is the same version", Tag: artifactTag,
+			Params: []api.Param{keyParam, tagParam},
+			Body:   api.ObjectBody, Handler: updateArtifactHandler},
+		{Method: "GET", Path: "/artifact/:project", Name: "getArtifact", Summary: "Get artifact, 304 when not modified (If-None-Match/If-Modified-Since)", Tag: artifactTag,
+			Params:  []api.Param{keyParam, tagParam},
+			Handler: getArtifactHandler},
+		{Method: "HEAD", Path: "/artifact/:project", Name: "headArtifact", Summary: "Check an artifact exists, its size, ETag and Last-Modified", Tag: artifactTag,
+			Params:  []api.Param{keyParam, tagParam},
+			Handler: getArtifactHandler},
+		{Method: "DELETE", Path: "/artifact/:project", Name: "deleteArtifact", Summary: "Delete artifact", Tag: artifactTag,
+			Params:  []api.Param{keyParam, tagParam},
+			Handler: deleteArtifactHandler},
+		{Method: "GET", Path: "/artifact/:project/redirect", Name: "downloadArtifact", Summary: "Download an artifact, v3io targets are streamed and s3 targets redirect to a presigned URL", Tag: artifactTag,
+			Params: []api.Param{keyParam, tagParam,
+				api.QueryParam("expires", api.Integer, false, "Presigned URL lifetime in seconds (default: 900)"),
+				api.QueryParam("redirect", api.Boolean, false, "Set to false to return the URL as JSON instead of redirecting")},
+			Handler: db.artifactRedirectHandler},
+		{Method: "GET", Path: "/artifacts", Name: "listArtifacts", Summary: "List artifacts", Tag: artifactTag,
+			Params:  []api.Param{projectParam, nameParam, tagsParam, labelParam},
+			Handler: listArtifactsHandler},
+		{Method: "GET", Path: apiV1Prefix + "/artifacts", Name: "listArtifactsV1", Summary: "List artifacts by pages, with the total, filter and server time", Tag: artifactTag,
+			Params:  []api.Param{projectParam, nameParam, tagsParam, labelParam, pageSizeParam, pageTokenParam},
+			Handler: listArtifactsV1Handler},
+		{Method: "GET", Path: "/artifacts/search", Name: "searchArtifacts", Summary: "Search the artifacts of all projects, by pages (admin)", Tag: artifactTag,
+			Params: []api.Param{nameParam, tagsParam, labelParam,
+				api.QueryParam("kind", api.String, false, "Filter by artifact kind"),
+				api.QueryParam("limit", api.Integer, false, "Maximal number of artifacts to return (default: 100)"),
+				api.QueryParam("marker", api.String, false, "The next_marker of the previous page")},
+			Handler: searchArtifactsHandler},
+		{Method: "DELETE", Path: "/artifacts", Name: "deleteArtifacts", Summary: "Delete artifacts", Tag: artifactTag,
+			Params:  []api.Param{projectParam, nameParam, tagsParam, labelParam},
+			Handler: deleteArtifactsHandler},
+		{Method: "POST", Path: "/func/:project/:name", Name: "storeFunction", Summary: "Store function, also as an immutable version with its hash as tag (function_hash header)", Tag: funcTag,
+			Params: []api.Param{functionTagParam},
+			Body:   api.ObjectBody, Handler: storeFunctionHandler},
+		{Method: "GET", Path: "/func/:project/:name", Name: "getFunction", Summary: "Get function", Tag: funcTag,
+			Params:  []api.Param{functionTagParam},
+			Handler: getFunctionHandler},
+		{Method: "DELETE", Path: "/func/:project/:name", Name: "deleteFunction", Summary: "Delete function", Tag: funcTag,
+			Params:  []api.Param{functionTagParam},
+			Handler: deleteFunctionHandler},
+		{Method: "GET", Path: "/funcs", Name: "listFunctions", Summary: "List functions", Tag: funcTag,
+			Params:  []api.Param{projectParam, nameParam, api.QueryParam("tag", api.String, false, "Function tag (default: latest, * for all)"), labelParam},
+			Handler: listFunctionsHandler},
+		{Method: "POST", Path: "/build/function", Name: "buildFunction", Summary: "Build a function image asynchronously, returns the build id", Tag: buildTag,
+			Body: api.ObjectBody, Handler: db.buildFunctionHandler},
+		{Method: "GET", Path: "/build/status", Name: "buildStatus", Summary: "Get the build state and image (function_status/function_image headers) and log from offset", Tag: buildTag,
+			Params: []api.Param{api.IdentifierParam("project", false, "Project name (default: default)"),
+				api.IdentifierParam("name", true, "Function name"), functionTagParam,
+				api.QueryParam("offset", api.Integer, false, "Log offset in bytes"),
+				api.QueryParam("logs", api.Boolean, false, "Set to false to skip the log")},
+			Handler: buildStatusHandler},
+		{Method: "POST", Path: "/submit", Name: "submitRun", Summary: "Store a run (task) and launch its function as a Kubernetes job", Tag: submitTag,
+			Body: api.ObjectBody, Handler: db.submitHandler},
+		{Method: "POST", Path: "/schedules/:project/:name", Name: "storeSchedule", Summary: "Create or replace a cron schedule of a run (task and function)", Tag: scheduleTag,
+			Body: api.ObjectBody, Handler: storeScheduleHandler},
+		{Method: "GET", Path: "/schedules/:project/:name", Name: "getSchedule", Summary: "Get a schedule with its next and last run", Tag: scheduleTag,
+			Handler: getScheduleHandler},
+		{Method: "DELETE", Path: "/schedules/:project/:name", Name: "deleteSchedule", Summary: "Delete a schedule", Tag: scheduleTag,
+			Handler: deleteScheduleHandler},
+		{Method: "GET", Path: "/schedules", Name: "listSchedules", Summary: "List schedules", Tag: scheduleTag,
+			Params:  []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)")},
+			Handler: listSchedulesHandler},
+		{Method: "POST", Path: "/alerts/:project/:name", Name: "storeAlert", Summary: "Create or replace an alert rule (run_failed or no_success) and its notification targets", Tag: alertTag,
+			Body: api.ObjectBody, Handler: storeAlertHandler},
+		{Method: "GET", Path: "/alerts/:project/:name", Name: "getAlert", Summary: "Get an alert rule with its firing state", Tag: alertTag,
+			Handler: getAlertHandler},
+		{Method: "DELETE", Path: "/alerts/:project/:name", Name: "deleteAlert", Summary: "Delete an alert rule", Tag: alertTag,
+			Handler: deleteAlertHandler},
+		{Method: "GET", Path: "/alerts", Name: "listAlerts", Summary: "List alert rules", Tag: alertTag,
+			Params:  []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)")},
+			Handler: listAlertsHandler},
+		{Method: "POST", Path: "/pipeline/:project", Name: "submitPipeline", Summary: "Submit a pipeline, a DAG of steps each running a task of a function", Tag: pipelineTag,
+			Body: api.ObjectBody, Handler: db.submitPipelineHandler},
+		{Method: "GET", Path: "/pipeline/:project/:id", Name: "getPipeline", Summary: "Get pipeline and step states", Tag: pipelineTag,
+			Handler: getPipelineHandler},
+		{Method: "DELETE", Path: "/pipeline/:project/:id", Name: "deletePipeline", Summary: "Delete a pipeline record", Tag: pipelineTag,
+			Handler: deletePipelineHandler},
+		{Method: "POST", Path: "/pipeline/:project/:id/retry", Name: "retryPipeline", Summary: "Run the failed and skipped steps of a failed pipeline again", Tag: pipelineTag,
+			Handler: db.retryPipelineHandler},
+		{Method: "GET", Path: "/pipelines", Name: "listPipelines", Summary: "List pipelines", Tag: pipelineTag,
+			Params: []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)"),
+				api.QueryParam("state", api.String, false, "Filter by pipeline state")},
+			Handler: listPipelinesHandler},
+		{Method: "POST", Path: "/pipelines/kfp", Name: "submitKFPPipeline", Summary: "Submit a Kubeflow pipeline run labeled with the project", Tag: pipelineTag,
+			Params: []api.Param{api.IdentifierParam("project", false, "Project name (default: default)"),
+				api.QueryParam("experiment", api.String, false, "KFP experiment id")},
+			Body: api.ObjectBody, Handler: db.submitKFPHandler},
+		{Method: "GET", Path: "/pipelines/kfp", Name: "listKFPPipelines", Summary: "List the Kubeflow pipeline runs of a project", Tag: pipelineTag,
+			Params:  []api.Param{api.IdentifierParam("project", false, "Project name (default: default)"), nameParam, labelParam},
+			Handler: listKFPRunsHandler},
+		{Method: "GET", Path: "/pipelines/kfp/:id", Name: "getKFPPipeline", Summary: "Get a Kubeflow pipeline run status", Tag: pipelineTag,
+			Handler: db.getKFPRunHandler},
+		{Method: "GET", Path: "/graphql", Name: "graphqlQuery", Summary: "Run a GraphQL query over runs and artifacts", Tag: graphqlTag,
+			Params: []api.Param{api.QueryParam("query", api.String, true, "GraphQL query document"),
+				api.QueryParam("operationName", api.String, false, "Operation to execute"),
+				api.QueryParam("variables", api.String, false, "JSON encoded query variables")},
+			Handler: graphqlHandler},
+		{Method: "POST", Path: "/graphql", Name: "graphqlPost", Summary: "Run a GraphQL query over runs and artifacts", Tag: graphqlTag,
+			Body: api.ObjectBody, Handler: graphqlHandler},
+		{Method: "GET", Path: "/admin/stats", Name: "storageStats", Summary: "Per project object counts and storage usage", Tag: adminTag,
+			Params:  []api.Param{api.QueryParam("refresh", api.Boolean, false, "Recompute instead of using cached stats")},
+			Handler: db.statsHandler},
+		{Method: "POST", Path: "/admin/fsck", Name: "fsck", Summary: "Check that run and artifact bodies parse and match their indexed attributes, that tags resolve and logs have runs", Tag: adminTag,
+			Params: []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)"),
+				api.QueryParam("repair", api.Boolean, false, "Reindex attributes and fix dangling tags (admin role)")},
+			Handler: fsckHandler},
+		{Method: "GET", Path: "/admin/orphans", Name: "orphanArtifacts", Summary: "Report the latest artifacts whose version was deleted", Tag: adminTag,
+			Params:  []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)")},
+			Handler: orphanLatestHandler},
+		{Method: "POST", Path: "/admin/orphans", Name: "fixOrphanArtifacts", Summary: "Repoint orphan latest artifacts to their newest remaining version, or remove them", Tag: adminTag,
+			Params:  []api.Param{api.IdentifierParam("project", false, "Project name (default: all projects)")},
+			Handler: orphanLatestHandler},
+		{Method: "GET", Path: "/metrics", Name: "metrics", Summary: "Run and artifact gauges and run completion counters in the Prometheus text format", Tag: adminTag,
+			Handler: metricsHandler},
+		{Method: "GET", Path: "/project/:name/summary", Name: "getProjectSummary", Summary: "Run counts by state, recent failures, artifact count and last activity of a project", Tag: projectTag,
+			Handler: projectSummaryHandler},
+		{Method: "GET", Path: "/project/:name/settings", Name: "getProjectSettings", Summary: "Get project defaults (artifact path, image, retention, notifications)", Tag: projectTag,
+			Handler: db.getProjectSettingsHandler},
+		{Method: "PUT", Path: "/project/:name/settings", Name: "storeProjectSettings", Summary: "Replace project defaults", Tag: projectTag,
+			Body: api.ObjectBody, Handler: db.storeProjectSettingsHandler},
+		{Method: "POST", Path: "/projects/:name/secrets", Name: "storeProjectSecrets", Summary: "Add or replace project secrets (a JSON object of keys and values) in the secrets provider", Tag: projectTag,
+			Body: api.ObjectBody, Handler: db.storeSecretsHandler},
+		{Method: "GET", Path: "/projects/:name/secrets", Name: "listProjectSecrets", Summary: "List project secret keys, values are never returned", Tag: projectTag,
+			Handler: db.listSecretsHandler},
+		{Method: "DELETE", Path: "/projects/:name/secrets", Name: "deleteProjectSecrets", Summary: "Delete project secrets", Tag: projectTag,
+			Params:  []api.Param{api.MultiQueryParam("key", "Secret keys to delete (default: all)")},
+			Handler: db.deleteSecretsHandler},
+		{Method: "POST", Path: "/queue/:name", Name: "enqueue", Summary: "Enqueue a run request (function reference and parameters)", Tag: queueTag,
+			Body: api.ObjectBody, Handler: db.enqueueHandler},
+		{Method: "GET", Path: "/queue/:name", Name: "listQueue", Summary: "List pending and leased queue items", Tag: queueTag,
+			Handler: db.listQueueHandler},
+		{Method: "POST", Path: "/queue/:name/lease", Name: "leaseQueue", Summary: "Lease pending items, they return to the queue unless acked before the visibility timeout", Tag: queueTag,
+			Params: []api.Param{api.QueryParam("visibility", api.Integer, false, "Visibility timeout in seconds (default: 60)"),
+				api.QueryParam("max", api.Integer, false, "Maximal number of items to lease (default: 1)")},
+			Handler: db.leaseHandler},
+		{Method: "POST", Path: "/queue/:name/ack", Name: "ackQueue", Summary: "Acknowledge and remove a leased item", Tag: queueTag,
+			Params:  []api.Param{leaseIDParam, leaseParam},
+			Handler: db.ackHandler},
+		{Method: "POST", Path: "/queue/:name/nack", Name: "nackQueue", Summary: "Return a leased item to the queue", Tag: queueTag,
+			Params:  []api.Param{leaseIDParam, leaseParam},
+			Handler: db.nackHandler},
+	}
+}
+
+func newContextInput(config *DBConfig) *v3io.NewContextInput {
+	input := v3io.NewContextInput{ClusterEndpoints: []string{config.Endpoint}, NumWorkers: config.NumWorkers}
+	if config.RequestTimeout == 0 && config.DialTimeout == 0 && config.MaxConnsPerHost == 0 {
+		return &input
+	}
+
+	httpClient := fasthttp.Client{
+		MaxConnsPerHost: config.MaxConnsPerHost,
+		ReadTimeout:     config.RequestTimeout,
+		WriteTimeout:    config.RequestTimeout,
+	}
+	if config.DialTimeout > 0 {
+		dialTimeout := config.DialTimeout
+		httpClient.Dial = func(addr string) (net.Conn, error) {
+			return fasthttp.DialTimeout(addr, dialTimeout)
+		}
+	}
+	input.HTTPClient = &httpClient
+	return &input
 }
 
 func createContainer(config *DBConfig) (v3io.Container, error) {
-	var logger *nucliozap.NuclioZap
-	var context v3io.Context
-	var session v3io.Session
-	var err error
-	if logger, err = nucliozap.NewNuclioZapCmd("mlrunhttp", nucliozap.DebugLevel); err != nil {
+	context, err := createContext(config)
+	if err != nil {
 		return nil, err
 	}
+	return createSessionContainer(context, config)
+}
 
-	if context, err = v3iohttp.NewContext(logger, &v3io.NewContextInput{ClusterEndpoints: []string{config.Endpoint}}); err != nil {
+// createContext creates a v3io context, with its workers
+func createContext(config *DBConfig) (v3io.Context, error) {
+	logger, err := nucliozap.NewNuclioZapCmd("mlrunhttp", nucliozap.DebugLevel)
+	if err != nil {
 		return nil, err
 	}
+	return v3iohttp.NewContext(logger, newContextInput(config))
+}
 
-	if session, err = context.NewSession(&v3io.NewSessionInput{AccessKey: config.AccessKey}); err != nil {
+// createSessionContainer opens a session of the access key on a context and
+// returns the container on it
+func createSessionContainer(context v3io.Context, config *DBConfig) (v3io.Container, error) {
+	session, err := context.NewSession(&v3io.NewSessionInput{AccessKey: config.AccessKey})
+	if err != nil {
 		return nil, err
 	}
-
 	return session.NewContainer(&v3io.NewContainerInput{ContainerName: config.Container})
 }
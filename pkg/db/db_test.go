@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+)
+
+// newTestDB returns a DB keeping its objects in memory
+func newTestDB(t *testing.T) *MLRunDB {
+	t.Helper()
+	mldb, err := InitDB(&DBConfig{MockV3io: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mldb
+}
+
+// testRequest sends a request to the route matching method and uri as an
+// admin, validating it as the server does, and returns the response
+func testRequest(t *testing.T, mldb *MLRunDB, method, uri, body string) (int, string) {
+	t.Helper()
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody([]byte(body))
+	api.SetRole(ctx, api.AdminRole)
+	segments := strings.Split(string(ctx.Path()), "/")
+	for _, route := range mldb.Routes() {
+		routeSegments := strings.Split(route.Path, "/")
+		if route.Method != method || len(routeSegments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		matched := true
+		for i, segment := range routeSegments {
+			if strings.HasPrefix(segment, ":") {
+				params[segment[1:]] = segments[i]
+			} else if segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		for name, value := range params {
+			ctx.SetUserValue(name, value)
+		}
+		if err := route.Validate(ctx); err != nil {
+			api.WriteError(ctx, fasthttp.StatusBadRequest, err)
+		} else {
+			route.Handler(ctx)
+		}
+		return ctx.Response.StatusCode(), string(ctx.Response.Body())
+	}
+	t.Fatalf("No route for %s %s", method, uri)
+	return 0, ""
+}
+
+// mustRequest is testRequest failing the test unless the response status is
+// expected
+func mustRequest(t *testing.T, mldb *MLRunDB, method, uri, body string, expected int) string {
+	t.Helper()
+	status, response := testRequest(t, mldb, method, uri, body)
+	if status != expected {
+		t.Fatalf("%s %s returned %d, expected %d: %s", method, uri, status, expected, response)
+	}
+	return response
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	downloadChunkSize     = 4 * 1024 * 1024
+	defaultPresignExpires = 15 * time.Minute
+	maxPresignExpires     = 7 * 24 * time.Hour
+)
+
+// S3Config holds the server credentials used to presign artifact downloads
+type S3Config struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	// Endpoint of S3 compatible stores (path style), empty for AWS
+	Endpoint string
+}
+
+// downloadTarget is an artifact target path split into the store (v3io, s3,
+// http or https), the container, bucket or host, and the clean object path
+type downloadTarget struct {
+	scheme string
+	host   string
+	path   string
+}
+
+// parseDownloadTarget accepts v3io:///container/path, v3io://container/path,
+// s3://bucket/key and http(s) URLs
+func parseDownloadTarget(targetPath string) (*downloadTarget, error) {
+	target, err := url.Parse(targetPath)
+	if err != nil || targetPath == "" {
+		return nil, fmt.Errorf("Invalid target path '%s'", targetPath)
+	}
+	result := downloadTarget{scheme: strings.ToLower(target.Scheme), host: target.Host, path: target.Path}
+	switch result.scheme {
+	case "v3ios":
+		result.scheme = "v3io"
+	case "v3io", "s3", "http", "https":
+	default:
+		return nil, fmt.Errorf("Can not download artifact target '%s', only v3io, s3 and http(s) are supported", targetPath)
+	}
+	if result.scheme == "v3io" && result.host == "" {
+		parts := strings.SplitN(strings.TrimPrefix(result.path, "/"), "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid v3io target path '%s'", targetPath)
+		}
+		result.host, result.path = parts[0], "/"+parts[1]
+	}
+	if result.host == "" || result.path == "" || result.path == "/" {
+		return nil, fmt.Errorf("Invalid target path '%s'", targetPath)
+	}
+	if result.scheme != "s3" {
+		result.path = path.Clean("/" + result.path)
+		return &result, nil
+	}
+	// S3 keys are kept as given, "a//b" and "a/" are keys of their own. Dot
+	// segments are rejected since clients resolve them in the URL
+	for _, segment := range strings.Split(result.path, "/") {
+		if segment == "." || segment == ".." {
+			return nil, fmt.Errorf("Invalid S3 target path '%s'", targetPath)
+		}
+	}
+	return &result, nil
+}
+
+// allowed tells if one of the DownloadTargets patterns of the project holds
+// the target, patterns are store URLs whose path is a prefix of the target
+// path, with {project} replaced by the project
+func (t *downloadTarget) allowed(patterns []string, project string) bool {
+	for _, pattern := range patterns {
+		allowed, err := parseDownloadTarget(strings.Replace(pattern, "{project}", project, -1))
+		if err != nil || allowed.scheme != t.scheme || allowed.host != t.host {
+			continue
+		}
+		if allowed.path == "/" || t.path == allowed.path || strings.HasPrefix(t.path, strings.TrimSuffix(allowed.path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// targetContainers caches sessions to containers other than the DB container
+type targetContainers struct {
+	lock       sync.Mutex
+	containers map[string]v3io.Container
+}
+
+func (c *targetContainers) get(config *DBConfig, name string) (v3io.Container, error) {
+	// The mock container holds the objects of all containers
+	if name == config.Container || config.MockV3io {
+		return rootContainer(), nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if targetContainer, ok := c.containers[name]; ok {
+		return targetContainer, nil
+	}
+	targetConfig := *config
+	targetConfig.Container = name
+	targetContainer, err := createContainer(&targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	if c.containers == nil {
+		c.containers = map[string]v3io.Container{}
+	}
+	c.containers[name] = targetContainer
+	return targetContainer, nil
+}
+
+func readArtifactTarget(container v3io.Container, project interface{}, key, tag string) (string, error) {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer v3ioResponse.Release()
+	body, err := openData(v3ioResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte))
+	if err != nil {
+		return "", err
+	}
+	JSONBody, err := convertDataToJSON(body)
+	if err != nil {
+		return "", err
+	}
+	artifact := common.Artifact{}
+	if err = json.Unmarshal(JSONBody, &artifact); err != nil {
+		return "", err
+	}
+	return artifact.TargetPath, nil
+}
+
+func (db *MLRunDB) artifactRedirectHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	key := string(ctx.QueryArgs().Peek("key"))
+	tag := string(ctx.QueryArgs().Peek("tag"))
+	if tag == "" {
+		tag = "latest"
+	}
+	targetPath, err := readArtifactTarget(container, ctx.UserValue("project"), key, tag)
+	if err != nil {
+		clog.printF("artifactRedirectHandler: Failed to read artifact %s: %s\n", key, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	target, err := parseDownloadTarget(targetPath)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Artifact %s has no valid target_path: %s", key, err))
+		return
+	}
+	// The server reads and signs with its own credentials, so only the stores
+	// configured for the project are served
+	if project, _ := ctx.UserValue("project").(string); !target.allowed(db.cfg.DownloadTargets, project) {
+		api.WriteError(ctx, http.StatusForbidden, fmt.Errorf("Artifact %s target '%s' is not an allowed download target of project %s", key, targetPath, project))
+		return
+	}
+
+	switch target.scheme {
+	case "v3io":
+		db.streamV3ioObject(container, ctx, target)
+	case "s3":
+		expires := defaultPresignExpires
+		if value := ctx.QueryArgs().Peek("expires"); len(value) > 0 {
+			seconds, _ := strconv.Atoi(string(value))
+			expires = time.Duration(seconds) * time.Second
+		}
+		if expires <= 0 || expires > maxPresignExpires {
+			api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("expires must be between 1 and %d seconds", int(maxPresignExpires.Seconds())))
+			return
+		}
+		if db.cfg.S3.AccessKey == "" || db.cfg.S3.SecretKey == "" {
+			api.WriteError(ctx, http.StatusNotImplemented, fmt.Errorf("S3 credentials are not configured on the server"))
+			return
+		}
+		redirect(ctx, presignS3URL(&db.cfg.S3, target.host, target.path, expires, time.Now()))
+	default:
+		redirect(ctx, targetPath)
+	}
+}
+
+// redirect sends a 307 to location, or returns it as JSON with ?redirect=false
+func redirect(ctx *fasthttp.RequestCtx, location string) {
+	if !api.QueryBool(ctx, "redirect", true) {
+		writeJSON(ctx, map[string]string{"url": location})
+		return
+	}
+	ctx.Response.Header.Set("Location", location)
+	ctx.Response.SetStatusCode(http.StatusTemporaryRedirect)
+}
+
+// streamV3ioObject sends a v3io object in chunks
+func (db *MLRunDB) streamV3ioObject(container v3io.Container, ctx *fasthttp.RequestCtx, target *downloadTarget) {
+	containerName, objectPath := target.host, target.path
+	targetContainer, err := db.targets.get(db.cfg, containerName)
+	if err != nil {
+		clog.printF("streamV3ioObject: Failed to open container %s: %s\n", containerName, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	v3ioResponse, err := targetContainer.GetItemSync(&v3io.GetItemInput{Path: objectPath, AttributeNames: []string{"__size"}})
+	if err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	size, _ := v3ioResponse.Output.(*v3io.GetItemOutput).Item.GetFieldInt("__size")
+	v3ioResponse.Release()
+
+	name := path.Base(objectPath)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	ctx.SetBodyStreamWriter(func(writer *bufio.Writer) {
+		for offset := 0; offset < size; offset += downloadChunkSize {
+			chunk, err := targetContainer.GetObjectSync(&v3io.GetObjectInput{Path: objectPath, Offset: offset, NumBytes: downloadChunkSize})
+			if err != nil {
+				clog.printF("streamV3ioObject: Failed to read %s at %d: %s\n", objectPath, offset, err)
+				return
+			}
+			_, err = writer.Write(chunk.Body())
+			chunk.Release()
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+	ctx.Response.Header.SetContentLength(size)
+}
+
+// awsEscape percent encodes everything but the RFC 3986 unreserved characters
+func awsEscape(value string, keepSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~', keepSlash && b == '/':
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// presignS3URL creates an AWS signature V4 presigned GET URL
+func presignS3URL(config *S3Config, bucket, key string, expires time.Duration, now time.Time) string {
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	scheme, host, uri := "https", fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, region), awsEscape(key, true)
+	if config.Endpoint != "" {
+		endpoint, err := url.Parse(config.Endpoint)
+		if err == nil && endpoint.Host != "" {
+			scheme, host = endpoint.Scheme, endpoint.Host
+			uri = "/" + awsEscape(bucket, false) + uri
+		}
+	}
+
+	return signS3URL(config, region, scheme, host, uri, expires, now)
+}
+
+// signS3URL returns the SigV4 presigned GET URL of the escaped uri on host
+func signS3URL(config *S3Config, region, scheme, host, uri string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/s3/aws4_request"
+	params := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    config.AccessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       strconv.Itoa(int(expires.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if config.SessionToken != "" {
+		params["X-Amz-Security-Token"] = config.SessionToken
+	}
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var query []string
+	for _, name := range names {
+		query = append(query, awsEscape(name, false)+"="+awsEscape(params[name], false))
+	}
+	canonicalQuery := strings.Join(query, "&")
+
+	canonicalRequest := strings.Join([]string{"GET", uri, canonicalQuery, "host:" + host, "", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", params["X-Amz-Date"], scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+config.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", scheme, host, uri, canonicalQuery, signature)
+}
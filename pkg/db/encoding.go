@@ -0,0 +1,130 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/tinylib/msgp/msgp"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+// MsgpackContentType is the content type of MessagePack run and artifact
+// bodies, they are stored in the compact MessagePack form and read as JSON
+const MsgpackContentType = "application/msgpack"
+
+// MessagePack values are prefixed, like encrypted ones, so JSON and YAML
+// values stay readable as is
+var msgpackPrefix = []byte("\x00mlmsgp1:")
+
+// isMsgpackRequest returns whether the request body is MessagePack
+func isMsgpackRequest(ctx *fasthttp.RequestCtx) bool {
+	contentType := strings.TrimSpace(strings.SplitN(string(ctx.Request.Header.ContentType()), ";", 2)[0])
+	switch strings.ToLower(contentType) {
+	case MsgpackContentType, "application/x-msgpack", "application/vnd.msgpack":
+		return true
+	}
+	return false
+}
+
+// requestData returns the request body, MessagePack bodies in JSON form
+func requestData(ctx *fasthttp.RequestCtx) ([]byte, error) {
+	if !isMsgpackRequest(ctx) {
+		return ctx.Request.Body(), nil
+	}
+	var JSONData bytes.Buffer
+	if rest, err := msgp.UnmarshalAsJSON(&JSONData, ctx.Request.Body()); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("Invalid MessagePack body: %v", err)
+	}
+	return JSONData.Bytes(), nil
+}
+
+// packData returns the prefixed MessagePack form of a JSON value
+func packData(JSONData []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(JSONData))
+	decoder.UseNumber()
+	return appendJSONValue(append([]byte{}, msgpackPrefix...), decoder)
+}
+
+// appendJSONValue appends the next JSON value of decoder in MessagePack form,
+// keeping the key order and the precision of integers
+func appendJSONValue(packed []byte, decoder *json.Decoder) ([]byte, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		var body []byte
+		count := 0
+		for decoder.More() {
+			if value == '{' {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				body = msgp.AppendString(body, key.(string))
+			}
+			if body, err = appendJSONValue(body, decoder); err != nil {
+				return nil, err
+			}
+			count++
+		}
+		// The closing delimiter
+		if _, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+		if value == '{' {
+			packed = msgp.AppendMapHeader(packed, uint32(count))
+		} else {
+			packed = msgp.AppendArrayHeader(packed, uint32(count))
+		}
+		return append(packed, body...), nil
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return msgp.AppendInt64(packed, integer), nil
+		}
+		float, err := value.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return msgp.AppendFloat64(packed, float), nil
+	case string:
+		return msgp.AppendString(packed, value), nil
+	case bool:
+		return msgp.AppendBool(packed, value), nil
+	}
+	return msgp.AppendNil(packed), nil
+}
+
+// unpackData returns the JSON form of values written by packData and other
+// values as is
+func unpackData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, msgpackPrefix) {
+		return data, nil
+	}
+	var JSONData bytes.Buffer
+	if _, err := msgp.UnmarshalAsJSON(&JSONData, data[len(msgpackPrefix):]); err != nil {
+		return nil, fmt.Errorf("Failed to decode MessagePack object: %s", err)
+	}
+	return JSONData.Bytes(), nil
+}
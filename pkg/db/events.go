@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/events"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+)
+
+// publisher is nil unless an events sink is configured
+var publisher *events.Publisher
+
+func initEvents(container v3io.Container, config *DBConfig) error {
+	if config.EventsSink == "" {
+		return nil
+	}
+	sink, err := events.NewSink(config.EventsSink, container)
+	if err != nil {
+		return err
+	}
+	publisher = events.NewPublisher(sink, events.NewV3ioOutbox(container, eventsOutboxDir))
+	return nil
+}
+
+// eventsOutboxDir holds the events until the sink accepts them
+const eventsOutboxDir = "/eventoutbox/"
+
+// publishRunEvent also drives run state notifications, alerts and project
+// summaries. It is called once the change is stored, so a failed publish is
+// logged and the event is lost rather than failing a stored change
+func publishRunEvent(container v3io.Container, eventType string, project, uid interface{}, data []byte) {
+	if data != nil {
+		notifications.runChanged(container, fmt.Sprint(project), fmt.Sprint(uid), data)
+		stateOf(container).summaries.runChanged(fmt.Sprint(project), fmt.Sprint(uid), data)
+		alertRunChanged(container, fmt.Sprint(project), fmt.Sprint(uid), data)
+	} else if eventType == events.RunDeleted {
+		stateOf(container).summaries.runDeleted(fmt.Sprint(project), fmt.Sprint(uid))
+	}
+	if publisher == nil {
+		return
+	}
+	event := events.NewEvent(eventType, fmt.Sprint(project), fmt.Sprint(uid))
+	event.Data = data
+	if err := publisher.Publish(event); err != nil {
+		clog.printF("Run %s/%s: %s\n", project, uid, err)
+	}
+}
+
+func publishArtifactEvent(container v3io.Container, project, uid interface{}, key, tag string, data []byte) {
+	stateOf(container).summaries.artifactStored(fmt.Sprint(project), key)
+	if publisher == nil {
+		return
+	}
+	event := events.NewEvent(events.ArtifactStored, fmt.Sprint(project), fmt.Sprint(uid))
+	event.Key = key
+	event.Tag = tag
+	event.Data = data
+	if err := publisher.Publish(event); err != nil {
+		clog.printF("Artifact %s/%s: %s\n", project, key, err)
+	}
+}
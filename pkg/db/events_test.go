@@ -0,0 +1,76 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/events"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"testing"
+)
+
+func TestV3ioOutbox(t *testing.T) {
+	container := newMockContainer()
+	outbox := events.NewV3ioOutbox(container, eventsOutboxDir)
+	if pending, err := outbox.Pending(10); err != nil || len(pending) != 0 {
+		t.Fatalf("Empty outbox returned %v, %v", pending, err)
+	}
+
+	var added []*events.Event
+	for i := 0; i < 3; i++ {
+		event := events.NewEvent(events.RunCreated, "p1", fmt.Sprint(i))
+		if err := outbox.Add(event); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, event)
+	}
+	// An object that is not an event must not block the ones after it
+	err := container.PutObjectSync(&v3io.PutObjectInput{Path: eventsOutboxDir + "00000000000000000000-bad", Body: []byte("{")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := outbox.Pending(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(added) {
+		t.Fatalf("%d events pending, expected %d", len(pending), len(added))
+	}
+	for i, event := range pending {
+		if event.ID != added[i].ID {
+			t.Errorf("Event %d is %s, expected %s", i, event.ID, added[i].ID)
+		}
+	}
+	if _, err := container.GetObjectSync(&v3io.GetObjectInput{Path: "/eventoutbox.quarantine/00000000000000000000-bad"}); err != nil {
+		t.Errorf("Bad event was not quarantined: %s", err)
+	}
+
+	if err = outbox.Remove(pending[:2]); err != nil {
+		t.Fatal(err)
+	}
+	// Removing an event removed by another server is not an error
+	if err = outbox.Remove(pending[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = outbox.Pending(10); err != nil || len(pending) != 1 || pending[0].ID != added[2].ID {
+		t.Fatalf("Pending returned %v, %v, expected the last event", pending, err)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Problems found by fsck
+const (
+	fsckUnreadableBody   = "unreadable_body"
+	fsckAttributeDrift   = "attribute_mismatch"
+	fsckDanglingTag      = "dangling_tag"
+	fsckOrphanLog        = "orphan_log"
+	fsckMaxDriftDetailed = 5
+)
+
+// fsckIssue is an inconsistency of one object, repairable ones are fixed when
+// fsck runs with repair
+type fsckIssue struct {
+	Kind       string `json:"kind"`
+	Project    string `json:"project"`
+	Name       string `json:"name"`
+	Problem    string `json:"problem"`
+	Detail     string `json:"detail,omitempty"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type fsckReport struct {
+	Repair   bool           `json:"repair"`
+	Started  time.Time      `json:"started"`
+	Duration string         `json:"duration"`
+	Scanned  map[string]int `json:"scanned"`
+	Issues   []*fsckIssue   `json:"issues"`
+}
+
+func (r *fsckReport) add(issue *fsckIssue, repair func() error) {
+	if r.Repair && issue.Repairable && repair != nil {
+		if err := repair(); err != nil {
+			issue.Error = err.Error()
+		} else {
+			issue.Repaired = true
+		}
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// readItemData returns the JSON form of the _data_ attribute of an item
+func readItemData(item v3io.Item) ([]byte, error) {
+	sealed, ok := item.GetField(dataAttributeName).([]byte)
+	if !ok {
+		return nil, fmt.Errorf("No %s attribute", dataAttributeName)
+	}
+	data, err := openData(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = convertDataToJSON(data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("Body is not valid JSON or YAML")
+	}
+	return data, nil
+}
+
+// attributeDrift compares the attributes indexed from the body with the
+// stored ones, it returns the expected attributes and the differences
+func attributeDrift(item v3io.Item, data []byte, descriptor interface{}) (map[string]interface{}, []string, error) {
+	if err := json.Unmarshal(data, descriptor); err != nil {
+		return nil, nil, err
+	}
+	expected := map[string]interface{}{}
+	if err := metadataToV3ioAttributes(descriptor, "", &expected); err != nil {
+		return nil, nil, err
+	}
+	var drift []string
+	for name, value := range expected {
+		stored := item.GetField(name)
+		if stored == nil || fmt.Sprint(stored) != fmt.Sprint(value) {
+			drift = append(drift, fmt.Sprintf("%s=%v (stored %v)", name, value, stored))
+		}
+	}
+	sort.Strings(drift)
+	return expected, drift, nil
+}
+
+func driftDetail(drift []string) string {
+	if len(drift) > fsckMaxDriftDetailed {
+		return strings.Join(drift[:fsckMaxDriftDetailed], ", ") + fmt.Sprintf(" and %d more", len(drift)-fsckMaxDriftDetailed)
+	}
+	return strings.Join(drift, ", ")
+}
+
+func listAllItems(container v3io.Container, path string) ([]v3io.Item, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{Path: path, AttributeNames: []string{"__name", "*"}})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cursor.AllSync()
+}
+
+// checkObjects checks the bodies and indexed attributes of the runs or
+// artifacts of a project, it returns the object names
+func checkObjects(container v3io.Container, report *fsckReport, kind, project string, newDescriptor func() interface{}) (map[string]bool, error) {
+	items, err := listAllItems(container, fmt.Sprintf("/%s/%s/", kind, project))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, item := range items {
+		name, _ := item.GetFieldString("__name")
+		names[name] = true
+		report.Scanned[kind]++
+		data, err := readItemData(item)
+		if err != nil {
+			report.add(&fsckIssue{Kind: kind, Project: project, Name: name, Problem: fsckUnreadableBody, Detail: err.Error()}, nil)
+			continue
+		}
+		expected, drift, err := attributeDrift(item, data, newDescriptor())
+		if err != nil {
+			report.add(&fsckIssue{Kind: kind, Project: project, Name: name, Problem: fsckUnreadableBody, Detail: err.Error()}, nil)
+			continue
+		}
+		if len(drift) == 0 {
+			continue
+		}
+		path := fmt.Sprintf("/%s/%s/%s", kind, project, name)
+		report.add(&fsckIssue{Kind: kind, Project: project, Name: name, Problem: fsckAttributeDrift,
+			Detail: driftDetail(drift), Repairable: true}, func() error {
+			// Reindexing from the body, as the store does
+			return container.UpdateItemSync(&v3io.UpdateItemInput{Path: path, Attributes: expected})
+		})
+	}
+	return names, nil
+}
+
+// checkLogs reports the logs of runs that do not exist
+func checkLogs(container v3io.Container, report *fsckReport, runs map[string]map[string]bool, project string) error {
+	input := v3io.GetContainerContentsInput{Path: "/log/"}
+	for {
+		v3ioResponse, err := container.GetContainerContentsSync(&input)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		output := v3ioResponse.Output.(*v3io.GetContainerContentsOutput)
+		for _, content := range output.Contents {
+			name := content.Key[strings.LastIndex(content.Key, "/")+1:]
+			sep := strings.LastIndex(name, "-")
+			if sep <= 0 || (project != "" && name[:sep] != project) {
+				continue
+			}
+			report.Scanned["log"]++
+			// Build logs are not run logs
+			if strings.HasPrefix(name[sep+1:], "build") {
+				continue
+			}
+			if projectRuns, ok := runs[name[:sep]]; ok && !projectRuns[name[sep+1:]] {
+				report.add(&fsckIssue{Kind: "log", Project: name[:sep], Name: name, Problem: fsckOrphanLog,
+					Detail: fmt.Sprintf("Run %s does not exist", name[sep+1:])}, nil)
+			}
+		}
+		v3ioResponse.Release()
+		if !output.IsTruncated || output.NextMarker == "" {
+			return nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+// runFsck checks the runs, artifacts and logs of a project, or of all
+// projects when project is empty
+func runFsck(container v3io.Container, project string, repair bool) (*fsckReport, error) {
+	report := fsckReport{Repair: repair, Started: time.Now().UTC(), Scanned: map[string]int{}, Issues: []*fsckIssue{}}
+	projects := map[string]bool{}
+	if project != "" {
+		projects[project] = true
+	} else {
+		for _, kind := range []string{"/run/", "/artifact/"} {
+			names, err := listProjectDirs(container, kind)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				projects[name] = true
+			}
+		}
+	}
+
+	runs := map[string]map[string]bool{}
+	for name := range projects {
+		projectRuns, err := checkObjects(container, &report, "run", name, func() interface{} { return &runMetadataEnvelope{} })
+		if err != nil {
+			return nil, err
+		}
+		runs[name] = projectRuns
+		if _, err = checkObjects(container, &report, "artifact", name, func() interface{} { return &artifactMetadataEnvelope{} }); err != nil {
+			return nil, err
+		}
+		// The same check (and fix) as the orphan cleanup
+		orphans, err := findOrphanLatest(container, name, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, orphan := range orphans {
+			orphan := orphan
+			report.add(&fsckIssue{Kind: "artifact", Project: name, Name: orphan.Key + ".latest", Problem: fsckDanglingTag,
+				Detail: fmt.Sprintf("Version %s does not exist, fix: %s %s", orphan.UID, orphan.Action, orphan.RepointedTo), Repairable: true},
+				func() error {
+					items, err := readArtifactItems(container, orphan.Project)
+					if err != nil {
+						return err
+					}
+					var newest *artifactVersion
+					for i, version := range items.versions[orphan.Key] {
+						if version.uid == orphan.RepointedTo {
+							newest = &items.versions[orphan.Key][i]
+						}
+					}
+					return fixOrphanLatest(container, orphan, newest)
+				})
+		}
+	}
+	if err := checkLogs(container, &report, runs, project); err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(report.Started).Round(time.Millisecond).String()
+	return &report, nil
+}
+
+// fsckHandler checks the consistency of the stored objects, repair=true
+// fixes the repairable issues and needs the admin role
+func fsckHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	repair := api.QueryBool(ctx, "repair", false)
+	if repair && !api.IsAdmin(ctx) {
+		api.WriteError(ctx, http.StatusForbidden, fmt.Errorf("Repairing needs the admin role"))
+		return
+	}
+	report, err := runFsck(container, string(ctx.QueryArgs().Peek("project")), repair)
+	if err != nil {
+		clog.printF("fsckHandler: %s\n", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+	writeJSON(ctx, report)
+}
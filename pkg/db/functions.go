@@ -0,0 +1,195 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"time"
+)
+
+// Functions are stored as /func/<project>/<name>.<tag>, each stored version
+// is kept as /func/<project>/<name>.<hash> too (see common.HashFunction), so
+// runs can get the exact function they ran with the hash as tag
+type functionMetadataEnvelope struct {
+	Kind     *string
+	Metadata struct {
+		Name   *string
+		Labels map[string]string
+	}
+}
+
+func functionTag(ctx *fasthttp.RequestCtx) string {
+	if tag := string(ctx.QueryArgs().Peek("tag")); tag != "" {
+		return tag
+	}
+	return "latest"
+}
+
+func storeFunctionHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := ctx.UserValue("project")
+	name := ctx.UserValue("name")
+	tag := functionTag(ctx)
+	var updateMetadata = functionMetadataEnvelope{}
+	JSONData, err := requestData(ctx)
+	if err == nil {
+		JSONData, err = convertDataToJSON(JSONData)
+	}
+	if err == nil {
+		JSONData, err = objectDefaults.Function(JSONData, tag, time.Now())
+	}
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	function := common.Function{}
+	if err = json.Unmarshal(JSONData, &function); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err = function.Validate(); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	hash, err := common.HashFunction(JSONData)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	updated := time.Now().UnixNano()
+	specialAttributes := map[string]interface{}{"name": name, "tag": tag, "hash": hash, "updated": updated}
+	if storeMetadataObject(container, ctx, fmt.Sprintf("/func/%s/%s.%s", project, name, tag), JSONData, specialAttributes, &updateMetadata) == nil {
+		return
+	}
+	// Storing an existing version again rewrites the same content
+	if tag != hash {
+		specialAttributes = map[string]interface{}{"name": name, "tag": hash, "hash": hash, "updated": updated}
+		if storeMetadataObject(container, ctx, fmt.Sprintf("/func/%s/%s.%s", project, name, hash), JSONData, specialAttributes, &updateMetadata) == nil {
+			return
+		}
+	}
+	ctx.Response.Header.Set("function_hash", hash)
+}
+
+func getFunctionHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	readMetadataObject(container, ctx, fmt.Sprintf("/func/%s/%s.%s", ctx.UserValue("project"), ctx.UserValue("name"), functionTag(ctx)))
+}
+
+// readFunction returns a stored function in JSON form
+func readFunction(container v3io.Container, project, name, tag string) ([]byte, error) {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           fmt.Sprintf("/func/%s/%s.%s", project, name, tag),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer v3ioResponse.Release()
+	body, err := openData(v3ioResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte))
+	if err != nil {
+		return nil, err
+	}
+	return convertDataToJSON(body)
+}
+
+func deleteFunctionHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{
+		Path: fmt.Sprintf("/func/%s/%s.%s", ctx.UserValue("project"), ctx.UserValue("name"), functionTag(ctx)),
+	})
+	setStatusFromError(ctx, err)
+}
+
+func listFunctionsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := string(ctx.QueryArgs().Peek("project"))
+	tag := functionTag(ctx)
+	if tag == "*" {
+		tag = ""
+	} else if err := validateIdentifiers("tag", tag); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	} else {
+		tag = "." + tag
+	}
+
+	labels, err := queryLabelFilters(ctx, "metadata.labels")
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	filterStr, err := buildArtifactFilterString(labels, string(ctx.QueryArgs().Peek("name")), tag)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/func/%s/", project),
+		AttributeNames: []string{dataAttributeName},
+		Filter:         filterStr,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			ctx.Response.SetBody([]byte("{\"funcs\": []}"))
+			return
+		}
+		clog.printF("listFunctionsHandler: Failed to call NewItemsCursor : %s", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	cursorItems, err := cursor.AllSync()
+	if err != nil {
+		clog.printF("listFunctionsHandler: Failed to call cursor.AllSync : %s", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	result := []byte("{\"funcs\": [")
+	for i, cursorItem := range cursorItems {
+		md, err := openData(cursorItem.GetField(dataAttributeName).([]byte))
+		if err == nil {
+			md, err = convertDataToJSON(md)
+		}
+		if err != nil {
+			clog.printF("listFunctionsHandler: %s\n", err)
+			ctx.Response.SetStatusCode(http.StatusInternalServerError)
+			return
+		}
+		if i > 0 {
+			result = append(result, ","...)
+		}
+		result = append(result, redactBody(ctx, md)...)
+	}
+	result = append(result, "]}"...)
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(result)
+}
@@ -0,0 +1,405 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/graphql"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// graphqlAttribute maps a GraphQL scalar field to the indexed item attribute that
+// holds it (if any) and to its location inside the stored object body
+type graphqlAttribute struct {
+	attribute string
+	dataPath  []string
+}
+
+var (
+	runGraphQLAttributes = map[string]graphqlAttribute{
+		"uid":        {encodeAttributeName("metadata.uid"), []string{"metadata", "uid"}},
+		"name":       {encodeAttributeName("metadata.name"), []string{"metadata", "name"}},
+		"project":    {encodeAttributeName("metadata.project"), []string{"metadata", "project"}},
+		"iteration":  {encodeAttributeName("metadata.iteration"), []string{"metadata", "iteration"}},
+		"state":      {encodeAttributeName("status.state"), []string{"status", "state"}},
+		"startTime":  {"", []string{"status", "start_time"}},
+		"lastUpdate": {"", []string{"status", "last_update"}},
+		"labels":     {"", []string{"metadata", "labels"}},
+	}
+
+	artifactGraphQLAttributes = map[string]graphqlAttribute{
+		"key":        {"name", []string{"key"}},
+		"tree":       {"", []string{"tree"}},
+		"kind":       {"", []string{"kind"}},
+		"targetPath": {"", []string{"target_path"}},
+		"labels":     {"", []string{"labels"}},
+	}
+)
+
+type graphqlObject struct {
+	project string
+	item    v3io.Item
+	data    map[string]interface{}
+}
+
+func (o *graphqlObject) value(attr graphqlAttribute) interface{} {
+	if attr.attribute != "" {
+		if value, ok := o.item[attr.attribute]; ok {
+			return value
+		}
+	}
+	var current interface{} = o.data
+	for _, key := range attr.dataPath {
+		asMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = asMap[key]
+	}
+	return current
+}
+
+func (o *graphqlObject) itemName() string {
+	name, _ := o.item["__name"].(string)
+	return name
+}
+
+// graphqlLoader holds per request state, so runs referenced by several artifacts
+// with the same selection are fetched once
+type graphqlLoader struct {
+	container v3io.Container
+	runs      map[string]*graphqlObject
+}
+
+func projectedAttributes(field *graphql.Field, attributes map[string]graphqlAttribute, extra ...string) []string {
+	result := append([]string{"__name"}, extra...)
+	needData := false
+	for _, sub := range field.SelectionSet {
+		attr, ok := attributes[sub.Name]
+		switch {
+		case ok && attr.attribute != "":
+			result = append(result, attr.attribute)
+		case sub.Name != "__typename":
+			needData = true
+		}
+	}
+	if needData {
+		result = append(result, dataAttributeName)
+	}
+	return result
+}
+
+func newGraphQLObject(project string, item v3io.Item) (*graphqlObject, error) {
+	obj := graphqlObject{project: project, item: item}
+	if body, ok := item[dataAttributeName].([]byte); ok {
+		body, err := openData(body)
+		if err != nil {
+			return nil, err
+		}
+		JSONBody, err := convertDataToJSON(body)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(JSONBody, &obj.data); err != nil {
+			return nil, err
+		}
+	}
+	return &obj, nil
+}
+
+func getGraphQLItems(container v3io.Container, project string, input *v3io.GetItemsInput) ([]*graphqlObject, error) {
+	cursor, err := v3io.NewItemsCursor(container, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+	var objects []*graphqlObject
+	for _, item := range items {
+		obj, err := newGraphQLObject(project, item)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func getGraphQLItem(container v3io.Container, project, path string, attributes []string) (*graphqlObject, error) {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: path, AttributeNames: attributes})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer v3ioResponse.Release()
+	return newGraphQLObject(project, v3ioResponse.Output.(*v3io.GetItemOutput).Item)
+}
+
+func requireStringArg(field *graphql.Field, name string) (string, error) {
+	value := field.StringArg(name)
+	if value == "" {
+		return "", fmt.Errorf("Argument '%s' of field '%s' is required", name, field.Name)
+	}
+	return value, nil
+}
+
+// requireIdentifierArg is requireStringArg for the identifiers of DB paths
+func requireIdentifierArg(field *graphql.Field, name string) (string, error) {
+	value, err := requireStringArg(field, name)
+	if err != nil {
+		return "", err
+	}
+	return value, api.ValidateIdentifier(name, value)
+}
+
+// tagArg returns the tag argument of a field, latest by default
+func tagArg(field *graphql.Field) (string, error) {
+	tag := field.StringArg("tag")
+	if tag == "" || tag == "*" {
+		return setFrom(tag, "latest"), nil
+	}
+	return tag, api.ValidateIdentifier("tag", tag)
+}
+
+func (l *graphqlLoader) run(project, uid string, field *graphql.Field) (*graphqlObject, error) {
+	// The item is fetched with the attributes of the field selection, another
+	// selection of the same run needs its own fetch
+	attributes := projectedAttributes(field, runGraphQLAttributes)
+	key := append([]string{}, attributes...)
+	sort.Strings(key)
+	cacheKey := project + "/" + uid + "?" + strings.Join(key, ",")
+	if run, ok := l.runs[cacheKey]; ok {
+		return run, nil
+	}
+	run, err := getGraphQLItem(l.container, project, fmt.Sprintf("/run/%s/%s", project, uid), attributes)
+	if err != nil {
+		return nil, err
+	}
+	l.runs[cacheKey] = run
+	return run, nil
+}
+
+func (l *graphqlLoader) listRuns(source interface{}, field *graphql.Field) (interface{}, error) {
+	project, err := requireIdentifierArg(field, "project")
+	if err != nil {
+		return nil, err
+	}
+	labels, err := labelFilters("metadata.labels", field.StringListArg("labels"))
+	if err != nil {
+		return nil, err
+	}
+	filterStr, err := buildRunFilterString(labels, field.StringArg("name"), field.StringArg("state"), -1)
+	if err != nil {
+		return nil, err
+	}
+	lastTimeAttribute := encodeAttributeName("status.lasttimeEpoch")
+	input := v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/run/%s/", project),
+		AttributeNames: projectedAttributes(field, runGraphQLAttributes, lastTimeAttribute),
+		Filter:         filterStr,
+	}
+	runs, err := getGraphQLItems(l.container, project, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		first, _ := runs[i].item.GetFieldInt(lastTimeAttribute)
+		second, _ := runs[j].item.GetFieldInt(lastTimeAttribute)
+		return first > second
+	})
+	if last := field.IntArg("last", 0); last > 0 && len(runs) > last {
+		runs = runs[:last]
+	}
+	return runs, nil
+}
+
+func (l *graphqlLoader) getRun(source interface{}, field *graphql.Field) (interface{}, error) {
+	project, err := requireIdentifierArg(field, "project")
+	if err != nil {
+		return nil, err
+	}
+	uid, err := requireIdentifierArg(field, "uid")
+	if err != nil {
+		return nil, err
+	}
+	return l.run(project, uid, field)
+}
+
+func (l *graphqlLoader) listArtifacts(project, name, tag string, labels []string, field *graphql.Field) ([]*graphqlObject, error) {
+	filters, err := labelFilters("labels", labels)
+	if err != nil {
+		return nil, err
+	}
+	filterStr, err := buildArtifactFilterString(filters, name, tag)
+	if err != nil {
+		return nil, err
+	}
+	input := v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifact/%s/", project),
+		AttributeNames: projectedAttributes(field, artifactGraphQLAttributes),
+		Filter:         filterStr,
+	}
+	return getGraphQLItems(l.container, project, &input)
+}
+
+func (l *graphqlLoader) queryArtifacts(source interface{}, field *graphql.Field) (interface{}, error) {
+	project, err := requireIdentifierArg(field, "project")
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tagArg(field)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "*" {
+		tag = ""
+	}
+	return l.listArtifacts(project, field.StringArg("name"), tag, field.StringListArg("labels"), field)
+}
+
+func (l *graphqlLoader) getArtifact(source interface{}, field *graphql.Field) (interface{}, error) {
+	project, err := requireIdentifierArg(field, "project")
+	if err != nil {
+		return nil, err
+	}
+	key, err := requireIdentifierArg(field, "key")
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tagArg(field)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "*" {
+		return nil, fmt.Errorf("Argument 'tag' of field '%s' names one tag", field.Name)
+	}
+	attributes := projectedAttributes(field, artifactGraphQLAttributes)
+	return getGraphQLItem(l.container, project, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag), attributes)
+}
+
+func (l *graphqlLoader) runArtifacts(source interface{}, field *graphql.Field) (interface{}, error) {
+	run := source.(*graphqlObject)
+	uid := run.itemName()
+	if uid == "" {
+		return nil, nil
+	}
+	// Artifacts produced by a run are stored as <key>.<run uid>
+	return l.listArtifacts(run.project, "", "."+uid, nil, field)
+}
+
+func (l *graphqlLoader) artifactProducer(source interface{}, field *graphql.Field) (interface{}, error) {
+	artifact := source.(*graphqlObject)
+	uid, _ := artifact.value(graphqlAttribute{dataPath: []string{"tree"}}).(string)
+	if uid == "" {
+		return nil, nil
+	}
+	return l.run(artifact.project, uid, field)
+}
+
+func scalarResolver(attr graphqlAttribute) graphql.Resolver {
+	return func(source interface{}, field *graphql.Field) (interface{}, error) {
+		return source.(*graphqlObject).value(attr), nil
+	}
+}
+
+func dataResolver(r *redactor) graphql.Resolver {
+	return func(source interface{}, field *graphql.Field) (interface{}, error) {
+		data := source.(*graphqlObject).data
+		if r != nil && data != nil {
+			r.redactValue(data)
+		}
+		return data, nil
+	}
+}
+
+func artifactTagResolver(source interface{}, field *graphql.Field) (interface{}, error) {
+	name := source.(*graphqlObject).itemName()
+	return name[strings.LastIndex(name, ".")+1:], nil
+}
+
+func newGraphQLSchema(container v3io.Container, r *redactor) *graphql.Object {
+	loader := graphqlLoader{container: container, runs: map[string]*graphqlObject{}}
+	runType := &graphql.Object{Name: "Run", Fields: map[string]*graphql.FieldDef{}}
+	artifactType := &graphql.Object{Name: "Artifact", Fields: map[string]*graphql.FieldDef{}}
+
+	for name, attr := range runGraphQLAttributes {
+		runType.Fields[name] = &graphql.FieldDef{Resolve: scalarResolver(attr)}
+	}
+	runType.Fields["data"] = &graphql.FieldDef{Resolve: dataResolver(r)}
+	runType.Fields["artifacts"] = &graphql.FieldDef{Type: artifactType, Resolve: loader.runArtifacts}
+
+	for name, attr := range artifactGraphQLAttributes {
+		artifactType.Fields[name] = &graphql.FieldDef{Resolve: scalarResolver(attr)}
+	}
+	artifactType.Fields["tag"] = &graphql.FieldDef{Resolve: artifactTagResolver}
+	artifactType.Fields["data"] = &graphql.FieldDef{Resolve: dataResolver(r)}
+	artifactType.Fields["producer"] = &graphql.FieldDef{Type: runType, Resolve: loader.artifactProducer}
+
+	return &graphql.Object{Name: "Query", Fields: map[string]*graphql.FieldDef{
+		"runs":      {Type: runType, Resolve: loader.listRuns},
+		"run":       {Type: runType, Resolve: loader.getRun},
+		"artifacts": {Type: artifactType, Resolve: loader.queryArtifacts},
+		"artifact":  {Type: artifactType, Resolve: loader.getArtifact},
+	}}
+}
+
+func graphqlHandler(ctx *fasthttp.RequestCtx) {
+	requestHandlerPrint(ctx)
+	request := graphql.Request{}
+	if ctx.IsGet() {
+		request.Query = string(ctx.QueryArgs().Peek("query"))
+		request.OperationName = string(ctx.QueryArgs().Peek("operationName"))
+		if variables := ctx.QueryArgs().Peek("variables"); len(variables) > 0 {
+			if err := json.Unmarshal(variables, &request.Variables); err != nil {
+				clog.printF("graphqlHandler: Failed to unmarshal variables: %s", err)
+				ctx.Response.SetStatusCode(http.StatusBadRequest)
+				return
+			}
+		}
+	} else if err := json.Unmarshal(ctx.Request.Body(), &request); err != nil {
+		clog.printF("graphqlHandler: Failed to unmarshal request body: %s", err)
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Execute(newGraphQLSchema(requestContainer(ctx), requestRedactor(ctx)), &request)
+	body, err := json.Marshal(result)
+	if err != nil {
+		clog.printF("graphqlHandler: Failed to marshal result: %s", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestGraphQLRunSelections(t *testing.T) {
+	mldb := newTestDB(t)
+	mustRequest(t, mldb, "POST", "/run/p1/u1",
+		`{"metadata":{"name":"train","uid":"u1","project":"p1"},"status":{"state":"completed"}}`, 200)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model&tag=latest", `{"key":"model","tree":"u1","kind":"model"}`, 200)
+
+	// The same run selected with different fields, through the query and as
+	// the artifact producer
+	query := `{
+		byName: run(project: "p1", uid: "u1") { name }
+		byState: run(project: "p1", uid: "u1") { state }
+		artifacts(project: "p1") { key producer { name state } }
+	}`
+	body := mustRequest(t, mldb, "GET", "/graphql?query="+url.QueryEscape(query), "", 200)
+	result := struct {
+		Data struct {
+			ByName    map[string]interface{}
+			ByState   map[string]interface{}
+			Artifacts []struct {
+				Key      string
+				Producer map[string]interface{}
+			}
+		}
+		Errors []interface{}
+	}{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Query errors: %v", result.Errors)
+	}
+	if result.Data.ByName["name"] != "train" || result.Data.ByState["state"] != "completed" {
+		t.Errorf("Run selections returned %v and %v", result.Data.ByName, result.Data.ByState)
+	}
+	if len(result.Data.Artifacts) != 1 {
+		t.Fatalf("Got %d artifacts, expected 1: %s", len(result.Data.Artifacts), body)
+	}
+	if producer := result.Data.Artifacts[0].Producer; producer["name"] != "train" || producer["state"] != "completed" {
+		t.Errorf("Artifact producer is %v", producer)
+	}
+}
@@ -24,12 +24,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ghodss/yaml"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/defaults"
+	"github.com/mlrun/controller/pkg/events"
 	"github.com/tidwall/sjson"
 	"github.com/v3io/v3io-go/pkg/dataplane"
 	"github.com/v3io/v3io-go/pkg/errors"
 	"github.com/valyala/fasthttp"
 	"io"
-	"math"
 	"net/http"
 	"os"
 	"reflect"
@@ -37,28 +40,34 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	invalidString = "?invalid"
-	invalidFloat  = math.Inf(+1)
-	invalidInt    = 0xBADACAFE
+	// Set by InitDB from the DBConfig
+	objectDefaults defaults.Config
 
-	container v3io.Container
-
-	clog              = ConditionalPrinter{print: false, writer: os.Stderr}
+	clog              = ConditionalPrinter{print: new(int32), writer: os.Stderr}
 	encodeRegex       = regexp.MustCompile(`[^a-zA-Z0-9_]`)
-	labelParsingRegex = regexp.MustCompile(`(.+)(~=|!=|=)(.+)`)
+	labelParsingRegex = regexp.MustCompile(`^([^=!~]+)(~=|!=|=)(.+)$`)
 )
 
 type ConditionalPrinter struct {
-	print  bool
+	print  *int32
 	writer io.Writer
 }
 
+func (p *ConditionalPrinter) setPrint(print bool) {
+	var value int32
+	if print {
+		value = 1
+	}
+	atomic.StoreInt32(p.print, value)
+}
+
 func (p ConditionalPrinter) printF(s string, params ...interface{}) {
-	if p.print {
+	if atomic.LoadInt32(p.print) == 1 {
 		if len(params) == 0 {
 			fmt.Fprintf(p.writer, s)
 		} else {
@@ -77,199 +86,220 @@ const (
 	dataAttributeName = "_data_"
 )
 
+// The envelopes are the indexed fields of the stored objects (common.Run and
+// common.Artifact), decoded from full objects or updates. Fields missing from
+// the JSON stay nil and their attributes are not set, the attribute names are
+// the lower case field paths
+
 type runMetadataEnvelope struct {
 	Metadata struct {
-		Name      string
-		UID       string
-		Iteration int
-		Project   string
+		Name      *string
+		UID       *string
+		Iteration *int
+		Project   *string
 		Labels    map[string]string
+		ParentUID *string `json:"parent_uid"`
+		Workflow  *string
 	}
 	Status struct {
-		State     string
-		LastTime  string `json:"last_update"`
-		StartTime string `json:"start_time"`
+		State     *string
+		LastTime  *string `json:"last_update"`
+		StartTime *string `json:"start_time"`
 	}
 }
 
-func (r *runMetadataEnvelope) makeInvalid() {
-	r.Metadata.Name = invalidString
-	r.Metadata.UID = invalidString
-	r.Metadata.Project = invalidString
-	r.Metadata.Labels = nil
-	r.Metadata.Iteration = invalidInt
-	r.Status.LastTime = invalidString
-	r.Status.StartTime = invalidString
-}
-
 type artifactMetadataEnvelope struct {
-	Name   string `json:"key"`
+	Name   *string `json:"key"`
+	Kind   *string
 	Labels map[string]string
 }
 
-func (r *artifactMetadataEnvelope) makeInvalid() {
-	r.Name = invalidString
-	r.Labels = nil
-}
-
 func encodeAttributeName(name string) string {
 	return encodeRegex.ReplaceAllString(name, "_")
 }
 
-func metadataToV3ioAttributes(md interface{}, attributePath string, result *map[string]interface{}) {
-	st := reflect.TypeOf(md)
+// metadataToV3ioAttributes sets the attributes indexed from the fields of an
+// envelope struct, with the encoders compiled for its type. It fails when the
+// index policy rejects the labels or values
+func metadataToV3ioAttributes(md interface{}, attributePath string, result *map[string]interface{}) error {
 	sv := reflect.ValueOf(md)
-	if st.Kind() == reflect.Ptr {
-		st = reflect.TypeOf(md).Elem()
-		sv = reflect.ValueOf(md).Elem()
+	if sv.Kind() == reflect.Ptr {
+		sv = sv.Elem()
 	}
 	if *result == nil {
 		*result = make(map[string]interface{})
 	}
-	for i := 0; i < st.NumField(); i++ {
-		field := st.Field(i)
-		name := attributePath + strings.ToLower(field.Name)
-		encodedName := encodeAttributeName(name)
-
-		fieldValue := sv.Field(i)
-		switch fieldValue.Kind() {
-		case reflect.Struct:
-			metadataToV3ioAttributes(fieldValue.Interface(), name+".", result)
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			if fieldValue.Int() != int64(invalidInt) {
-				(*result)[encodedName] = fieldValue.Int()
-			}
-		case reflect.Bool:
-			(*result)[encodedName] = fieldValue.Bool()
-		case reflect.Float32, reflect.Float64:
-			if fieldValue.Float() != invalidFloat {
-				(*result)[encodedName] = fieldValue.Float()
-			}
-		case reflect.String:
-			if fieldValue.String() != invalidString {
-				// Replace time fields to Epoch integer representation
-				t, err := time.Parse("2006-01-02 15:04:05.000000", fieldValue.String())
-				if err != nil {
-					(*result)[encodedName] = fieldValue.String()
-				} else {
-					encodedName := encodeAttributeName(name + "Epoch")
-					(*result)[encodedName] = t.UnixNano()
-				}
-			}
-		case reflect.Map:
-			values := fieldValue.Interface().(map[string]string)
-			for key, value := range values {
-				encodedName := encodeAttributeName(name + "." + key)
-				(*result)[encodedName] = value
-			}
-		default:
-			clog.printF("metadataToV3ioAttributes : usupported type %v for attribute %s\n", fieldValue.Kind(), name)
+	policy := indexing
+	for _, encode := range structEncoders(sv.Type(), attributePath) {
+		if err := encode(sv, policy, *result); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func parseLabelToV3IOFilterSubexpression(labelPrefix string, text string) string {
-	result := labelParsingRegex.FindStringSubmatch(text)
-	// The attribute with no comparison means "exists"- text is the attribute name
-	if labelPrefix != "" {
-		labelPrefix = labelPrefix + "."
+// validateIdentifiers checks name and value pairs of identifiers read from
+// request bodies, as the router checks those of the path and query. Empty
+// values are left to the defaults
+func validateIdentifiers(namesAndValues ...string) error {
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		if namesAndValues[i+1] == "" {
+			continue
+		}
+		if err := api.ValidateIdentifier(namesAndValues[i], namesAndValues[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLabelFilter turns a label query (key, key=value, key!=value or
+// key~=value) into a filter expression on the labels under labelPrefix
+func parseLabelFilter(labelPrefix string, text string) (string, error) {
+	key, op, value := text, "", ""
+	if result := labelParsingRegex.FindStringSubmatch(text); result != nil {
+		key, op, value = result[1], result[2], result[3]
+	}
+	if key == "" || encodeRegex.MatchString(strings.NewReplacer(".", "_", "-", "_", "/", "_").Replace(key)) {
+		return "", fmt.Errorf("Invalid label filter '%s'", text)
 	}
-	if len(result) != 4 {
-		return "exists(" + encodeAttributeName(labelPrefix+text) + ")"
+	attribute := encodeAttributeName(labelPrefix + "." + key)
+	if op == "" {
+		return "exists(" + attribute + ")", nil
+	}
+	literal, err := filterField{attribute: key, kind: stringField}.literal(value)
+	if err != nil {
+		return "", err
 	}
-	label := encodeAttributeName(labelPrefix + result[1])
-	op := result[2]
-	comp := result[3]
 	switch op {
 	case "~=":
-		return "contains(" + label + ",'" + comp + "')"
-	case "=":
-		return label + "='" + comp + "'"
+		return "contains(" + attribute + ", " + literal + ")", nil
 	case "!=":
-		return label + "='" + comp + "'"
+		return attribute + " != " + literal, nil
 	}
-	return "<unknown field>"
+	return attribute + " == " + literal, nil
 }
 
-func buildRunFilterString(labels map[string]string, name string, state string, endPosixDate int64) string {
-	result := ""
-	if name != "" {
-		if result != "" {
-			result += " AND "
+// labelFilters parses label queries, a list of filters that must all match
+func labelFilters(labelPrefix string, labels []string) ([]string, error) {
+	filters := make([]string, 0, len(labels))
+	for _, label := range labels {
+		filter, err := parseLabelFilter(labelPrefix, label)
+		if err != nil {
+			return nil, err
 		}
-		result += encodeAttributeName("metadata.name") + "== \"" + name + "\""
+		filters = append(filters, filter)
 	}
+	return filters, nil
+}
 
-	if state != "" {
-		if result != "" {
-			result += " AND "
+// queryLabelFilters parses the repeated label query parameters
+func queryLabelFilters(ctx *fasthttp.RequestCtx, labelPrefix string) ([]string, error) {
+	var labels []string
+	for _, value := range ctx.QueryArgs().PeekMulti("label") {
+		labels = append(labels, string(value))
+	}
+	return labelFilters(labelPrefix, labels)
+}
+
+// joinFilters combines filters with AND, skipping empty ones
+func joinFilters(filters ...string) string {
+	var nonEmpty []string
+	for _, filter := range filters {
+		if filter != "" {
+			nonEmpty = append(nonEmpty, filter)
 		}
-		result += encodeAttributeName("status.state") + "== \"" + state + "\""
 	}
+	return strings.Join(nonEmpty, " AND ")
+}
+
+// filterLiteral quotes a filter value as the label filters do, values with
+// quotes or backslashes are rejected
+func filterLiteral(attribute, value string) (string, error) {
+	return filterField{attribute: attribute, kind: stringField}.literal(value)
+}
 
-	for _, value := range labels {
-		if result != "" {
-			result += " AND "
+func buildRunFilterString(labels []string, name string, state string, endPosixDate int64) (string, error) {
+	var filters []string
+	if name != "" {
+		literal, err := filterLiteral("name", name)
+		if err != nil {
+			return "", err
 		}
-		result += value
+		filters = append(filters, encodeAttributeName("metadata.name")+" == "+literal)
 	}
-	if endPosixDate > 0 {
-		if result != "" {
-			result += " AND "
+	if state != "" {
+		literal, err := filterLiteral("state", state)
+		if err != nil {
+			return "", err
 		}
-		result += encodeAttributeName("status.lasttimeEpoch") + " > " + string(endPosixDate)
+		filters = append(filters, encodeAttributeName("status.state")+" == "+literal)
 	}
+	filters = append(filters, labels...)
+	if endPosixDate > 0 {
+		filters = append(filters, encodeAttributeName("status.lasttimeEpoch")+" > "+strconv.FormatInt(endPosixDate, 10))
+	}
+	result := joinFilters(filters...)
 	clog.printF("Filter string is %s\n", result)
-	return result
+	return result, nil
 }
 
-func buildArtifactFilterString(labels map[string]string, name string, tag string) string {
-	result := ""
+func buildArtifactFilterString(labels []string, name string, tag string) (string, error) {
+	var filters []string
 	if name != "" {
-		if result != "" {
-			result += " AND "
+		literal, err := filterLiteral("name", name)
+		if err != nil {
+			return "", err
 		}
-		result += encodeAttributeName("name") + "== \"" + name + "\""
+		filters = append(filters, encodeAttributeName("name")+" == "+literal)
 	}
-
 	if tag != "" {
-		if result != "" {
-			result += " AND "
-		}
-		result += "ends(__name,\"" + tag + "\")"
-	}
-
-	for _, value := range labels {
-		if result != "" {
-			result += " AND "
+		literal, err := filterLiteral("tag", tag)
+		if err != nil {
+			return "", err
 		}
-		result += value
+		filters = append(filters, "ends(__name, "+literal+")")
 	}
+	filters = append(filters, labels...)
+	result := joinFilters(filters...)
 	clog.printF("artifact Filter string is %s\n", result)
-	return result
+	return result, nil
 }
+
 func isYAML(data []byte) bool {
 	return bytes.HasPrefix(data, []byte("---"))
 }
 
 func storeLogHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
 	clog.printF("storeLogHandler : Project %s uid %s\n", project, uid)
 	logBody := ctx.Request.Body()
 
+	if err := storeLog(container, project, uid, logBody); err != nil {
+		api.WriteError(ctx, statusFromError(err), err)
+	}
+}
+
+func storeLog(container v3io.Container, project, uid interface{}, logBody []byte) error {
 	putObjectInput := &v3io.PutObjectInput{}
 
 	putObjectInput.Path = fmt.Sprintf("/log/%s-%s", project, uid)
-	putObjectInput.Body = logBody
+	putObjectInput.Body = sealData(logBody)
+	if err := stateOf(container).storage.reserve(container, fmt.Sprint(project), putObjectInput.Path, len(putObjectInput.Body)); err != nil {
+		return err
+	}
 
 	err := container.PutObjectSync(putObjectInput)
-
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	if err == nil {
+		logs.mirror(project, uid, logBody)
+	}
+	return err
 }
 
 func getLogHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
 	clog.printF("getLogHandler : Project %s uid %s\n", project, uid)
@@ -278,9 +308,48 @@ func getLogHandler(ctx *fasthttp.RequestCtx) {
 	getObjectInput.Path = fmt.Sprintf("/log/%s-%s", project, uid)
 
 	v3ioResponse, err := container.GetObjectSync(getObjectInput)
+	if err != nil || dataCipher == nil {
+		setStatusFromError(ctx, err)
+		writeResponseBody(ctx, v3ioResponse)
+		return
+	}
+	body, err := openData(v3ioResponse.Body())
+	v3ioResponse.Release()
+	if err != nil {
+		clog.printF("getLogHandler: %s\n", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	writeConditional(ctx, body, objectModified(container, getObjectInput.Path))
+}
+
+// setStatusFromError maps v3io errors to the response status, errors without a
+// status code mean v3io could not be reached
+func setStatusFromError(ctx *fasthttp.RequestCtx, err error) {
+	ctx.Response.SetStatusCode(statusFromError(err))
+}
+
+func statusFromError(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode); ok {
+		return errWithStatusCode.StatusCode()
+	}
+	return http.StatusServiceUnavailable
+}
+
+func isNotFound(err error) bool {
+	errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	return ok && errWithStatusCode.StatusCode() == http.StatusNotFound
+}
 
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+// writeResponseBody copies a v3io response body, which may be missing when the
+// call failed before reaching v3io
+func writeResponseBody(ctx *fasthttp.RequestCtx, v3ioResponse *v3io.Response) {
+	if v3ioResponse == nil {
+		return
+	}
 	ctx.Response.SetBody(v3ioResponse.Body())
 	v3ioResponse.Release()
 }
@@ -297,50 +366,159 @@ func convertDataToJSON(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-func storeMetadataObject(ctx *fasthttp.RequestCtx, path string, data []byte, attributesToAdd map[string]interface{}, descriptor interface{}) {
+// storeMetadataObject returns the JSON form of the stored object, or nil if it was not stored
+func storeMetadataObject(container v3io.Container, ctx *fasthttp.RequestCtx, path string, data []byte, attributesToAdd map[string]interface{}, descriptor interface{}) []byte {
+	return storeMetadataObjectIf(container, ctx, path, "", data, attributesToAdd, descriptor)
+}
+
+// storeMetadataObjectIf is storeMetadataObject storing only while the v3io
+// condition holds, the response is 409 when it does not
+func storeMetadataObjectIf(container v3io.Container, ctx *fasthttp.RequestCtx, path, condition string, data []byte, attributesToAdd map[string]interface{}, descriptor interface{}) []byte {
 	JSONData, err := convertDataToJSON(data)
 	if err != nil {
 		clog.printF("storeRunHandler: Failed to convertDataToJSON: %s", err)
 		ctx.Response.SetStatusCode(http.StatusBadRequest)
-		return
+		return nil
 	}
 	err = json.Unmarshal(JSONData, descriptor)
 	if err != nil {
 		clog.printF("storeRunHandler: Failed to unmarshal run body: %s", err)
 		ctx.Response.SetStatusCode(http.StatusBadRequest)
-		return
+		return nil
 	}
 
 	updateItemInput := v3io.UpdateItemInput{}
 	updateItemInput.Attributes = make(map[string]interface{})
 	updateItemInput.Path = path
+	updateItemInput.Condition = condition
 	for key, value := range attributesToAdd {
 		updateItemInput.Attributes[key] = value
 	}
 
-	attributes := &updateItemInput.Attributes
-	metadataToV3ioAttributes(descriptor, "", attributes)
-	updateItemInput.Attributes[dataAttributeName] = data
+	if err = metadataToV3ioAttributes(descriptor, "", &updateItemInput.Attributes); err != nil {
+		clog.printF("storeRunHandler: Failed to index the object: %s", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return nil
+	}
+	if isMsgpackRequest(ctx) {
+		if data, err = packData(JSONData); err != nil {
+			api.WriteError(ctx, http.StatusBadRequest, err)
+			return nil
+		}
+	}
+	updateItemInput.Attributes[dataAttributeName] = sealData(data)
+	if err = reserveObject(container, path, len(updateItemInput.Attributes[dataAttributeName].([]byte))); err != nil {
+		clog.printF("storeRunHandler: Storage quota: %s", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return nil
+	}
 
 	err = container.UpdateItemSync(&updateItemInput)
+	if condition != "" && isConditionFailed(err) {
+		api.WriteError(ctx, http.StatusConflict, fmt.Errorf("%s was stored by another request meanwhile", path))
+		return nil
+	}
 	if err != nil {
 		clog.printF("storeRunHandler: Failed to call UpdateItemSync : %s", err)
+		setStatusFromError(ctx, err)
+		return nil
 	}
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	return JSONData
 }
 
 func storeRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
-	project := ctx.UserValue("project")
-	uid := ctx.UserValue("uid")
+	storeRun(container, ctx, fmt.Sprint(ctx.UserValue("project")), fmt.Sprint(ctx.UserValue("uid")), false)
+}
+
+// storeNewRunHandler stores a run under a generated uid, set as its
+// metadata.uid, and returns the uid
+func storeNewRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	uid := randomID()
+	if data := storeRun(container, ctx, fmt.Sprint(ctx.UserValue("project")), uid, true); data != nil {
+		writeJSON(ctx, map[string]interface{}{"uid": uid, "data": json.RawMessage(data)})
+	}
+}
+
+// storeRun stores a run and returns it in JSON form, or nil when it failed and
+// the response status is set. Runs replacing a run with another name are
+// rejected with 409
+func storeRun(container v3io.Container, ctx *fasthttp.RequestCtx, project, uid string, setUID bool) []byte {
 	var updateMetadata = runMetadataEnvelope{}
-	updateMetadata.makeInvalid()
+	JSONData, err := requestData(ctx)
+	if err == nil {
+		JSONData, err = convertDataToJSON(JSONData)
+	}
+	if err == nil && setUID {
+		JSONData, err = sjson.SetBytes(JSONData, "metadata.uid", uid)
+	}
+	if err == nil {
+		JSONData, err = objectDefaults.Run(JSONData, time.Now())
+	}
+	if err != nil {
+		clog.printF("storeRunHandler: Failed to convertDataToJSON: %s", err)
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return nil
+	}
+	path := fmt.Sprintf("/run/%s/%s", project, uid)
+	if !setUID {
+		run := common.Run{}
+		json.Unmarshal(JSONData, &run)
+		name, notes, err := previousRun(container, path)
+		if err == nil && name != "" && run.Metadata.Name != "" && name != run.Metadata.Name {
+			err = fmt.Errorf("Run %s already exists with name %s, not %s", path, name, run.Metadata.Name)
+			api.WriteError(ctx, http.StatusConflict, err)
+			return nil
+		}
+		if err != nil {
+			clog.printF("storeRunHandler: Failed to read the stored run: %s", err)
+			setStatusFromError(ctx, err)
+			return nil
+		}
+		// Runs are stored again as a whole by the SDK, with no notes
+		if notes != "" && len(run.Notes) == 0 {
+			if JSONData, err = sjson.SetRawBytes(JSONData, "notes", []byte(notes)); err != nil {
+				ctx.Response.SetStatusCode(http.StatusBadRequest)
+				return nil
+			}
+		}
+	}
 	specialAttributes := map[string]interface{}{}
-	storeMetadataObject(ctx, fmt.Sprintf("/run/%s/%s", project, uid), ctx.Request.Body(), specialAttributes, &updateMetadata)
+	if err = indexRunMetrics(container, project, JSONData, specialAttributes); err != nil {
+		clog.printF("storeRunHandler: Failed to index run parameters and results: %s", err)
+		setStatusFromError(ctx, err)
+		return nil
+	}
+	data := storeMetadataObject(container, ctx, path, JSONData, specialAttributes, &updateMetadata)
+	if data != nil {
+		publishRunEvent(container, events.RunCreated, project, uid, data)
+	}
+	return data
+}
+
+// previousRun returns the name and notes (in JSON form) of the run stored at
+// path, empty when there is none
+func previousRun(container v3io.Container, path string) (string, string, error) {
+	nameAttribute := encodeAttributeName("metadata.name")
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: path, AttributeNames: []string{nameAttribute, notesAttribute}})
+	if isNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer v3ioResponse.Release()
+	item := v3ioResponse.Output.(*v3io.GetItemOutput).Item
+	name, _ := item.GetFieldString(nameAttribute)
+	notes, _ := item.GetFieldString(notesAttribute)
+	return name, notes, nil
 }
 
 func updateRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
@@ -351,104 +529,118 @@ func updateRunHandler(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(http.StatusBadRequest)
 		return
 	}
-
-	updateJSONBodyUndecorated, err := dotSeparatedPathToJSON(updateJSONBody, []byte(""))
+	var updateMetadata runMetadataEnvelope
+	_, newJSONBody, status, err := patchMetadataObject(container, fmt.Sprintf("/run/%s/%s", project, uid), updateJSONBody, &updateMetadata, nil)
 	if err != nil {
-		clog.printF("updateRunHandler: Failed to call dotSeparatedPathToJSON : %s", err)
-		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		clog.printF("updateRunHandler: %s\n", err)
+		ctx.Response.SetStatusCode(status)
 		return
 	}
+	publishRunEvent(container, events.RunUpdated, project, uid, newJSONBody)
+}
 
-	var updateMetadata runMetadataEnvelope
-	updateMetadata.makeInvalid()
-	json.Unmarshal(updateJSONBodyUndecorated, &updateMetadata)
-
-	getItemInput := &v3io.GetItemInput{
-		Path:           fmt.Sprintf("/run/%s/%s", project, uid),
-		AttributeNames: []string{dataAttributeName},
+// patchMetadataObject applies dot separated path updates to the object at
+// path, keeping its YAML or JSON form, and sets the attributes of the updated
+// descriptor fields. With expect, the object is only updated when it is expect
+// in JSON form. It returns the old and new object in JSON form, or the response
+// status of the error
+func patchMetadataObject(container v3io.Container, path string, updateJSONBody []byte, descriptor interface{}, expect []byte) ([]byte, []byte, int, error) {
+	updateJSONBodyUndecorated, err := dotSeparatedPathToJSON(updateJSONBody, []byte(""))
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("Failed to call dotSeparatedPathToJSON : %s", err)
 	}
+	json.Unmarshal(updateJSONBodyUndecorated, descriptor)
 
-	v3ioResponse, err := container.GetItemSync(getItemInput)
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           path,
+		AttributeNames: []string{dataAttributeName},
+	})
 	if err != nil {
-		clog.printF("updateRunHandler: Failed to read existing object: %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
-		ctx.Response.SetBody(v3ioResponse.Body())
-		v3ioResponse.Release()
-		return
+		return nil, nil, statusFromError(err), fmt.Errorf("Failed to read existing object: %s", err)
 	}
 	getItemOutput := v3ioResponse.Output.(*v3io.GetItemOutput)
-	oldBody := getItemOutput.Item[dataAttributeName].([]byte)
+	oldBody, err := openData(getItemOutput.Item[dataAttributeName].([]byte))
 	v3ioResponse.Release()
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
 	oldJSONBody, err := convertDataToJSON(oldBody)
 	if err != nil {
-		clog.printF("updateRunHandler: Failed to convertDataToJSON: %s", err)
-		ctx.Response.SetStatusCode(http.StatusBadRequest)
-		return
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("Failed to convertDataToJSON: %s", err)
+	}
+	if expect != nil && !bytes.Equal(oldJSONBody, expect) {
+		return oldJSONBody, nil, http.StatusOK, nil
 	}
 
 	newJSONBody, err := dotSeparatedPathToJSON(updateJSONBody, oldJSONBody)
 	if err != nil {
-		clog.printF("updateRunHandler: Failed to call dotSeparatedPathToJSON : %s", err)
-		ctx.Response.SetStatusCode(http.StatusBadRequest)
-		return
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("Failed to call dotSeparatedPathToJSON : %s", err)
 	}
 
-	updateItemInput := v3io.UpdateItemInput{}
-	updateItemInput.Path = getItemInput.Path
-	metadataToV3ioAttributes(updateMetadata, "", &updateItemInput.Attributes)
-	println(updateItemInput.Attributes)
+	updateItemInput := v3io.UpdateItemInput{Path: path}
+	if err = metadataToV3ioAttributes(descriptor, "", &updateItemInput.Attributes); err != nil {
+		return nil, nil, statusFromError(err), fmt.Errorf("Failed to index the object: %s", err)
+	}
+	if _, ok := descriptor.(*runMetadataEnvelope); ok {
+		// Run paths are /run/<project>/<uid>
+		if err = indexRunMetrics(container, strings.Split(path, "/")[2], newJSONBody, updateItemInput.Attributes); err != nil {
+			return nil, nil, statusFromError(err), fmt.Errorf("Failed to index run parameters and results: %s", err)
+		}
+	}
 	if isYAML(oldBody) {
 		newYamlBody, err := yaml.JSONToYAML(newJSONBody)
 		if err != nil {
-			clog.printF("updateRunHandler: Failed to call JSONToYAML : %s", err)
-			ctx.Response.SetStatusCode(http.StatusBadRequest)
-			return
+			return nil, nil, http.StatusBadRequest, fmt.Errorf("Failed to call JSONToYAML : %s", err)
 		}
-		updateItemInput.Attributes[dataAttributeName] = newYamlBody
+		updateItemInput.Attributes[dataAttributeName] = sealData(newYamlBody)
 	} else {
-		updateItemInput.Attributes[dataAttributeName] = newJSONBody
+		updateItemInput.Attributes[dataAttributeName] = sealData(newJSONBody)
 	}
-	err = container.UpdateItemSync(&updateItemInput)
-	if err != nil {
-		clog.printF("updateRunHandler: Failed to call UpdateItemSync : %s", err)
+	if err = container.UpdateItemSync(&updateItemInput); err != nil {
+		return nil, nil, statusFromError(err), fmt.Errorf("Failed to call UpdateItemSync : %s", err)
 	}
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	return oldJSONBody, newJSONBody, http.StatusOK, nil
 }
 
-func readMetadataObject(ctx *fasthttp.RequestCtx, path string) {
+func readMetadataObject(container v3io.Container, ctx *fasthttp.RequestCtx, path string) {
 	getItemInput := &v3io.GetItemInput{
 		Path:           path,
-		AttributeNames: []string{dataAttributeName},
+		AttributeNames: []string{dataAttributeName, mtimeAttribute},
 	}
 
 	v3ioResponse, err := container.GetItemSync(getItemInput)
 	if err != nil {
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
-		ctx.Response.SetBody(v3ioResponse.Body())
-		v3ioResponse.Release()
+		setStatusFromError(ctx, err)
+		writeResponseBody(ctx, v3ioResponse)
 		return
 	}
 	getItemOutput := v3ioResponse.Output.(*v3io.GetItemOutput)
-	body := getItemOutput.Item[dataAttributeName].([]byte)
+	body, err := openData(getItemOutput.Item[dataAttributeName].([]byte))
+	modified := itemModified(getItemOutput.Item)
 	v3ioResponse.Release()
+	if err != nil {
+		clog.printF("readMetadataObject: %s\n", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	body = redactBody(ctx, body)
 	body = append([]byte("{\"data\":"), body...)
 	body = append(body, "}"...)
-	ctx.Response.SetBody(body)
+	writeConditional(ctx, body, modified)
 }
 
 func readRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
 	clog.printF("readRunHandler : Project %s uid %s\n", project, uid)
 
-	readMetadataObject(ctx, fmt.Sprintf("/run/%s/%s", project, uid))
+	readMetadataObject(container, ctx, fmt.Sprintf("/run/%s/%s", project, uid))
 }
 
 func deleteRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
@@ -458,17 +650,58 @@ func deleteRunHandler(ctx *fasthttp.RequestCtx) {
 		Path: fmt.Sprintf("/run/%s/%s", project, uid),
 	}
 	err := container.DeleteObjectSync(deleteItemInput)
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	if err == nil {
+		publishRunEvent(container, events.RunDeleted, project, uid, nil)
+		if err = deleteRunResources(container, project, uid, cascadeMode(ctx)); err != nil {
+			clog.printF("deleteRunHandler: Failed to delete run resources : %s", err)
+		}
+	}
+	setStatusFromError(ctx, err)
+}
+
+// runListFilter returns the filter expression of the runs list parameters,
+// invalid parameters fail with 400
+func runListFilter(container v3io.Container, ctx *fasthttp.RequestCtx, project string) (string, error) {
+	labels, err := queryLabelFilters(ctx, "metadata.labels")
+	if err != nil {
+		return "", badFilter("%s", err)
+	}
+	filterStr, err := buildRunFilterString(labels,
+		string(ctx.QueryArgs().Peek("name")),
+		string(ctx.QueryArgs().Peek("state")),
+		-1)
+	if err != nil {
+		return "", badFilter("%s", err)
+	}
+	if tag := string(ctx.QueryArgs().Peek("tag")); tag != "" {
+		filterStr = joinFilters(filterStr, runTagFilter(tag))
+	}
+	if api.QueryBool(ctx, "notes", false) {
+		filterStr = joinFilters(filterStr, runNotesFilter())
+	}
+	if workflow := string(ctx.QueryArgs().Peek("workflow")); workflow != "" {
+		workflowFilter, err := runWorkflowFilter(workflow)
+		if err != nil {
+			return "", badFilter("%s", err)
+		}
+		filterStr = joinFilters(filterStr, workflowFilter)
+	}
+	var metricFilters []string
+	for _, value := range ctx.QueryArgs().PeekMulti("filter") {
+		metricFilters = append(metricFilters, string(value))
+	}
+	metricFilterStr, err := runMetricFilters(container, project, metricFilters)
+	if err != nil {
+		return "", err
+	}
+	return joinFilters(filterStr, metricFilterStr), nil
 }
 
 func listRunsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
-	doSort := string(ctx.QueryArgs().Peek("sort"))
-	last, err := strconv.Atoi(string(ctx.QueryArgs().Peek("last")))
-	if err == nil {
-		last = 30 // Same as in python code
-	}
+	// The runs are not limited without last
+	last, _ := strconv.Atoi(string(ctx.QueryArgs().Peek("last")))
 
 	project := string(ctx.QueryArgs().Peek("project"))
 	if project == "" {
@@ -476,82 +709,132 @@ func listRunsHandler(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(http.StatusBadRequest)
 		return
 	}
-
-	labelsParams := ctx.QueryArgs().PeekMulti("label")
-	var labels map[string]string
-	for key, value := range labelsParams {
-		labels[string(key)] = parseLabelToV3IOFilterSubexpression("metadata", string(value))
+	filterStr, err := runListFilter(container, ctx, project)
+	if err != nil {
+		clog.printF("listRunsHandler : %s", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return
 	}
 
-	filterStr := buildRunFilterString(labels,
-		string(ctx.QueryArgs().Peek("name")),
-		string(ctx.QueryArgs().Peek("state")),
-		-1)
+	rows, err := runPartitionRows(ctx)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if rows > 0 {
+		listPartitionedRuns(container, ctx, project, filterStr, rows)
+		return
+	}
+	listRuns(container, ctx, project, filterStr, api.QueryBool(ctx, "sort", false), last)
+}
 
+func listRuns(container v3io.Container, ctx *fasthttp.RequestCtx, project string, filterStr string, doSort bool, last int) {
 	getItemsInput := v3io.GetItemsInput{
 		Path:           fmt.Sprintf("/run/%s/", project),
-		AttributeNames: []string{"__name", dataAttributeName, encodeAttributeName("status.starttimeEpoch")},
+		AttributeNames: []string{"__name", dataAttributeName, encodeAttributeName("status.lasttimeEpoch")},
 		Filter:         filterStr,
 	}
 
 	cursor, err := v3io.NewItemsCursor(container, &getItemsInput)
 	if err != nil {
-		if err.(v3ioerrors.ErrorWithStatusCode).StatusCode() == http.StatusNotFound {
+		if isNotFound(err) {
 			//Directory not found! Return an empty list
-			result := []byte("{\"runs\": []}")
-			println(string(result))
-			ctx.Response.SetBody([]byte(result))
+			ctx.Response.SetBody([]byte("{\"runs\": []}"))
 			return
 		}
 		clog.printF("listRunHandler: Failed to call NewItemsCursor : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	redactor := requestRedactor(ctx)
+	if !doSort && last == 0 {
+		// Unsorted runs are written as the cursor reads them, page by page
+		writeRuns(ctx, func() ([]byte, error) {
+			cursorItem, err := cursor.NextItemSync()
+			if cursorItem == nil || err != nil {
+				return nil, err
+			}
+			md, err := openData(cursorItem.GetField(dataAttributeName).([]byte))
+			if err != nil {
+				return nil, err
+			}
+			return redactor.redactBody(md), nil
+		})
 		return
 	}
 
-	result := []byte("{\"runs\": [")
 	cursorItems, err := cursor.AllSync()
-	resultMapByTime := make(map[int][]byte)
-	dummyTimestampEpoch := 0
-	var keys []int
+	if err != nil {
+		clog.printF("listRuns: Failed to call cursor.AllSync : %s", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	type timedRun struct {
+		lastUpdate int
+		body       []byte
+	}
+	runs := make([]timedRun, 0, len(cursorItems))
 	for _, cursorItem := range cursorItems {
-		key, err := cursorItem.GetFieldInt(encodeAttributeName("status.lasttimeEpoch"))
+		// Runs without an update time are listed last
+		lastUpdate, _ := cursorItem.GetFieldInt(encodeAttributeName("status.lasttimeEpoch"))
+		md, err := openData(cursorItem.GetField(dataAttributeName).([]byte))
 		if err != nil {
-			key = dummyTimestampEpoch
-			dummyTimestampEpoch++
+			clog.printF("listRuns: %s\n", err)
+			ctx.Response.SetStatusCode(http.StatusInternalServerError)
+			return
 		}
-		keys = append(keys, key)
-
-		md := cursorItem.GetField(dataAttributeName).([]byte)
-		resultMapByTime[key] = md
+		runs = append(runs, timedRun{lastUpdate: lastUpdate, body: md})
 	}
-	if doSort == "true" || last != 0 {
-		sort.Slice(keys, func(i, j int) bool { return keys[i] > keys[j] })
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].lastUpdate > runs[j].lastUpdate })
+	if last != 0 && len(runs) > last {
+		runs = runs[:last]
 	}
-	numOfKeysLeftToAdd := len(keys)
-	if last != 0 && numOfKeysLeftToAdd > last {
-		numOfKeysLeftToAdd = last
-	}
-	keysToAdd := keys[:numOfKeysLeftToAdd]
-	for _, key := range keysToAdd {
-		numOfKeysLeftToAdd--
-		result = append(result, resultMapByTime[key]...)
-		if numOfKeysLeftToAdd > 0 {
-			result = append(result, ","...)
+	writeRuns(ctx, func() ([]byte, error) {
+		if len(runs) == 0 {
+			return nil, nil
 		}
+		body := redactor.redactBody(runs[0].body)
+		runs = runs[1:]
+		return body, nil
+	})
+}
+
+// writeRuns writes the runs list body as next returns the runs, until it
+// returns a nil run. The body is complete before the handler returns, so the
+// reads are done in the request concurrency slot and an error fails the
+// request rather than truncate its body
+func writeRuns(ctx *fasthttp.RequestCtx, next func() ([]byte, error)) {
+	body := bytes.Buffer{}
+	body.WriteString("{\"runs\": [")
+	for count := 0; ; count++ {
+		run, err := next()
+		if err != nil {
+			clog.printF("listRuns: Failed to read run %d: %s\n", count, err)
+			setStatusFromError(ctx, err)
+			return
+		}
+		if run == nil {
+			break
+		}
+		if count > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(run)
 	}
-	result = append(result, "]}"...)
-	println(string(result))
-	ctx.Response.SetBody([]byte(result))
+	body.WriteString("]}")
+	ctx.Response.SetBody(body.Bytes())
 }
 
 func deleteRunsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 
-	labelsParams := ctx.QueryArgs().PeekMulti("label")
-	var labels map[string]string
-	for key, value := range labelsParams {
-		labels[string(key)] = parseLabelToV3IOFilterSubexpression("", string(value))
+	labels, err := queryLabelFilters(ctx, "metadata.labels")
+	if err != nil {
+		clog.printF("deleteRunsHandler : %s", err)
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
 	}
 	project := string(ctx.QueryArgs().Peek("project"))
 	if project == "" {
@@ -560,23 +843,32 @@ func deleteRunsHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	filterStr := buildRunFilterString(labels,
+	filterStr, err := buildRunFilterString(labels,
 		string(ctx.QueryArgs().Peek("name")),
 		string(ctx.QueryArgs().Peek("state")),
 		-1)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err = deleteRunItems(container, project, filterStr, cascadeMode(ctx))
+	if err != nil {
+		clog.printF("deleteRunsHandler: Failed to delete runs : %s", err)
+	}
+	setStatusFromError(ctx, err)
+}
 
+func deleteRunItems(container v3io.Container, project string, filter string, cascade string) error {
 	getItemsInput := v3io.GetItemsInput{
 		Path:           fmt.Sprintf("/run/%s/", project),
 		AttributeNames: []string{"__name"},
-		Filter:         filterStr,
+		Filter:         filter,
 	}
 
 	cursor, err := v3io.NewItemsCursor(container, &getItemsInput)
 	if err != nil {
-		clog.printF("deleteRunsHandler: Failed to call NewItemsCursor : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
-		return
+		return err
 	}
 	var allErrors error
 	allErrors = nil
@@ -590,13 +882,18 @@ func deleteRunsHandler(ctx *fasthttp.RequestCtx) {
 		err := container.DeleteObjectSync(deleteItemInput)
 		if err != nil {
 			allErrors = err
+		} else {
+			publishRunEvent(container, events.RunDeleted, project, name, nil)
+			if err := deleteRunResources(container, project, name, cascade); err != nil {
+				allErrors = err
+			}
 		}
 	}
-	errWithStatusCode, _ := allErrors.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	return allErrors
 }
 
 func storeArtifactHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	uid := ctx.UserValue("uid")
@@ -611,15 +908,92 @@ func storeArtifactHandler(ctx *fasthttp.RequestCtx) {
 	if tag == "" {
 		tag = "latest"
 	}
+	body, err := requestData(ctx)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	versionPath := fmt.Sprintf("/artifact/%s/%s.%s", project, key, uid)
+	hash := artifactVersionHash(body)
+	condition := ""
+	if !api.QueryBool(ctx, "force", false) {
+		if condition, err = checkArtifactVersion(container, versionPath, hash); err != nil {
+			clog.printF("storeArtifactHandler: %s\n", err)
+			api.WriteError(ctx, statusFromError(err), err)
+			return
+		}
+	}
+	journal, err := journalTagFlip(container, fmt.Sprint(project), key, tag, fmt.Sprint(uid))
+	if err != nil {
+		clog.printF("storeArtifactHandler: Failed to journal the tag: %s\n", err)
+		api.WriteError(ctx, statusFromError(err), fmt.Errorf("Failed to journal the tag update: %s", err))
+		return
+	}
 	var updateMetadata = artifactMetadataEnvelope{}
-	updateMetadata.makeInvalid()
-	specialAttributes := map[string]interface{}{"name": key}
-	storeMetadataObject(ctx, fmt.Sprintf("/artifact/%s/%s.%s", project, key, uid), ctx.Request.Body(), specialAttributes, &updateMetadata)
-	updateMetadata.makeInvalid()
-	storeMetadataObject(ctx, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag), ctx.Request.Body(), specialAttributes, &updateMetadata)
+	specialAttributes := map[string]interface{}{"name": key, artifactUIDAttribute: fmt.Sprint(uid)}
+	if hash != "" {
+		specialAttributes[artifactHashAttribute] = hash
+	}
+	if storeMetadataObjectIf(container, ctx, versionPath, condition, body, specialAttributes, &updateMetadata) == nil {
+		journal.done(container)
+		return
+	}
+	updateMetadata = artifactMetadataEnvelope{}
+	data := storeMetadataObject(container, ctx, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag), body, specialAttributes, &updateMetadata)
+	if data == nil {
+		// The journal entry is kept, its replay updates the tag
+		writeTagPending(ctx, uid, tag)
+		return
+	}
+	journal.done(container)
+	publishArtifactEvent(container, project, uid, key, tag, data)
+}
+
+// updateArtifactHandler updates artifact fields by dot separated path, the tag
+// is updated too while it still is the same artifact version
+func updateArtifactHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := ctx.UserValue("project")
+	uid := ctx.UserValue("uid")
+	key := string(ctx.QueryArgs().Peek("key"))
+	if key == "" {
+		clog.printF("updateArtifactHandler : Expecting 'key' parameter")
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
+	}
+	tag := string(ctx.QueryArgs().Peek("tag"))
+	if tag == "" {
+		tag = "latest"
+	}
+	updateJSONBody, err := convertDataToJSON(ctx.Request.Body())
+	if err != nil {
+		clog.printF("updateArtifactHandler: Failed to convertDataToJSON: %s", err)
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
+	}
+	var updateMetadata artifactMetadataEnvelope
+	oldJSONBody, newJSONBody, status, err := patchMetadataObject(container, fmt.Sprintf("/artifact/%s/%s.%s", project, key, uid), updateJSONBody, &updateMetadata, nil)
+	if err != nil {
+		clog.printF("updateArtifactHandler: %s\n", err)
+		ctx.Response.SetStatusCode(status)
+		return
+	}
+	updateMetadata = artifactMetadataEnvelope{}
+	_, tagged, status, err := patchMetadataObject(container, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag), updateJSONBody, &updateMetadata, oldJSONBody)
+	if err != nil && status != http.StatusNotFound {
+		clog.printF("updateArtifactHandler: %s\n", err)
+		ctx.Response.SetStatusCode(status)
+		return
+	}
+	if tagged == nil {
+		tag = ""
+	}
+	publishArtifactEvent(container, project, uid, key, tag, newJSONBody)
 }
 
 func getArtifactHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	key := string(ctx.QueryArgs().Peek("key"))
@@ -632,10 +1006,11 @@ func getArtifactHandler(ctx *fasthttp.RequestCtx) {
 	if tag == "" {
 		tag = "latest"
 	}
-	readMetadataObject(ctx, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag))
+	readMetadataObject(container, ctx, fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag))
 }
 
 func deleteArtifactHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := ctx.UserValue("project")
 	key := string(ctx.QueryArgs().Peek("key"))
@@ -652,19 +1027,15 @@ func deleteArtifactHandler(ctx *fasthttp.RequestCtx) {
 		Path: fmt.Sprintf("/artifact/%s/%s.%s", project, key, tag),
 	}
 	err := container.DeleteObjectSync(deleteItemInput)
-	errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
-}
-
-func listArtifactsHandler(ctx *fasthttp.RequestCtx) {
-	requestHandlerPrint(ctx)
-	project := string(ctx.QueryArgs().Peek("project"))
-	if project == "" {
-		clog.printF("listArtifactsHandler : Expecting 'project' parameter")
-		ctx.Response.SetStatusCode(http.StatusBadRequest)
-		return
+	if err == nil {
+		stateOf(container).summaries.invalidate(fmt.Sprint(project))
 	}
+	setStatusFromError(ctx, err)
+}
 
+// artifactListFilter returns the filter expression of the artifacts list
+// parameters, the tag defaults to latest and * lists all tags and versions
+func artifactListFilter(ctx *fasthttp.RequestCtx) (string, error) {
 	tag := string(ctx.QueryArgs().Peek("tag"))
 	if tag == "" {
 		tag = "latest"
@@ -672,17 +1043,36 @@ func listArtifactsHandler(ctx *fasthttp.RequestCtx) {
 	if tag == "*" {
 		tag = ""
 	}
+	if err := validateIdentifiers("tag", tag); err != nil {
+		return "", badFilter("%s", err)
+	}
+	labels, err := queryLabelFilters(ctx, "labels")
+	if err != nil {
+		return "", badFilter("%s", err)
+	}
+	filterStr, err := buildArtifactFilterString(labels, string(ctx.QueryArgs().Peek("name")), tag)
+	if err != nil {
+		return "", badFilter("%s", err)
+	}
+	return filterStr, nil
+}
 
-	labelsParams := ctx.QueryArgs().PeekMulti("label")
-
-	var labels map[string]string
-	for key, value := range labelsParams {
-		labels[string(key)] = parseLabelToV3IOFilterSubexpression("metadata", string(value))
+func listArtifactsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := string(ctx.QueryArgs().Peek("project"))
+	if project == "" {
+		clog.printF("listArtifactsHandler : Expecting 'project' parameter")
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
 	}
 
-	filterStr := buildArtifactFilterString(labels,
-		string(ctx.QueryArgs().Peek("name")),
-		tag)
+	filterStr, err := artifactListFilter(ctx)
+	if err != nil {
+		clog.printF("listArtifactsHandler : %s", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
 
 	getItemsInput := v3io.GetItemsInput{
 		Path:           fmt.Sprintf("/artifact/%s/", project),
@@ -692,24 +1082,20 @@ func listArtifactsHandler(ctx *fasthttp.RequestCtx) {
 
 	cursor, err := v3io.NewItemsCursor(container, &getItemsInput)
 	if err != nil {
-		if err.(v3ioerrors.ErrorWithStatusCode).StatusCode() == http.StatusNotFound {
+		if isNotFound(err) {
 			//Directory not found! Return an empty list
-			result := []byte("{\"artifacts\": []}")
-			println(string(result))
-			ctx.Response.SetBody([]byte(result))
+			ctx.Response.SetBody([]byte("{\"artifacts\": []}"))
 			return
 		}
 		clog.printF("listArtifactsHandler: Failed to call NewItemsCursor : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+		setStatusFromError(ctx, err)
 		return
 	}
 	result := []byte("{\"artifacts\": [")
 	cursorItems, err := cursor.AllSync()
 	if err != nil {
 		clog.printF("listArtifactsHandler: Failed to call cursor.AllSync : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+		setStatusFromError(ctx, err)
 		return
 	}
 	first := true
@@ -718,15 +1104,20 @@ func listArtifactsHandler(ctx *fasthttp.RequestCtx) {
 			result = append(result, ","...)
 		}
 		first = false
-		md := cursorItem.GetField(dataAttributeName).([]byte)
-		result = append(result, md...)
+		md, err := openData(cursorItem.GetField(dataAttributeName).([]byte))
+		if err != nil {
+			clog.printF("listArtifactsHandler: %s\n", err)
+			ctx.Response.SetStatusCode(http.StatusInternalServerError)
+			return
+		}
+		result = append(result, redactBody(ctx, md)...)
 	}
 	result = append(result, "]}"...)
-	println(string(result))
-	ctx.Response.SetBody([]byte(result))
+	ctx.Response.SetBody(result)
 }
 
 func deleteArtifactsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
 	requestHandlerPrint(ctx)
 	project := string(ctx.QueryArgs().Peek("project"))
 	if project == "" {
@@ -741,17 +1132,25 @@ func deleteArtifactsHandler(ctx *fasthttp.RequestCtx) {
 	if tag == "*" {
 		tag = ""
 	}
+	if err := validateIdentifiers("tag", tag); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
 
-	labelsParams := ctx.QueryArgs().PeekMulti("label")
-
-	var labels map[string]string
-	for key, value := range labelsParams {
-		labels[string(key)] = parseLabelToV3IOFilterSubexpression("metadata", string(value))
+	labels, err := queryLabelFilters(ctx, "labels")
+	if err != nil {
+		clog.printF("deleteArtifactsHandler : %s", err)
+		ctx.Response.SetStatusCode(http.StatusBadRequest)
+		return
 	}
 
-	filterStr := buildArtifactFilterString(labels,
+	filterStr, err := buildArtifactFilterString(labels,
 		string(ctx.QueryArgs().Peek("name")),
 		tag)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
 
 	getItemsInput := v3io.GetItemsInput{
 		Path:           fmt.Sprintf("/artifact/%s/", project),
@@ -761,19 +1160,17 @@ func deleteArtifactsHandler(ctx *fasthttp.RequestCtx) {
 
 	cursor, err := v3io.NewItemsCursor(container, &getItemsInput)
 	if err != nil {
-		if err.(v3ioerrors.ErrorWithStatusCode).StatusCode() == http.StatusNotFound {
+		if isNotFound(err) {
 			return
 		}
 		clog.printF("deleteArtifactsHandler: Failed to call NewItemsCursor : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+		setStatusFromError(ctx, err)
 		return
 	}
 	cursorItems, err := cursor.AllSync()
 	if err != nil {
 		clog.printF("deleteArtifactsHandler: Failed to call cursor.AllSync : %s", err)
-		errWithStatusCode, _ := err.(v3ioerrors.ErrorWithStatusCode)
-		ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+		setStatusFromError(ctx, err)
 		return
 	}
 	var allErrors error
@@ -789,8 +1186,10 @@ func deleteArtifactsHandler(ctx *fasthttp.RequestCtx) {
 			allErrors = err
 		}
 	}
-	errWithStatusCode, _ := allErrors.(v3ioerrors.ErrorWithStatusCode)
-	ctx.Response.SetStatusCode(errWithStatusCode.StatusCode())
+	if len(cursorItems) > 0 {
+		stateOf(container).summaries.invalidate(project)
+	}
+	setStatusFromError(ctx, allErrors)
 }
 
 func requestHandlerPrint(ctx *fasthttp.RequestCtx) {
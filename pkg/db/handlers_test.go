@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseLabelFilter(t *testing.T) {
+	for _, test := range []struct {
+		label  string
+		filter string
+	}{
+		{"owner", "exists(metadata_labels_owner)"},
+		{"owner=me", "metadata_labels_owner == 'me'"},
+		{"owner!=me", "metadata_labels_owner != 'me'"},
+		{"owner~=m", "contains(metadata_labels_owner, 'm')"},
+		{"mlrun/kind=a=b", "metadata_labels_mlrun_kind == 'a=b'"},
+		{"", ""},
+		{"=me", ""},
+		{"owner=", ""},
+		{"my owner=me", ""},
+	} {
+		filter, err := parseLabelFilter("metadata.labels", test.label)
+		if test.filter == "" {
+			if err == nil {
+				t.Errorf("%q: parsed as %q, expected an error", test.label, filter)
+			}
+			continue
+		}
+		if err != nil || filter != test.filter {
+			t.Errorf("%q: parsed as %q (%v), expected %q", test.label, filter, err, test.filter)
+		}
+	}
+}
+
+func TestListRunsByLabels(t *testing.T) {
+	mldb := newTestDB(t)
+	for uid, labels := range map[string]string{
+		"u1": `{"owner":"me","team":"a"}`,
+		"u2": `{"owner":"me","team":"b"}`,
+		"u3": `{"owner":"mo","team":"a"}`,
+		"u4": `{"team":"a"}`,
+	} {
+		mustRequest(t, mldb, "POST", "/run/p1/"+uid,
+			fmt.Sprintf(`{"metadata":{"name":"run","uid":"%s","labels":%s}}`, uid, labels), 200)
+	}
+
+	// Repeated label parameters must all match
+	for _, test := range []struct {
+		query string
+		uids  []string
+	}{
+		{"label=owner", []string{"u1", "u2", "u3"}},
+		{"label=owner=me", []string{"u1", "u2"}},
+		{"label=owner!=me", []string{"u3"}},
+		{"label=owner~=m", []string{"u1", "u2", "u3"}},
+		{"label=owner=me&label=team=a", []string{"u1"}},
+		{"label=owner~=m&label=team!=b", []string{"u1", "u3"}},
+		{"label=owner=me&label=team=c", nil},
+	} {
+		var result struct {
+			Runs []struct {
+				Metadata struct{ UID string }
+			}
+		}
+		response := mustRequest(t, mldb, "GET", "/runs?project=p1&"+test.query, "", 200)
+		if err := json.Unmarshal([]byte(response), &result); err != nil {
+			t.Fatalf("%q: %s", test.query, err)
+		}
+		var uids []string
+		for _, run := range result.Runs {
+			uids = append(uids, run.Metadata.UID)
+		}
+		sort.Strings(uids)
+		if fmt.Sprint(uids) != fmt.Sprint(test.uids) {
+			t.Errorf("%q: listed %v, expected %v", test.query, uids, test.uids)
+		}
+	}
+	mustRequest(t, mldb, "GET", "/runs?project=p1&label=my+owner", "", 400)
+
+	mustRequest(t, mldb, "DELETE", "/runs?project=p1&label=owner=me&label=team=b", "", 200)
+	mustRequest(t, mldb, "GET", "/run/p1/u2", "", 404)
+	mustRequest(t, mldb, "GET", "/run/p1/u1", "", 200)
+}
+
+func TestRejectUnsafeValues(t *testing.T) {
+	mldb := newTestDB(t)
+	mustRequest(t, mldb, "POST", "/run/p1/u1", `{"metadata":{"name":"run","uid":"u1"}}`, 200)
+
+	// Quotes in filter values and identifiers outside the path are rejected
+	for _, uri := range []string{
+		"/runs?project=p1&name=" + url.QueryEscape("a' OR '1'=='1"),
+		"/runs?project=p1&state=" + url.QueryEscape(`a\`),
+		"/artifacts?project=p1&name=" + url.QueryEscape("a'"),
+		"/build/status?project=p1&name=" + url.QueryEscape("../p2/fn"),
+	} {
+		mustRequest(t, mldb, "GET", uri, "", 400)
+	}
+	// GraphQL reports field errors in the response
+	for _, query := range []string{
+		`{ run(project: "../p2", uid: "u1") { name } }`,
+		`{ artifacts(project: "p1", tag: "a'b") { key } }`,
+	} {
+		body := mustRequest(t, mldb, "GET", "/graphql?query="+url.QueryEscape(query), "", 200)
+		if !strings.Contains(body, "Invalid") {
+			t.Errorf("%s: expected an invalid argument error, got %s", query, body)
+		}
+	}
+	mustRequest(t, mldb, "POST", "/pipeline/p1",
+		`{"name":"pipe","steps":[{"name":"s1","function":"p2/fn:latest"}]}`, 400)
+}
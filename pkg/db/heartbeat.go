@@ -0,0 +1,171 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/mlrun/controller/pkg/events"
+	"github.com/tidwall/sjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	heartbeatAttribute = "last_heartbeat"
+	watchdogInterval   = time.Minute
+	staleRunState      = "error"
+	runTimeLayout      = "2006-01-02 15:04:05.000000"
+)
+
+// SetHeartbeatTimeout sets how long a running run may go without a heartbeat
+// before the watchdog marks it failed, zero disables the watchdog. Runs that
+// never sent a heartbeat are not affected
+func (db *MLRunDB) SetHeartbeatTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&db.heartbeatTimeout, int64(timeout))
+}
+
+func heartbeatHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := ctx.UserValue("project")
+	uid := ctx.UserValue("uid")
+	err := container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       fmt.Sprintf("/run/%s/%s", project, uid),
+		Condition:  "exists(" + dataAttributeName + ")",
+		Attributes: map[string]interface{}{heartbeatAttribute: time.Now().UnixNano()},
+	})
+	if isConditionFailed(err) {
+		ctx.Response.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		clog.printF("heartbeatHandler: Failed to update %s/%s: %s\n", project, uid, err)
+	}
+	setStatusFromError(ctx, err)
+}
+
+func (db *MLRunDB) StartWatchdog() {
+	go func() {
+		for {
+			time.Sleep(watchdogInterval)
+			timeout := time.Duration(atomic.LoadInt64(&db.heartbeatTimeout))
+			if timeout <= 0 {
+				continue
+			}
+			for _, container := range namespaceContainers() {
+				if err := failStaleRuns(container, time.Now().Add(-timeout)); err != nil {
+					fmt.Printf("Failed to check run heartbeats: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+func failStaleRuns(container v3io.Container, before time.Time) error {
+	projects, err := listProjectDirs(container, "/run/")
+	if err != nil {
+		return err
+	}
+	stateAttribute := encodeAttributeName("status.state")
+	filter := fmt.Sprintf("%s == 'running' AND %s < %d", stateAttribute, heartbeatAttribute, before.UnixNano())
+	var lastErr error
+	for _, project := range projects {
+		cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+			Path:           fmt.Sprintf("/run/%s/", project),
+			AttributeNames: []string{"__name", heartbeatAttribute, dataAttributeName},
+			Filter:         filter,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		items, err := cursor.AllSync()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, item := range items {
+			if err := failStaleRun(container, project, item); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// failStaleRun sets the run state to error, unless a heartbeat arrived since it was listed
+func failStaleRun(container v3io.Container, project string, item v3io.Item) error {
+	uid, _ := item.GetFieldString("__name")
+	lastHeartbeat, _ := item.GetFieldInt(heartbeatAttribute)
+	body, err := openData(item.GetField(dataAttributeName).([]byte))
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("no heartbeat since %s", time.Unix(0, int64(lastHeartbeat)).UTC().Format(time.RFC3339))
+	return setRunState(container, project, uid, body, staleRunState, message, heartbeatAttribute+" == "+strconv.Itoa(lastHeartbeat))
+}
+
+// setRunState sets the state (and error message when not empty) of a run with
+// the given decrypted body, nothing is changed if the condition does not hold
+func setRunState(container v3io.Container, project, uid string, body []byte, state, message, condition string) error {
+	JSONBody, err := convertDataToJSON(body)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fields := map[string]string{"status.state": state, "status.last_update": now.UTC().Format(runTimeLayout)}
+	if message != "" {
+		fields["status.error"] = message
+	}
+	for path, value := range fields {
+		if JSONBody, err = sjson.SetBytes(JSONBody, path, value); err != nil {
+			return err
+		}
+	}
+	data := JSONBody
+	if isYAML(body) {
+		if data, err = yaml.JSONToYAML(JSONBody); err != nil {
+			return err
+		}
+	}
+
+	clog.printF("Marking run %s/%s as %s %s\n", project, uid, state, message)
+	err = container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:      fmt.Sprintf("/run/%s/%s", project, uid),
+		Condition: condition,
+		Attributes: map[string]interface{}{
+			dataAttributeName:                           sealData(data),
+			encodeAttributeName("status.state"):         state,
+			encodeAttributeName("status.lasttimeEpoch"): now.UnixNano(),
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	if err == nil {
+		publishRunEvent(container, events.RunUpdated, project, uid, JSONBody)
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Overflow policies of the indexed labels and values
+const (
+	IndexTruncate = "truncate"
+	IndexSkip     = "skip"
+	IndexReject   = "reject"
+)
+
+const (
+	defaultMaxIndexedLabels = 200
+	defaultMaxIndexedValue  = 4096
+)
+
+// IndexConfig controls which fields and labels of runs and artifacts are
+// indexed as item attributes, the body is always stored whole. Objects with
+// more labels than MaxLabels have only the first ones (in key order) indexed,
+// or are rejected with 400 by the reject policy. String values longer than
+// MaxValueLength bytes are cut (truncate, the default), not indexed (skip) or
+// rejected with 400 (reject). Runs and artifacts whose labels or fields are
+// not indexed, or are truncated, do not match filters on them. Changes apply
+// to the objects stored afterwards, fsck --repair reindexes the others
+type IndexConfig struct {
+	// Label keys indexed (default: all) and not indexed, as path.Match
+	// patterns, e.g. mlrun/*
+	AllowLabels []string
+	DenyLabels  []string
+	// Dot separated fields not indexed, e.g. metadata.iteration, except the
+	// fields the server looks up and filters runs and artifacts by
+	DenyFields []string
+	// Zero values use the defaults (200 labels, 4096 bytes)
+	MaxLabels      int
+	MaxValueLength int
+	Overflow       string
+}
+
+// requiredFields are always indexed: the name check of stored runs, the
+// heartbeat watchdog, run slots, queues and retention, the run tree and the
+// artifact lookups filter on them
+var requiredFields = map[string]bool{
+	"metadata.name":      true,
+	"metadata.uid":       true,
+	"metadata.parentuid": true,
+	"metadata.workflow":  true,
+	"status.state":       true,
+	"status.lasttime":    true,
+	"status.starttime":   true,
+	"name":               true,
+	"kind":               true,
+}
+
+func (c *IndexConfig) validate() error {
+	for _, field := range c.DenyFields {
+		// Time fields are indexed with their Epoch form
+		if requiredFields[strings.ToLower(strings.TrimSuffix(field, "Epoch"))] {
+			return fmt.Errorf("Field %s is always indexed, the server filters by it", field)
+		}
+	}
+	for _, pattern := range append(append([]string{}, c.AllowLabels...), c.DenyLabels...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid label pattern '%s'", pattern)
+		}
+	}
+	if c.MaxLabels < 0 || c.MaxValueLength < 0 {
+		return fmt.Errorf("The indexed labels and value length limits must not be negative")
+	}
+	switch c.Overflow {
+	case "", IndexTruncate, IndexSkip, IndexReject:
+		return nil
+	}
+	return fmt.Errorf("Unknown index overflow policy '%s', use truncate, skip or reject", c.Overflow)
+}
+
+type indexPolicy struct {
+	config     IndexConfig
+	denyFields map[string]bool
+}
+
+var indexing = newIndexPolicy(IndexConfig{})
+
+func newIndexPolicy(config IndexConfig) *indexPolicy {
+	if config.MaxLabels == 0 {
+		config.MaxLabels = defaultMaxIndexedLabels
+	}
+	if config.MaxValueLength == 0 {
+		config.MaxValueLength = defaultMaxIndexedValue
+	}
+	if config.Overflow == "" {
+		config.Overflow = IndexTruncate
+	}
+	policy := indexPolicy{config: config, denyFields: map[string]bool{}}
+	for _, field := range config.DenyFields {
+		policy.denyFields[field] = true
+	}
+	return &policy
+}
+
+func indexOverflow(format string, args ...interface{}) error {
+	return v3ioerrors.NewErrorWithStatusCode(fmt.Errorf(format, args...), http.StatusBadRequest)
+}
+
+func matchesLabel(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// labels returns the keys of the labels indexed, in order
+func (p *indexPolicy) labels(field string, labels map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if len(p.config.AllowLabels) > 0 && !matchesLabel(p.config.AllowLabels, key) || matchesLabel(p.config.DenyLabels, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > p.config.MaxLabels {
+		if p.config.Overflow == IndexReject {
+			return nil, indexOverflow("%s has %d labels, more than the %d indexed", field, len(keys), p.config.MaxLabels)
+		}
+		clog.printF("Indexing %d of the %d %s\n", p.config.MaxLabels, len(keys), field)
+		keys = keys[:p.config.MaxLabels]
+	}
+	return keys, nil
+}
+
+// value returns the indexed form of a string value, false when it is not
+// indexed
+func (p *indexPolicy) value(name, value string) (string, bool, error) {
+	limit := p.config.MaxValueLength
+	if len(value) <= limit {
+		return value, true, nil
+	}
+	switch p.config.Overflow {
+	case IndexReject:
+		return "", false, indexOverflow("The %d bytes value of %s is longer than the %d indexed", len(value), name, limit)
+	case IndexSkip:
+		return "", false, nil
+	}
+	for limit > 0 && !utf8.RuneStart(value[limit]) {
+		limit--
+	}
+	return value[:limit], true, nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/tidwall/sjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	kfpRunsPath   = "/apis/v1beta1/runs"
+	kfpTimeout    = 30 * time.Second
+	kfpProjectKey = "mlrun/project"
+)
+
+var kfpClient = &http.Client{Timeout: kfpTimeout}
+
+// kfpSubmitRequest is a KFP pipeline run, the workflow is the compiled Argo
+// workflow as an object or a YAML string
+type kfpSubmitRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Workflow    json.RawMessage   `json:"workflow"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type kfpParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type kfpResourceReference struct {
+	Key struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"key"`
+	Relationship string `json:"relationship"`
+}
+
+type kfpRun struct {
+	ID                 string                 `json:"id,omitempty"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description,omitempty"`
+	PipelineSpec       kfpPipelineSpec        `json:"pipeline_spec"`
+	ResourceReferences []kfpResourceReference `json:"resource_references,omitempty"`
+}
+
+type kfpPipelineSpec struct {
+	WorkflowManifest string         `json:"workflow_manifest"`
+	Parameters       []kfpParameter `json:"parameters,omitempty"`
+}
+
+// kfpRecord links a KFP run to its mlrun project, stored as /kfp/<project>/<run id>
+type kfpRecord struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Project string            `json:"project"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Created string            `json:"created"`
+}
+
+func (db *MLRunDB) kfpCall(method, path string, body interface{}) (int, []byte, error) {
+	if db.cfg.KFPURL == "" {
+		return 0, nil, fmt.Errorf("No KFP API server configured")
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(db.cfg.KFPURL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := kfpClient.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	data, err = ioutil.ReadAll(response.Body)
+	return response.StatusCode, data, err
+}
+
+// writeKFPResponse passes the KFP response through, failures to reach KFP are 502
+func writeKFPResponse(ctx *fasthttp.RequestCtx, status int, body []byte, err error) bool {
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadGateway, fmt.Errorf("KFP API: %s", err))
+		return false
+	}
+	if status >= http.StatusBadRequest {
+		ctx.SetContentType("application/json")
+		ctx.Response.SetStatusCode(status)
+		ctx.Response.SetBody(body)
+		return false
+	}
+	return true
+}
+
+// workflowManifest returns the workflow as JSON, with the project and labels
+// set as workflow labels so they show on the Argo workflow and its pods
+func workflowManifest(workflow json.RawMessage, project string, labels map[string]string) (string, error) {
+	var text string
+	manifest := []byte(workflow)
+	if json.Unmarshal(workflow, &text) == nil {
+		var err error
+		if manifest, err = yaml.YAMLToJSON([]byte(text)); err != nil {
+			return "", err
+		}
+	}
+	all := map[string]string{kfpProjectKey: project}
+	for key, value := range labels {
+		all[key] = value
+	}
+	var err error
+	for key, value := range all {
+		path := "metadata.labels." + strings.Replace(strings.Replace(key, ".", `\.`, -1), "*", `\*`, -1)
+		if manifest, err = sjson.SetBytes(manifest, path, value); err != nil {
+			return "", err
+		}
+	}
+	return string(manifest), nil
+}
+
+func (db *MLRunDB) submitKFPHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := setFrom(string(ctx.QueryArgs().Peek("project")), "default")
+	request := kfpSubmitRequest{}
+	if err := json.Unmarshal(ctx.Request.Body(), &request); err != nil || len(request.Workflow) == 0 {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON body with a workflow"))
+		return
+	}
+	manifest, err := workflowManifest(request.Workflow, project, request.Labels)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Bad workflow: %s", err))
+		return
+	}
+
+	run := kfpRun{
+		Name:         setFrom(request.Name, project+"-"+time.Now().UTC().Format("20060102150405")),
+		Description:  request.Description,
+		PipelineSpec: kfpPipelineSpec{WorkflowManifest: manifest},
+	}
+	for name, value := range request.Parameters {
+		run.PipelineSpec.Parameters = append(run.PipelineSpec.Parameters, kfpParameter{Name: name, Value: value})
+	}
+	if experiment := string(ctx.QueryArgs().Peek("experiment")); experiment != "" {
+		reference := kfpResourceReference{Relationship: "OWNER"}
+		reference.Key.Type = "EXPERIMENT"
+		reference.Key.ID = experiment
+		run.ResourceReferences = append(run.ResourceReferences, reference)
+	}
+
+	status, body, err := db.kfpCall("POST", kfpRunsPath, &run)
+	if !writeKFPResponse(ctx, status, body, err) {
+		return
+	}
+	response := struct {
+		Run struct {
+			ID string `json:"id"`
+		} `json:"run"`
+	}{}
+	json.Unmarshal(body, &response)
+	record := kfpRecord{ID: response.Run.ID, Name: run.Name, Project: project, Labels: request.Labels, Created: time.Now().UTC().Format(time.RFC3339)}
+	if err := storeKFPRecord(container, &record); err != nil {
+		fmt.Printf("Failed to record KFP run %s of project %s: %s\n", record.ID, project, err)
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
+
+func storeKFPRecord(container v3io.Container, record *kfpRecord) error {
+	if record.ID == "" {
+		return fmt.Errorf("KFP returned no run id")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	attributes := map[string]interface{}{
+		"name":            record.Name,
+		"updated":         time.Now().UnixNano(),
+		dataAttributeName: sealData(data),
+	}
+	for key, value := range record.Labels {
+		attributes[encodeAttributeName("labels."+key)] = value
+	}
+	return container.PutItemSync(&v3io.PutItemInput{Path: fmt.Sprintf("/kfp/%s/%s", record.Project, record.ID), Attributes: attributes})
+}
+
+// getKFPRunHandler returns the KFP run status
+func (db *MLRunDB) getKFPRunHandler(ctx *fasthttp.RequestCtx) {
+	status, body, err := db.kfpCall("GET", fmt.Sprintf("%s/%s", kfpRunsPath, ctx.UserValue("id")), nil)
+	if !writeKFPResponse(ctx, status, body, err) {
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
+
+// listKFPRunsHandler lists the KFP runs submitted for a project
+func listKFPRunsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := setFrom(string(ctx.QueryArgs().Peek("project")), "default")
+	labels, err := queryLabelFilters(ctx, "labels")
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	filterStr, err := buildArtifactFilterString(labels, string(ctx.QueryArgs().Peek("name")), "")
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/kfp/%s/", project),
+		AttributeNames: []string{dataAttributeName},
+		Filter:         filterStr,
+	})
+	runs := []json.RawMessage{}
+	if err == nil {
+		var items []v3io.Item
+		if items, err = cursor.AllSync(); err == nil {
+			for _, item := range items {
+				if data, err := openData(item.GetField(dataAttributeName).([]byte)); err == nil {
+					runs = append(runs, data)
+				}
+			}
+		}
+	}
+	if err != nil && !isNotFound(err) {
+		setStatusFromError(ctx, err)
+		return
+	}
+	writeJSON(ctx, map[string]interface{}{"runs": runs})
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"os"
+	"time"
+)
+
+// newLeaseHolder returns a name identifying this server as a lease holder
+func newLeaseHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", hostname, randomID()[:8])
+}
+
+// acquireLease takes or renews the lease item at path for duration, so one of
+// the replicas runs a background task. The lease is free when missing or not
+// renewed by its holder in time, replicas racing for it get it once
+func acquireLease(container v3io.Container, path, holder string, duration time.Duration) bool {
+	now := time.Now()
+	attributes := map[string]interface{}{"holder": holder, "expires": now.Add(duration).UnixNano()}
+	err := container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       path,
+		Condition:  fmt.Sprintf("not(exists(holder)) or holder == '%s' or expires < %d", holder, now.UnixNano()),
+		Attributes: attributes,
+	})
+	if isNotFound(err) {
+		err = container.PutItemSync(&v3io.PutItemInput{Path: path, Condition: "not(exists(holder))", Attributes: attributes})
+	}
+	if err != nil && !isConditionFailed(err) {
+		fmt.Printf("Failed to acquire the lease %s: %s\n", path, err)
+	}
+	return err == nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"time"
+)
+
+// The versioned API lists return pages in an envelope, see writeListPage
+const (
+	apiV1Prefix = "/api/v1"
+
+	defaultListPage = 100
+	maxListPage     = 1000
+)
+
+// listFilter is the filter applied to a list, the expression is the v3io one
+type listFilter struct {
+	Project    string `json:"project"`
+	Expression string `json:"expression,omitempty"`
+}
+
+// The page token is the v3io marker of the next page, opaque to clients
+func pageToken(marker string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(marker))
+}
+
+func parsePageToken(token string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) == 0 {
+		return "", badFilter("Invalid page_token '%s'", token)
+	}
+	return string(decoded), nil
+}
+
+// readListPage reads up to limit items of a list in v3io order, from the
+// marker on, and returns their bodies and the marker of the next page, empty
+// after the last one. Filtered v3io pages may be short, they are read until
+// the page is full
+func readListPage(container v3io.Container, path, filter, marker string, limit int) ([]json.RawMessage, string, error) {
+	page := []json.RawMessage{}
+	for len(page) < limit {
+		v3ioResponse, err := container.GetItemsSync(&v3io.GetItemsInput{
+			Path:           path,
+			AttributeNames: []string{"__name", dataAttributeName},
+			Filter:         filter,
+			Marker:         marker,
+			Limit:          limit - len(page),
+		})
+		if isNotFound(err) {
+			return page, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		output := v3ioResponse.Output.(*v3io.GetItemsOutput)
+		for _, item := range output.Items {
+			name, _ := item.GetFieldString("__name")
+			data, ok := item.GetField(dataAttributeName).([]byte)
+			if !ok {
+				v3ioResponse.Release()
+				return nil, "", fmt.Errorf("%s%s has no body", path, name)
+			}
+			if data, err = openData(data); err != nil {
+				v3ioResponse.Release()
+				return nil, "", fmt.Errorf("%s%s: %s", path, name, err)
+			}
+			page = append(page, data)
+		}
+		last := output.Last || output.NextMarker == ""
+		marker = output.NextMarker
+		v3ioResponse.Release()
+		if last {
+			return page, "", nil
+		}
+	}
+	return page, marker, nil
+}
+
+// countListItems counts the items of a list, reading their names only
+func countListItems(container v3io.Container, path, filter string) (int, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           path,
+		AttributeNames: []string{"__name"},
+		Filter:         filter,
+	})
+	if isNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Release()
+	count := 0
+	for cursor.NextSync() {
+		count++
+	}
+	return count, cursor.Err()
+}
+
+// writeListPage writes the page of the list at path selected by the page_size
+// and page_token parameters, in an envelope with the items under the kind name
+// (runs or artifacts), the applied filter, the server time and, unless it is
+// the last page, the next_page_token to pass as page_token. Items are in v3io
+// order, the first page has the total of the list too
+func writeListPage(container v3io.Container, ctx *fasthttp.RequestCtx, kind, path string, filter listFilter) {
+	size := defaultListPage
+	if ctx.QueryArgs().Has("page_size") {
+		size = ctx.QueryArgs().GetUintOrZero("page_size")
+		if size <= 0 || size > maxListPage {
+			api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("The page_size must be between 1 and %d", maxListPage))
+			return
+		}
+	}
+	marker := ""
+	if token := string(ctx.QueryArgs().Peek("page_token")); token != "" {
+		var err error
+		if marker, err = parsePageToken(token); err != nil {
+			api.WriteError(ctx, statusFromError(err), err)
+			return
+		}
+	}
+	page, next, err := readListPage(container, path, filter.Expression, marker, size)
+	if err != nil {
+		clog.printF("writeListPage: %s\n", err)
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+
+	redactor := requestRedactor(ctx)
+	for i := range page {
+		page[i] = redactor.redactBody(page[i])
+	}
+	envelope := map[string]interface{}{
+		kind:          page,
+		"filter":      filter,
+		"server_time": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if marker == "" {
+		total := len(page)
+		if next != "" {
+			if total, err = countListItems(container, path, filter.Expression); err != nil {
+				clog.printF("writeListPage: %s\n", err)
+				api.WriteError(ctx, statusFromError(err), err)
+				return
+			}
+		}
+		envelope["total"] = total
+	}
+	if next != "" {
+		envelope["next_page_token"] = pageToken(next)
+	}
+	writeJSON(ctx, envelope)
+}
+
+// listRunsV1Handler lists runs as /runs does, by pages
+func listRunsV1Handler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := string(ctx.QueryArgs().Peek("project"))
+	filterStr, err := runListFilter(container, ctx, project)
+	if err != nil {
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+	writeListPage(container, ctx, "runs", fmt.Sprintf("/run/%s/", project), listFilter{Project: project, Expression: filterStr})
+}
+
+// listArtifactsV1Handler lists artifacts as /artifacts does, by key and tag
+// or uid, by pages
+func listArtifactsV1Handler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := string(ctx.QueryArgs().Peek("project"))
+	filterStr, err := artifactListFilter(ctx)
+	if err != nil {
+		api.WriteError(ctx, statusFromError(err), err)
+		return
+	}
+	writeListPage(container, ctx, "artifacts", fmt.Sprintf("/artifact/%s/", project), listFilter{Project: project, Expression: filterStr})
+}
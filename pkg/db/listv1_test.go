@@ -0,0 +1,88 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+type listPage struct {
+	Runs          []map[string]interface{} `json:"runs"`
+	Total         *int                     `json:"total"`
+	NextPageToken string                   `json:"next_page_token"`
+}
+
+func TestListRunsV1(t *testing.T) {
+	mldb := newTestDB(t)
+	for i := 0; i < 5; i++ {
+		label := "a"
+		if i%2 == 1 {
+			label = "b"
+		}
+		mustRequest(t, mldb, "POST", fmt.Sprintf("/run/p1/u%d", i),
+			fmt.Sprintf(`{"metadata":{"name":"run%d","uid":"u%d","labels":{"group":"%s"}}}`, i, i, label), 200)
+	}
+
+	for _, test := range []struct {
+		query string
+		uids  []string
+	}{
+		{"", []string{"u0", "u1", "u2", "u3", "u4"}},
+		{"&label=group=a", []string{"u0", "u2", "u4"}},
+		{"&label=group=c", nil},
+	} {
+		var uids []string
+		token := ""
+		for pages := 0; ; pages++ {
+			uri := "/api/v1/runs?project=p1&page_size=2" + test.query
+			if token != "" {
+				uri += "&page_token=" + url.QueryEscape(token)
+			}
+			var page listPage
+			if err := json.Unmarshal([]byte(mustRequest(t, mldb, "GET", uri, "", 200)), &page); err != nil {
+				t.Fatal(err)
+			}
+			if pages == 0 && (page.Total == nil || *page.Total != len(test.uids)) {
+				t.Errorf("%q: total %v, expected %d", test.query, page.Total, len(test.uids))
+			}
+			if pages > 0 && page.Total != nil {
+				t.Errorf("%q: total on page %d", test.query, pages)
+			}
+			if len(page.Runs) > 2 {
+				t.Errorf("%q: %d runs in a page of 2", test.query, len(page.Runs))
+			}
+			for _, run := range page.Runs {
+				uids = append(uids, run["metadata"].(map[string]interface{})["uid"].(string))
+			}
+			if token = page.NextPageToken; token == "" || pages > 5 {
+				break
+			}
+		}
+		if fmt.Sprint(uids) != fmt.Sprint(test.uids) {
+			t.Errorf("%q: listed %v, expected %v", test.query, uids, test.uids)
+		}
+	}
+
+	mustRequest(t, mldb, "GET", "/api/v1/runs?project=p1&page_token=!", "", 400)
+	mustRequest(t, mldb, "GET", "/api/v1/runs?project=p1&page_size=0", "", 400)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/kafka"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	logMirrorQueueSize = 1000
+	logMirrorBatchSize = 100
+	logMirrorRetries   = 3
+)
+
+type logChunk struct {
+	Project string    `json:"project"`
+	UID     string    `json:"uid"`
+	Time    time.Time `json:"time"`
+	Log     string    `json:"log"`
+}
+
+// logMirror copies stored log chunks of selected projects to Kafka, v3io stays
+// the source of truth so chunks are dropped when Kafka is unavailable
+type logMirror struct {
+	lock     sync.RWMutex
+	brokers  []string
+	producer *kafka.Producer
+	topics   map[string]string
+	queue    chan *logChunk
+	running  bool
+}
+
+var logs = logMirror{queue: make(chan *logChunk, logMirrorQueueSize)}
+
+// SetLogMirror sets the Kafka brokers and per project topics log chunks are
+// mirrored to, "*" matches all other projects and no brokers disables mirroring
+func (db *MLRunDB) SetLogMirror(brokers []string, topics map[string]string) {
+	logs.configure(brokers, topics)
+}
+
+func (m *logMirror) configure(brokers []string, topics map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(brokers) == 0 || len(topics) == 0 {
+		topics = nil
+	}
+	m.topics = topics
+	if reflect.DeepEqual(brokers, m.brokers) {
+		return
+	}
+	if m.producer != nil {
+		m.producer.Close()
+		m.producer = nil
+	}
+	m.brokers = brokers
+	if len(brokers) > 0 {
+		m.producer = kafka.NewProducer(brokers)
+		if !m.running {
+			m.running = true
+			go m.run()
+		}
+	}
+}
+
+func (m *logMirror) topic(project string) string {
+	if topic, ok := m.topics[project]; ok {
+		return topic
+	}
+	return m.topics["*"]
+}
+
+func (m *logMirror) mirror(project, uid interface{}, body []byte) {
+	m.lock.RLock()
+	enabled := m.topic(fmt.Sprint(project)) != ""
+	m.lock.RUnlock()
+	if !enabled {
+		return
+	}
+
+	chunk := logChunk{Project: fmt.Sprint(project), UID: fmt.Sprint(uid), Time: time.Now().UTC(), Log: string(body)}
+	select {
+	case m.queue <- &chunk:
+	default:
+		clog.printF("logMirror: queue full, dropping log chunk of %s/%s\n", chunk.Project, chunk.UID)
+	}
+}
+
+func (m *logMirror) run() {
+	for {
+		batch := []*logChunk{<-m.queue}
+	drain:
+		for len(batch) < logMirrorBatchSize {
+			select {
+			case chunk := <-m.queue:
+				batch = append(batch, chunk)
+			default:
+				break drain
+			}
+		}
+		m.send(batch)
+	}
+}
+
+func (m *logMirror) send(batch []*logChunk) {
+	m.lock.RLock()
+	producer := m.producer
+	byTopic := map[string][]kafka.Message{}
+	for _, chunk := range batch {
+		topic := m.topic(chunk.Project)
+		if topic == "" {
+			continue
+		}
+		value, _ := json.Marshal(chunk)
+		key := []byte(chunk.Project + "/" + chunk.UID)
+		byTopic[topic] = append(byTopic[topic], kafka.Message{Key: key, Value: value})
+	}
+	m.lock.RUnlock()
+	if producer == nil {
+		return
+	}
+
+	for topic, messages := range byTopic {
+		var err error
+		for attempt := 0; attempt < logMirrorRetries; attempt++ {
+			if err = producer.Produce(topic, messages); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+		if err != nil {
+			fmt.Printf("Failed to mirror %d log chunks to Kafka topic %s: %s\n", len(messages), topic, err)
+		}
+	}
+}
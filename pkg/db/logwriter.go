@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"sync"
+	"time"
+)
+
+const logFlushInterval = 2 * time.Second
+
+// logWriter collects output (e.g. of a build or a followed pod) and
+// periodically stores it as a run log, so it can be polled while it is written
+type logWriter struct {
+	lock      sync.Mutex
+	container v3io.Container
+	project   string
+	uid       string
+	buffer    bytes.Buffer
+	flushed   int
+	done      chan struct{}
+	closed    chan struct{}
+}
+
+func newLogWriter(container v3io.Container, project, uid string) *logWriter {
+	log := &logWriter{container: container, project: project, uid: uid, done: make(chan struct{}), closed: make(chan struct{})}
+	go log.run()
+	return log
+}
+
+func (l *logWriter) Write(data []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buffer.Write(data)
+}
+
+// Close stores the remaining output
+func (l *logWriter) Close() error {
+	close(l.done)
+	<-l.closed
+	return nil
+}
+
+func (l *logWriter) run() {
+	defer close(l.closed)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.flush()
+		case <-l.done:
+			l.flush()
+			return
+		}
+	}
+}
+
+func (l *logWriter) flush() {
+	l.lock.Lock()
+	body := append([]byte(nil), l.buffer.Bytes()...)
+	l.lock.Unlock()
+	if len(body) == l.flushed {
+		return
+	}
+
+	err := l.container.PutObjectSync(&v3io.PutObjectInput{
+		Path: fmt.Sprintf("/log/%s-%s", l.project, l.uid),
+		Body: sealData(body),
+	})
+	if err != nil {
+		fmt.Printf("Failed to store log %s/%s: %s\n", l.project, l.uid, err)
+		return
+	}
+	logs.mirror(l.project, l.uid, body[l.flushed:])
+	l.flushed = len(body)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"sort"
+	"strings"
+)
+
+const metricsContentType = "text/plain; version=0.0.4"
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler exports the project summaries in the Prometheus text format
+func metricsHandler(ctx *fasthttp.RequestCtx) {
+	summaries := stateOf(requestContainer(ctx)).summaries
+	summaries.lock.Lock()
+	var projects []string
+	for project := range summaries.projects {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	runs := map[string]map[string]int{}
+	artifacts := map[string]int{}
+	lastActivity := map[string]int64{}
+	for _, project := range projects {
+		summary := summaries.projects[project].summary(project)
+		runs[project], artifacts[project] = summary.Runs, summary.Artifacts
+		if summary.LastActivity != nil {
+			lastActivity[project] = summary.LastActivity.Unix()
+		}
+	}
+	counted := map[string]bool{}
+	for project := range summaries.completed {
+		counted[project] = true
+	}
+	for project := range summaries.failed {
+		counted[project] = true
+	}
+	var counters []string
+	for project := range counted {
+		counters = append(counters, project)
+	}
+	sort.Strings(counters)
+	completed, failed := map[string]int64{}, map[string]int64{}
+	for _, project := range counters {
+		completed[project], failed[project] = summaries.completed[project], summaries.failed[project]
+	}
+	summaries.lock.Unlock()
+
+	out := bytes.Buffer{}
+	writeMetricHeader(&out, "mlrun_runs", "gauge", "Runs in the DB by project and state")
+	for _, project := range projects {
+		var states []string
+		for state := range runs[project] {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		for _, state := range states {
+			fmt.Fprintf(&out, "mlrun_runs{project=\"%s\",state=\"%s\"} %d\n",
+				metricLabelEscaper.Replace(project), metricLabelEscaper.Replace(state), runs[project][state])
+		}
+	}
+	writeMetricHeader(&out, "mlrun_runs_completed_total", "counter", "Runs that completed since the server started")
+	for _, project := range counters {
+		fmt.Fprintf(&out, "mlrun_runs_completed_total{project=\"%s\"} %d\n", metricLabelEscaper.Replace(project), completed[project])
+	}
+	writeMetricHeader(&out, "mlrun_runs_failed_total", "counter", "Runs that failed since the server started")
+	for _, project := range counters {
+		fmt.Fprintf(&out, "mlrun_runs_failed_total{project=\"%s\"} %d\n", metricLabelEscaper.Replace(project), failed[project])
+	}
+	writeMetricHeader(&out, "mlrun_artifacts", "gauge", "Artifact keys in the DB by project")
+	for _, project := range projects {
+		fmt.Fprintf(&out, "mlrun_artifacts{project=\"%s\"} %d\n", metricLabelEscaper.Replace(project), artifacts[project])
+	}
+	writeMetricHeader(&out, "mlrun_project_last_activity_seconds", "gauge", "Unix time of the last run or artifact change by project")
+	for _, project := range projects {
+		if when, ok := lastActivity[project]; ok {
+			fmt.Fprintf(&out, "mlrun_project_last_activity_seconds{project=\"%s\"} %d\n", metricLabelEscaper.Replace(project), when)
+		}
+	}
+	ctx.SetContentType(metricsContentType)
+	ctx.Response.SetBody(out.Bytes())
+}
+
+func writeMetricHeader(out *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
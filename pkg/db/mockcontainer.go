@@ -0,0 +1,717 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"hash/fnv"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mockPageSize = 1000
+
+// mockEntry is an item or object of the mock container, v3io objects can have
+// attributes and items a body
+type mockEntry struct {
+	attributes map[string]interface{}
+	body       []byte
+	modified   time.Time
+}
+
+// mockContainer keeps the DB objects in memory, it implements the calls the
+// DB uses (items with filters and conditions, objects and directory listings)
+// so the API runs without v3io, e.g. locally and in tests
+type mockContainer struct {
+	v3io.Container
+	lock    sync.RWMutex
+	entries map[string]*mockEntry
+	dirs    map[string]bool
+}
+
+func newMockContainer() *mockContainer {
+	return &mockContainer{entries: map[string]*mockEntry{}, dirs: map[string]bool{"": true}}
+}
+
+func mockError(status int, format string, args ...interface{}) error {
+	return v3ioerrors.NewErrorWithStatusCode(fmt.Errorf(format, args...), status)
+}
+
+func mockResponse(output interface{}) *v3io.Response {
+	return &v3io.Response{Output: output, HTTPResponse: fasthttp.AcquireResponse()}
+}
+
+func mockKey(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// put stores an entry and creates its parent directories, the lock is held
+func (c *mockContainer) put(key string, entry *mockEntry) {
+	entry.modified = time.Now()
+	c.entries[key] = entry
+	for dir := path.Dir(key); dir != "." && !c.dirs[dir]; dir = path.Dir(dir) {
+		c.dirs[dir] = true
+	}
+}
+
+// item returns the requested attributes of an entry, "*" is all the user
+// attributes and "**" adds the system ones
+func (e *mockEntry) item(key string, names []string) v3io.Item {
+	all := e.all(key)
+	if len(names) == 0 {
+		names = []string{"*"}
+	}
+	item := v3io.Item{}
+	for _, name := range names {
+		switch name {
+		case "*", "**":
+			for attribute, value := range all {
+				if name == "**" || !strings.HasPrefix(attribute, "__") {
+					item[attribute] = value
+				}
+			}
+		default:
+			if value, ok := all[name]; ok {
+				item[name] = value
+			}
+		}
+	}
+	return item
+}
+
+// all returns the user and system attributes of an entry
+func (e *mockEntry) all(key string) map[string]interface{} {
+	all := make(map[string]interface{}, len(e.attributes)+4)
+	for name, value := range e.attributes {
+		all[name] = value
+	}
+	all["__name"] = path.Base(key)
+	all["__size"] = len(e.body)
+	all["__mtime_secs"] = int(e.modified.Unix())
+	all["__mtime_nsecs"] = e.modified.Nanosecond()
+	return all
+}
+
+// mockValue converts an attribute to the type v3io returns it as
+func mockValue(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string, bool, float64:
+		return typed, nil
+	case int:
+		return typed, nil
+	case int64:
+		return int(typed), nil
+	case int32:
+		return int(typed), nil
+	case uint64:
+		return int(typed), nil
+	case uint32:
+		return int(typed), nil
+	case float32:
+		return float64(typed), nil
+	case []byte:
+		return append([]byte{}, typed...), nil
+	}
+	return nil, fmt.Errorf("Unsupported attribute type %T", value)
+}
+
+func mockAttributes(attributes map[string]interface{}, into map[string]interface{}) error {
+	for name, value := range attributes {
+		if strings.HasPrefix(name, "__") {
+			return mockError(http.StatusBadRequest, "Attribute %s is a system attribute", name)
+		}
+		converted, err := mockValue(value)
+		if err != nil {
+			return mockError(http.StatusBadRequest, "Attribute %s: %s", name, err)
+		}
+		into[name] = converted
+	}
+	return nil
+}
+
+// check evaluates a condition on an entry, missing entries have no attributes
+func (c *mockContainer) check(key, condition string) error {
+	if condition == "" {
+		return nil
+	}
+	expression, err := parseMockFilter(condition)
+	if err != nil {
+		return mockError(http.StatusBadRequest, "Invalid condition '%s': %s", condition, err)
+	}
+	attributes := map[string]interface{}{}
+	if entry, ok := c.entries[key]; ok {
+		attributes = entry.all(key)
+	}
+	if !expression(attributes) {
+		return mockError(http.StatusPreconditionFailed, "Condition '%s' failed for %s", condition, key)
+	}
+	return nil
+}
+
+func (c *mockContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	key := mockKey(input.Path)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, mockError(http.StatusNotFound, "Item %s not found", input.Path)
+	}
+	return mockResponse(&v3io.GetItemOutput{Item: entry.item(key, input.AttributeNames)}), nil
+}
+
+// GetItemsSync returns the items of a directory matching the filter, in name
+// order and pages of the input limit
+func (c *mockContainer) GetItemsSync(input *v3io.GetItemsInput) (*v3io.Response, error) {
+	var filter func(map[string]interface{}) bool
+	if input.Filter != "" {
+		var err error
+		if filter, err = parseMockFilter(input.Filter); err != nil {
+			return nil, mockError(http.StatusBadRequest, "Invalid filter '%s': %s", input.Filter, err)
+		}
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	dir := mockKey(input.Path)
+	if !c.dirs[dir] {
+		return nil, mockError(http.StatusNotFound, "Directory %s not found", input.Path)
+	}
+	var names []string
+	for key := range c.entries {
+		if path.Dir(key) == dir || (dir == "" && !strings.Contains(key, "/")) {
+			names = append(names, path.Base(key))
+		}
+	}
+	sort.Strings(names)
+
+	limit := input.Limit
+	if limit <= 0 {
+		limit = mockPageSize
+	}
+	output := v3io.GetItemsOutput{Last: true}
+	for _, name := range names {
+		if name <= input.Marker {
+			continue
+		}
+		if input.TotalSegments > 1 {
+			hash := fnv.New32a()
+			hash.Write([]byte(name))
+			if int(hash.Sum32()%uint32(input.TotalSegments)) != input.Segment {
+				continue
+			}
+		}
+		if len(output.Items) == limit {
+			output.Last = false
+			break
+		}
+		key := path.Join(dir, name)
+		entry := c.entries[key]
+		if filter != nil && !filter(entry.all(key)) {
+			continue
+		}
+		output.Items = append(output.Items, entry.item(key, input.AttributeNames))
+		output.NextMarker = name
+	}
+	return mockResponse(&output), nil
+}
+
+func (c *mockContainer) PutItemSync(input *v3io.PutItemInput) error {
+	attributes := map[string]interface{}{}
+	if err := mockAttributes(input.Attributes, attributes); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := mockKey(input.Path)
+	if err := c.check(key, input.Condition); err != nil {
+		return err
+	}
+	entry := mockEntry{attributes: attributes}
+	if existing, ok := c.entries[key]; ok {
+		entry.body = existing.body
+	}
+	c.put(key, &entry)
+	return nil
+}
+
+func (c *mockContainer) UpdateItemSync(input *v3io.UpdateItemInput) error {
+	if input.Expression != nil {
+		return mockError(http.StatusBadRequest, "Update expressions are not supported by the mock container")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := mockKey(input.Path)
+	if err := c.check(key, input.Condition); err != nil {
+		return err
+	}
+	entry := mockEntry{attributes: map[string]interface{}{}}
+	if existing, ok := c.entries[key]; ok {
+		entry.body = existing.body
+		for name, value := range existing.attributes {
+			entry.attributes[name] = value
+		}
+	}
+	if err := mockAttributes(input.Attributes, entry.attributes); err != nil {
+		return err
+	}
+	c.put(key, &entry)
+	return nil
+}
+
+func (c *mockContainer) GetObjectSync(input *v3io.GetObjectInput) (*v3io.Response, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.entries[mockKey(input.Path)]
+	if !ok {
+		return nil, mockError(http.StatusNotFound, "Object %s not found", input.Path)
+	}
+	body := entry.body
+	if input.Offset > 0 {
+		if input.Offset > len(body) {
+			return nil, mockError(http.StatusRequestedRangeNotSatisfiable, "Offset %d is past the end of %s", input.Offset, input.Path)
+		}
+		body = body[input.Offset:]
+	}
+	if input.NumBytes > 0 && input.NumBytes < len(body) {
+		body = body[:input.NumBytes]
+	}
+	response := mockResponse(nil)
+	response.HTTPResponse.SetBody(body)
+	return response, nil
+}
+
+func (c *mockContainer) PutObjectSync(input *v3io.PutObjectInput) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := mockKey(input.Path)
+	entry := mockEntry{attributes: map[string]interface{}{}}
+	if existing, ok := c.entries[key]; ok {
+		entry.attributes = existing.attributes
+		if input.Append {
+			entry.body = existing.body
+		}
+	}
+	entry.body = append(append([]byte{}, entry.body...), input.Body...)
+	c.put(key, &entry)
+	return nil
+}
+
+func (c *mockContainer) DeleteObjectSync(input *v3io.DeleteObjectInput) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := mockKey(input.Path)
+	if _, ok := c.entries[key]; !ok {
+		return mockError(http.StatusNotFound, "Object %s not found", input.Path)
+	}
+	delete(c.entries, key)
+	return nil
+}
+
+// GetContainerContentsSync lists the objects and subdirectories of a directory
+// in key order, keys and prefixes are relative to the container root
+func (c *mockContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (*v3io.Response, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	dir := mockKey(input.Path)
+	if !c.dirs[dir] {
+		return nil, mockError(http.StatusNotFound, "Directory %s not found", input.Path)
+	}
+	parent := func(key string) string {
+		if parent := path.Dir(key); parent != "." {
+			return parent
+		}
+		return ""
+	}
+	var keys []string
+	for subdir := range c.dirs {
+		if subdir != "" && parent(subdir) == dir {
+			keys = append(keys, subdir+"/")
+		}
+	}
+	if !input.DirectoriesOnly {
+		for key := range c.entries {
+			if parent(key) == dir {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	limit := input.Limit
+	if limit <= 0 {
+		limit = mockPageSize
+	}
+	output := v3io.GetContainerContentsOutput{Name: dir}
+	for _, key := range keys {
+		if key <= input.Marker {
+			continue
+		}
+		if len(output.Contents)+len(output.CommonPrefixes) == limit {
+			output.IsTruncated = true
+			break
+		}
+		if strings.HasSuffix(key, "/") {
+			output.CommonPrefixes = append(output.CommonPrefixes, v3io.CommonPrefix{Prefix: key})
+		} else {
+			entry := c.entries[key]
+			output.Contents = append(output.Contents, v3io.Content{Key: key, Size: len(entry.body),
+				LastModified: entry.modified.UTC().Format(time.RFC3339Nano)})
+		}
+		output.NextMarker = key
+	}
+	return mockResponse(&output), nil
+}
+
+// Stream records (e.g. of a v3io events sink) are accepted and dropped
+
+func (c *mockContainer) CreateStreamSync(input *v3io.CreateStreamInput) error {
+	return nil
+}
+
+func (c *mockContainer) PutRecordsSync(input *v3io.PutRecordsInput) (*v3io.Response, error) {
+	return mockResponse(&v3io.PutRecordsOutput{Records: make([]v3io.PutRecordResult, len(input.Records))}), nil
+}
+
+// Filter and condition expressions, e.g. "status_state == 'error' AND
+// exists(metadata_labels_owner) AND NOT (starttimeEpoch < 1565000000)"
+
+type mockFilterToken struct {
+	kind  byte // i(dentifier), s(tring), n(umber), o(perator) or the punctuation
+	text  string
+	value interface{}
+}
+
+func tokenizeMockFilter(text string) ([]mockFilterToken, error) {
+	var tokens []mockFilterToken
+	for i := 0; i < len(text); {
+		char := text[i]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n':
+			i++
+		case char == '(' || char == ')' || char == ',':
+			tokens = append(tokens, mockFilterToken{kind: char, text: string(char)})
+			i++
+		case char == '\'' || char == '"':
+			end := strings.IndexByte(text[i+1:], char)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			value := text[i+1 : i+1+end]
+			tokens = append(tokens, mockFilterToken{kind: 's', text: value, value: value})
+			i += end + 2
+		case strings.IndexByte("=!<>", char) >= 0:
+			op := text[i : i+1]
+			if i+1 < len(text) && text[i+1] == '=' {
+				op = text[i : i+2]
+			}
+			i += len(op)
+			switch op {
+			case "=":
+				op = "=="
+			case "!":
+				return nil, fmt.Errorf("Unexpected ! at %d", i-1)
+			}
+			tokens = append(tokens, mockFilterToken{kind: 'o', text: op})
+		default:
+			end := i
+			for end < len(text) && (isMockNameChar(text[end]) || (end == i && text[end] == '-')) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("Unexpected %q at %d", char, i)
+			}
+			word := text[i:end]
+			if number, err := strconv.ParseInt(word, 10, 64); err == nil {
+				tokens = append(tokens, mockFilterToken{kind: 'n', text: word, value: int(number)})
+			} else if number, err := strconv.ParseFloat(word, 64); err == nil {
+				tokens = append(tokens, mockFilterToken{kind: 'n', text: word, value: number})
+			} else {
+				tokens = append(tokens, mockFilterToken{kind: 'i', text: word})
+			}
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func isMockNameChar(char byte) bool {
+	return char == '_' || char == '.' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+type mockFilterParser struct {
+	tokens []mockFilterToken
+	next   int
+}
+
+// parseMockFilter compiles a v3io filter expression to a function of the item
+// attributes, comparisons of missing attributes are false as in v3io
+func parseMockFilter(text string) (func(map[string]interface{}) bool, error) {
+	tokens, err := tokenizeMockFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	parser := mockFilterParser{tokens: tokens}
+	expression, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if parser.next < len(tokens) {
+		return nil, fmt.Errorf("Unexpected '%s'", tokens[parser.next].text)
+	}
+	return expression, nil
+}
+
+func (p *mockFilterParser) peek() *mockFilterToken {
+	if p.next < len(p.tokens) {
+		return &p.tokens[p.next]
+	}
+	return nil
+}
+
+func (p *mockFilterParser) keyword(word string) bool {
+	if token := p.peek(); token != nil && token.kind == 'i' && strings.EqualFold(token.text, word) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *mockFilterParser) expect(kind byte) (*mockFilterToken, error) {
+	token := p.peek()
+	if token == nil {
+		return nil, fmt.Errorf("Unexpected end of expression, expecting '%c'", kind)
+	}
+	if token.kind != kind {
+		return nil, fmt.Errorf("Unexpected '%s', expecting '%c'", token.text, kind)
+	}
+	p.next++
+	return token, nil
+}
+
+func (p *mockFilterParser) or() (func(map[string]interface{}) bool, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or") {
+		var right func(map[string]interface{}) bool
+		if right, err = p.and(); err == nil {
+			first := left
+			left = func(item map[string]interface{}) bool { return first(item) || right(item) }
+		}
+	}
+	return left, err
+}
+
+func (p *mockFilterParser) and() (func(map[string]interface{}) bool, error) {
+	left, err := p.unary()
+	for err == nil && p.keyword("and") {
+		var right func(map[string]interface{}) bool
+		if right, err = p.unary(); err == nil {
+			first := left
+			left = func(item map[string]interface{}) bool { return first(item) && right(item) }
+		}
+	}
+	return left, err
+}
+
+func (p *mockFilterParser) unary() (func(map[string]interface{}) bool, error) {
+	if p.keyword("not") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(item map[string]interface{}) bool { return !operand(item) }, nil
+	}
+	token := p.peek()
+	if token == nil {
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+	if token.kind == '(' {
+		p.next++
+		expression, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(')'); err != nil {
+			return nil, err
+		}
+		return expression, nil
+	}
+	if token.kind == 'i' && p.next+1 < len(p.tokens) && p.tokens[p.next+1].kind == '(' {
+		return p.function()
+	}
+	return p.comparison()
+}
+
+// function parses exists(attribute) and contains/starts/ends(attribute, text)
+func (p *mockFilterParser) function() (func(map[string]interface{}) bool, error) {
+	name := strings.ToLower(p.tokens[p.next].text)
+	p.next += 2
+	attribute, err := p.expect('i')
+	if err != nil {
+		return nil, err
+	}
+	var match func(string, string) bool
+	switch name {
+	case "exists":
+		if _, err = p.expect(')'); err != nil {
+			return nil, err
+		}
+		return func(item map[string]interface{}) bool {
+			_, ok := item[attribute.text]
+			return ok
+		}, nil
+	case "contains":
+		match = strings.Contains
+	case "starts":
+		match = strings.HasPrefix
+	case "ends":
+		match = strings.HasSuffix
+	default:
+		return nil, fmt.Errorf("Unknown function %s", name)
+	}
+	if _, err = p.expect(','); err != nil {
+		return nil, err
+	}
+	text, err := p.expect('s')
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.expect(')'); err != nil {
+		return nil, err
+	}
+	return func(item map[string]interface{}) bool {
+		value, ok := item[attribute.text].(string)
+		return ok && match(value, text.text)
+	}, nil
+}
+
+func (p *mockFilterParser) operand() (func(map[string]interface{}) (interface{}, bool), error) {
+	token := p.peek()
+	if token == nil {
+		return nil, fmt.Errorf("Unexpected end of expression, expecting an operand")
+	}
+	p.next++
+	switch token.kind {
+	case 's', 'n':
+		value := token.value
+		return func(map[string]interface{}) (interface{}, bool) { return value, true }, nil
+	case 'i':
+		switch strings.ToLower(token.text) {
+		case "true", "false":
+			value := strings.EqualFold(token.text, "true")
+			return func(map[string]interface{}) (interface{}, bool) { return value, true }, nil
+		}
+		name := token.text
+		return func(item map[string]interface{}) (interface{}, bool) {
+			value, ok := item[name]
+			return value, ok
+		}, nil
+	}
+	return nil, fmt.Errorf("Unexpected '%s', expecting an operand", token.text)
+}
+
+func (p *mockFilterParser) comparison() (func(map[string]interface{}) bool, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.expect('o')
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return func(item map[string]interface{}) bool {
+		leftValue, leftOK := left(item)
+		rightValue, rightOK := right(item)
+		if !leftOK || !rightOK {
+			return false
+		}
+		order, ok := compareMockValues(leftValue, rightValue)
+		if !ok {
+			return false
+		}
+		switch op.text {
+		case "==":
+			return order == 0
+		case "!=":
+			return order != 0
+		case "<":
+			return order < 0
+		case "<=":
+			return order <= 0
+		case ">":
+			return order > 0
+		}
+		return order >= 0
+	}, nil
+}
+
+// compareMockValues orders two values of the same kind, integers are compared
+// exactly (e.g. nanosecond epochs)
+func compareMockValues(left, right interface{}) (int, bool) {
+	switch leftValue := left.(type) {
+	case int:
+		switch rightValue := right.(type) {
+		case int:
+			return compareNumbers(leftValue < rightValue, leftValue > rightValue), true
+		case float64:
+			return compareNumbers(float64(leftValue) < rightValue, float64(leftValue) > rightValue), true
+		}
+	case float64:
+		switch rightValue := right.(type) {
+		case int:
+			return compareNumbers(leftValue < float64(rightValue), leftValue > float64(rightValue)), true
+		case float64:
+			return compareNumbers(leftValue < rightValue, leftValue > rightValue), true
+		}
+	case string:
+		if rightValue, ok := right.(string); ok {
+			return strings.Compare(leftValue, rightValue), true
+		}
+	case bool:
+		if rightValue, ok := right.(bool); ok {
+			if leftValue == rightValue {
+				return 0, true
+			}
+			// Only equality is meaningful for booleans
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func compareNumbers(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func TestMockFilter(t *testing.T) {
+	item := map[string]interface{}{"state": "error", "count": 3, "ratio": 0.5, "enabled": true, "name": "train-model"}
+	for _, test := range []struct {
+		filter  string
+		matches bool
+	}{
+		{"state == 'error'", true},
+		{`state == "error"`, true},
+		{"state = 'error'", true},
+		{"state != 'error'", false},
+		{"count > 2 AND count <= 3", true},
+		{"count < 3", false},
+		{"ratio >= 0.5 and count == 3", true},
+		{"count == 3.0", true},
+		{"state == 'ok' OR count == 3", true},
+		{"NOT (state == 'error')", false},
+		{"not(exists(missing))", true},
+		{"exists(state) and not exists(missing)", true},
+		{"missing == 'x' or missing != 'x'", false},
+		{"state == 3", false},
+		{"enabled == true", true},
+		{"contains(name, 'mod') and starts(name, 'train') and ends(name, 'model')", true},
+		{"starts(count, '3')", false},
+	} {
+		filter, err := parseMockFilter(test.filter)
+		if err != nil {
+			t.Errorf("%q: %s", test.filter, err)
+			continue
+		}
+		if matches := filter(item); matches != test.matches {
+			t.Errorf("%q: matched %v, expected %v", test.filter, matches, test.matches)
+		}
+	}
+	for _, filter := range []string{"state ==", "state ! 'x'", "(state == 'x'", "unknown(state)", "state == 'x", "count > 1 2"} {
+		if _, err := parseMockFilter(filter); err == nil {
+			t.Errorf("%q: parsed, expected an error", filter)
+		}
+	}
+}
+
+func TestMockGetItemsPages(t *testing.T) {
+	container := newMockContainer()
+	for i := 0; i < 7; i++ {
+		path := fmt.Sprintf("/items/i%d", i)
+		if err := container.PutItemSync(&v3io.PutItemInput{Path: path, Attributes: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	marker := ""
+	for pages := 0; pages < 10; pages++ {
+		response, err := container.GetItemsSync(&v3io.GetItemsInput{Path: "/items/", Filter: "n != 3", Marker: marker, Limit: 2,
+			AttributeNames: []string{"__name"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := response.Output.(*v3io.GetItemsOutput)
+		if len(output.Items) > 2 {
+			t.Errorf("%d items in a page of 2", len(output.Items))
+		}
+		for _, item := range output.Items {
+			name, _ := item.GetFieldString("__name")
+			names = append(names, name)
+		}
+		response.Release()
+		if output.Last {
+			break
+		}
+		marker = output.NextMarker
+	}
+	if expected := "[i0 i1 i2 i4 i5 i6]"; fmt.Sprint(names) != expected {
+		t.Errorf("Listed %v, expected %s", names, expected)
+	}
+	if _, err := container.GetItemsSync(&v3io.GetItemsInput{Path: "/missing/"}); !isNotFound(err) {
+		t.Errorf("Listing a missing directory returned %v, expected not found", err)
+	}
+	if _, err := container.GetItemsSync(&v3io.GetItemsInput{Path: "/items/", Filter: "n =="}); statusFromError(err) != 400 {
+		t.Errorf("Listing with a bad filter returned %v, expected 400", err)
+	}
+}
+
+func runUIDs(t *testing.T, response string) []string {
+	t.Helper()
+	var result struct {
+		Runs []struct {
+			Metadata struct{ UID string }
+		}
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		t.Fatalf("Failed to parse %s: %s", response, err)
+	}
+	var uids []string
+	for _, run := range result.Runs {
+		uids = append(uids, run.Metadata.UID)
+	}
+	return uids
+}
+
+func TestMockRunFilters(t *testing.T) {
+	mldb := newTestDB(t)
+	for i, state := range []string{"completed", "error", "running", "error"} {
+		mustRequest(t, mldb, "POST", fmt.Sprintf("/run/p1/u%d", i), fmt.Sprintf(
+			`{"metadata":{"name":"run%d","uid":"u%d"},"status":{"state":"%s","last_update":"2019-08-0%dT10:00:00Z"}}`,
+			i%2, i, state, i+1), 200)
+	}
+	mustRequest(t, mldb, "POST", "/run/p2/u9", `{"metadata":{"name":"run1","uid":"u9"},"status":{"state":"error"}}`, 200)
+
+	for _, test := range []struct {
+		query string
+		uids  string
+		// Sorted queries keep the order of the response
+		sorted bool
+	}{
+		{"project=p1", "[u0 u1 u2 u3]", false},
+		{"project=p1&state=error", "[u1 u3]", false},
+		{"project=p1&name=run0", "[u0 u2]", false},
+		{"project=p1&name=run1&state=error", "[u1 u3]", false},
+		{"project=p1&name=run2", "[]", false},
+		{"project=p2&state=error", "[u9]", false},
+		{"project=p1&sort=true", "[u3 u2 u1 u0]", true},
+		{"project=p1&state=error&sort=true", "[u3 u1]", true},
+		{"project=p1&last=2", "[u3 u2]", true},
+		{"project=p1&state=error&sort=true&last=1", "[u3]", true},
+		{"project=p1&last=10", "[u3 u2 u1 u0]", true},
+	} {
+		uids := runUIDs(t, mustRequest(t, mldb, "GET", "/runs?"+test.query, "", 200))
+		if !test.sorted {
+			sort.Strings(uids)
+		}
+		if fmt.Sprint(uids) != test.uids && !(len(uids) == 0 && test.uids == "[]") {
+			t.Errorf("%q: listed %v, expected %s", test.query, uids, test.uids)
+		}
+	}
+
+	// Sorted by the update time, not the store order
+	mustRequest(t, mldb, "POST", "/run/p3/new", `{"metadata":{"uid":"new"},"status":{"last_update":"2019-08-09T10:00:00Z"}}`, 200)
+	mustRequest(t, mldb, "POST", "/run/p3/old", `{"metadata":{"uid":"old"},"status":{"last_update":"2019-08-01T10:00:00Z"}}`, 200)
+	if uids := runUIDs(t, mustRequest(t, mldb, "GET", "/runs?project=p3&last=1", "", 200)); fmt.Sprint(uids) != "[new]" {
+		t.Errorf("Listed %v as the last updated run", uids)
+	}
+
+	mustRequest(t, mldb, "DELETE", "/runs?project=p1&state=error", "", 200)
+	if uids := runUIDs(t, mustRequest(t, mldb, "GET", "/runs?project=p1", "", 200)); len(uids) != 2 {
+		t.Errorf("Listed %v after deleting the failed runs", uids)
+	}
+	mustRequest(t, mldb, "GET", "/run/p2/u9", "", 200)
+
+	mustRequest(t, mldb, "POST", "/log/p1/u0", "line 1\n", 200)
+	if log := mustRequest(t, mldb, "GET", "/log/p1/u0", "", 200); log != "line 1\n" {
+		t.Errorf("Read log %q", log)
+	}
+	mustRequest(t, mldb, "GET", "/log/p1/u1", "", 404)
+}
+
+func TestMockArtifactSearchPages(t *testing.T) {
+	mldb := newTestDB(t)
+	for _, project := range []string{"p1", "p2"} {
+		for i := 0; i < 3; i++ {
+			mustRequest(t, mldb, "POST", fmt.Sprintf("/artifact/%s/u%d?key=model%d&tag=v1", project, i, i),
+				fmt.Sprintf(`{"key":"model%d","kind":"model","tree":"u%d"}`, i, i), 200)
+		}
+		mustRequest(t, mldb, "POST", fmt.Sprintf("/artifact/%s/u9?key=data&tag=v1", project),
+			`{"key":"data","kind":"dataset","tree":"u9"}`, 200)
+	}
+
+	var found []string
+	marker := ""
+	for pages := 0; pages < 10; pages++ {
+		uri := "/artifacts/search?kind=model&tag=v1&limit=2"
+		if marker != "" {
+			uri += "&marker=" + url.QueryEscape(marker)
+		}
+		var page struct {
+			Artifacts []struct {
+				Project string
+				Data    struct{ Key string }
+			}
+			NextMarker string `json:"next_marker"`
+		}
+		if err := json.Unmarshal([]byte(mustRequest(t, mldb, "GET", uri, "", 200)), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Artifacts) > 2 {
+			t.Errorf("%d artifacts in a page of 2", len(page.Artifacts))
+		}
+		for _, artifact := range page.Artifacts {
+			found = append(found, artifact.Project+"/"+artifact.Data.Key)
+		}
+		if marker = page.NextMarker; marker == "" {
+			break
+		}
+	}
+	sort.Strings(found)
+	if expected := "[p1/model0 p1/model1 p1/model2 p2/model0 p2/model1 p2/model2]"; fmt.Sprint(found) != expected {
+		t.Errorf("Found %v, expected %s", found, expected)
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"regexp"
+	"strings"
+)
+
+// Kubernetes namespace names are DNS labels
+var namespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// NamespaceHeader names the namespace a request is for, requests naming none
+// are for the default namespace of the server
+const NamespaceHeader = "X-Mlrun-Namespace"
+
+var (
+	// containers are the DB containers of the served namespaces by namespace,
+	// the one of the default namespace is under "" too
+	containers = map[string]v3io.Container{}
+	// servedNamespaces lists the served namespaces, the default one first
+	servedNamespaces []string
+	// namespaceStates are the states of the served namespaces, keyed as
+	// containers
+	namespaceStates = map[string]*namespaceState{}
+)
+
+// namespaceState is the state kept of the projects of a served namespace
+type namespaceState struct {
+	summaries  *summaryAggregator
+	storage    *storageTracker
+	runIndexes *runIndexCache
+	stats      statsCache
+}
+
+func newNamespaceState() *namespaceState {
+	return &namespaceState{
+		summaries: &summaryAggregator{projects: map[string]*projectAggregate{}, dirty: map[string]bool{},
+			completed: map[string]int64{}, failed: map[string]int64{}},
+		storage:    &storageTracker{defaults: storageDefaults, projects: map[string]*projectStorage{}, limits: map[string]*storageLimits{}},
+		runIndexes: &runIndexCache{projects: map[string]*projectRunIndex{}},
+	}
+}
+
+// stateOf returns the state of the namespace of a DB container
+func stateOf(container v3io.Container) *namespaceState {
+	return namespaceStates[containerNamespace(container)]
+}
+
+// initContainers sets the DB containers of the default storage namespace and
+// of the other served namespaces over the root container. Other namespaces
+// need a default one, so the DB paths all have a namespace segment
+func initContainers(root v3io.Container, defaultNamespace string, namespaces []string) error {
+	containers = map[string]v3io.Container{"": root}
+	servedNamespaces = []string{""}
+	namespaceStates = map[string]*namespaceState{"": newNamespaceState()}
+	if defaultNamespace == "" {
+		if len(namespaces) > 0 {
+			return fmt.Errorf("Serving other namespaces needs a default storage namespace")
+		}
+		return nil
+	}
+	servedNamespaces = nil
+	for _, namespace := range append([]string{defaultNamespace}, namespaces...) {
+		if _, ok := containers[namespace]; ok {
+			continue
+		}
+		namespaced, err := newNamespacedContainer(root, namespace)
+		if err != nil {
+			return err
+		}
+		containers[namespace] = namespaced
+		servedNamespaces = append(servedNamespaces, namespace)
+		namespaceStates[namespace] = newNamespaceState()
+	}
+	containers[""] = containers[defaultNamespace]
+	namespaceStates[""] = namespaceStates[defaultNamespace]
+	return nil
+}
+
+// namespacedContainer adds the namespace segment to the DB paths, after the
+// kind directory (e.g. /run/<namespace>/<project>/<uid>), so servers of
+// different namespaces can share a container
+type namespacedContainer struct {
+	v3io.Container
+	namespace string
+}
+
+func newNamespacedContainer(inner v3io.Container, namespace string) (*namespacedContainer, error) {
+	if !namespaceRegex.MatchString(namespace) {
+		return nil, fmt.Errorf("Invalid storage namespace '%s', expecting a Kubernetes namespace name", namespace)
+	}
+	return &namespacedContainer{Container: inner, namespace: namespace}, nil
+}
+
+func (c *namespacedContainer) path(path string) string {
+	end := strings.Index(strings.TrimPrefix(path, "/"), "/")
+	if !strings.HasPrefix(path, "/") || end < 0 {
+		return path
+	}
+	return path[:end+2] + c.namespace + path[end+1:]
+}
+
+// All the calls with a path are namespaced, the inputs are copied as cursors
+// reuse them for the next pages
+
+func (c *namespacedContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetItemSync(&namespaced)
+}
+
+func (c *namespacedContainer) GetItemsSync(input *v3io.GetItemsInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetItemsSync(&namespaced)
+}
+
+func (c *namespacedContainer) PutItemSync(input *v3io.PutItemInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutItemSync(&namespaced)
+}
+
+func (c *namespacedContainer) UpdateItemSync(input *v3io.UpdateItemInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.UpdateItemSync(&namespaced)
+}
+
+func (c *namespacedContainer) GetObjectSync(input *v3io.GetObjectInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetObjectSync(&namespaced)
+}
+
+func (c *namespacedContainer) PutObjectSync(input *v3io.PutObjectInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutObjectSync(&namespaced)
+}
+
+func (c *namespacedContainer) DeleteObjectSync(input *v3io.DeleteObjectInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.DeleteObjectSync(&namespaced)
+}
+
+func (c *namespacedContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetContainerContentsSync(&namespaced)
+}
+
+func (c *namespacedContainer) GetItem(input *v3io.GetItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetItem(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) GetItems(input *v3io.GetItemsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetItems(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) PutItem(input *v3io.PutItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutItem(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) PutItems(input *v3io.PutItemsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutItems(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) PutItemsSync(input *v3io.PutItemsInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutItemsSync(&namespaced)
+}
+
+func (c *namespacedContainer) UpdateItem(input *v3io.UpdateItemInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.UpdateItem(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) GetObject(input *v3io.GetObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetObject(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) PutObject(input *v3io.PutObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutObject(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) DeleteObject(input *v3io.DeleteObjectInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.DeleteObject(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) GetContainerContents(input *v3io.GetContainerContentsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetContainerContents(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) CreateStream(input *v3io.CreateStreamInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.CreateStream(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) CreateStreamSync(input *v3io.CreateStreamInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.CreateStreamSync(&namespaced)
+}
+
+func (c *namespacedContainer) DeleteStream(input *v3io.DeleteStreamInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.DeleteStream(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) DeleteStreamSync(input *v3io.DeleteStreamInput) error {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.DeleteStreamSync(&namespaced)
+}
+
+func (c *namespacedContainer) SeekShard(input *v3io.SeekShardInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.SeekShard(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) SeekShardSync(input *v3io.SeekShardInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.SeekShardSync(&namespaced)
+}
+
+func (c *namespacedContainer) PutRecords(input *v3io.PutRecordsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutRecords(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) PutRecordsSync(input *v3io.PutRecordsInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.PutRecordsSync(&namespaced)
+}
+
+func (c *namespacedContainer) GetRecords(input *v3io.GetRecordsInput, context interface{}, responseChan chan *v3io.Response) (*v3io.Request, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetRecords(&namespaced, context, responseChan)
+}
+
+func (c *namespacedContainer) GetRecordsSync(input *v3io.GetRecordsInput) (*v3io.Response, error) {
+	namespaced := *input
+	namespaced.Path = c.path(input.Path)
+	return c.Container.GetRecordsSync(&namespaced)
+}
+
+// requestNamespace returns the namespace a request names in the
+// NamespaceHeader or the namespace query parameter, empty for the default one
+func requestNamespace(ctx *fasthttp.RequestCtx) string {
+	if namespace := string(ctx.Request.Header.Peek(NamespaceHeader)); namespace != "" {
+		return namespace
+	}
+	return string(ctx.QueryArgs().Peek("namespace"))
+}
+
+// requestContainer returns the DB container of the namespace of a request,
+// requests for namespaces the server does not serve are rejected before
+func requestContainer(ctx *fasthttp.RequestCtx) v3io.Container {
+	return namespaceContainer(requestNamespace(ctx))
+}
+
+// namespaceContainer returns the DB container of a served namespace, or the
+// one of the default namespace
+func namespaceContainer(namespace string) v3io.Container {
+	if namespaced, ok := containers[namespace]; ok {
+		return namespaced
+	}
+	return containers[""]
+}
+
+// namespaceContainers returns the DB containers of the served namespaces, for
+// the background tasks that run in each
+func namespaceContainers() []v3io.Container {
+	result := make([]v3io.Container, 0, len(servedNamespaces))
+	for _, namespace := range servedNamespaces {
+		result = append(result, containers[namespace])
+	}
+	return result
+}
+
+// containerNamespace returns the namespace of a DB container, empty when its
+// paths have no namespace segment
+func containerNamespace(container v3io.Container) string {
+	if namespaced, ok := container.(*namespacedContainer); ok {
+		return namespaced.namespace
+	}
+	return ""
+}
+
+// namespaceKey qualifies a project by the namespace of a DB container, for the
+// caches of project state
+func namespaceKey(container v3io.Container, project string) string {
+	if namespace := containerNamespace(container); namespace != "" {
+		return namespace + "/" + project
+	}
+	return project
+}
+
+// rootContainer returns the DB container without the namespace segment, for
+// paths that are not DB objects such as artifact targets
+func rootContainer() v3io.Container {
+	if namespaced, ok := containers[""].(*namespacedContainer); ok {
+		return namespaced.Container
+	}
+	return containers[""]
+}
+
+// Namespace returns the default storage namespace of the server, empty when
+// the paths have no namespace segment
+func (db *MLRunDB) Namespace() string {
+	return db.cfg.StorageNamespace
+}
+
+// ServesNamespace reports whether requests may name a namespace
+func (db *MLRunDB) ServesNamespace(namespace string) bool {
+	_, ok := containers[namespace]
+	return ok
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/mlrun/controller/pkg/common"
+	"strings"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	mldb, err := InitDB(&DBConfig{MockV3io: true, StorageNamespace: "ns1", Namespaces: []string{"ns2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mldb.ServesNamespace("ns2") || mldb.ServesNamespace("ns3") {
+		t.Error("Served namespaces do not match the config")
+	}
+	mustRequest(t, mldb, "POST", "/run/p1/u1", `{"metadata":{"name":"default"}}`, 200)
+	mustRequest(t, mldb, "POST", "/run/p1/u1?namespace=ns2", `{"metadata":{"name":"other"}}`, 200)
+
+	// The same project and uid are different runs in each namespace
+	for _, test := range []struct{ query, name string }{
+		{"", "default"},
+		{"?namespace=ns1", "default"},
+		{"?namespace=ns2", "other"},
+	} {
+		if run := mustRequest(t, mldb, "GET", "/run/p1/u1"+test.query, "", 200); !strings.Contains(run, `"name":"`+test.name+`"`) {
+			t.Errorf("Run read with %q is %s, expected %s", test.query, run, test.name)
+		}
+	}
+	mustRequest(t, mldb, "DELETE", "/run/p1/u1?namespace=ns2", "", 200)
+	mustRequest(t, mldb, "GET", "/run/p1/u1?namespace=ns2", "", 404)
+	mustRequest(t, mldb, "GET", "/run/p1/u1", "", 200)
+
+	// Submitted runs are stored in the namespace they are submitted in
+	function := &common.Function{}
+	function.Metadata.Name = "trainer"
+	if _, _, err := mldb.submitRun(containers["ns2"], []byte(`{"metadata":{"project":"p1","uid":"u2"}}`), function, nil); err != nil {
+		t.Fatal(err)
+	}
+	mustRequest(t, mldb, "GET", "/run/p1/u2?namespace=ns2", "", 200)
+	mustRequest(t, mldb, "GET", "/run/p1/u2", "", 404)
+
+	if _, err := InitDB(&DBConfig{MockV3io: true, Namespaces: []string{"ns2"}}); err == nil {
+		t.Error("Served other namespaces without a default one")
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultNotificationTemplate = `Run {{.Name}} ({{.UID}}) in project {{.Project}} {{.State}}{{if .Duration}} after {{.Duration}}{{end}}{{if .Error}}: {{.Error}}{{end}}`
+	notificationQueueSize       = 1000
+	notificationTimeout         = 10 * time.Second
+	notifiedCacheSize           = 10000
+
+	// Run labels adding targets on top of the project settings
+	slackLabel = "notify-slack"
+	emailLabel = "notify-email"
+)
+
+// Notifications are sent when a run reaches one of these states, unless the
+// target lists its own states in events
+var terminalRunStates = []string{"completed", "error", "aborted"}
+
+// defaultNotificationHosts are allowed when the config lists no hosts
+var defaultNotificationHosts = []string{"hooks.slack.com"}
+
+// headerNewlines are removed from the values of email headers
+var headerNewlines = strings.NewReplacer("\r", " ", "\n", " ")
+
+type SMTPConfig struct {
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from,omitempty"`
+}
+
+type NotificationConfig struct {
+	// Go text/template over the notification fields (Project, Name, UID,
+	// State, Error, Duration, Labels)
+	Template string     `json:"template,omitempty"`
+	SMTP     SMTPConfig `json:"smtp,omitempty"`
+	// Hosts slack and webhook notifications are sent to, "*.example.com"
+	// allows the subdomains. Defaults to hooks.slack.com
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+}
+
+type runNotification struct {
+	Project  string            `json:"project"`
+	Name     string            `json:"name"`
+	UID      string            `json:"uid"`
+	State    string            `json:"state"`
+	Error    string            `json:"error,omitempty"`
+	Duration string            `json:"duration,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Message  string            `json:"message"`
+	// The alert rule, for alert notifications
+	Alert string `json:"alert,omitempty"`
+}
+
+type notifier struct {
+	lock     sync.Mutex
+	config   NotificationConfig
+	template *template.Template
+	notified map[string]string // run uid -> last notified state
+	queue    chan func()
+	client   *http.Client
+}
+
+var notifications = newNotifier()
+
+func newNotifier() *notifier {
+	n := &notifier{
+		template: template.Must(template.New("notification").Parse(defaultNotificationTemplate)),
+		notified: map[string]string{},
+		queue:    make(chan func(), notificationQueueSize),
+	}
+	n.client = &http.Client{Timeout: notificationTimeout, CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("Stopped after 10 redirects")
+		}
+		return n.checkURL(request.URL)
+	}}
+	go func() {
+		for send := range n.queue {
+			send()
+		}
+	}()
+	return n
+}
+
+// SetNotificationConfig sets the message template and SMTP server
+func (db *MLRunDB) SetNotificationConfig(config NotificationConfig) error {
+	text := config.Template
+	if text == "" {
+		text = defaultNotificationTemplate
+	}
+	messageTemplate, err := template.New("notification").Parse(text)
+	if err != nil {
+		return fmt.Errorf("Invalid notification template: %s", err)
+	}
+	notifications.lock.Lock()
+	defer notifications.lock.Unlock()
+	notifications.config = config
+	notifications.template = messageTemplate
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// runChanged notifies the project and run label targets once per run state
+func (n *notifier) runChanged(container v3io.Container, project, uid string, data []byte) {
+	doc := common.Run{}
+	if err := json.Unmarshal(data, &doc); err != nil || doc.Status.State == "" {
+		return
+	}
+	state := doc.Status.State
+
+	n.lock.Lock()
+	if n.notified[uid] == state {
+		n.lock.Unlock()
+		return
+	}
+	if len(n.notified) >= notifiedCacheSize {
+		n.notified = map[string]string{}
+	}
+	n.notified[uid] = state
+	config, messageTemplate := n.config, n.template
+	n.lock.Unlock()
+
+	targets := notificationTargets(container, project, doc.Metadata.Labels)
+	notification := runNotification{Project: project, Name: doc.Metadata.Name, UID: uid, State: state,
+		Error: doc.Status.Error, Labels: doc.Metadata.Labels}
+	if start, ok := parseRunTime(doc.Status.StartTime); ok {
+		if end, ok := parseRunTime(doc.Status.LastUpdate); ok && end.After(start) {
+			notification.Duration = end.Sub(start).Round(time.Second).String()
+		}
+	}
+	message := bytes.Buffer{}
+	if err := messageTemplate.Execute(&message, &notification); err != nil {
+		clog.printF("Failed to render notification for run %s: %s\n", uid, err)
+		return
+	}
+	notification.Message = message.String()
+
+	var stateTargets []NotificationTarget
+	for _, target := range targets {
+		states := target.Events
+		if len(states) == 0 {
+			states = terminalRunStates
+		}
+		if contains(states, state) {
+			stateTargets = append(stateTargets, target)
+		}
+	}
+	n.queueSends(stateTargets, &notification, &config.SMTP)
+}
+
+// queueSends queues the notification to each target, dropping it when the
+// queue is full
+func (n *notifier) queueSends(targets []NotificationTarget, notification *runNotification, smtpConfig *SMTPConfig) {
+	for _, target := range targets {
+		target := target
+		select {
+		case n.queue <- func() { n.send(&target, notification, smtpConfig) }:
+		default:
+			fmt.Printf("Notification queue is full, dropping %s notification for run %s\n", target.Kind, notification.UID)
+		}
+	}
+}
+
+func notificationTargets(container v3io.Container, project string, labels map[string]string) []NotificationTarget {
+	var targets []NotificationTarget
+	settings, err := readProjectSettings(container, project)
+	if err != nil {
+		clog.printF("Failed to read settings of project %s: %s\n", project, err)
+	} else {
+		targets = append(targets, settings.Notifications...)
+	}
+	if slackURL := labels[slackLabel]; slackURL != "" {
+		targets = append(targets, NotificationTarget{Kind: "slack", URL: slackURL})
+	}
+	if emails := labels[emailLabel]; emails != "" {
+		targets = append(targets, NotificationTarget{Kind: "email", Emails: strings.Split(emails, ",")})
+	}
+	return targets
+}
+
+func (n *notifier) send(target *NotificationTarget, notification *runNotification, smtpConfig *SMTPConfig) {
+	var err error
+	switch target.Kind {
+	case "slack":
+		err = n.postJSON(target.URL, map[string]string{"text": notification.Message})
+	case "webhook":
+		err = n.postJSON(target.URL, notification)
+	case "email":
+		err = sendEmail(smtpConfig, target.Emails, notification)
+	}
+	if err != nil {
+		fmt.Printf("Failed to send %s notification for run %s: %s\n", target.Kind, notification.UID, err)
+	}
+}
+
+// checkURL allows https URLs of the allowed hosts only, run labels and
+// project settings must not reach internal services
+func (n *notifier) checkURL(target *url.URL) error {
+	n.lock.Lock()
+	hosts := n.config.AllowedHosts
+	n.lock.Unlock()
+	if len(hosts) == 0 {
+		hosts = defaultNotificationHosts
+	}
+	if target.Scheme != "https" {
+		return fmt.Errorf("Notification URLs must be https")
+	}
+	host := strings.ToLower(target.Hostname())
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Notification host %s is not in the allowed hosts", host)
+}
+
+func (n *notifier) postJSON(rawURL string, value interface{}) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if err = n.checkURL(target); err != nil {
+		return err
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	response, err := n.client.Post(target.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", target.Host, response.Status)
+	}
+	return nil
+}
+
+func sendEmail(config *SMTPConfig, to []string, notification *runNotification) error {
+	if config.Host == "" || config.From == "" {
+		return fmt.Errorf("SMTP host and from address are not configured")
+	}
+	port := config.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	var addresses []string
+	for _, recipient := range to {
+		address, err := mail.ParseAddress(strings.TrimSpace(recipient))
+		if err != nil {
+			return fmt.Errorf("Invalid email address '%s': %s", recipient, err)
+		}
+		addresses = append(addresses, address.Address)
+	}
+	subject := headerNewlines.Replace(fmt.Sprintf("[mlrun] %s %s", notification.Name, notification.State))
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		config.From, strings.Join(addresses, ", "), subject, notification.Message)
+	return smtp.SendMail(config.Host+":"+strconv.Itoa(port), auth, config.From, addresses, []byte(message))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNotificationURLs(t *testing.T) {
+	n := &notifier{}
+	for _, test := range []struct {
+		hosts   []string
+		url     string
+		allowed bool
+	}{
+		{nil, "https://hooks.slack.com/services/T0/B0/X", true},
+		{nil, "http://hooks.slack.com/services/T0/B0/X", false},
+		{nil, "https://169.254.169.254/latest/meta-data", false},
+		{nil, "https://hooks.slack.com.evil.io/x", false},
+		{[]string{"*.example.com"}, "https://hooks.example.com/x", true},
+		{[]string{"*.example.com"}, "https://example.com.evil.io/x", false},
+		{[]string{"*.example.com"}, "https://hooks.slack.com/x", false},
+		{[]string{"Hooks.Example.com"}, "https://hooks.example.com:8443/x", true},
+	} {
+		n.config.AllowedHosts = test.hosts
+		target, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = n.checkURL(target); (err == nil) != test.allowed {
+			t.Errorf("%s with hosts %v: allowed %v, expected %v (%v)", test.url, test.hosts, err == nil, test.allowed, err)
+		}
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	for _, test := range []struct {
+		target NotificationTarget
+		valid  bool
+	}{
+		{NotificationTarget{Kind: "slack", URL: "https://hooks.slack.com/x"}, true},
+		{NotificationTarget{Kind: "webhook", URL: "http://10.0.0.1/x"}, false},
+		{NotificationTarget{Kind: "webhook", URL: "file:///etc/passwd"}, false},
+		{NotificationTarget{Kind: "email", Emails: []string{"me@example.com"}}, true},
+		{NotificationTarget{Kind: "email", Emails: []string{"me@example.com\r\nBcc: you@example.com"}}, false},
+	} {
+		if err := validateTargets([]NotificationTarget{test.target}); (err == nil) != test.valid {
+			t.Errorf("%+v: valid %v, expected %v (%v)", test.target, err == nil, test.valid, err)
+		}
+	}
+	if headerNewlines.Replace("run\r\nBcc: x") != "run  Bcc: x" {
+		t.Errorf("Newlines were not removed from the header")
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Artifact items record the uid they were stored under, older items are
+// matched by the artifact tree
+const artifactUIDAttribute = "uid"
+
+// orphanLatest is a latest artifact item whose version item no longer exists
+type orphanLatest struct {
+	Project string `json:"project"`
+	Key     string `json:"key"`
+	UID     string `json:"uid"`
+	// repoint (to the newest remaining version) or remove
+	Action      string `json:"action"`
+	RepointedTo string `json:"repointed_to,omitempty"`
+	Fixed       bool   `json:"fixed"`
+	Error       string `json:"error,omitempty"`
+}
+
+type artifactVersion struct {
+	uid      string
+	data     []byte
+	modified time.Time
+}
+
+type artifactItems struct {
+	latest   map[string]v3io.Item // key -> latest item
+	versions map[string][]artifactVersion
+}
+
+// itemUID returns the uid an artifact item was stored under, from its uid
+// attribute or artifact tree
+func itemUID(item v3io.Item, data []byte) string {
+	if uid, err := item.GetFieldString(artifactUIDAttribute); err == nil && uid != "" {
+		return uid
+	}
+	artifact := common.Artifact{}
+	json.Unmarshal(data, &artifact)
+	return artifact.Tree
+}
+
+func readArtifactItems(container v3io.Container, project string) (*artifactItems, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifact/%s/", project),
+		AttributeNames: []string{"__name", "name", artifactUIDAttribute, dataAttributeName, mtimeAttribute},
+	})
+	if err != nil {
+		return nil, err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+	result := artifactItems{latest: map[string]v3io.Item{}, versions: map[string][]artifactVersion{}}
+	for _, item := range items {
+		name, _ := item.GetFieldString("__name")
+		key, _ := item.GetFieldString("name")
+		if key == "" || !strings.HasPrefix(name, key+".") {
+			continue
+		}
+		suffix := name[len(key)+1:]
+		if suffix == "latest" {
+			result.latest[key] = item
+			continue
+		}
+		sealed, ok := item.GetField(dataAttributeName).([]byte)
+		if !ok {
+			continue
+		}
+		data, err := openData(sealed)
+		if err != nil {
+			return nil, err
+		}
+		if itemUID(item, data) == suffix {
+			result.versions[key] = append(result.versions[key], artifactVersion{uid: suffix, data: data, modified: itemModified(item)})
+		}
+	}
+	return &result, nil
+}
+
+// findOrphanLatest returns the latest items of a project whose uid item is
+// gone, and fixes them when fix is set
+func findOrphanLatest(container v3io.Container, project string, fix bool) ([]*orphanLatest, error) {
+	items, err := readArtifactItems(container, project)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var orphans []*orphanLatest
+	for key, item := range items.latest {
+		sealed, _ := item.GetField(dataAttributeName).([]byte)
+		data, err := openData(sealed)
+		if err != nil {
+			return nil, err
+		}
+		uid := itemUID(item, data)
+		if uid == "" {
+			continue
+		}
+		var newest *artifactVersion
+		found := false
+		for i, version := range items.versions[key] {
+			if version.uid == uid {
+				found = true
+				break
+			}
+			if newest == nil || version.modified.After(newest.modified) {
+				newest = &items.versions[key][i]
+			}
+		}
+		if found {
+			continue
+		}
+		orphan := orphanLatest{Project: project, Key: key, UID: uid, Action: "remove"}
+		if newest != nil {
+			orphan.Action, orphan.RepointedTo = "repoint", newest.uid
+		}
+		if fix {
+			if err := fixOrphanLatest(container, &orphan, newest); err != nil {
+				orphan.Error = err.Error()
+			} else {
+				orphan.Fixed = true
+			}
+		}
+		orphans = append(orphans, &orphan)
+	}
+	if fix && len(orphans) > 0 {
+		stateOf(container).summaries.invalidate(project)
+	}
+	return orphans, nil
+}
+
+// fixOrphanLatest stores the newest remaining version as the latest item, or
+// removes the latest item when no version remains
+func fixOrphanLatest(container v3io.Container, orphan *orphanLatest, newest *artifactVersion) error {
+	path := fmt.Sprintf("/artifact/%s/%s.latest", orphan.Project, orphan.Key)
+	if newest == nil {
+		clog.printF("Removing orphan latest artifact %s/%s of uid %s\n", orphan.Project, orphan.Key, orphan.UID)
+		return container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: path})
+	}
+	clog.printF("Repointing latest artifact %s/%s from uid %s to %s\n", orphan.Project, orphan.Key, orphan.UID, newest.uid)
+	descriptor := artifactMetadataEnvelope{}
+	if err := json.Unmarshal(newest.data, &descriptor); err != nil {
+		return err
+	}
+	attributes := map[string]interface{}{"name": orphan.Key, artifactUIDAttribute: newest.uid}
+	if err := metadataToV3ioAttributes(&descriptor, "", &attributes); err != nil {
+		return err
+	}
+	attributes[dataAttributeName] = sealData(newest.data)
+	// Put replaces the item, the attributes of the orphaned version are dropped
+	return container.PutItemSync(&v3io.PutItemInput{Path: path, Attributes: attributes})
+}
+
+// cleanOrphanLatest repoints or removes the orphan latest items of all projects
+func cleanOrphanLatest(container v3io.Container) error {
+	projects, err := listProjectDirs(container, "/artifact/")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, project := range projects {
+		orphans, err := findOrphanLatest(container, project, true)
+		if err != nil {
+			lastErr = err
+		}
+		for _, orphan := range orphans {
+			if orphan.Error != "" {
+				lastErr = fmt.Errorf("Failed to %s latest artifact %s/%s: %s", orphan.Action, project, orphan.Key, orphan.Error)
+			}
+		}
+	}
+	return lastErr
+}
+
+// orphanLatestHandler reports the orphan latest artifact items, POST fixes
+// them
+func orphanLatestHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	fix := ctx.IsPost()
+	if fix && !api.IsAdmin(ctx) {
+		api.WriteError(ctx, http.StatusForbidden, fmt.Errorf("Fixing orphans needs the admin role"))
+		return
+	}
+	projects := []string{string(ctx.QueryArgs().Peek("project"))}
+	if err := validateIdentifiers("project", projects[0]); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if projects[0] == "" {
+		var err error
+		if projects, err = listProjectDirs(container, "/artifact/"); err != nil {
+			setStatusFromError(ctx, err)
+			return
+		}
+	}
+	orphans := []*orphanLatest{}
+	for _, project := range projects {
+		projectOrphans, err := findOrphanLatest(container, project, fix)
+		if err != nil {
+			clog.printF("orphanLatestHandler: Failed to scan project %s: %s\n", project, err)
+			setStatusFromError(ctx, err)
+			return
+		}
+		orphans = append(orphans, projectOrphans...)
+	}
+	writeJSON(ctx, map[string]interface{}{"orphans": orphans})
+}
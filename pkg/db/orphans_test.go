@@ -0,0 +1,43 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"testing"
+)
+
+func TestFixOrphansNeedsAdmin(t *testing.T) {
+	mldb := newTestDB(t)
+	mustRequest(t, mldb, "POST", "/admin/orphans", "", http.StatusOK)
+
+	for method, expected := range map[string]int{"GET": http.StatusOK, "POST": http.StatusForbidden} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/admin/orphans")
+		api.SetRole(ctx, api.UserRole)
+		orphanLatestHandler(ctx)
+		if status := ctx.Response.StatusCode(); status != expected {
+			t.Errorf("%s as a user returned %d, expected %d", method, status, expected)
+		}
+	}
+}
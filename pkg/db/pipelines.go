@@ -0,0 +1,512 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/tidwall/sjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pipeline and step states, steps whose dependencies failed are skipped
+const (
+	pipelineRunning   = "running"
+	pipelineCompleted = "completed"
+	pipelineError     = "error"
+	stepPending       = "pending"
+	stepRunning       = "running"
+	stepCompleted     = "completed"
+	stepError         = "error"
+	stepSkipped       = "skipped"
+)
+
+// Pipeline is a DAG of runs, stored as /pipeline/<project>/<id>
+type Pipeline struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name"`
+	Project string          `json:"project"`
+	State   string          `json:"state"`
+	Created string          `json:"created,omitempty"`
+	Updated string          `json:"updated,omitempty"`
+	Steps   []*PipelineStep `json:"steps"`
+}
+
+// PipelineStep runs a task of a function after the steps it depends on
+// completed, the function is an object or a "[project/]name[:tag]" reference
+// to a stored function
+type PipelineStep struct {
+	Name       string                 `json:"name"`
+	After      []string               `json:"after,omitempty"`
+	Function   json.RawMessage        `json:"function"`
+	Task       json.RawMessage        `json:"task,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	State      string                 `json:"state,omitempty"`
+	RunUID     string                 `json:"run_uid,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func pipelinePath(project, id interface{}) string {
+	return fmt.Sprintf("/pipeline/%s/%s", project, id)
+}
+
+// validate checks the step names and dependencies and that there are no cycles
+func (p *Pipeline) validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("Pipeline has no steps")
+	}
+	steps := map[string]*PipelineStep{}
+	for _, step := range p.Steps {
+		if step.Name == "" || steps[step.Name] != nil {
+			return fmt.Errorf("Step names must be unique and not empty (%q)", step.Name)
+		}
+		if len(step.Function) == 0 {
+			return fmt.Errorf("Step %s has no function", step.Name)
+		}
+		var reference string
+		if json.Unmarshal(step.Function, &reference) == nil {
+			if _, _, _, err := parseFunctionReference(reference, p.Project); err != nil {
+				return fmt.Errorf("Step %s: %s", step.Name, err)
+			}
+		}
+		steps[step.Name] = step
+	}
+	visited := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("Pipeline has a cycle through step %s", name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, dependency := range steps[name].After {
+			if steps[dependency] == nil {
+				return fmt.Errorf("Step %s depends on unknown step %s", name, dependency)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for _, step := range p.Steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MLRunDB) submitPipelineHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	pipeline := Pipeline{}
+	if err := json.Unmarshal(ctx.Request.Body(), &pipeline); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	pipeline.Project = fmt.Sprint(ctx.UserValue("project"))
+	if err := pipeline.validate(); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	now := time.Now().UTC().Format(runTimeLayout)
+	pipeline.ID = randomID()
+	pipeline.State = pipelineRunning
+	pipeline.Created, pipeline.Updated = now, now
+	for _, step := range pipeline.Steps {
+		step.State, step.RunUID, step.Error = stepPending, "", ""
+	}
+
+	clog.printF("submitPipelineHandler : Project %s pipeline %s (%s)\n", pipeline.Project, pipeline.Name, pipeline.ID)
+	if err := savePipeline(container, &pipeline, -1); err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	if err := db.advancePipeline(container, &pipeline, 0); err != nil {
+		fmt.Printf("Failed to start pipeline %s/%s: %s\n", pipeline.Project, pipeline.ID, err)
+	}
+	writeJSON(ctx, &pipeline)
+}
+
+// savePipeline stores the pipeline if its version did not change since it was
+// read (-1 for a new pipeline), so concurrent advances don't run a step twice
+func savePipeline(container v3io.Container, pipeline *Pipeline, version int) error {
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return err
+	}
+	input := v3io.UpdateItemInput{
+		Path: pipelinePath(pipeline.Project, pipeline.ID),
+		Attributes: map[string]interface{}{
+			"name":            pipeline.Name,
+			"state":           pipeline.State,
+			"version":         version + 1,
+			"updated":         time.Now().UnixNano(),
+			dataAttributeName: sealData(data),
+		},
+	}
+	if version >= 0 {
+		input.Condition = fmt.Sprintf("version == %d", version)
+	}
+	return container.UpdateItemSync(&input)
+}
+
+func readPipeline(container v3io.Container, project, id interface{}) (*Pipeline, int, error) {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           pipelinePath(project, id),
+		AttributeNames: []string{"version", dataAttributeName},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer v3ioResponse.Release()
+	return pipelineFromItem(v3ioResponse.Output.(*v3io.GetItemOutput).Item)
+}
+
+func pipelineFromItem(item v3io.Item) (*Pipeline, int, error) {
+	version, _ := item.GetFieldInt("version")
+	data, err := openData(item.GetField(dataAttributeName).([]byte))
+	if err != nil {
+		return nil, 0, err
+	}
+	pipeline := Pipeline{}
+	if err = json.Unmarshal(data, &pipeline); err != nil {
+		return nil, 0, err
+	}
+	return &pipeline, version, nil
+}
+
+func getPipelineHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	pipeline, _, err := readPipeline(container, ctx.UserValue("project"), ctx.UserValue("id"))
+	if err != nil {
+		clog.printF("getPipelineHandler: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	writeJSON(ctx, pipeline)
+}
+
+func deletePipelineHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: pipelinePath(ctx.UserValue("project"), ctx.UserValue("id"))})
+	setStatusFromError(ctx, err)
+}
+
+func listPipelinesHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	projects := []string{string(ctx.QueryArgs().Peek("project"))}
+	if projects[0] == "" {
+		var err error
+		if projects, err = listProjectDirs(container, "/pipeline/"); err != nil {
+			setStatusFromError(ctx, err)
+			return
+		}
+	}
+	filter := ""
+	if state := string(ctx.QueryArgs().Peek("state")); state != "" {
+		filter = fmt.Sprintf("state == '%s'", strings.Replace(state, "'", "", -1))
+	}
+	pipelines := []*Pipeline{}
+	for _, project := range projects {
+		items, err := listPipelineItems(container, project, filter)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			setStatusFromError(ctx, err)
+			return
+		}
+		for _, item := range items {
+			if pipeline, _, err := pipelineFromItem(item); err == nil {
+				pipelines = append(pipelines, pipeline)
+			}
+		}
+	}
+	writeJSON(ctx, map[string]interface{}{"pipelines": pipelines})
+}
+
+func listPipelineItems(container v3io.Container, project, filter string) ([]v3io.Item, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/pipeline/%s/", project),
+		AttributeNames: []string{"version", dataAttributeName},
+		Filter:         filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursor.AllSync()
+}
+
+// retryPipelineHandler runs the failed and skipped steps again, completed
+// steps are kept
+func (db *MLRunDB) retryPipelineHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	pipeline, version, err := readPipeline(container, ctx.UserValue("project"), ctx.UserValue("id"))
+	if err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	if pipeline.State != pipelineError {
+		api.WriteError(ctx, http.StatusConflict, fmt.Errorf("Only failed pipelines can be retried, pipeline is %s", pipeline.State))
+		return
+	}
+	for _, step := range pipeline.Steps {
+		if step.State == stepError || step.State == stepSkipped {
+			step.State, step.RunUID, step.Error = stepPending, "", ""
+		}
+	}
+	pipeline.State = pipelineRunning
+	if err := db.advancePipeline(container, pipeline, version); err != nil {
+		if isConditionFailed(err) {
+			api.WriteError(ctx, http.StatusConflict, fmt.Errorf("Pipeline changed while retrying, try again"))
+			return
+		}
+		setStatusFromError(ctx, err)
+		return
+	}
+	writeJSON(ctx, pipeline)
+}
+
+// advancePipelines moves running pipelines forward as their step runs finish
+func (db *MLRunDB) advancePipelines(container v3io.Container) error {
+	projects, err := listProjectDirs(container, "/pipeline/")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, project := range projects {
+		items, err := listPipelineItems(container, project, fmt.Sprintf("state == '%s'", pipelineRunning))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, item := range items {
+			pipeline, version, err := pipelineFromItem(item)
+			if err == nil {
+				err = db.advancePipeline(container, pipeline, version)
+			}
+			if err != nil && !isConditionFailed(err) {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// advancePipeline updates the running steps from their runs, starts the steps
+// whose dependencies completed and skips the ones whose dependencies failed
+func (db *MLRunDB) advancePipeline(container v3io.Container, pipeline *Pipeline, version int) error {
+	steps := map[string]*PipelineStep{}
+	for _, step := range pipeline.Steps {
+		steps[step.Name] = step
+		if step.State == stepRunning {
+			state, message, err := readRunState(container, pipeline.Project, step.RunUID)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			switch {
+			case err != nil:
+				step.State, step.Error = stepError, "run record not found"
+			case state == runtime.StateCompleted:
+				step.State = stepCompleted
+			case state == runtime.StateError || state == runtime.StateAborted:
+				step.State, step.Error = stepError, setFrom(message, "run "+state)
+			}
+		}
+	}
+
+	// Dependencies are resolved in passes until nothing changes
+	var toStart []*PipelineStep
+	for changed := true; changed; {
+		changed = false
+		for _, step := range pipeline.Steps {
+			if step.State != stepPending || containsStep(toStart, step) {
+				continue
+			}
+			ready := true
+			for _, dependency := range step.After {
+				switch steps[dependency].State {
+				case stepCompleted:
+				case stepError, stepSkipped:
+					step.State, step.Error = stepSkipped, "dependency "+dependency+" failed"
+					changed = true
+					ready = false
+				default:
+					ready = false
+				}
+				if step.State == stepSkipped {
+					break
+				}
+			}
+			if ready {
+				toStart = append(toStart, step)
+			}
+		}
+	}
+
+	// Claim the steps before starting them, so a concurrent advance does not
+	// start them again
+	for _, step := range toStart {
+		step.State = stepRunning
+		step.RunUID = randomID()
+	}
+	pipeline.State = pipelineState(pipeline.Steps)
+	pipeline.Updated = time.Now().UTC().Format(runTimeLayout)
+	if err := savePipeline(container, pipeline, version); err != nil {
+		return err
+	}
+	if len(toStart) == 0 {
+		return nil
+	}
+	version++
+
+	for _, step := range toStart {
+		if err := db.startStep(container, pipeline, step); err != nil {
+			fmt.Printf("Failed to start step %s of pipeline %s/%s: %s\n", step.Name, pipeline.Project, pipeline.ID, err)
+			step.State, step.Error = stepError, err.Error()
+		}
+	}
+	pipeline.State = pipelineState(pipeline.Steps)
+	return savePipeline(container, pipeline, version)
+}
+
+func containsStep(steps []*PipelineStep, step *PipelineStep) bool {
+	for _, other := range steps {
+		if other == step {
+			return true
+		}
+	}
+	return false
+}
+
+// pipelineState is running while steps run or can still start
+func pipelineState(steps []*PipelineStep) string {
+	state := pipelineCompleted
+	for _, step := range steps {
+		switch step.State {
+		case stepPending, stepRunning:
+			return pipelineRunning
+		case stepError, stepSkipped:
+			state = pipelineError
+		}
+	}
+	return state
+}
+
+func (db *MLRunDB) startStep(container v3io.Container, pipeline *Pipeline, step *PipelineStep) error {
+	functionJSON := []byte(step.Function)
+	var reference string
+	if json.Unmarshal(step.Function, &reference) == nil {
+		project, name, tag, err := parseFunctionReference(reference, pipeline.Project)
+		if err != nil {
+			return err
+		}
+		if functionJSON, err = readFunction(container, project, name, tag); err != nil {
+			return fmt.Errorf("Failed to read function %s: %s", reference, err)
+		}
+	}
+	function := common.Function{}
+	if err := json.Unmarshal(functionJSON, &function); err != nil {
+		return err
+	}
+
+	task := []byte(step.Task)
+	if len(task) == 0 {
+		task = []byte("{}")
+	}
+	fields := map[string]interface{}{
+		"metadata.uid":     step.RunUID,
+		"metadata.project": pipeline.Project,
+	}
+	if function.Metadata.Name != "" {
+		fields["metadata.name"] = function.Metadata.Name + "-" + step.Name
+	}
+	for key, value := range step.Parameters {
+		fields["spec.parameters."+key] = value
+	}
+	var err error
+	for path, value := range fields {
+		if task, err = sjson.SetBytes(task, path, value); err != nil {
+			return err
+		}
+	}
+	_, _, err = db.submitRun(container, task, &function, map[string]string{"workflow": pipeline.ID, "step": step.Name})
+	return err
+}
+
+// parseFunctionReference splits [project/]name[:tag], the functions of a
+// pipeline are those of its project
+func parseFunctionReference(reference, pipelineProject string) (string, string, string, error) {
+	project, tag := pipelineProject, "latest"
+	if i := strings.Index(reference, "/"); i >= 0 {
+		project, reference = reference[:i], reference[i+1:]
+	}
+	if i := strings.LastIndex(reference, ":"); i >= 0 {
+		reference, tag = reference[:i], reference[i+1:]
+	}
+	if err := validateIdentifiers("project", project, "name", reference, "tag", tag); err != nil {
+		return "", "", "", err
+	}
+	if project != pipelineProject {
+		return "", "", "", fmt.Errorf("Function %s is not of project %s", reference, pipelineProject)
+	}
+	return project, reference, tag, nil
+}
+
+// readRunJSON returns a stored run in JSON form
+func readRunJSON(container v3io.Container, project, uid string) ([]byte, error) {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           fmt.Sprintf("/run/%s/%s", project, uid),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := openData(v3ioResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte))
+	v3ioResponse.Release()
+	if err != nil {
+		return nil, err
+	}
+	return convertDataToJSON(body)
+}
+
+// readRunState returns the state and error message of a run
+func readRunState(container v3io.Container, project, uid string) (string, string, error) {
+	JSONBody, err := readRunJSON(container, project, uid)
+	if err != nil {
+		return "", "", err
+	}
+	run := common.Run{}
+	if err = json.Unmarshal(JSONBody, &run); err != nil {
+		return "", "", err
+	}
+	return run.Status.State, run.Status.Error, nil
+}
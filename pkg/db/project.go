@@ -0,0 +1,183 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+// NotificationTarget is where project events (e.g. failed runs) are sent
+type NotificationTarget struct {
+	Kind   string   `json:"kind"`
+	URL    string   `json:"url,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// ProjectSettings are per project defaults, empty fields fall back to the
+// server wide configuration
+type ProjectSettings struct {
+	ArtifactPath  string               `json:"artifact_path,omitempty"`
+	DefaultImage  string               `json:"default_image,omitempty"`
+	RunRetention  string               `json:"run_retention,omitempty"`
+	Notifications []NotificationTarget `json:"notifications,omitempty"`
+	Quota         *ProjectQuota        `json:"quota,omitempty"`
+	StorageQuota  *StorageQuota        `json:"storage_quota,omitempty"`
+	RunIndex      *RunIndex            `json:"run_index,omitempty"`
+}
+
+var notificationKinds = map[string]bool{"slack": true, "email": true, "webhook": true}
+
+func (s *ProjectSettings) runRetention() time.Duration {
+	retention, _ := time.ParseDuration(s.RunRetention)
+	return retention
+}
+
+func (s *ProjectSettings) validate() error {
+	if s.RunRetention != "" {
+		if _, err := time.ParseDuration(s.RunRetention); err != nil {
+			return fmt.Errorf("Invalid run_retention '%s': %s", s.RunRetention, err)
+		}
+	}
+	if err := validateTargets(s.Notifications); err != nil {
+		return err
+	}
+	if s.StorageQuota != nil {
+		if err := s.StorageQuota.validate(); err != nil {
+			return err
+		}
+	}
+	if s.RunIndex != nil {
+		if err := s.RunIndex.validate(); err != nil {
+			return err
+		}
+	}
+	if s.Quota != nil {
+		return s.Quota.validate()
+	}
+	return nil
+}
+
+func validateTargets(targets []NotificationTarget) error {
+	for _, target := range targets {
+		if !notificationKinds[target.Kind] {
+			return fmt.Errorf("Unknown notification kind '%s', use slack, email or webhook", target.Kind)
+		}
+		if target.Kind == "email" && len(target.Emails) == 0 {
+			return fmt.Errorf("Email notifications require emails")
+		}
+		if target.Kind != "email" {
+			if u, err := url.Parse(target.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("%s notifications require an https url", target.Kind)
+			}
+		}
+		for _, email := range target.Emails {
+			if _, err := mail.ParseAddress(email); err != nil {
+				return fmt.Errorf("Invalid email address '%s': %s", email, err)
+			}
+		}
+	}
+	return nil
+}
+
+func projectSettingsPath(project string) string {
+	return fmt.Sprintf("/project/%s/settings", project)
+}
+
+// GetProjectSettings returns the stored settings, or empty settings for
+// projects that have none
+func (db *MLRunDB) GetProjectSettings(container v3io.Container, project string) (*ProjectSettings, error) {
+	return readProjectSettings(container, project)
+}
+
+func readProjectSettings(container v3io.Container, project string) (*ProjectSettings, error) {
+	settings := ProjectSettings{}
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           projectSettingsPath(project),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return &settings, nil
+		}
+		return nil, err
+	}
+	defer v3ioResponse.Release()
+	body, err := openData(v3ioResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte))
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (db *MLRunDB) getProjectSettingsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	settings, err := db.GetProjectSettings(container, fmt.Sprint(ctx.UserValue("name")))
+	if err != nil {
+		clog.printF("getProjectSettingsHandler: Failed to read settings: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	writeJSON(ctx, settings)
+}
+
+func (db *MLRunDB) storeProjectSettingsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	body, err := convertDataToJSON(ctx.Request.Body())
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	settings := ProjectSettings{}
+	if err = json.Unmarshal(body, &settings); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err = settings.validate(); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	data, _ := json.Marshal(&settings)
+	project := fmt.Sprint(ctx.UserValue("name"))
+	err = container.PutItemSync(&v3io.PutItemInput{
+		Path:       projectSettingsPath(project),
+		Attributes: map[string]interface{}{dataAttributeName: sealData(data)},
+	})
+	if err != nil {
+		clog.printF("storeProjectSettingsHandler: Failed to store settings: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	stateOf(container).storage.forget(project)
+	stateOf(container).runIndexes.forget(project)
+	writeJSON(ctx, &settings)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxFilterDepth = 16
+
+type filterFieldKind int
+
+const (
+	stringField filterFieldKind = iota
+	intField
+	timeField
+)
+
+type filterField struct {
+	attribute string
+	kind      filterFieldKind
+}
+
+var runFilterFields = map[string]filterField{
+	"name":        {"metadata.name", stringField},
+	"uid":         {"metadata.uid", stringField},
+	"iteration":   {"metadata.iteration", intField},
+	"state":       {"status.state", stringField},
+	"start_time":  {"status.starttimeEpoch", timeField},
+	"last_update": {"status.lasttimeEpoch", timeField},
+	"parent_uid":  {"metadata.parentuid", stringField},
+	"workflow":    {"metadata.workflow", stringField},
+}
+
+// FilterNode is one node of a structured filter, either a group (and/or/not)
+// or a comparison of a run field or label, e.g. {"or": [{"field": "state",
+// "op": "in", "values": ["failed", "error"]}, {"label": "owner", "op": "=", "value": "me"}]}
+type FilterNode struct {
+	And []*FilterNode `json:"and,omitempty"`
+	Or  []*FilterNode `json:"or,omitempty"`
+	Not *FilterNode   `json:"not,omitempty"`
+
+	Field  string        `json:"field,omitempty"`
+	Label  string        `json:"label,omitempty"`
+	Op     string        `json:"op,omitempty"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+type runQuery struct {
+	Project string      `json:"project"`
+	Filter  *FilterNode `json:"filter"`
+	Sort    bool        `json:"sort"`
+	Last    int         `json:"last"`
+}
+
+// compile turns the filter tree into a v3io filter expression, every value is
+// type checked and quoted so user input can not change the expression structure
+func (n *FilterNode) compile(depth int) (string, error) {
+	if depth > maxFilterDepth {
+		return "", fmt.Errorf("Filter is nested deeper than %d levels", maxFilterDepth)
+	}
+	groups := 0
+	for _, set := range []bool{n.And != nil, n.Or != nil, n.Not != nil, n.Field != "" || n.Label != ""} {
+		if set {
+			groups++
+		}
+	}
+	if groups != 1 {
+		return "", fmt.Errorf("Filter node must have exactly one of and, or, not, field or label")
+	}
+
+	switch {
+	case n.And != nil:
+		return compileGroup(n.And, " AND ", depth)
+	case n.Or != nil:
+		return compileGroup(n.Or, " OR ", depth)
+	case n.Not != nil:
+		expression, err := n.Not.compile(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + expression + ")", nil
+	}
+	return n.compileComparison()
+}
+
+func compileGroup(nodes []*FilterNode, operator string, depth int) (string, error) {
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("Empty filter group")
+	}
+	expressions := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node == nil {
+			return "", fmt.Errorf("Empty filter node")
+		}
+		expression, err := node.compile(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		expressions = append(expressions, "("+expression+")")
+	}
+	return strings.Join(expressions, operator), nil
+}
+
+func (n *FilterNode) compileComparison() (string, error) {
+	field := filterField{kind: stringField}
+	if n.Field != "" {
+		var ok bool
+		if field, ok = runFilterFields[n.Field]; !ok {
+			return "", fmt.Errorf("Unknown filter field '%s'", n.Field)
+		}
+	} else {
+		if encodeRegex.MatchString(strings.Replace(n.Label, ".", "_", -1)) {
+			return "", fmt.Errorf("Invalid label name '%s'", n.Label)
+		}
+		field.attribute = "metadata.labels." + n.Label
+	}
+	attribute := encodeAttributeName(field.attribute)
+
+	switch n.Op {
+	case "exists":
+		return "exists(" + attribute + ")", nil
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		if field.kind == stringField && strings.ContainsAny(n.Op, "<>") {
+			return "", fmt.Errorf("Operator %s is not supported for '%s'", n.Op, n.Field+n.Label)
+		}
+		value, err := field.literal(n.Value)
+		if err != nil {
+			return "", err
+		}
+		op := n.Op
+		if op == "=" {
+			op = "=="
+		}
+		return attribute + " " + op + " " + value, nil
+	case "contains":
+		if field.kind != stringField {
+			return "", fmt.Errorf("Operator contains is only supported for string fields")
+		}
+		value, err := field.literal(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return "contains(" + attribute + ", " + value + ")", nil
+	case "in", "not in":
+		if len(n.Values) == 0 {
+			return "", fmt.Errorf("Operator %s requires a non empty values list", n.Op)
+		}
+		terms := make([]string, 0, len(n.Values))
+		for _, value := range n.Values {
+			literal, err := field.literal(value)
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, attribute+" == "+literal)
+		}
+		expression := "(" + strings.Join(terms, " OR ") + ")"
+		if n.Op == "not in" {
+			expression = "NOT " + expression
+		}
+		return expression, nil
+	}
+	return "", fmt.Errorf("Unknown filter operator '%s'", n.Op)
+}
+
+func (f filterField) literal(value interface{}) (string, error) {
+	switch f.kind {
+	case intField:
+		if number, ok := value.(float64); ok && number == float64(int64(number)) {
+			return strconv.FormatInt(int64(number), 10), nil
+		}
+		return "", fmt.Errorf("Expected an integer value for %s, got %v", f.attribute, value)
+	case timeField:
+		text, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("Expected a time string for %s, got %v", f.attribute, value)
+		}
+		if t, ok := parseRunTime(text); ok {
+			return strconv.FormatInt(t.UnixNano(), 10), nil
+		}
+		return "", fmt.Errorf("Invalid time '%s', use RFC3339", text)
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("Expected a string value for %s, got %v", f.attribute, value)
+	}
+	if strings.ContainsAny(text, "'\\") {
+		return "", fmt.Errorf("Filter values must not contain quotes or backslashes")
+	}
+	return "'" + text + "'", nil
+}
+
+func queryRunsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	query := runQuery{}
+	if err := json.Unmarshal(ctx.Request.Body(), &query); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if query.Project == "" {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting 'project'"))
+		return
+	}
+	if err := api.ValidateIdentifier("project", query.Project); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	filterStr := ""
+	if query.Filter != nil {
+		var err error
+		if filterStr, err = query.Filter.compile(0); err != nil {
+			api.WriteError(ctx, http.StatusBadRequest, err)
+			return
+		}
+	}
+	clog.printF("queryRunsHandler: Filter string is %s\n", filterStr)
+	listRuns(container, ctx, query.Project, filterStr, query.Sort, query.Last)
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Queue items live in /queue/<name>/<id>, an item can be leased when its lease
+// expired, new items start with an expired (zero) lease
+const (
+	queueEnqueuedAttribute = "enqueued"
+	queueLeaseAttribute    = "lease_id"
+	queueExpiresAttribute  = "lease_expires"
+	queueAttemptsAttribute = "attempts"
+
+	defaultVisibility = 60
+	maxLeaseBatch     = 100
+)
+
+var (
+	hexRegex       = regexp.MustCompile(`^[0-9a-f]+$`)
+	errLeaseLost   = errors.New("Lease expired or was taken by another worker")
+	queueAttrNames = []string{"__name", dataAttributeName, queueEnqueuedAttribute, queueLeaseAttribute, queueExpiresAttribute, queueAttemptsAttribute}
+)
+
+type queueItem struct {
+	ID           string          `json:"id"`
+	State        string          `json:"state"`
+	Lease        string          `json:"lease,omitempty"`
+	LeaseExpires *time.Time      `json:"lease_expires,omitempty"`
+	Attempts     int             `json:"attempts"`
+	Enqueued     time.Time       `json:"enqueued"`
+	Data         json.RawMessage `json:"data"`
+}
+
+func randomID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func queuePath(name interface{}, id string) string {
+	return fmt.Sprintf("/queue/%s/%s", name, id)
+}
+
+// isConditionFailed reports a conditional update that did not match the item,
+// invalid requests (400) are errors
+func isConditionFailed(err error) bool {
+	errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	return ok && errWithStatusCode.StatusCode() == http.StatusPreconditionFailed
+}
+
+func newQueueItem(item v3io.Item, now int64) *queueItem {
+	result := queueItem{}
+	result.ID, _ = item.GetFieldString("__name")
+	result.Lease, _ = item.GetFieldString(queueLeaseAttribute)
+	result.Attempts, _ = item.GetFieldInt(queueAttemptsAttribute)
+	enqueued, _ := item.GetFieldInt(queueEnqueuedAttribute)
+	result.Enqueued = time.Unix(0, int64(enqueued)).UTC()
+	if data, ok := item.GetField(dataAttributeName).([]byte); ok {
+		result.Data, _ = openData(data)
+	}
+	result.State = "pending"
+	if expires, _ := item.GetFieldInt(queueExpiresAttribute); int64(expires) > now {
+		result.State = "leased"
+		leaseExpires := time.Unix(0, int64(expires)).UTC()
+		result.LeaseExpires = &leaseExpires
+	} else {
+		result.Lease = ""
+	}
+	return &result
+}
+
+func listQueueItems(container v3io.Container, name interface{}, filter string) ([]*queueItem, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/queue/%s/", name),
+		AttributeNames: queueAttrNames,
+		Filter:         filter,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cursorItems, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+	items := make([]*queueItem, 0, len(cursorItems))
+	for _, cursorItem := range cursorItems {
+		items = append(items, newQueueItem(cursorItem, now))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Enqueued.Before(items[j].Enqueued) })
+	return items, nil
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		api.WriteError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
+
+func (db *MLRunDB) enqueueHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	name := ctx.UserValue("name")
+	data, err := convertDataToJSON(ctx.Request.Body())
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	id := randomID()
+	err = container.PutItemSync(&v3io.PutItemInput{
+		Path: queuePath(name, id),
+		Attributes: map[string]interface{}{
+			dataAttributeName:      sealData(data),
+			queueEnqueuedAttribute: time.Now().UnixNano(),
+			queueLeaseAttribute:    "none",
+			queueExpiresAttribute:  0,
+			queueAttemptsAttribute: 0,
+		},
+	})
+	if err != nil {
+		clog.printF("enqueueHandler: Failed to store item in queue %s: %s\n", name, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	ctx.Response.SetStatusCode(http.StatusCreated)
+	writeJSON(ctx, map[string]string{"id": id})
+}
+
+func (db *MLRunDB) listQueueHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	items, err := listQueueItems(container, ctx.UserValue("name"), "")
+	if err != nil {
+		clog.printF("listQueueHandler: Failed to list queue: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	for _, item := range items {
+		item.Data = redactBody(ctx, item.Data)
+	}
+	writeJSON(ctx, map[string]interface{}{"items": items})
+}
+
+// leaseHandler claims up to max available items, each claim is a conditional
+// update on the attempts counter so concurrent workers never share an item
+func (db *MLRunDB) leaseHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	name := ctx.UserValue("name")
+	visibility := defaultVisibility
+	if value := ctx.QueryArgs().Peek("visibility"); len(value) > 0 {
+		visibility, _ = strconv.Atoi(string(value))
+	}
+	max := 1
+	if value := ctx.QueryArgs().Peek("max"); len(value) > 0 {
+		max, _ = strconv.Atoi(string(value))
+	}
+	if visibility <= 0 || max <= 0 || max > maxLeaseBatch {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("visibility must be positive and max between 1 and %d", maxLeaseBatch))
+		return
+	}
+
+	now := time.Now().UnixNano()
+	candidates, err := listQueueItems(container, name, fmt.Sprintf("%s < %d", queueExpiresAttribute, now))
+	if err != nil {
+		clog.printF("leaseHandler: Failed to list queue: %s\n", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	leased := []*queueItem{}
+	expires := time.Now().Add(time.Duration(visibility) * time.Second)
+	for _, item := range candidates {
+		if len(leased) == max {
+			break
+		}
+		lease := randomID()
+		err := container.UpdateItemSync(&v3io.UpdateItemInput{
+			Path:      queuePath(name, item.ID),
+			Condition: fmt.Sprintf("%s == %d and %s < %d", queueAttemptsAttribute, item.Attempts, queueExpiresAttribute, now),
+			Attributes: map[string]interface{}{
+				queueLeaseAttribute:    lease,
+				queueExpiresAttribute:  expires.UnixNano(),
+				queueAttemptsAttribute: item.Attempts + 1,
+			},
+		})
+		if err != nil {
+			if isConditionFailed(err) || isNotFound(err) {
+				continue
+			}
+			clog.printF("leaseHandler: Failed to lease %s: %s\n", item.ID, err)
+			if len(leased) == 0 {
+				setStatusFromError(ctx, err)
+				return
+			}
+			break
+		}
+		item.State = "leased"
+		item.Lease = lease
+		item.Attempts++
+		leaseExpires := expires.UTC()
+		item.LeaseExpires = &leaseExpires
+		leased = append(leased, item)
+	}
+	writeJSON(ctx, map[string]interface{}{"items": leased})
+}
+
+// releaseLease updates an item only while the caller still holds its lease
+func releaseLease(container v3io.Container, name interface{}, id, lease string, expires int64) error {
+	if !hexRegex.MatchString(id) || !hexRegex.MatchString(lease) {
+		return errLeaseLost
+	}
+	err := container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       queuePath(name, id),
+		Condition:  fmt.Sprintf("%s == '%s' and %s > %d", queueLeaseAttribute, lease, queueExpiresAttribute, time.Now().UnixNano()),
+		Attributes: map[string]interface{}{queueExpiresAttribute: expires},
+	})
+	if isConditionFailed(err) || isNotFound(err) {
+		return errLeaseLost
+	}
+	return err
+}
+
+func writeLeaseError(ctx *fasthttp.RequestCtx, err error) {
+	if err == errLeaseLost {
+		api.WriteError(ctx, http.StatusConflict, err)
+		return
+	}
+	setStatusFromError(ctx, err)
+}
+
+// ackHandler removes a processed item, the lease is first pushed to the far
+// future so the item cannot be leased again if the delete fails
+func (db *MLRunDB) ackHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	name := ctx.UserValue("name")
+	id := string(ctx.QueryArgs().Peek("id"))
+	if err := releaseLease(container, name, id, string(ctx.QueryArgs().Peek("lease")), math.MaxInt64); err != nil {
+		writeLeaseError(ctx, err)
+		return
+	}
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: queuePath(name, id)})
+	if err != nil {
+		clog.printF("ackHandler: Failed to delete %s: %s\n", id, err)
+	}
+	setStatusFromError(ctx, err)
+}
+
+// nackHandler returns a leased item to the queue immediately
+func (db *MLRunDB) nackHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	name := ctx.UserValue("name")
+	err := releaseLease(container, name, string(ctx.QueryArgs().Peek("id")), string(ctx.QueryArgs().Peek("lease")), 0)
+	writeLeaseError(ctx, err)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"testing"
+)
+
+func TestIsConditionFailed(t *testing.T) {
+	newTestDB(t)
+	path := "/queue/test/item"
+	if err := containers[""].PutItemSync(&v3io.PutItemInput{Path: path, Attributes: map[string]interface{}{"state": "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := containers[""].UpdateItemSync(&v3io.UpdateItemInput{Path: path, Condition: "state == 'leased'",
+		Attributes: map[string]interface{}{"state": "done"}})
+	if !isConditionFailed(err) {
+		t.Errorf("Failed condition reported as %v", err)
+	}
+	err = containers[""].UpdateItemSync(&v3io.UpdateItemInput{Path: path, Condition: "state ==",
+		Attributes: map[string]interface{}{"state": "done"}})
+	if err == nil || isConditionFailed(err) {
+		t.Errorf("Invalid condition reported as %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"sort"
+	"time"
+)
+
+const (
+	// Runs over their project quota wait in the run queue in this state
+	stateQueued = "queued"
+
+	// Run slots reserved more than runSlotTime ago count no more, their runs
+	// are stored by then. Reservations racing on a project are retried up to
+	// runSlotAttempts times
+	runSlotTime     = time.Minute
+	runSlotAttempts = 10
+)
+
+// ProjectQuota limits the runs a project launches, zero values are unlimited
+type ProjectQuota struct {
+	MaxRunningRuns int    `json:"max_running_runs,omitempty"`
+	CPU            string `json:"cpu,omitempty"`
+	Memory         string `json:"memory,omitempty"`
+	GPU            int    `json:"gpu,omitempty"`
+	// OverQuota is what happens to runs submitted over quota, queue (default) or reject
+	OverQuota string `json:"over_quota,omitempty"`
+}
+
+// quotaUsage is the usage of a project against its quota
+type quotaUsage struct {
+	Quota       *ProjectQuota     `json:"quota"`
+	RunningRuns int               `json:"running_runs"`
+	Used        runtime.Resources `json:"used"`
+	Queued      int               `json:"queued"`
+}
+
+// quotaError is returned for runs rejected over quota
+type quotaError struct {
+	project string
+	reason  string
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("Project %s is over quota: %s", e.project, e.reason)
+}
+
+type queuedRun struct {
+	Name     string           `json:"name"`
+	Function *common.Function `json:"function"`
+}
+
+func (q *ProjectQuota) validate() error {
+	if q.MaxRunningRuns < 0 || q.GPU < 0 {
+		return fmt.Errorf("Quota limits must not be negative")
+	}
+	if q.CPU != "" {
+		if _, err := runtime.ParseCPU(q.CPU); err != nil {
+			return fmt.Errorf("Invalid quota cpu '%s'", q.CPU)
+		}
+	}
+	if q.Memory != "" {
+		if _, err := runtime.ParseMemory(q.Memory); err != nil {
+			return fmt.Errorf("Invalid quota memory '%s'", q.Memory)
+		}
+	}
+	if q.OverQuota != "" && q.OverQuota != "queue" && q.OverQuota != "reject" {
+		return fmt.Errorf("Unknown over_quota '%s', use queue or reject", q.OverQuota)
+	}
+	return nil
+}
+
+// exceeds returns why a run requesting resources does not fit in the quota,
+// or an empty string
+func (u *quotaUsage) exceeds(requested runtime.Resources) string {
+	quota := u.Quota
+	if quota.MaxRunningRuns > 0 && u.RunningRuns+1 > quota.MaxRunningRuns {
+		return fmt.Sprintf("%d of %d runs are running", u.RunningRuns, quota.MaxRunningRuns)
+	}
+	if quota.CPU != "" {
+		limit, _ := runtime.ParseCPU(quota.CPU)
+		if u.Used.CPU+requested.CPU > limit {
+			return fmt.Sprintf("%g of %s cpus are used, the run requests %g", u.Used.CPU, quota.CPU, requested.CPU)
+		}
+	}
+	if quota.Memory != "" {
+		limit, _ := runtime.ParseMemory(quota.Memory)
+		if u.Used.Memory+requested.Memory > limit {
+			return fmt.Sprintf("%d of %s memory bytes are used, the run requests %d", u.Used.Memory, quota.Memory, requested.Memory)
+		}
+	}
+	if quota.GPU > 0 && u.Used.GPU+requested.GPU > quota.GPU {
+		return fmt.Sprintf("%d of %d gpus are used, the run requests %d", u.Used.GPU, quota.GPU, requested.GPU)
+	}
+	return ""
+}
+
+// quotaUsage returns the usage of a project with a quota, or nil for projects
+// without one. Running runs are counted from the run records, so just
+// launched runs count before their pods exist, resources from the pods
+func (db *MLRunDB) quotaUsage(container v3io.Container, project string) (*quotaUsage, error) {
+	settings, err := readProjectSettings(container, project)
+	if err != nil || settings.Quota == nil {
+		return nil, err
+	}
+	usage := &quotaUsage{Quota: settings.Quota}
+	stateAttribute := encodeAttributeName("status.state")
+	runs, err := listItems(container, fmt.Sprintf("/run/%s/", project),
+		fmt.Sprintf("%s == '%s' or %s == '%s'", stateAttribute, runtime.StatePending, stateAttribute, runtime.StateRunning))
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	usage.RunningRuns = len(runs)
+	queued, err := listItems(container, fmt.Sprintf("/runqueue/%s/", project), "")
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	usage.Queued = len(queued)
+	if db.k8s != nil {
+		if usage.Used, err = runtime.UsedResources(db.k8s, project); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+func listItems(container v3io.Container, path, filter string, attributes ...string) ([]v3io.Item, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           path,
+		AttributeNames: append([]string{"__name"}, attributes...),
+		Filter:         filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursor.AllSync()
+}
+
+// runSlots are the run slots of a project reserved by runs admitted but not
+// stored yet, which the run records do not count
+type runSlots struct {
+	project   string
+	reserved  int
+	condition string
+}
+
+func runSlotsPath(project string) string {
+	return fmt.Sprintf("/runslots/%s", project)
+}
+
+// readRunSlots reads the run slots of a project, with the condition to update
+// them while unchanged
+func readRunSlots(container v3io.Container, project string) (*runSlots, error) {
+	slots := &runSlots{project: project, condition: "not(exists(reserved))"}
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: runSlotsPath(project),
+		AttributeNames: []string{"reserved", "reserved_at", mtimeAttribute, mtimeNsecsAttribute}})
+	if isNotFound(err) {
+		return slots, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer v3ioResponse.Release()
+	item := v3ioResponse.Output.(*v3io.GetItemOutput).Item
+	if _, err := item.GetFieldInt("reserved"); err == nil {
+		slots.condition = unchangedCondition(item)
+	}
+	reservedAt, _ := item.GetFieldInt("reserved_at")
+	if time.Since(time.Unix(int64(reservedAt), 0)) < runSlotTime {
+		slots.reserved, _ = item.GetFieldInt("reserved")
+	}
+	return slots, nil
+}
+
+// update sets the reserved slots if they are unchanged since read, it fails
+// with a condition failure otherwise
+func (s *runSlots) update(container v3io.Container, reserved int) error {
+	attributes := map[string]interface{}{"reserved": reserved, "reserved_at": int(time.Now().Unix())}
+	err := container.UpdateItemSync(&v3io.UpdateItemInput{Path: runSlotsPath(s.project), Condition: s.condition, Attributes: attributes})
+	if isNotFound(err) {
+		err = container.PutItemSync(&v3io.PutItemInput{Path: runSlotsPath(s.project), Condition: "not(exists(reserved))", Attributes: attributes})
+	}
+	return err
+}
+
+// releaseRunSlot releases a run slot once its run is stored
+func releaseRunSlot(container v3io.Container, project string) {
+	for attempt := 0; attempt < runSlotAttempts; attempt++ {
+		slots, err := readRunSlots(container, project)
+		if err == nil && slots.reserved == 0 {
+			return
+		}
+		if err == nil {
+			if err = slots.update(container, slots.reserved-1); isConditionFailed(err) {
+				continue
+			}
+		}
+		if err != nil {
+			fmt.Printf("Failed to release a run slot of %s: %s\n", project, err)
+		}
+		return
+	}
+}
+
+// admitRun checks a run against its project quota, it returns whether the run
+// should be queued, whether a run slot was reserved for it, to release with
+// releaseRunSlot once the run is stored, or a quotaError when the project
+// rejects runs over quota. Runs queue behind already queued runs, so the queue
+// stays in order. Slots are reserved with a conditional update, so runs
+// admitted concurrently do not take the same slot
+func (db *MLRunDB) admitRun(container v3io.Container, project string, function *common.Function) (bool, bool, error) {
+	requested, err := runtime.FunctionResources(function)
+	if err != nil {
+		return false, false, err
+	}
+	for attempt := 0; attempt < runSlotAttempts; attempt++ {
+		slots, err := readRunSlots(container, project)
+		if err != nil {
+			return false, false, err
+		}
+		usage, err := db.quotaUsage(container, project)
+		if err != nil || usage == nil {
+			return false, false, err
+		}
+		usage.RunningRuns += slots.reserved
+		reason := usage.exceeds(requested)
+		if reason == "" && usage.Queued == 0 {
+			if err = slots.update(container, slots.reserved+1); isConditionFailed(err) {
+				continue
+			}
+			return false, err == nil, err
+		}
+		if usage.Quota.OverQuota == "reject" {
+			if reason == "" {
+				reason = fmt.Sprintf("%d runs are queued", usage.Queued)
+			}
+			return false, false, &quotaError{project: project, reason: reason}
+		}
+		return true, false, nil
+	}
+	return false, false, fmt.Errorf("Failed to reserve a run slot of %s, too many concurrent submissions", project)
+}
+
+func queueRun(container v3io.Container, project, uid, name string, function *common.Function) error {
+	data, err := json.Marshal(&queuedRun{Name: name, Function: function})
+	if err != nil {
+		return err
+	}
+	return container.PutItemSync(&v3io.PutItemInput{
+		Path: fmt.Sprintf("/runqueue/%s/%s", project, uid),
+		Attributes: map[string]interface{}{
+			"created":         time.Now().UnixNano(),
+			dataAttributeName: sealData(data),
+		},
+	})
+}
+
+// dispatchQueuedRuns launches queued runs, in submission order, as their
+// projects get below quota
+func (db *MLRunDB) dispatchQueuedRuns(container v3io.Container) error {
+	if db.launcher == nil {
+		return nil
+	}
+	projects, err := listProjectDirs(container, "/runqueue/")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, project := range projects {
+		if err := db.dispatchProjectQueue(container, project); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (db *MLRunDB) dispatchProjectQueue(container v3io.Container, project string) error {
+	items, err := listItems(container, fmt.Sprintf("/runqueue/%s/", project), "", "created", dataAttributeName)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		created, _ := items[i].GetFieldInt("created")
+		otherCreated, _ := items[j].GetFieldInt("created")
+		return created < otherCreated
+	})
+	usage, err := db.quotaUsage(container, project)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		uid, _ := item.GetFieldString("__name")
+		queued := queuedRun{}
+		body, err := openData(item[dataAttributeName].([]byte))
+		if err == nil {
+			err = json.Unmarshal(body, &queued)
+		}
+		if err != nil || queued.Function == nil {
+			fmt.Printf("Dropping bad queued run %s/%s: %v\n", project, uid, err)
+			deleteQueuedRun(container, project, uid)
+			continue
+		}
+		requested, err := runtime.FunctionResources(queued.Function)
+		if err != nil {
+			return err
+		}
+		// The quota may have been removed or raised since the run was queued.
+		// Slots reserved by runs admitted meanwhile count as running
+		if usage != nil {
+			slots, err := readRunSlots(container, project)
+			if err != nil {
+				return err
+			}
+			usage.RunningRuns += slots.reserved
+			reason := usage.exceeds(requested)
+			usage.RunningRuns -= slots.reserved
+			if reason != "" {
+				return nil
+			}
+			// Runs admitted meanwhile are counted on the next dispatch
+			if err = slots.update(container, slots.reserved+1); err != nil {
+				if isConditionFailed(err) {
+					return nil
+				}
+				return err
+			}
+		}
+		if err := deleteQueuedRun(container, project, uid); err != nil {
+			return err
+		}
+		db.launchQueuedRun(container, project, uid, &queued)
+		if usage != nil {
+			releaseRunSlot(container, project)
+			usage.RunningRuns++
+			usage.Used.Add(requested)
+		}
+	}
+	return nil
+}
+
+// launchQueuedRun launches a run unless it left the queued state (e.g. it was
+// aborted) while queued
+func (db *MLRunDB) launchQueuedRun(container v3io.Container, project, uid string, queued *queuedRun) {
+	data, err := readRunJSON(container, project, uid)
+	if err != nil {
+		if !isNotFound(err) {
+			fmt.Printf("Failed to read queued run %s/%s: %s\n", project, uid, err)
+		}
+		return
+	}
+	condition := fmt.Sprintf("%s == '%s'", encodeAttributeName("status.state"), stateQueued)
+	if err = setRunState(container, project, uid, data, runtime.StatePending, "", condition); err != nil {
+		if !isConditionFailed(err) {
+			fmt.Printf("Failed to update queued run %s/%s: %s\n", project, uid, err)
+		}
+		return
+	}
+	run := &runtime.Run{Namespace: containerNamespace(container), Project: project, UID: uid, Name: queued.Name, Function: queued.Function, Object: data}
+	if _, err = db.launcher.Launch(run); err != nil {
+		fmt.Printf("Failed to launch run %s/%s: %s\n", project, uid, err)
+		if stateErr := setRunState(container, project, uid, data, runtime.StateError, err.Error(), ""); stateErr != nil {
+			fmt.Printf("Failed to update run %s/%s: %s\n", project, uid, stateErr)
+		}
+	}
+}
+
+func deleteQueuedRun(container v3io.Container, project, uid string) error {
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: fmt.Sprintf("/runqueue/%s/%s", project, uid)})
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// quotaStats returns the quota usage of the projects that have a quota
+func (db *MLRunDB) quotaStats(container v3io.Container) map[string]*quotaUsage {
+	projects, err := listProjectDirs(container, "/project/")
+	if err != nil {
+		clog.printF("quotaStats: Failed to list projects: %s\n", err)
+		return nil
+	}
+	stats := map[string]*quotaUsage{}
+	for _, project := range projects {
+		usage, err := db.quotaUsage(container, project)
+		if err != nil {
+			clog.printF("quotaStats: Failed to compute the quota usage of %s: %s\n", project, err)
+			continue
+		}
+		if usage != nil {
+			stats[project] = usage
+		}
+	}
+	return stats
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/mlrun/controller/pkg/common"
+	"sync"
+	"testing"
+)
+
+func TestAdmitRun(t *testing.T) {
+	mldb := newTestDB(t)
+	mustRequest(t, mldb, "PUT", "/project/p1/settings", `{"quota":{"max_running_runs":3}}`, 200)
+
+	// Concurrent submissions take the free slots once
+	var lock sync.Mutex
+	admitted, queued := 0, 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			isQueued, reserved, err := mldb.admitRun(containers[""], "p1", &common.Function{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if reserved {
+				admitted++
+			}
+			if isQueued {
+				queued++
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted != 3 || queued != 7 {
+		t.Errorf("Admitted %d and queued %d runs, expected 3 and 7", admitted, queued)
+	}
+
+	// Released slots are counted by the stored runs
+	releaseRunSlot(containers[""], "p1")
+	mustRequest(t, mldb, "POST", "/run/p1/u1", `{"metadata":{"name":"run1"},"status":{"state":"running"}}`, 200)
+	if _, reserved, err := mldb.admitRun(containers[""], "p1", &common.Function{}); err != nil || reserved {
+		t.Errorf("Admitted a run over quota (%v)", err)
+	}
+	if slots, err := readRunSlots(containers[""], "p1"); err != nil || slots.reserved != 2 {
+		t.Errorf("%v slots reserved (%v), expected 2", slots, err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/valyala/fasthttp"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const redactedValue = "***"
+
+var defaultRedactPatterns = []string{"password", "secret", "token", "access_?key"}
+
+// redactor masks values whose attribute name matches one of its patterns,
+// including k8s style {"name": "DB_PASSWORD", "value": "..."} env entries.
+// Patterns match whole name segments, split by non alphanumeric characters,
+// so secret_key and DB_PASSWORD match but max_tokens and tokenizer do not
+type redactor struct {
+	regex *regexp.Regexp
+	// quick finds bodies that may have sensitive names
+	quick *regexp.Regexp
+}
+
+var redaction atomic.Value
+
+func init() {
+	redaction.Store((*redactor)(nil))
+}
+
+func newRedactor(patterns []string) (*redactor, error) {
+	names := "(?:" + strings.Join(patterns, "|") + ")"
+	regex, err := regexp.Compile("(?i)(?:^|[^a-z0-9])" + names + "(?:$|[^a-z0-9])")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redaction pattern: %s", err)
+	}
+	return &redactor{regex: regex, quick: regexp.MustCompile("(?i)" + names)}, nil
+}
+
+// SetRedaction sets the (case insensitive) patterns of sensitive attribute
+// names, an empty list restores the defaults. Redaction is disabled until set
+func (db *MLRunDB) SetRedaction(enabled bool, patterns []string) error {
+	if !enabled {
+		redaction.Store((*redactor)(nil))
+		return nil
+	}
+	if len(patterns) == 0 {
+		patterns = defaultRedactPatterns
+	}
+	r, err := newRedactor(patterns)
+	if err != nil {
+		return err
+	}
+	redaction.Store(r)
+	return nil
+}
+
+// requestRedactor returns nil when the response should not be redacted
+func requestRedactor(ctx *fasthttp.RequestCtx) *redactor {
+	if api.IsAdmin(ctx) {
+		return nil
+	}
+	return redaction.Load().(*redactor)
+}
+
+func (r *redactor) redactValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if name, ok := typed["name"].(string); ok && r.regex.MatchString(name) {
+			if _, ok := typed["value"]; ok {
+				typed["value"] = redactedValue
+			}
+		}
+		for key, item := range typed {
+			if r.regex.MatchString(key) && isScalar(item) {
+				typed[key] = redactedValue
+			} else {
+				typed[key] = r.redactValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = r.redactValue(item)
+		}
+	}
+	return value
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return false
+	}
+	return true
+}
+
+// redactBody masks sensitive values of a stored JSON/YAML object, bodies that
+// can not be parsed are returned as is
+func redactBody(ctx *fasthttp.RequestCtx, body []byte) []byte {
+	return requestRedactor(ctx).redactBody(body)
+}
+
+// redactBody masks the body with the redactor, a nil redactor (admins) returns
+// the body as is
+func (r *redactor) redactBody(body []byte) []byte {
+	if r == nil || !r.quick.Match(body) {
+		return body
+	}
+	JSONBody, err := convertDataToJSON(body)
+	if err != nil {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(JSONBody))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+	redacted, err := json.Marshal(r.redactValue(value))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := newRedactor(defaultRedactPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		redacted bool
+	}{
+		{"password", true},
+		{"DB_PASSWORD", true},
+		{"aws-secret-access-key", true},
+		{"secret_key", true},
+		{"accessKey", true},
+		{"v3io.token", true},
+		{"max_tokens", false},
+		{"tokenizer", false},
+		{"secretary", false},
+		{"passwords_policy", false},
+	} {
+		if redacted := r.regex.MatchString(test.name); redacted != test.redacted {
+			t.Errorf("%s: redacted %v, expected %v", test.name, redacted, test.redacted)
+		}
+	}
+
+	body := r.redactBody([]byte(`{"password":"p","max_tokens":10,"env":[{"name":"DB_PASSWORD","value":"p"},{"name":"TOKENIZER","value":"bpe"}]}`))
+	var redacted struct {
+		Password  string
+		MaxTokens int `json:"max_tokens"`
+		Env       []struct{ Name, Value string }
+	}
+	if err := json.Unmarshal(body, &redacted); err != nil {
+		t.Fatal(err)
+	}
+	if redacted.Password != redactedValue || redacted.MaxTokens != 10 ||
+		redacted.Env[0].Value != redactedValue || redacted.Env[1].Value != "bpe" {
+		t.Errorf("Unexpected redaction %s", body)
+	}
+	var none *redactor
+	if body := none.redactBody([]byte(`{"password":"p"}`)); string(body) != `{"password":"p"}` {
+		t.Errorf("Disabled redaction returned %s", body)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"errors"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const retryBackoff = 100 * time.Millisecond
+
+var errCircuitOpen = v3ioerrors.NewErrorWithStatusCode(errors.New("v3io is unavailable, circuit breaker is open"), http.StatusServiceUnavailable)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker opens after threshold consecutive backend failures and fails
+// calls fast until cooldown passes, then lets a single trial call through
+type circuitBreaker struct {
+	lock      sync.Mutex
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// A trial call is already in flight
+		return false
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !isBackendFailure(err) {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) status() (circuitState, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state, b.failures
+}
+
+// isBackendFailure reports whether err means v3io itself is failing (transport
+// errors and 5xx responses) rather than the request being wrong
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	errWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	if !ok {
+		return true
+	}
+	return errWithStatusCode.StatusCode() >= http.StatusInternalServerError
+}
+
+// resilientContainer retries idempotent calls on backend failures and guards
+// all calls with a circuit breaker
+type resilientContainer struct {
+	v3io.Container
+	retries int
+	breaker *circuitBreaker
+}
+
+func newResilientContainer(config *DBConfig, inner v3io.Container) *resilientContainer {
+	return &resilientContainer{
+		Container: inner,
+		retries:   config.Retries,
+		breaker:   &circuitBreaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown},
+	}
+}
+
+func (c *resilientContainer) call(idempotent bool, call func() error) error {
+	attempts := 1
+	if idempotent {
+		attempts += c.retries
+	}
+	var err error
+	backoff := retryBackoff
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if !c.breaker.allow() {
+			return errCircuitOpen
+		}
+		err = call()
+		c.breaker.record(err)
+		if !isBackendFailure(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *resilientContainer) GetItemSync(input *v3io.GetItemInput) (response *v3io.Response, err error) {
+	err = c.call(true, func() (callErr error) {
+		response, callErr = c.Container.GetItemSync(input)
+		return
+	})
+	return
+}
+
+func (c *resilientContainer) GetItemsSync(input *v3io.GetItemsInput) (response *v3io.Response, err error) {
+	err = c.call(true, func() (callErr error) {
+		response, callErr = c.Container.GetItemsSync(input)
+		return
+	})
+	return
+}
+
+func (c *resilientContainer) PutItemSync(input *v3io.PutItemInput) error {
+	return c.call(input.Condition == "", func() error {
+		return c.Container.PutItemSync(input)
+	})
+}
+
+func (c *resilientContainer) UpdateItemSync(input *v3io.UpdateItemInput) error {
+	// Expressions and conditions may depend on the current value, so they are not retried
+	return c.call(input.Expression == nil && input.Condition == "", func() error {
+		return c.Container.UpdateItemSync(input)
+	})
+}
+
+func (c *resilientContainer) GetObjectSync(input *v3io.GetObjectInput) (response *v3io.Response, err error) {
+	err = c.call(true, func() (callErr error) {
+		response, callErr = c.Container.GetObjectSync(input)
+		return
+	})
+	return
+}
+
+func (c *resilientContainer) PutObjectSync(input *v3io.PutObjectInput) error {
+	return c.call(!input.Append, func() error {
+		return c.Container.PutObjectSync(input)
+	})
+}
+
+func (c *resilientContainer) DeleteObjectSync(input *v3io.DeleteObjectInput) error {
+	return c.call(true, func() error {
+		return c.Container.DeleteObjectSync(input)
+	})
+}
+
+func (c *resilientContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (response *v3io.Response, err error) {
+	err = c.call(true, func() (callErr error) {
+		response, callErr = c.Container.GetContainerContentsSync(input)
+		return
+	})
+	return
+}
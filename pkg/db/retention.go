@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const retentionInterval = time.Hour
+
+// SetRunRetention sets the age (by last update) after which runs are deleted, zero keeps runs forever
+func (db *MLRunDB) SetRunRetention(retention time.Duration) {
+	atomic.StoreInt64(&db.runRetention, int64(retention))
+}
+
+func (db *MLRunDB) StartRetention() {
+	go func() {
+		for {
+			time.Sleep(retentionInterval)
+			for _, container := range namespaceContainers() {
+				if err := db.deleteExpiredRuns(container, time.Duration(atomic.LoadInt64(&db.runRetention))); err != nil {
+					fmt.Printf("Failed to apply run retention: %s\n", err)
+				}
+				if err := cleanOrphanLatest(container); err != nil {
+					fmt.Printf("Failed to clean orphan latest artifacts: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// deleteExpiredRuns applies the project run retention, or the global one for
+// projects that do not set it
+func (db *MLRunDB) deleteExpiredRuns(container v3io.Container, globalRetention time.Duration) error {
+	projects, err := listProjectDirs(container, "/run/")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, project := range projects {
+		retention := globalRetention
+		if settings, err := db.GetProjectSettings(container, project); err != nil {
+			lastErr = err
+		} else if settings.RunRetention != "" {
+			retention = settings.runRetention()
+		}
+		if retention <= 0 {
+			continue
+		}
+		before := time.Now().Add(-retention)
+		filter := encodeAttributeName("status.lasttimeEpoch") + " < " + strconv.FormatInt(before.UnixNano(), 10)
+		clog.printF("Deleting runs of project %s last updated before %s\n", project, before)
+		if err := deleteRunItems(container, project, filter, cascadeLogs); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// listProjectDirs returns the project directories under a kind directory such as /run/
+func listProjectDirs(container v3io.Container, path string) ([]string, error) {
+	var projects []string
+	input := v3io.GetContainerContentsInput{Path: path, DirectoriesOnly: true}
+	for {
+		v3ioResponse, err := container.GetContainerContentsSync(&input)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		output := v3ioResponse.Output.(*v3io.GetContainerContentsOutput)
+		for _, prefix := range output.CommonPrefixes {
+			segments := strings.Split(strings.Trim(prefix.Prefix, "/"), "/")
+			projects = append(projects, segments[len(segments)-1])
+		}
+		v3ioResponse.Release()
+		if !output.IsTruncated || output.NextMarker == "" {
+			return projects, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
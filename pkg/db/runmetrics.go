@@ -0,0 +1,228 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Project run indexes are read again after this long
+	runIndexTTL = time.Minute
+	// Indexed parameters or results per project
+	maxRunIndexNames = 32
+)
+
+var (
+	runIndexNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+	runMetricFilterRegex = regexp.MustCompile(`^(param|result)\.([a-zA-Z0-9_.\-]+?)\s*(<=|>=|==|!=|=|<|>)\s*(\S+)$`)
+)
+
+// RunIndex lists the numeric run parameters (spec.parameters) and results
+// (status.results) indexed when runs are stored, so runs can be filtered by
+// them, e.g. param.lr<0.01 or result.accuracy>=0.9. Runs stored before a name
+// is added are not indexed by it
+type RunIndex struct {
+	Parameters []string `json:"parameters,omitempty"`
+	Results    []string `json:"results,omitempty"`
+}
+
+func (i *RunIndex) validate() error {
+	for kind, names := range map[string][]string{"parameters": i.Parameters, "results": i.Results} {
+		if len(names) > maxRunIndexNames {
+			return fmt.Errorf("At most %d indexed run %s are allowed", maxRunIndexNames, kind)
+		}
+		for _, name := range names {
+			if !runIndexNameRegex.MatchString(name) {
+				return fmt.Errorf("Invalid indexed run %s name '%s'", kind, name)
+			}
+		}
+	}
+	return nil
+}
+
+// The attributes of the indexed parameters and results, as those of the
+// other run fields
+func runParameterAttribute(name string) string {
+	return encodeAttributeName("spec.parameters." + name)
+}
+
+func runResultAttribute(name string) string {
+	return encodeAttributeName("status.results." + name)
+}
+
+type projectRunIndex struct {
+	parameters, results map[string]bool
+	read                time.Time
+}
+
+func (i *projectRunIndex) empty() bool {
+	return len(i.parameters) == 0 && len(i.results) == 0
+}
+
+type runIndexCache struct {
+	lock     sync.Mutex
+	projects map[string]*projectRunIndex
+}
+
+// get returns the run index of a project, from its settings
+func (c *runIndexCache) get(container v3io.Container, project string) (*projectRunIndex, error) {
+	c.lock.Lock()
+	index, ok := c.projects[project]
+	c.lock.Unlock()
+	if ok && time.Since(index.read) < runIndexTTL {
+		return index, nil
+	}
+	settings, err := readProjectSettings(container, project)
+	if err != nil {
+		return nil, err
+	}
+	index = &projectRunIndex{parameters: map[string]bool{}, results: map[string]bool{}, read: time.Now()}
+	if settings.RunIndex != nil {
+		for _, name := range settings.RunIndex.Parameters {
+			index.parameters[name] = true
+		}
+		for _, name := range settings.RunIndex.Results {
+			index.results[name] = true
+		}
+	}
+	c.lock.Lock()
+	c.projects[project] = index
+	c.lock.Unlock()
+	return index, nil
+}
+
+// forget drops the cached index of a project, e.g. when its settings change
+func (c *runIndexCache) forget(project string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.projects, project)
+}
+
+// indexRunMetrics sets the attributes of the numeric parameters and results
+// of a run (in JSON form) indexed by its project. Integers are indexed as
+// integers, other numbers as floats, values of other types are not indexed
+func indexRunMetrics(container v3io.Container, project string, JSONData []byte, attributes map[string]interface{}) error {
+	index, err := stateOf(container).runIndexes.get(container, project)
+	if err != nil || index.empty() {
+		return err
+	}
+	run := struct {
+		Spec struct {
+			Parameters map[string]interface{}
+		}
+		Status struct {
+			Results map[string]interface{}
+		}
+	}{}
+	decoder := json.NewDecoder(bytes.NewReader(JSONData))
+	decoder.UseNumber()
+	if err = decoder.Decode(&run); err != nil {
+		return err
+	}
+	for name, value := range run.Spec.Parameters {
+		if index.parameters[name] {
+			setNumericAttribute(attributes, runParameterAttribute(name), value)
+		}
+	}
+	for name, value := range run.Status.Results {
+		if index.results[name] {
+			setNumericAttribute(attributes, runResultAttribute(name), value)
+		}
+	}
+	return nil
+}
+
+func setNumericAttribute(attributes map[string]interface{}, attribute string, value interface{}) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return
+	}
+	if intValue, err := number.Int64(); err == nil {
+		attributes[attribute] = intValue
+	} else if floatValue, err := number.Float64(); err == nil {
+		attributes[attribute] = floatValue
+	}
+}
+
+// runMetricFilters turns numeric parameter and result filters, such as
+// param.lr<0.01 or result.accuracy>=0.9, into a filter expression. Only the
+// names indexed by the project can be filtered
+func runMetricFilters(container v3io.Container, project string, filters []string) (string, error) {
+	if len(filters) == 0 {
+		return "", nil
+	}
+	index, err := stateOf(container).runIndexes.get(container, project)
+	if err != nil {
+		return "", err
+	}
+	var expressions []string
+	for _, filter := range filters {
+		match := runMetricFilterRegex.FindStringSubmatch(strings.TrimSpace(filter))
+		if match == nil {
+			return "", badFilter("Invalid filter '%s', expecting param.<name> or result.<name>, an operator and a number", filter)
+		}
+		kind, name, op, value := match[1], match[2], match[3], match[4]
+		attribute := runParameterAttribute(name)
+		indexed := index.parameters[name]
+		if kind == "result" {
+			attribute, indexed = runResultAttribute(name), index.results[name]
+		}
+		if !indexed {
+			return "", badFilter("Run %s %s is not indexed by project %s", map[string]string{"param": "parameter", "result": "result"}[kind], name, project)
+		}
+		number, err := formatFilterNumber(value)
+		if err != nil {
+			return "", badFilter("Invalid number '%s' in filter '%s'", value, filter)
+		}
+		if op == "=" {
+			op = "=="
+		}
+		expressions = append(expressions, attribute+" "+op+" "+number)
+	}
+	return joinFilters(expressions...), nil
+}
+
+func badFilter(format string, args ...interface{}) error {
+	return v3ioerrors.NewErrorWithStatusCode(fmt.Errorf(format, args...), http.StatusBadRequest)
+}
+
+// formatFilterNumber returns the number in a form the filter expressions
+// accept, without exponent
+func formatFilterNumber(value string) (string, error) {
+	if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(intValue, 10), nil
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(floatValue, 'f', -1, 64), nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/defaults"
+	"github.com/mlrun/controller/pkg/events"
+	"github.com/tidwall/gjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Run notes are appended to the notes of the run body, and kept in the notes
+// attribute (in JSON form) so runs with notes can be listed and the notes
+// survive the run being stored again
+const notesAttribute = "notes"
+
+const maxNoteLength = 64 * 1024
+
+// runNotesFilter matches the runs with notes
+func runNotesFilter() string {
+	return "exists(" + notesAttribute + ")"
+}
+
+// addRunNoteHandler appends a note ({"text": ..., "author": ...}) to a run and
+// returns the run notes
+func addRunNoteHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := ctx.UserValue("project")
+	uid := ctx.UserValue("uid")
+	note := common.RunNote{}
+	if err := json.Unmarshal(ctx.Request.Body(), &note); err != nil || strings.TrimSpace(note.Text) == "" {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON body with a note text"))
+		return
+	}
+	if len(note.Text) > maxNoteLength {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Notes are limited to %d bytes", maxNoteLength))
+		return
+	}
+	note.Created = time.Now().UTC().Format(defaults.TimeLayout)
+	update, err := json.Marshal(map[string]interface{}{"notes.-1": note})
+	if err != nil {
+		api.WriteError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	path := fmt.Sprintf("/run/%s/%s", project, uid)
+	_, newJSONBody, status, err := patchMetadataObject(container, path, update, &runMetadataEnvelope{}, nil)
+	if err != nil {
+		clog.printF("addRunNoteHandler: %s\n", err)
+		ctx.Response.SetStatusCode(status)
+		return
+	}
+	notes := gjson.GetBytes(newJSONBody, "notes").Raw
+	err = container.UpdateItemSync(&v3io.UpdateItemInput{Path: path, Attributes: map[string]interface{}{notesAttribute: notes}})
+	if err != nil {
+		clog.printF("addRunNoteHandler: Failed to index the notes of %s: %s\n", path, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	publishRunEvent(container, events.RunUpdated, project, uid, newJSONBody)
+	writeJSON(ctx, map[string]interface{}{"notes": json.RawMessage(notes)})
+}
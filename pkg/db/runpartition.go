@@ -0,0 +1,131 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"sort"
+)
+
+// Runs per partition of a partitioned runs list
+const maxRowsPerPartition = 100
+
+type partitionedRun struct {
+	name string
+	time int
+	data []byte
+}
+
+// runPartitionRows parses the partition-by and rows-per-partition parameters
+// of the runs list, zero rows when the runs are not partitioned
+func runPartitionRows(ctx *fasthttp.RequestCtx) (int, error) {
+	partitionBy := string(ctx.QueryArgs().Peek("partition-by"))
+	if partitionBy == "" {
+		return 0, nil
+	}
+	if partitionBy != "name" {
+		return 0, fmt.Errorf("Runs can only be partitioned by name, not '%s'", partitionBy)
+	}
+	if !ctx.QueryArgs().Has("rows-per-partition") {
+		return 1, nil
+	}
+	rows := ctx.QueryArgs().GetUintOrZero("rows-per-partition")
+	if rows < 1 || rows > maxRowsPerPartition {
+		return 0, fmt.Errorf("The rows-per-partition must be between 1 and %d", maxRowsPerPartition)
+	}
+	return rows, nil
+}
+
+// listPartitionedRuns writes the newest runs of each run name, by last update
+// or else start time, up to rows per name. The names are in order and their
+// runs newest first, only rows runs per name are kept while reading
+func listPartitionedRuns(container v3io.Container, ctx *fasthttp.RequestCtx, project, filterStr string, rows int) {
+	nameAttribute := encodeAttributeName("metadata.name")
+	lastTimeAttribute := encodeAttributeName("status.lasttimeEpoch")
+	startTimeAttribute := encodeAttributeName("status.starttimeEpoch")
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/run/%s/", project),
+		AttributeNames: []string{dataAttributeName, nameAttribute, lastTimeAttribute, startTimeAttribute},
+		Filter:         filterStr,
+	})
+	if err != nil && !isNotFound(err) {
+		clog.printF("listPartitionedRuns: Failed to call NewItemsCursor : %s", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+
+	partitions := map[string][]partitionedRun{}
+	for cursor != nil {
+		item, err := cursor.NextItemSync()
+		if err != nil {
+			clog.printF("listPartitionedRuns: Failed to read runs : %s", err)
+			setStatusFromError(ctx, err)
+			return
+		}
+		if item == nil {
+			break
+		}
+		run := partitionedRun{}
+		run.name, _ = item.GetFieldString(nameAttribute)
+		if run.time, err = item.GetFieldInt(lastTimeAttribute); err != nil {
+			run.time, _ = item.GetFieldInt(startTimeAttribute)
+		}
+		partition := partitions[run.name]
+		if len(partition) == rows && run.time <= partition[rows-1].time {
+			continue
+		}
+		if run.data, err = openData(item.GetField(dataAttributeName).([]byte)); err != nil {
+			clog.printF("listPartitionedRuns: %s\n", err)
+			ctx.Response.SetStatusCode(http.StatusInternalServerError)
+			return
+		}
+		// Insert in time order, dropping the oldest beyond rows
+		position := sort.Search(len(partition), func(i int) bool { return partition[i].time < run.time })
+		partition = append(partition, partitionedRun{})
+		copy(partition[position+1:], partition[position:])
+		partition[position] = run
+		if len(partition) > rows {
+			partition = partition[:rows]
+		}
+		partitions[run.name] = partition
+	}
+
+	names := make([]string, 0, len(partitions))
+	for name := range partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var runs []partitionedRun
+	for _, name := range names {
+		runs = append(runs, partitions[name]...)
+	}
+	redactor := requestRedactor(ctx)
+	writeRuns(ctx, func() ([]byte, error) {
+		if len(runs) == 0 {
+			return nil, nil
+		}
+		run := redactor.redactBody(runs[0].data)
+		runs = runs[1:]
+		return run, nil
+	})
+}
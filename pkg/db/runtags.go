@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Run tags mark runs across experiments (e.g. baseline), a tagged run has a
+// true tags.<tag> attribute and the tags used in a project are kept as
+// /runtags/<project>/<tag>
+var runTagRegex = regexp.MustCompile(`^[a-zA-Z0-9][-_a-zA-Z0-9]{0,62}$`)
+
+func runTagAttribute(tag string) string {
+	return encodeAttributeName("tags." + tag)
+}
+
+// runTagFilter matches the runs with the tag
+func runTagFilter(tag string) string {
+	return runTagAttribute(tag) + " == true"
+}
+
+func tagRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	setRunTag(container, ctx, true)
+}
+
+func untagRunHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	setRunTag(container, ctx, false)
+}
+
+func setRunTag(container v3io.Container, ctx *fasthttp.RequestCtx, tagged bool) {
+	requestHandlerPrint(ctx)
+	project := ctx.UserValue("project")
+	uid := ctx.UserValue("uid")
+	tag := fmt.Sprint(ctx.UserValue("tag"))
+	if !runTagRegex.MatchString(tag) {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Bad run tag '%s', expecting letters, digits, - and _", tag))
+		return
+	}
+	err := container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       fmt.Sprintf("/run/%s/%s", project, uid),
+		Condition:  "exists(" + dataAttributeName + ")",
+		Attributes: map[string]interface{}{runTagAttribute(tag): tagged},
+	})
+	if isConditionFailed(err) {
+		ctx.Response.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	if err == nil && tagged {
+		err = container.UpdateItemSync(&v3io.UpdateItemInput{
+			Path:       fmt.Sprintf("/runtags/%s/%s", project, tag),
+			Attributes: map[string]interface{}{"tag": tag, "updated": time.Now().UnixNano()},
+		})
+	}
+	if err != nil {
+		clog.printF("setRunTag: Failed to tag %s/%s: %s\n", project, uid, err)
+	}
+	setStatusFromError(ctx, err)
+}
+
+// listRunTagsHandler returns the tags used in a project
+func listRunTagsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	tags := []string{}
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/runtags/%s/", ctx.UserValue("project")),
+		AttributeNames: []string{"tag"},
+	})
+	if err == nil {
+		var items []v3io.Item
+		if items, err = cursor.AllSync(); err == nil {
+			for _, item := range items {
+				if tag, _ := item.GetFieldString("tag"); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	if err != nil && !isNotFound(err) {
+		setStatusFromError(ctx, err)
+		return
+	}
+	sort.Strings(tags)
+	writeJSON(ctx, map[string]interface{}{"tags": tags})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+// Runs started by another run (e.g. the steps of a workflow) have the uid of
+// their parent as metadata.parent_uid, and the workflow id as
+// metadata.workflow or, as set by the SDK, the workflow label
+const (
+	// Parent uids per children query
+	runTreeBatch = 32
+	// Levels of a run tree returned
+	maxRunTreeDepth = 16
+)
+
+var (
+	runParentAttribute   = encodeAttributeName("metadata.parentuid")
+	runWorkflowAttribute = encodeAttributeName("metadata.workflow")
+)
+
+// runWorkflowFilter matches the runs of a workflow
+func runWorkflowFilter(workflow string) (string, error) {
+	literal, err := filterField{attribute: runWorkflowAttribute, kind: stringField}.literal(workflow)
+	if err != nil {
+		return "", err
+	}
+	return "(" + runWorkflowAttribute + " == " + literal + " OR " +
+		encodeAttributeName("metadata.labels.workflow") + " == " + literal + ")", nil
+}
+
+// runParentsFilter matches the children of any of the parent uids
+func runParentsFilter(parents []string) (string, error) {
+	var expressions []string
+	for _, parent := range parents {
+		literal, err := filterField{attribute: runParentAttribute, kind: stringField}.literal(parent)
+		if err != nil {
+			return "", err
+		}
+		expressions = append(expressions, runParentAttribute+" == "+literal)
+	}
+	return "(" + strings.Join(expressions, " OR ") + ")", nil
+}
+
+type childRun struct {
+	uid  string
+	data []byte
+}
+
+// readRunChildren returns the runs of a project with one of the parents
+func readRunChildren(container v3io.Container, project string, parents []string) ([]childRun, error) {
+	filter, err := runParentsFilter(parents)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/run/%s/", project),
+		AttributeNames: []string{dataAttributeName, encodeAttributeName("metadata.uid")},
+		Filter:         filter,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+	children := make([]childRun, 0, len(items))
+	for _, item := range items {
+		uid, _ := item.GetFieldString(encodeAttributeName("metadata.uid"))
+		data, err := openData(item.GetField(dataAttributeName).([]byte))
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, childRun{uid: uid, data: data})
+	}
+	return children, nil
+}
+
+// runChildrenHandler lists the runs started by a run, with recursive=true
+// the runs they started too, down to maxRunTreeDepth levels. Each run has its
+// parent_uid so the tree can be rebuilt
+func runChildrenHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	project := fmt.Sprint(ctx.UserValue("project"))
+	uid := fmt.Sprint(ctx.UserValue("uid"))
+	if !api.QueryBool(ctx, "recursive", false) {
+		filter, err := runParentsFilter([]string{uid})
+		if err != nil {
+			setStatusFromError(ctx, err)
+			return
+		}
+		listRuns(container, ctx, project, filter, false, 0)
+		return
+	}
+
+	var runs [][]byte
+	seen := map[string]bool{uid: true}
+	parents := []string{uid}
+	for depth := 0; depth < maxRunTreeDepth && len(parents) > 0; depth++ {
+		var children []string
+		for start := 0; start < len(parents); start += runTreeBatch {
+			end := start + runTreeBatch
+			if end > len(parents) {
+				end = len(parents)
+			}
+			found, err := readRunChildren(container, project, parents[start:end])
+			if err != nil {
+				clog.printF("runChildrenHandler: Failed to read the children of %s: %s\n", uid, err)
+				setStatusFromError(ctx, err)
+				return
+			}
+			for _, child := range found {
+				// Hyper-param iterations share the uid of their parent
+				if !seen[child.uid] {
+					seen[child.uid] = true
+					children = append(children, child.uid)
+				}
+				runs = append(runs, child.data)
+			}
+		}
+		parents = children
+	}
+	redactor := requestRedactor(ctx)
+	writeRuns(ctx, func() ([]byte, error) {
+		if len(runs) == 0 {
+			return nil, nil
+		}
+		run := redactor.redactBody(runs[0])
+		runs = runs[1:]
+		return run, nil
+	})
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/schedule"
+	"github.com/tidwall/sjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"time"
+)
+
+const (
+	schedulerInterval   = 10 * time.Second
+	schedulerLeaseTime  = 30 * time.Second
+	schedulerLeaderPath = "/schedules/.leader"
+	nextRunAttribute    = "next_run"
+)
+
+// Schedule runs a task of a function on a cron expression, the runs carry a
+// schedule=<name> label
+type Schedule struct {
+	Name      string          `json:"name"`
+	Project   string          `json:"project"`
+	Cron      string          `json:"cron"`
+	Disabled  bool            `json:"disabled,omitempty"`
+	Task      json.RawMessage `json:"task,omitempty"`
+	Function  json.RawMessage `json:"function"`
+	NextRun   string          `json:"next_run,omitempty"`
+	LastRun   string          `json:"last_run,omitempty"`
+	LastUID   string          `json:"last_uid,omitempty"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+func schedulePath(project, name interface{}) string {
+	return fmt.Sprintf("/schedules/%s/%s", project, name)
+}
+
+func formatScheduleTime(nanos int) string {
+	if nanos == 0 {
+		return ""
+	}
+	return time.Unix(0, int64(nanos)).UTC().Format(time.RFC3339)
+}
+
+func storeScheduleHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project := fmt.Sprint(ctx.UserValue("project"))
+	name := fmt.Sprint(ctx.UserValue("name"))
+	scheduleObject := Schedule{}
+	if err := json.Unmarshal(ctx.Request.Body(), &scheduleObject); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if len(scheduleObject.Function) == 0 || json.Unmarshal(scheduleObject.Function, &common.Function{}) != nil {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Schedule needs a function object"))
+		return
+	}
+	cron, err := schedule.Parse(scheduleObject.Cron)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	nextRun := cron.Next(time.Now())
+	if nextRun.IsZero() {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Cron expression %q never matches", scheduleObject.Cron))
+		return
+	}
+	scheduleObject.Name = name
+	scheduleObject.Project = project
+	scheduleObject.NextRun, scheduleObject.LastRun, scheduleObject.LastUID, scheduleObject.LastError = "", "", "", ""
+	data, err := json.Marshal(scheduleObject)
+	if err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	clog.printF("storeScheduleHandler : Project %s name %s cron %s\n", project, name, scheduleObject.Cron)
+	err = container.PutItemSync(&v3io.PutItemInput{
+		Path: schedulePath(project, name),
+		Attributes: map[string]interface{}{
+			"name":            name,
+			"project":         project,
+			"cron":            scheduleObject.Cron,
+			"enabled":         !scheduleObject.Disabled,
+			nextRunAttribute:  int(nextRun.UnixNano()),
+			"updated":         time.Now().UnixNano(),
+			dataAttributeName: sealData(data),
+		},
+	})
+	if err != nil {
+		clog.printF("storeScheduleHandler: Failed to store %s/%s: %s\n", project, name, err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	scheduleObject.NextRun = nextRun.Format(time.RFC3339)
+	writeJSON(ctx, &scheduleObject)
+}
+
+var scheduleAttributes = []string{dataAttributeName, nextRunAttribute, "last_run", "last_uid", "last_error"}
+
+func scheduleFromItem(item v3io.Item) (*Schedule, error) {
+	data, err := openData(item.GetField(dataAttributeName).([]byte))
+	if err != nil {
+		return nil, err
+	}
+	scheduleObject := Schedule{}
+	if err = json.Unmarshal(data, &scheduleObject); err != nil {
+		return nil, err
+	}
+	nextRun, _ := item.GetFieldInt(nextRunAttribute)
+	lastRun, _ := item.GetFieldInt("last_run")
+	scheduleObject.NextRun = formatScheduleTime(nextRun)
+	scheduleObject.LastRun = formatScheduleTime(lastRun)
+	scheduleObject.LastUID, _ = item.GetFieldString("last_uid")
+	scheduleObject.LastError, _ = item.GetFieldString("last_error")
+	return &scheduleObject, nil
+}
+
+func getScheduleHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           schedulePath(ctx.UserValue("project"), ctx.UserValue("name")),
+		AttributeNames: scheduleAttributes,
+	})
+	if err != nil {
+		setStatusFromError(ctx, err)
+		return
+	}
+	defer v3ioResponse.Release()
+	scheduleObject, err := scheduleFromItem(v3ioResponse.Output.(*v3io.GetItemOutput).Item)
+	if err != nil {
+		clog.printF("getScheduleHandler: %s\n", err)
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, scheduleObject)
+}
+
+func deleteScheduleHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: schedulePath(ctx.UserValue("project"), ctx.UserValue("name"))})
+	setStatusFromError(ctx, err)
+}
+
+func listSchedulesHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	projects := []string{string(ctx.QueryArgs().Peek("project"))}
+	if projects[0] == "" {
+		var err error
+		if projects, err = listProjectDirs(container, "/schedules/"); err != nil {
+			setStatusFromError(ctx, err)
+			return
+		}
+	}
+	schedules := []*Schedule{}
+	for _, project := range projects {
+		items, err := listScheduleItems(container, project, "", append(scheduleAttributes, "__name"))
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			setStatusFromError(ctx, err)
+			return
+		}
+		for _, item := range items {
+			scheduleObject, err := scheduleFromItem(item)
+			if err != nil {
+				clog.printF("listSchedulesHandler: %s\n", err)
+				continue
+			}
+			schedules = append(schedules, scheduleObject)
+		}
+	}
+	writeJSON(ctx, map[string]interface{}{"schedules": schedules})
+}
+
+func listScheduleItems(container v3io.Container, project, filter string, attributes []string) ([]v3io.Item, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/schedules/%s/", project),
+		AttributeNames: attributes,
+		Filter:         filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cursor.AllSync()
+}
+
+// StartScheduler triggers due schedules, advances running pipelines, launches
+// queued runs and evaluates alerts, with several replicas only the one
+// holding the scheduler lease does
+func (db *MLRunDB) StartScheduler() {
+	holder := newLeaseHolder()
+	go func() {
+		for {
+			time.Sleep(schedulerInterval)
+			for _, container := range namespaceContainers() {
+				if !acquireLease(container, schedulerLeaderPath, holder, schedulerLeaseTime) {
+					continue
+				}
+				if err := db.triggerDueSchedules(container, time.Now()); err != nil {
+					fmt.Printf("Failed to trigger schedules: %s\n", err)
+				}
+				if err := db.advancePipelines(container); err != nil {
+					fmt.Printf("Failed to advance pipelines: %s\n", err)
+				}
+				if err := db.dispatchQueuedRuns(container); err != nil {
+					fmt.Printf("Failed to dispatch queued runs: %s\n", err)
+				}
+				if err := evaluateAlerts(container, time.Now()); err != nil {
+					fmt.Printf("Failed to evaluate alerts: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+func (db *MLRunDB) triggerDueSchedules(container v3io.Container, now time.Time) error {
+	projects, err := listProjectDirs(container, "/schedules/")
+	if err != nil {
+		return err
+	}
+	filter := fmt.Sprintf("enabled == true and %s <= %d", nextRunAttribute, now.UnixNano())
+	var lastErr error
+	for _, project := range projects {
+		items, err := listScheduleItems(container, project, filter, []string{"__name", "cron", nextRunAttribute, dataAttributeName})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, item := range items {
+			if err := db.triggerSchedule(container, project, item, now); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+// triggerSchedule claims a due schedule by moving its next run time, so it is
+// triggered once even if the lease changed hands, and submits its run
+func (db *MLRunDB) triggerSchedule(container v3io.Container, project string, item v3io.Item, now time.Time) error {
+	name, _ := item.GetFieldString("__name")
+	cronExpression, _ := item.GetFieldString("cron")
+	nextRun, _ := item.GetFieldInt(nextRunAttribute)
+	scheduleObject, err := scheduleFromItem(item)
+	if err != nil {
+		return err
+	}
+	cron, err := schedule.Parse(cronExpression)
+	if err != nil {
+		return err
+	}
+	path := schedulePath(project, name)
+	err = container.UpdateItemSync(&v3io.UpdateItemInput{
+		Path:       path,
+		Condition:  fmt.Sprintf("%s == %d", nextRunAttribute, nextRun),
+		Attributes: map[string]interface{}{nextRunAttribute: int(cron.Next(now).UnixNano()), "last_run": int(now.UnixNano())},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	function := common.Function{}
+	task := []byte(scheduleObject.Task)
+	if len(task) == 0 {
+		task = []byte("{}")
+	}
+	if task, err = sjson.DeleteBytes(task, "metadata.uid"); err == nil {
+		if task, err = sjson.SetBytes(task, "metadata.project", project); err == nil {
+			err = json.Unmarshal(scheduleObject.Function, &function)
+		}
+	}
+	var data []byte
+	if err == nil {
+		data, _, err = db.submitRun(container, task, &function, map[string]string{"schedule": name})
+	}
+	attributes := map[string]interface{}{"last_error": ""}
+	if err != nil {
+		fmt.Printf("Failed to run schedule %s/%s: %s\n", project, name, err)
+		attributes["last_error"] = err.Error()
+	} else {
+		run := common.Run{}
+		json.Unmarshal(data, &run)
+		attributes["last_uid"] = run.Metadata.UID
+		clog.printF("Schedule %s/%s started run %s\n", project, name, run.Metadata.UID)
+	}
+	return container.UpdateItemSync(&v3io.UpdateItemInput{Path: path, Attributes: attributes})
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/secrets"
+	"github.com/valyala/fasthttp"
+	"net/http"
+)
+
+// Secret values are only ever written, responses list the keys
+
+func (db *MLRunDB) secretsEnabled(ctx *fasthttp.RequestCtx) bool {
+	if db.secrets == nil {
+		api.WriteError(ctx, http.StatusNotImplemented, fmt.Errorf("Secrets are not enabled on this server"))
+		return false
+	}
+	return true
+}
+
+func (db *MLRunDB) writeSecretKeys(ctx *fasthttp.RequestCtx, project string) {
+	keys, err := db.secrets.Keys(project)
+	if err != nil {
+		clog.printF("writeSecretKeys: Failed to list the secrets of %s: %s\n", project, err)
+		api.WriteError(ctx, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(ctx, map[string]interface{}{"provider": db.secrets.Provider(), "keys": keys})
+}
+
+func (db *MLRunDB) storeSecretsHandler(ctx *fasthttp.RequestCtx) {
+	if !db.secretsEnabled(ctx) {
+		return
+	}
+	project := fmt.Sprint(ctx.UserValue("name"))
+	values := map[string]string{}
+	if err := json.Unmarshal(ctx.Request.Body(), &values); err != nil || len(values) == 0 {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON object of secret keys and string values"))
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	if err := secrets.ValidateKeys(keys); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := db.secrets.Set(project, values); err != nil {
+		clog.printF("storeSecretsHandler: Failed to store the secrets of %s: %s\n", project, err)
+		api.WriteError(ctx, http.StatusBadGateway, err)
+		return
+	}
+	db.writeSecretKeys(ctx, project)
+}
+
+func (db *MLRunDB) listSecretsHandler(ctx *fasthttp.RequestCtx) {
+	if db.secretsEnabled(ctx) {
+		db.writeSecretKeys(ctx, fmt.Sprint(ctx.UserValue("name")))
+	}
+}
+
+func (db *MLRunDB) deleteSecretsHandler(ctx *fasthttp.RequestCtx) {
+	if !db.secretsEnabled(ctx) {
+		return
+	}
+	project := fmt.Sprint(ctx.UserValue("name"))
+	var keys []string
+	for _, key := range ctx.QueryArgs().PeekMulti("key") {
+		keys = append(keys, string(key))
+	}
+	if err := db.secrets.Delete(project, keys); err != nil {
+		clog.printF("deleteSecretsHandler: Failed to delete the secrets of %s: %s\n", project, err)
+		api.WriteError(ctx, http.StatusBadGateway, err)
+		return
+	}
+	db.writeSecretKeys(ctx, project)
+}
+
+// functionSecrets returns the values of the secrets a function references, for
+// builds which run in the server
+func (db *MLRunDB) functionSecrets(function *common.Function) (map[string]string, error) {
+	if len(function.Spec.Secrets) == 0 {
+		return nil, nil
+	}
+	if db.secrets == nil {
+		return nil, fmt.Errorf("Function %s uses secrets but no secrets provider is configured", function.Metadata.Name)
+	}
+	return db.secrets.Get(function.Metadata.Project, function.Spec.Secrets)
+}
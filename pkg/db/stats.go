@@ -0,0 +1,268 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsTTL     = 5 * time.Minute
+	statsWorkers = 8
+)
+
+type usage struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *usage) add(other usage) {
+	u.Count += other.Count
+	u.Bytes += other.Bytes
+}
+
+type projectUsage struct {
+	Runs      usage `json:"runs"`
+	Artifacts usage `json:"artifacts"`
+	Logs      usage `json:"logs"`
+}
+
+type backendHealth struct {
+	Circuit             string `json:"circuit"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+type storageStats struct {
+	Projects   map[string]*projectUsage `json:"projects"`
+	Totals     projectUsage             `json:"totals"`
+	ComputedAt time.Time                `json:"computed_at"`
+	Cached     bool                     `json:"cached"`
+	Backend    backendHealth            `json:"backend"`
+	// Quotas is the live quota usage of the projects that have a quota
+	Quotas map[string]*quotaUsage `json:"quotas,omitempty"`
+}
+
+type statsCache struct {
+	lock  sync.Mutex
+	stats *storageStats
+}
+
+// get returns a copy of the cached stats, callers may set its fields
+func (c *statsCache) get(container v3io.Container, refresh bool) (*storageStats, error) {
+	// Holding the lock while computing makes concurrent callers share one scan
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !refresh && c.stats != nil && time.Since(c.stats.ComputedAt) < statsTTL {
+		cached := *c.stats
+		cached.Cached = true
+		return &cached, nil
+	}
+	stats, err := computeStorageStats(container)
+	if err != nil {
+		return nil, err
+	}
+	stateOf(container).storage.seed(stats)
+	c.stats = stats
+	fresh := *stats
+	return &fresh, nil
+}
+
+// scanDir counts the objects under path and sums their sizes
+func scanDir(container v3io.Container, path string) (usage, error) {
+	result := usage{}
+	input := v3io.GetContainerContentsInput{Path: path}
+	for {
+		v3ioResponse, err := container.GetContainerContentsSync(&input)
+		if err != nil {
+			if isNotFound(err) {
+				return result, nil
+			}
+			return result, err
+		}
+		output := v3ioResponse.Output.(*v3io.GetContainerContentsOutput)
+		for _, content := range output.Contents {
+			result.Count++
+			result.Bytes += int64(content.Size)
+		}
+		v3ioResponse.Release()
+		if !output.IsTruncated || output.NextMarker == "" {
+			return result, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+// scanLogs groups /log/<project>-<uid> objects by project
+func scanLogs(container v3io.Container) (map[string]usage, error) {
+	result := map[string]usage{}
+	input := v3io.GetContainerContentsInput{Path: "/log/"}
+	for {
+		v3ioResponse, err := container.GetContainerContentsSync(&input)
+		if err != nil {
+			if isNotFound(err) {
+				return result, nil
+			}
+			return nil, err
+		}
+		output := v3ioResponse.Output.(*v3io.GetContainerContentsOutput)
+		for _, content := range output.Contents {
+			name := content.Key[strings.LastIndex(content.Key, "/")+1:]
+			project := name
+			if sep := strings.LastIndex(name, "-"); sep > 0 {
+				project = name[:sep]
+			}
+			projectLogs := result[project]
+			projectLogs.add(usage{Count: 1, Bytes: int64(content.Size)})
+			result[project] = projectLogs
+		}
+		v3ioResponse.Release()
+		if !output.IsTruncated || output.NextMarker == "" {
+			return result, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+func computeStorageStats(container v3io.Container) (*storageStats, error) {
+	stats := storageStats{Projects: map[string]*projectUsage{}, ComputedAt: time.Now().UTC()}
+	type scanTask struct {
+		path   string
+		target *usage
+	}
+	var tasks []scanTask
+	for _, kind := range []string{"run", "artifact"} {
+		projects, err := listProjectDirs(container, "/"+kind+"/")
+		if err != nil {
+			return nil, err
+		}
+		for _, project := range projects {
+			if _, ok := stats.Projects[project]; !ok {
+				stats.Projects[project] = &projectUsage{}
+			}
+			target := &stats.Projects[project].Runs
+			if kind == "artifact" {
+				target = &stats.Projects[project].Artifacts
+			}
+			tasks = append(tasks, scanTask{path: "/" + kind + "/" + project + "/", target: target})
+		}
+	}
+
+	taskChan := make(chan scanTask)
+	// Only the first error is kept, the workers keep draining the tasks
+	errChan := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < statsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				result, err := scanDir(container, task.path)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				*task.target = result
+			}
+		}()
+	}
+	var logs map[string]usage
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		if logs, err = scanLogs(container); err != nil {
+			fail(err)
+		}
+	}()
+	for _, task := range tasks {
+		taskChan <- task
+	}
+	close(taskChan)
+	wg.Wait()
+
+	select {
+	case err := <-errChan:
+		return nil, err
+	default:
+	}
+
+	for project, logUsage := range logs {
+		if _, ok := stats.Projects[project]; !ok {
+			stats.Projects[project] = &projectUsage{}
+		}
+		stats.Projects[project].Logs = logUsage
+	}
+	for _, projectStats := range stats.Projects {
+		stats.Totals.Runs.add(projectStats.Runs)
+		stats.Totals.Artifacts.add(projectStats.Artifacts)
+		stats.Totals.Logs.add(projectStats.Logs)
+	}
+	return &stats, nil
+}
+
+func (db *MLRunDB) backendHealth() backendHealth {
+	health := backendHealth{Circuit: "closed"}
+	resilient, ok := db.container.(*resilientContainer)
+	if !ok {
+		return health
+	}
+	state, failures := resilient.breaker.status()
+	health.ConsecutiveFailures = failures
+	switch state {
+	case circuitOpen:
+		health.Circuit = "open"
+	case circuitHalfOpen:
+		health.Circuit = "half-open"
+	}
+	return health
+}
+
+func (db *MLRunDB) statsHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	requestHandlerPrint(ctx)
+	stats, err := stateOf(container).stats.get(container, api.QueryBool(ctx, "refresh", false))
+	if err != nil {
+		clog.printF("statsHandler: Failed to compute storage stats : %s", err)
+		setStatusFromError(ctx, err)
+		return
+	}
+	stats.Backend = db.backendHealth()
+	stats.Quotas = db.quotaStats(container)
+	body, err := json.Marshal(stats)
+	if err != nil {
+		ctx.Response.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.SetBody(body)
+}
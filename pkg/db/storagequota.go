@@ -0,0 +1,286 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Project storage quotas are read again after this long
+const storageLimitsTTL = time.Minute
+
+// StorageQuota limits the bytes a project stores in runs, artifacts and logs,
+// as quantities such as 10Gi. Stores over the hard quota fail, projects over
+// the soft quota get a warning in their summary. Empty values are unlimited
+type StorageQuota struct {
+	Soft string `json:"soft,omitempty"`
+	Hard string `json:"hard,omitempty"`
+}
+
+func (q *StorageQuota) validate() error {
+	soft, err := parseStorageSize(q.Soft)
+	if err != nil {
+		return fmt.Errorf("Invalid soft storage quota '%s'", q.Soft)
+	}
+	hard, err := parseStorageSize(q.Hard)
+	if err != nil {
+		return fmt.Errorf("Invalid hard storage quota '%s'", q.Hard)
+	}
+	if soft > 0 && hard > 0 && soft > hard {
+		return fmt.Errorf("The soft storage quota %s is above the hard quota %s", q.Soft, q.Hard)
+	}
+	return nil
+}
+
+// parseStorageSize returns the bytes of a quantity, zero when empty
+func parseStorageSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	return runtime.ParseMemory(size)
+}
+
+// storageStatus is the usage of a project against its storage quota
+type storageStatus struct {
+	Bytes   int64  `json:"bytes"`
+	Soft    string `json:"soft,omitempty"`
+	Hard    string `json:"hard,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+type storageLimits struct {
+	quota      StorageQuota
+	soft, hard int64
+	read       time.Time
+}
+
+type projectStorage struct {
+	scanned int64
+	// Bytes stored since the scan, deletes are only seen by the next scan
+	added  int64
+	warned bool
+}
+
+// storageTracker keeps the approximate bytes stored per project, from the
+// storage stats scan and the stores since
+type storageTracker struct {
+	lock      sync.Mutex
+	defaults  StorageQuota
+	projects  map[string]*projectStorage
+	limits    map[string]*storageLimits
+	scannedAt time.Time
+	started   bool
+}
+
+// storageDefaults is the storage quota of the projects that do not set their
+// own, set by InitDB
+var storageDefaults StorageQuota
+
+// seed replaces the tracked usage with the one of a storage scan
+func (t *storageTracker) seed(stats *storageStats) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	projects := map[string]*projectStorage{}
+	for project, usage := range stats.Projects {
+		scanned := &projectStorage{scanned: usage.Runs.Bytes + usage.Artifacts.Bytes + usage.Logs.Bytes}
+		if previous, ok := t.projects[project]; ok {
+			scanned.warned = previous.warned
+		}
+		projects[project] = scanned
+	}
+	t.projects, t.scannedAt = projects, stats.ComputedAt
+}
+
+// projectLimits returns the storage quota of a project, its own or the
+// server default
+func (t *storageTracker) projectLimits(container v3io.Container, project string) (*storageLimits, error) {
+	t.lock.Lock()
+	limits, ok := t.limits[project]
+	t.lock.Unlock()
+	if ok && time.Since(limits.read) < storageLimitsTTL {
+		return limits, nil
+	}
+	settings, err := readProjectSettings(container, project)
+	if err != nil {
+		return nil, err
+	}
+	limits = &storageLimits{quota: t.defaults, read: time.Now()}
+	if settings.StorageQuota != nil {
+		limits.quota = *settings.StorageQuota
+	}
+	// The quotas were validated when set
+	limits.soft, _ = parseStorageSize(limits.quota.Soft)
+	limits.hard, _ = parseStorageSize(limits.quota.Hard)
+	t.lock.Lock()
+	t.limits[project] = limits
+	t.lock.Unlock()
+	return limits, nil
+}
+
+// forget drops the cached quota of a project, e.g. when its settings change
+func (t *storageTracker) forget(project string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.limits, project)
+}
+
+// reserve accounts a body about to be stored at path by a project, replacing
+// the one stored there. It fails with 413 when the body is larger than the
+// hard quota and 507 when the project is over it. Usage is only tracked for
+// projects with a quota, after the first scan
+func (t *storageTracker) reserve(container v3io.Container, project, path string, size int) error {
+	limits, err := t.projectLimits(container, project)
+	if err != nil || (limits.soft == 0 && limits.hard == 0) {
+		return err
+	}
+	if limits.hard > 0 && int64(size) > limits.hard {
+		return v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("The %d bytes body is larger than the %s storage quota of project %s",
+			size, limits.quota.Hard, project), http.StatusRequestEntityTooLarge)
+	}
+	t.lock.Lock()
+	scanned := !t.scannedAt.IsZero()
+	t.lock.Unlock()
+	if !scanned {
+		return nil
+	}
+	// Runs and logs are stored again as they progress
+	added := int64(size - storedSize(container, path))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	usage, ok := t.projects[project]
+	if !ok {
+		usage = &projectStorage{}
+		t.projects[project] = usage
+	}
+	used := usage.scanned + usage.added
+	if limits.hard > 0 && added > 0 && used+added > limits.hard {
+		return v3ioerrors.NewErrorWithStatusCode(fmt.Errorf("Project %s stores %d bytes and is over its %s storage quota",
+			project, used, limits.quota.Hard), http.StatusInsufficientStorage)
+	}
+	usage.added += added
+	if limits.soft > 0 && used+added > limits.soft && !usage.warned {
+		usage.warned = true
+		fmt.Printf("Project %s stores %d bytes, over its soft storage quota of %s\n", project, used+added, limits.quota.Soft)
+	}
+	return nil
+}
+
+// storedSize returns the size of the object or item body at path, zero when
+// there is none
+func storedSize(container v3io.Container, path string) int {
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: path, AttributeNames: []string{"__size", dataAttributeName}})
+	if err != nil {
+		return 0
+	}
+	defer v3ioResponse.Release()
+	item := v3ioResponse.Output.(*v3io.GetItemOutput).Item
+	size, _ := item.GetFieldInt("__size")
+	if data, ok := item.GetField(dataAttributeName).([]byte); ok && len(data) > size {
+		size = len(data)
+	}
+	return size
+}
+
+// reserveObject reserves the storage of a run or artifact stored at path,
+// /<kind>/<project>/<name>
+func reserveObject(container v3io.Container, path string, size int) error {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) < 3 || (segments[0] != "run" && segments[0] != "artifact") {
+		return nil
+	}
+	return stateOf(container).storage.reserve(container, segments[1], path, size)
+}
+
+// status returns the storage usage and quota of a project, nil before the
+// first scan
+func (t *storageTracker) status(container v3io.Container, project string) (*storageStatus, error) {
+	limits, err := t.projectLimits(container, project)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.scannedAt.IsZero() {
+		return nil, nil
+	}
+	status := storageStatus{Soft: limits.quota.Soft, Hard: limits.quota.Hard}
+	if usage, ok := t.projects[project]; ok {
+		status.Bytes = usage.scanned + usage.added
+	}
+	switch {
+	case limits.hard > 0 && status.Bytes >= limits.hard:
+		status.Warning = fmt.Sprintf("Over the hard storage quota of %s, stores are rejected", limits.quota.Hard)
+	case limits.soft > 0 && status.Bytes > limits.soft:
+		status.Warning = fmt.Sprintf("Over the soft storage quota of %s", limits.quota.Soft)
+	}
+	return &status, nil
+}
+
+// enabled returns whether the server or any project has a storage quota
+func (t *storageTracker) enabled(container v3io.Container) bool {
+	if t.defaults.Soft != "" || t.defaults.Hard != "" {
+		return true
+	}
+	projects, err := listProjectDirs(container, "/project/")
+	if err != nil {
+		clog.printF("Failed to list the project storage quotas: %s\n", err)
+		return false
+	}
+	for _, project := range projects {
+		if limits, err := t.projectLimits(container, project); err == nil && (limits.soft > 0 || limits.hard > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartStorageQuotas rescans the project storage usage as the storage stats
+// expire, while there are storage quotas
+func (db *MLRunDB) StartStorageQuotas() {
+	storage := stateOf(containers[""]).storage
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	if storage.started {
+		return
+	}
+	storage.started = true
+	go func() {
+		for {
+			for _, container := range namespaceContainers() {
+				state := stateOf(container)
+				if !state.storage.enabled(container) {
+					continue
+				}
+				if _, err := state.stats.get(container, false); err != nil {
+					fmt.Printf("Failed to scan the project storage usage: %s\n", err)
+				}
+			}
+			time.Sleep(statsTTL)
+		}
+	}()
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/events"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/tidwall/sjson"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"time"
+)
+
+const reconcileInterval = 5 * time.Second
+
+type submitRequest struct {
+	Task     json.RawMessage `json:"task"`
+	Function json.RawMessage `json:"function"`
+}
+
+func (db *MLRunDB) submitHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	if db.launcher == nil {
+		api.WriteError(ctx, http.StatusNotImplemented, fmt.Errorf("Running functions is not enabled on this server"))
+		return
+	}
+	request := submitRequest{}
+	if err := json.Unmarshal(ctx.Request.Body(), &request); err != nil || len(request.Task) == 0 || len(request.Function) == 0 {
+		api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Expecting a JSON body with a task and a function"))
+		return
+	}
+	function := common.Function{}
+	if err := json.Unmarshal(request.Function, &function); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	taskRun := common.Run{}
+	if err := json.Unmarshal(request.Task, &taskRun); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateIdentifiers("project", setFrom(taskRun.Metadata.Project, function.Metadata.Project),
+		"uid", taskRun.Metadata.UID); err != nil {
+		api.WriteError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	data, job, err := db.submitRun(container, request.Task, &function, nil)
+	if _, ok := err.(*quotaError); ok {
+		api.WriteError(ctx, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		api.WriteError(ctx, http.StatusInternalServerError, fmt.Errorf("Failed to submit run: %s", err))
+		return
+	}
+	writeJSON(ctx, map[string]interface{}{"data": json.RawMessage(data), "job": job})
+}
+
+// submitRun stores the run with the extra labels and launches it when running
+// functions is enabled, runs over their project quota are queued (with no job
+// name) or rejected. It returns the stored run and the job name
+func (db *MLRunDB) submitRun(container v3io.Container, task json.RawMessage, function *common.Function, labels map[string]string) ([]byte, string, error) {
+	taskRun := common.Run{}
+	if err := json.Unmarshal(task, &taskRun); err != nil {
+		return nil, "", err
+	}
+	project := setFrom(taskRun.Metadata.Project, setFrom(function.Metadata.Project, "default"))
+	uid := setFrom(taskRun.Metadata.UID, randomID())
+	if err := validateIdentifiers("project", project, "uid", uid); err != nil {
+		return nil, "", err
+	}
+	name := setFrom(taskRun.Metadata.Name, function.Metadata.Name)
+	fields := map[string]string{
+		"metadata.uid":       uid,
+		"metadata.project":   project,
+		"metadata.name":      name,
+		"status.state":       runtime.StatePending,
+		"status.last_update": time.Now().UTC().Format(runTimeLayout),
+	}
+	for key, value := range labels {
+		fields["metadata.labels."+key] = value
+	}
+	queued, reserved := false, false
+	if db.launcher != nil {
+		var err error
+		if queued, reserved, err = db.admitRun(container, project, function); err != nil {
+			return nil, "", err
+		}
+		if queued {
+			fields["status.state"] = stateQueued
+		}
+	}
+	run := []byte(task)
+	var err error
+	for path, value := range fields {
+		if run, err = sjson.SetBytes(run, path, value); err != nil {
+			return nil, "", err
+		}
+	}
+
+	data, err := createRun(container, project, uid, run)
+	if reserved {
+		releaseRunSlot(container, project)
+	}
+	if err != nil || db.launcher == nil {
+		return data, "", err
+	}
+	if queued {
+		return data, "", queueRun(container, project, uid, name, function)
+	}
+	job, err := db.launcher.Launch(&runtime.Run{Namespace: containerNamespace(container), Project: project, UID: uid, Name: name, Function: function, Object: data})
+	if err != nil {
+		fmt.Printf("Failed to launch run %s/%s: %s\n", project, uid, err)
+		if stateErr := setRunState(container, project, uid, data, runtime.StateError, err.Error(), ""); stateErr != nil {
+			fmt.Printf("Failed to update run %s/%s: %s\n", project, uid, stateErr)
+		}
+		return nil, "", err
+	}
+	return data, job, nil
+}
+
+// StartPodWatcher feeds the state of the pods labeled with a run uid back into
+// their runs
+func (db *MLRunDB) StartPodWatcher() {
+	if db.k8s == nil {
+		return
+	}
+	go runtime.NewPodWatcher(db.k8s).Watch(func(status *runtime.RunStatus) {
+		container := namespaceContainer(status.Namespace)
+		// Worker pods only fail the run, their logs stay with the pods, Spark
+		// executors are retried by Spark
+		if status.Role != "" {
+			if status.State == runtime.StateError && status.Role != runtime.RoleExecutor {
+				status.Message = fmt.Sprintf("%s pod %s failed: %s", status.Role, status.Pod, status.Message)
+				if err := updateRunState(container, status); err != nil {
+					fmt.Printf("Failed to update run %s/%s from pod %s: %s\n", status.Project, status.UID, status.Pod, err)
+				}
+			}
+			return
+		}
+		if err := updateRunState(container, status); err != nil {
+			fmt.Printf("Failed to update run %s/%s from pod %s: %s\n", status.Project, status.UID, status.Pod, err)
+		}
+		switch {
+		case status.Class == runtime.KindSpark && status.State == runtime.StateRunning:
+			go db.followPodLog(container, status)
+		case status.Class != runtime.KindSpark && (status.State == runtime.StateCompleted || status.State == runtime.StateError):
+			go db.collectPodLogs(container, status)
+		}
+	})
+}
+
+// followPodLog streams the log of a running pod (e.g. a Spark driver) into the
+// run log
+func (db *MLRunDB) followPodLog(container v3io.Container, status *runtime.RunStatus) {
+	out := newLogWriter(container, status.Project, status.UID)
+	defer out.Close()
+	if err := runtime.FollowPodLog(db.k8s, status.Pod, out); err != nil {
+		fmt.Printf("Failed to follow logs of run %s/%s from pod %s: %s\n", status.Project, status.UID, status.Pod, err)
+	}
+}
+
+// collectPodLogs stores the logs of a terminated run pod, so they outlive the pod
+func (db *MLRunDB) collectPodLogs(container v3io.Container, status *runtime.RunStatus) {
+	body, err := runtime.PodLogs(db.k8s, status.Pod, status.Containers)
+	if err == nil && len(body) > 0 {
+		err = storeLog(container, status.Project, status.UID, body)
+	}
+	if err != nil {
+		fmt.Printf("Failed to collect logs of run %s/%s from pod %s: %s\n", status.Project, status.UID, status.Pod, err)
+	}
+}
+
+// updateRunState moves pending runs to running, and runs that did not report
+// a final state themselves to the final state of their job or pod
+func updateRunState(container v3io.Container, status *runtime.RunStatus) error {
+	stateAttribute := encodeAttributeName("status.state")
+	var condition string
+	switch status.State {
+	case runtime.StateRunning:
+		condition = fmt.Sprintf("%s == '%s'", stateAttribute, runtime.StatePending)
+	case runtime.StateCompleted, runtime.StateError, runtime.StateAborted:
+		condition = fmt.Sprintf("%s != '%s' AND %s != '%s' AND %s != '%s'",
+			stateAttribute, runtime.StateCompleted, stateAttribute, runtime.StateError, stateAttribute, runtime.StateAborted)
+	default:
+		return nil
+	}
+
+	v3ioResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           fmt.Sprintf("/run/%s/%s", status.Project, status.UID),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	body, err := openData(v3ioResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte))
+	v3ioResponse.Release()
+	if err != nil {
+		return err
+	}
+	return setRunState(container, status.Project, status.UID, body, status.State, status.Message, condition)
+}
+
+// StartRunReconciler launches the jobs of Run resources and mirrors their state
+// into the resources and the run records
+func (db *MLRunDB) StartRunReconciler() {
+	if db.launcher == nil {
+		return
+	}
+	go runtime.NewReconciler(db.launcher, db).Run(reconcileInterval)
+}
+
+// StoreRun stores a new run record of the run reconciler, whose Run resources
+// are in the default namespace, and returns it in JSON form
+func (db *MLRunDB) StoreRun(project, uid string, run []byte) ([]byte, error) {
+	return createRun(containers[""], project, uid, run)
+}
+
+// createRun stores a new run record and returns it in JSON form
+func createRun(container v3io.Container, project, uid string, run []byte) ([]byte, error) {
+	JSONData, err := convertDataToJSON(run)
+	if err == nil {
+		JSONData, err = objectDefaults.Run(JSONData, time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+	metadata := runMetadataEnvelope{}
+	if err = json.Unmarshal(JSONData, &metadata); err != nil {
+		return nil, err
+	}
+	updateItemInput := v3io.UpdateItemInput{Path: fmt.Sprintf("/run/%s/%s", project, uid)}
+	if err = metadataToV3ioAttributes(metadata, "", &updateItemInput.Attributes); err != nil {
+		return nil, err
+	}
+	if err = indexRunMetrics(container, project, JSONData, updateItemInput.Attributes); err != nil {
+		return nil, err
+	}
+	updateItemInput.Attributes[dataAttributeName] = sealData(JSONData)
+	if err = container.UpdateItemSync(&updateItemInput); err != nil {
+		return nil, err
+	}
+	publishRunEvent(container, events.RunCreated, project, uid, JSONData)
+	return JSONData, nil
+}
+
+// SetRunState updates the state of a run of the run reconciler unless it
+// already has a final state
+func (db *MLRunDB) SetRunState(project, uid, state, message string) error {
+	return updateRunState(containers[""], &runtime.RunStatus{Project: project, UID: uid, State: state, Message: message})
+}
+
+func setFrom(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Projects changed in ways the writes do not describe (e.g. deletes) are
+	// rescanned at this interval
+	summaryRescanInterval = time.Minute
+	summaryRecentFailures = 10
+)
+
+// runFailure is a failed run of a project summary
+type runFailure struct {
+	UID   string    `json:"uid"`
+	Name  string    `json:"name"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// projectSummary is the response of GET /project/:name/summary
+type projectSummary struct {
+	Project        string         `json:"project"`
+	Runs           map[string]int `json:"runs"`
+	RecentFailures []runFailure   `json:"recent_failures"`
+	Artifacts      int            `json:"artifacts"`
+	LastActivity   *time.Time     `json:"last_activity,omitempty"`
+	ScannedAt      time.Time      `json:"scanned_at"`
+	// Storage is the approximate usage against the storage quota, once scanned
+	Storage *storageStatus `json:"storage,omitempty"`
+}
+
+// projectAggregate is the state a project summary is computed from
+type projectAggregate struct {
+	runStates    map[string]string // run uid -> state
+	failures     []runFailure      // most recent first
+	artifactKeys map[string]bool
+	lastActivity time.Time
+	scannedAt    time.Time
+	// Incremented on every change, a scan that raced with a change is redone
+	version int
+}
+
+func newProjectAggregate() *projectAggregate {
+	return &projectAggregate{runStates: map[string]string{}, artifactKeys: map[string]bool{}}
+}
+
+func (a *projectAggregate) touch(when time.Time) {
+	if when.After(a.lastActivity) {
+		a.lastActivity = when
+	}
+}
+
+func (a *projectAggregate) addFailure(failure runFailure) {
+	failures := []runFailure{failure}
+	for _, other := range a.failures {
+		if other.UID != failure.UID {
+			failures = append(failures, other)
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool { return failures[i].Time.After(failures[j].Time) })
+	if len(failures) > summaryRecentFailures {
+		failures = failures[:summaryRecentFailures]
+	}
+	a.failures = failures
+}
+
+func (a *projectAggregate) runChanged(uid string, run *common.Run, when time.Time) {
+	a.runStates[uid] = run.Status.State
+	if run.Status.State == "error" {
+		failureTime, ok := parseRunTime(run.Status.LastUpdate)
+		if !ok {
+			failureTime = when
+		}
+		a.addFailure(runFailure{UID: uid, Name: run.Metadata.Name, Error: run.Status.Error, Time: failureTime.UTC()})
+	}
+	a.touch(when)
+}
+
+func (a *projectAggregate) summary(project string) *projectSummary {
+	summary := projectSummary{Project: project, Runs: map[string]int{}, RecentFailures: a.failures,
+		Artifacts: len(a.artifactKeys), ScannedAt: a.scannedAt}
+	for _, state := range a.runStates {
+		if state == "" {
+			state = "unknown"
+		}
+		summary.Runs[state]++
+	}
+	if summary.RecentFailures == nil {
+		summary.RecentFailures = []runFailure{}
+	}
+	if !a.lastActivity.IsZero() {
+		lastActivity := a.lastActivity.UTC()
+		summary.LastActivity = &lastActivity
+	}
+	return &summary
+}
+
+// summaryAggregator keeps the project summaries, updated on run and artifact
+// writes and seeded (and repaired) by background scans
+type summaryAggregator struct {
+	lock     sync.Mutex
+	projects map[string]*projectAggregate
+	dirty    map[string]bool
+	started  bool
+	// Runs seen entering the completed and error states, by project, since
+	// the server started
+	completed map[string]int64
+	failed    map[string]int64
+}
+
+// changed returns the aggregate of a changed project, nil before its first
+// scan. The aggregator lock is held
+func (s *summaryAggregator) changed(project string) *projectAggregate {
+	aggregate := s.projects[project]
+	if aggregate != nil {
+		aggregate.version++
+	}
+	return aggregate
+}
+
+func (s *summaryAggregator) runChanged(project, uid string, data []byte) {
+	run := common.Run{}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous := ""
+	if aggregate := s.changed(project); aggregate != nil {
+		previous = aggregate.runStates[uid]
+		aggregate.runChanged(uid, &run, time.Now())
+	}
+	if run.Status.State != previous {
+		switch run.Status.State {
+		case "completed":
+			s.completed[project]++
+		case "error":
+			s.failed[project]++
+		}
+	}
+}
+
+func (s *summaryAggregator) runDeleted(project, uid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if aggregate := s.changed(project); aggregate != nil {
+		delete(aggregate.runStates, uid)
+		failures := aggregate.failures[:0:0]
+		for _, failure := range aggregate.failures {
+			if failure.UID != uid {
+				failures = append(failures, failure)
+			}
+		}
+		aggregate.failures = failures
+		aggregate.touch(time.Now())
+	}
+}
+
+func (s *summaryAggregator) artifactStored(project, key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if aggregate := s.changed(project); aggregate != nil {
+		aggregate.artifactKeys[key] = true
+		aggregate.touch(time.Now())
+	}
+}
+
+// invalidate marks a project for rescanning, for changes such as artifact
+// deletes that cannot be applied incrementally
+func (s *summaryAggregator) invalidate(project string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if aggregate := s.changed(project); aggregate != nil {
+		aggregate.touch(time.Now())
+		s.dirty[project] = true
+	}
+}
+
+// get returns the summary of a project, scanning it when not yet seeded
+func (s *summaryAggregator) get(container v3io.Container, project string) (*projectSummary, error) {
+	s.lock.Lock()
+	aggregate := s.projects[project]
+	s.lock.Unlock()
+	if aggregate == nil {
+		if err := s.scan(container, project); err != nil {
+			return nil, err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.projects[project].summary(project), nil
+}
+
+// scan recomputes the aggregate of a project from its runs and artifacts
+func (s *summaryAggregator) scan(container v3io.Container, project string) error {
+	s.lock.Lock()
+	version := -1
+	if aggregate := s.projects[project]; aggregate != nil {
+		version = aggregate.version
+	}
+	delete(s.dirty, project)
+	s.lock.Unlock()
+
+	aggregate := newProjectAggregate()
+	aggregate.scannedAt = time.Now().UTC()
+	if err := scanRunSummary(container, project, aggregate); err != nil {
+		return err
+	}
+	if err := scanArtifactSummary(container, project, aggregate); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if previous := s.projects[project]; previous != nil && previous.version != version {
+		// Changed while scanning, the scan may have missed it
+		aggregate.version = previous.version
+		s.dirty[project] = true
+	}
+	s.projects[project] = aggregate
+	return nil
+}
+
+func scanRunSummary(container v3io.Container, project string, aggregate *projectAggregate) error {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/run/%s/", project),
+		AttributeNames: []string{"__name", dataAttributeName, mtimeAttribute},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		uid, _ := item.GetFieldString("__name")
+		data, ok := item.GetField(dataAttributeName).([]byte)
+		if !ok {
+			continue
+		}
+		if data, err = openData(data); err != nil {
+			return err
+		}
+		run := common.Run{}
+		if err := json.Unmarshal(data, &run); err != nil {
+			continue
+		}
+		aggregate.runChanged(uid, &run, itemModified(item))
+	}
+	return nil
+}
+
+func scanArtifactSummary(container v3io.Container, project string, aggregate *projectAggregate) error {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifact/%s/", project),
+		AttributeNames: []string{"name", mtimeAttribute},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if key, err := item.GetFieldString("name"); err == nil && key != "" {
+			aggregate.artifactKeys[key] = true
+		}
+		aggregate.touch(itemModified(item))
+	}
+	return nil
+}
+
+// scanAll seeds the projects with runs or artifacts and rescans the
+// invalidated ones
+func (s *summaryAggregator) scanAll(container v3io.Container) error {
+	projects := map[string]bool{}
+	for _, kind := range []string{"/run/", "/artifact/"} {
+		names, err := listProjectDirs(container, kind)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			projects[name] = true
+		}
+	}
+	var lastErr error
+	for project := range projects {
+		if err := s.scan(container, project); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (s *summaryAggregator) rescanDirty(container v3io.Container) error {
+	s.lock.Lock()
+	var projects []string
+	for project := range s.dirty {
+		projects = append(projects, project)
+	}
+	s.lock.Unlock()
+	var lastErr error
+	for _, project := range projects {
+		if err := s.scan(container, project); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// StartSummaries seeds the project summaries in the background and keeps
+// rescanning the projects the writes could not update
+func (db *MLRunDB) StartSummaries() {
+	summaries := stateOf(containers[""]).summaries
+	summaries.lock.Lock()
+	defer summaries.lock.Unlock()
+	if summaries.started {
+		return
+	}
+	summaries.started = true
+	go func() {
+		for _, container := range namespaceContainers() {
+			if err := stateOf(container).summaries.scanAll(container); err != nil {
+				fmt.Printf("Failed to scan project summaries: %s\n", err)
+			}
+		}
+		for {
+			time.Sleep(summaryRescanInterval)
+			for _, container := range namespaceContainers() {
+				if err := stateOf(container).summaries.rescanDirty(container); err != nil {
+					fmt.Printf("Failed to rescan project summaries: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// projectSummaryHandler returns the run counts by state, recent failures,
+// artifact count and last activity of a project
+func projectSummaryHandler(ctx *fasthttp.RequestCtx) {
+	container := requestContainer(ctx)
+	project, _ := ctx.UserValue("name").(string)
+	summary, err := stateOf(container).summaries.get(container, project)
+	if err == nil {
+		summary.Storage, err = stateOf(container).storage.status(container, project)
+	}
+	if err != nil {
+		api.WriteError(ctx, statusFromError(err), fmt.Errorf("Failed to scan project %s: %s", project, err))
+		return
+	}
+	writeJSON(ctx, summary)
+}
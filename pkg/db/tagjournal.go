@@ -0,0 +1,240 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Storing an artifact writes its version item and then its tag item. A tag
+// journal entry, /artifactjournal/<project>/<key>.<tag>.<uid>, is written
+// first and removed once both are stored, entries left by a failed or
+// interrupted store are replayed by copying the version to the tag, unless
+// the tag was stored again since. The replica holding the journal lease
+// replays it
+const (
+	tagJournalInterval = time.Minute
+	// Entries younger than this may be of stores still in progress
+	tagJournalGrace     = time.Minute
+	tagJournalLeaseTime = 3 * tagJournalInterval
+	tagJournalLeasePath = "/artifactjournal/.leader"
+)
+
+type tagJournalEntry struct {
+	path    string
+	project string
+	key     string
+	tag     string
+	uid     string
+	created int
+}
+
+// journalTagFlip records that the tag of an artifact is about to point to the
+// version uid
+func journalTagFlip(container v3io.Container, project, key, tag, uid string) (*tagJournalEntry, error) {
+	entry := tagJournalEntry{
+		path:    fmt.Sprintf("/artifactjournal/%s/%s.%s.%s", project, key, tag, uid),
+		project: project, key: key, tag: tag, uid: uid,
+		created: int(time.Now().Unix()),
+	}
+	err := container.PutItemSync(&v3io.PutItemInput{Path: entry.path, Attributes: map[string]interface{}{
+		"key": key, "tag": tag, artifactUIDAttribute: uid, "created": entry.created,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// done removes the entry, a left entry is dropped by the next replay
+func (e *tagJournalEntry) done(container v3io.Container) {
+	if err := container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: e.path}); err != nil {
+		clog.printF("Failed to remove the tag journal entry %s: %s\n", e.path, err)
+	}
+}
+
+// writeTagPending reports an artifact stored as a version but not under its
+// tag, with the status of the failed tag store
+func writeTagPending(ctx *fasthttp.RequestCtx, uid interface{}, tag string) {
+	previous := struct {
+		Error string `json:"error"`
+	}{}
+	json.Unmarshal(ctx.Response.Body(), &previous)
+	if ctx.Response.StatusCode() < http.StatusBadRequest {
+		ctx.Response.SetStatusCode(http.StatusServiceUnavailable)
+	}
+	writeJSON(ctx, map[string]interface{}{
+		"error":          fmt.Sprintf("Stored version %s but not tag %s, the tag is updated by the journal replay: %s", uid, tag, previous.Error),
+		"version_stored": true,
+		"tag_stored":     false,
+	})
+}
+
+func readTagJournal(container v3io.Container, project string) ([]tagJournalEntry, error) {
+	cursor, err := v3io.NewItemsCursor(container, &v3io.GetItemsInput{
+		Path:           fmt.Sprintf("/artifactjournal/%s/", project),
+		AttributeNames: []string{"__name", "key", "tag", artifactUIDAttribute, "created"},
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items, err := cursor.AllSync()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]tagJournalEntry, 0, len(items))
+	for _, item := range items {
+		name, _ := item.GetFieldString("__name")
+		entry := tagJournalEntry{path: fmt.Sprintf("/artifactjournal/%s/%s", project, name), project: project}
+		entry.key, _ = item.GetFieldString("key")
+		entry.tag, _ = item.GetFieldString("tag")
+		entry.uid, _ = item.GetFieldString(artifactUIDAttribute)
+		entry.created, _ = item.GetFieldInt("created")
+		entries = append(entries, entry)
+	}
+	// Replayed in order, so the newest store of a tag wins
+	sort.Slice(entries, func(i, j int) bool { return entries[i].created < entries[j].created })
+	return entries, nil
+}
+
+// replay copies the version of the entry to its tag, unless the version is
+// missing or the tag points to it or was stored since. The tag is written on
+// condition it is still as read, a store racing the replay wins
+func (e *tagJournalEntry) replay(container v3io.Container) error {
+	versionResponse, err := container.GetItemSync(&v3io.GetItemInput{
+		Path:           fmt.Sprintf("/artifact/%s/%s.%s", e.project, e.key, e.uid),
+		AttributeNames: []string{dataAttributeName},
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	body, ok := versionResponse.Output.(*v3io.GetItemOutput).Item[dataAttributeName].([]byte)
+	versionResponse.Release()
+	if !ok {
+		return fmt.Errorf("Artifact %s/%s version %s has no body", e.project, e.key, e.uid)
+	}
+	data, err := openData(body)
+	if err != nil {
+		return err
+	}
+
+	tagPath := fmt.Sprintf("/artifact/%s/%s.%s", e.project, e.key, e.tag)
+	tagResponse, err := container.GetItemSync(&v3io.GetItemInput{Path: tagPath,
+		AttributeNames: []string{artifactUIDAttribute, mtimeAttribute, mtimeNsecsAttribute}})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	// Every tag item has a name
+	condition := "not(exists(name))"
+	if err == nil {
+		item := tagResponse.Output.(*v3io.GetItemOutput).Item
+		tagged, _ := item.GetFieldString(artifactUIDAttribute)
+		modified := itemModified(item)
+		condition = unchangedCondition(item)
+		tagResponse.Release()
+		if tagged == e.uid || modified.Unix() > int64(e.created) {
+			return nil
+		}
+	}
+
+	clog.printF("Replaying the tag journal: pointing artifact %s/%s tag %s to uid %s\n", e.project, e.key, e.tag, e.uid)
+	descriptor := artifactMetadataEnvelope{}
+	if err = json.Unmarshal(data, &descriptor); err != nil {
+		return err
+	}
+	attributes := map[string]interface{}{"name": e.key, artifactUIDAttribute: e.uid}
+	if err = metadataToV3ioAttributes(&descriptor, "", &attributes); err != nil {
+		return err
+	}
+	attributes[dataAttributeName] = sealData(data)
+	if err = reserveObject(container, tagPath, len(attributes[dataAttributeName].([]byte))); err != nil {
+		return err
+	}
+	err = container.PutItemSync(&v3io.PutItemInput{Path: tagPath, Condition: condition, Attributes: attributes})
+	if isConditionFailed(err) {
+		clog.printF("Artifact %s/%s tag %s was stored during the replay, keeping it\n", e.project, e.key, e.tag)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	publishArtifactEvent(container, e.project, e.uid, e.key, e.tag, data)
+	return nil
+}
+
+// replayTagJournal replays the journal entries of all projects older than
+// the grace period
+func replayTagJournal(container v3io.Container) error {
+	projects, err := listProjectDirs(container, "/artifactjournal/")
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-tagJournalGrace).Unix()
+	var lastErr error
+	for _, project := range projects {
+		entries, err := readTagJournal(container, project)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for i := range entries {
+			if int64(entries[i].created) > before {
+				continue
+			}
+			if err = entries[i].replay(container); err != nil {
+				lastErr = err
+				continue
+			}
+			entries[i].done(container)
+		}
+	}
+	return lastErr
+}
+
+// StartTagJournal replays the artifact tag journal, left by interrupted
+// artifact stores, on start and periodically
+func (db *MLRunDB) StartTagJournal() {
+	holder := newLeaseHolder()
+	go func() {
+		for {
+			for _, container := range namespaceContainers() {
+				if !acquireLease(container, tagJournalLeasePath, holder, tagJournalLeaseTime) {
+					continue
+				}
+				if err := replayTagJournal(container); err != nil {
+					fmt.Printf("Failed to replay the artifact tag journal: %s\n", err)
+				}
+			}
+			time.Sleep(tagJournalInterval)
+		}
+	}()
+}
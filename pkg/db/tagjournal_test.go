@@ -0,0 +1,102 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"testing"
+	"time"
+)
+
+// hookContainer calls afterGet after each item read
+type hookContainer struct {
+	v3io.Container
+	afterGet func(path string)
+}
+
+func (c *hookContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	response, err := c.Container.GetItemSync(input)
+	c.afterGet(input.Path)
+	return response, err
+}
+
+func taggedUID(t *testing.T, path string) string {
+	t.Helper()
+	response, err := containers[""].GetItemSync(&v3io.GetItemInput{Path: path, AttributeNames: []string{artifactUIDAttribute}})
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", path, err)
+	}
+	defer response.Release()
+	uid, _ := response.Output.(*v3io.GetItemOutput).Item.GetFieldString(artifactUIDAttribute)
+	return uid
+}
+
+func TestTagJournalReplay(t *testing.T) {
+	mldb := newTestDB(t)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u0?key=model&tag=prod", `{"key":"model","tree":"u0"}`, 200)
+	mustRequest(t, mldb, "POST", "/artifact/p1/u1?key=model&tag=v1", `{"key":"model","tree":"u1"}`, 200)
+	future := int(time.Now().Unix()) + 100
+
+	// A missing tag is created
+	entry := tagJournalEntry{project: "p1", key: "model", tag: "latest", uid: "u1", created: future}
+	if err := entry.replay(containers[""]); err != nil {
+		t.Fatal(err)
+	}
+	if uid := taggedUID(t, "/artifact/p1/model.latest"); uid != "u1" {
+		t.Errorf("Replayed tag points to %q, expected u1", uid)
+	}
+
+	// A tag stored after the replay read it is kept
+	original := containers[""]
+	defer func() { containers[""] = original }()
+	containers[""] = &hookContainer{Container: original, afterGet: func(path string) {
+		if path == "/artifact/p1/model.prod" {
+			original.PutItemSync(&v3io.PutItemInput{Path: path, Attributes: map[string]interface{}{
+				"name": "model", artifactUIDAttribute: "u2"}})
+		}
+	}}
+	entry = tagJournalEntry{project: "p1", key: "model", tag: "prod", uid: "u1", created: future}
+	if err := entry.replay(containers[""]); err != nil {
+		t.Fatal(err)
+	}
+	if uid := taggedUID(t, "/artifact/p1/model.prod"); uid != "u2" {
+		t.Errorf("Tag stored during the replay points to %q, expected u2", uid)
+	}
+}
+
+func TestAcquireLease(t *testing.T) {
+	newTestDB(t)
+	path := "/leases/test"
+	if !acquireLease(containers[""], path, "a", time.Minute) {
+		t.Fatal("Failed to acquire a free lease")
+	}
+	if acquireLease(containers[""], path, "b", time.Minute) {
+		t.Error("Acquired a lease held by another holder")
+	}
+	if !acquireLease(containers[""], path, "a", time.Minute) {
+		t.Error("Failed to renew a held lease")
+	}
+	if !acquireLease(containers[""], path, "a", -time.Minute) {
+		t.Fatal("Failed to renew a held lease")
+	}
+	if !acquireLease(containers[""], path, "b", time.Minute) {
+		t.Error("Failed to acquire an expired lease")
+	}
+}
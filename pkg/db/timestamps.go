@@ -0,0 +1,58 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Layouts of the run and artifact timestamps, older SDKs write runTimeLayout
+// and newer ones RFC3339. Fractional seconds are optional in all of them, and
+// timestamps with no zone are UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// parseRunTime parses a timestamp in any of the timestampLayouts, in UTC
+func parseRunTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	// Cheap rejection of the non time strings of objects
+	if len(value) < len("2006-01-02 15:04:05") || value[4] != '-' {
+		return time.Time{}, false
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// canonicalTime is the form timestamps are stored in next to their epoch
+func canonicalTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+// Package defaults normalizes the function and run objects stored and built
+// through the API, so they have the same fields whichever client wrote them.
+// Only missing fields are set, fields the controller does not model are kept
+package defaults
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"sort"
+	"time"
+)
+
+const (
+	Tag             = "latest"
+	ImagePullPolicy = "IfNotPresent"
+	// TimeLayout is the mlrun SDK time format
+	TimeLayout = "2006-01-02 15:04:05.000000"
+)
+
+// Config holds the server side defaults, zero values are the package defaults
+// (no namespace is set with no Namespace)
+type Config struct {
+	Namespace       string
+	ImagePullPolicy string
+}
+
+// Function sets the missing tag (the tag it is stored under), namespace,
+// image pull policy and created time of a function in JSON form
+func (c *Config) Function(data []byte, tag string, now time.Time) ([]byte, error) {
+	values := map[string]interface{}{
+		"metadata.tag":           setFrom(tag, Tag),
+		"metadata.created":       now.UTC().Format(TimeLayout),
+		"spec.image_pull_policy": setFrom(c.ImagePullPolicy, ImagePullPolicy),
+	}
+	if c.Namespace != "" {
+		values["metadata.namespace"] = c.Namespace
+	}
+	return setMissing(data, values)
+}
+
+// Run sets the missing iteration (0, the parent run) and start time of a run
+// in JSON form
+func (c *Config) Run(data []byte, now time.Time) ([]byte, error) {
+	return setMissing(data, map[string]interface{}{
+		"metadata.iteration": 0,
+		"status.start_time":  now.UTC().Format(TimeLayout),
+	})
+}
+
+// setMissing sets the values by JSON path, unless set already
+func setMissing(data []byte, values map[string]interface{}) ([]byte, error) {
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var err error
+	for _, path := range paths {
+		if gjson.GetBytes(data, path).Exists() {
+			continue
+		}
+		if data, err = sjson.SetBytes(data, path, values[path]); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func setFrom(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/kafka"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SchemaVersion = 1
+
+	RunCreated     = "run.created"
+	RunUpdated     = "run.updated"
+	RunDeleted     = "run.deleted"
+	ArtifactStored = "artifact.stored"
+
+	batchSize  = 100
+	maxBackoff = 30 * time.Second
+)
+
+// Event is the JSON document published for every run/artifact change.
+// Events are persisted in the outbox before the change is acknowledged, unless
+// the outbox fails, and delivered at least once, consumers should dedup by ID
+type Event struct {
+	Version int             `json:"version"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	Project string          `json:"project"`
+	UID     string          `json:"uid,omitempty"`
+	Key     string          `json:"key,omitempty"`
+	Tag     string          `json:"tag,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func NewEvent(eventType, project, uid string) *Event {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &Event{
+		Version: SchemaVersion,
+		ID:      hex.EncodeToString(id),
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		Project: project,
+		UID:     uid,
+	}
+}
+
+// Sink delivers a batch of events, an error means the whole batch is retried
+type Sink interface {
+	Publish(events []*Event) error
+}
+
+// NewSink creates a sink from a URL, one of v3io:///stream/path (in the DB
+// container), kafka://broker1:9092,broker2:9092/topic or nats://host:4222/subject
+func NewSink(sinkURL string, container v3io.Container) (Sink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, err
+	}
+	target := strings.Trim(u.Path, "/")
+	if target == "" {
+		return nil, fmt.Errorf("Missing stream, topic or subject in events sink %s", sinkURL)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "v3io":
+		return newV3ioSink(container, target)
+	case "kafka":
+		return &kafkaSink{producer: kafka.NewProducer(strings.Split(u.Host, ",")), topic: target}, nil
+	case "nats":
+		return newNatsSink(u, target), nil
+	default:
+		return nil, fmt.Errorf("Unknown events sink (%s) use v3io, kafka or nats", u.Scheme)
+	}
+}
+
+// Publisher persists events in an outbox and delivers them in the
+// background, retrying failed batches until the sink accepts them. Servers
+// sharing an outbox may deliver an event twice
+type Publisher struct {
+	sink   Sink
+	outbox Outbox
+	wake   chan struct{}
+}
+
+func NewPublisher(sink Sink, outbox Outbox) *Publisher {
+	p := &Publisher{sink: sink, outbox: outbox, wake: make(chan struct{}, 1)}
+	go p.run()
+	return p
+}
+
+// Publish persists an event for delivery, the event is lost on error
+func (p *Publisher) Publish(event *Event) error {
+	if err := p.outbox.Add(event); err != nil {
+		return fmt.Errorf("Failed to persist %s event: %s", event.Type, err)
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *Publisher) run() {
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+	for {
+		batch, err := p.outbox.Pending(batchSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "events: failed to read the outbox: %s\n", err)
+		}
+		if len(batch) == 0 {
+			select {
+			case <-p.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+		p.deliver(batch)
+		// Events that are not removed are delivered again
+		if err = p.outbox.Remove(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "events: failed to remove %d delivered events from the outbox: %s\n", len(batch), err)
+		}
+	}
+}
+
+func (p *Publisher) deliver(batch []*Event) {
+	backoff := 100 * time.Millisecond
+	for {
+		err := p.sink.Publish(batch)
+		if err == nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "events: failed to publish %d events, retrying in %s: %s\n", len(batch), backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func partitionKey(event *Event) string {
+	return event.Project
+}
+
+type kafkaSink struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func (s *kafkaSink) Publish(events []*Event) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{Key: []byte(partitionKey(event)), Value: body})
+	}
+	return s.producer.Produce(s.topic, messages)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryOutbox struct {
+	lock   sync.Mutex
+	events []*Event
+	fail   bool
+}
+
+func (o *memoryOutbox) Add(event *Event) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.fail {
+		return fmt.Errorf("Outbox is down")
+	}
+	o.events = append(o.events, event)
+	return nil
+}
+
+func (o *memoryOutbox) Pending(limit int) ([]*Event, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(o.events) < limit {
+		limit = len(o.events)
+	}
+	return append([]*Event(nil), o.events[:limit]...), nil
+}
+
+func (o *memoryOutbox) Remove(events []*Event) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	removed := map[string]bool{}
+	for _, event := range events {
+		removed[event.ID] = true
+	}
+	var left []*Event
+	for _, event := range o.events {
+		if !removed[event.ID] {
+			left = append(left, event)
+		}
+	}
+	o.events = left
+	return nil
+}
+
+func (o *memoryOutbox) size() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.events)
+}
+
+// flakySink fails the first failures batches
+type flakySink struct {
+	lock      sync.Mutex
+	failures  int
+	delivered []*Event
+}
+
+func (s *flakySink) Publish(events []*Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("Sink is down")
+	}
+	s.delivered = append(s.delivered, events...)
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	outbox := &memoryOutbox{}
+	sink := &flakySink{failures: 2}
+	publisher := NewPublisher(sink, outbox)
+	var events []*Event
+	for i := 0; i < 3; i++ {
+		event := NewEvent(RunCreated, "p1", fmt.Sprint(i))
+		events = append(events, event)
+		if err := publisher.Publish(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for outbox.size() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size := outbox.size(); size != 0 {
+		t.Fatalf("%d events left in the outbox", size)
+	}
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	delivered := map[string]bool{}
+	for _, event := range sink.delivered {
+		delivered[event.ID] = true
+	}
+	for _, event := range events {
+		if !delivered[event.ID] {
+			t.Errorf("Event %s was not delivered", event.UID)
+		}
+	}
+}
+
+func TestPublisherOutboxError(t *testing.T) {
+	publisher := NewPublisher(&flakySink{}, &memoryOutbox{fail: true})
+	if err := publisher.Publish(NewEvent(RunDeleted, "p1", "u1")); err == nil {
+		t.Error("Publish succeeded without persisting the event")
+	}
+}
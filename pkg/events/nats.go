@@ -0,0 +1,135 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const natsTimeout = 10 * time.Second
+
+// natsSink speaks the NATS text protocol, a PING after the batch confirms the
+// server processed every PUB before the batch is acknowledged
+type natsSink struct {
+	addr     string
+	user     string
+	password string
+	subject  string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newNatsSink(u *url.URL, subject string) Sink {
+	s := &natsSink{addr: u.Host, subject: strings.Replace(subject, "/", ".", -1)}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if _, _, err := net.SplitHostPort(s.addr); err != nil {
+		s.addr = net.JoinHostPort(s.addr, "4222")
+	}
+	return s
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("Unexpected NATS greeting: %s", strings.TrimSpace(line))
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "mlrun-controller"}
+	if s.user != "" {
+		options["user"] = s.user
+		options["pass"] = s.password
+	}
+	connectOptions, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connectOptions); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	s.reader = reader
+	return nil
+}
+
+func (s *natsSink) Publish(events []*Event) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if err := s.publish(events); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *natsSink) publish(events []*Event) error {
+	s.conn.SetDeadline(time.Now().Add(natsTimeout))
+	writer := bufio.NewWriter(s.conn)
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "PUB %s %d\r\n", s.subject, len(body))
+		writer.Write(body)
+		writer.WriteString("\r\n")
+	}
+	writer.WriteString("PING\r\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			fmt.Fprintf(s.conn, "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", line)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeNats accepts a connection and answers a batch of PUBs followed by a
+// PING, replying with reply (PONG or an -ERR line)
+type fakeNats struct {
+	listener  net.Listener
+	connect   chan string
+	published chan []*Event
+}
+
+func newFakeNats(t *testing.T, replies ...string) *fakeNats {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNats{listener: listener, connect: make(chan string, 10), published: make(chan []*Event, 10)}
+	go func() {
+		for _, reply := range replies {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if err := s.serve(conn, reply); err != nil {
+				t.Errorf("Fake NATS server: %s", err)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *fakeNats) serve(conn net.Conn, reply string) error {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\"}\r\n")
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	s.connect <- strings.TrimSpace(line)
+
+	var batch []*Event
+	for {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "PUB":
+			if len(fields) != 3 || fields[1] != "mlrun.events" {
+				return fmt.Errorf("Bad PUB line %q", line)
+			}
+			size, _ := strconv.Atoi(fields[2])
+			body := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, body); err != nil {
+				return err
+			}
+			event := Event{}
+			if err = json.Unmarshal(body[:size], &event); err != nil {
+				return err
+			}
+			batch = append(batch, &event)
+		case "PING":
+			// A server PING in the middle must be answered by the client
+			fmt.Fprintf(conn, "PING\r\n%s\r\n", reply)
+			s.published <- batch
+			batch = nil
+			if reply != "PONG" {
+				return nil
+			}
+		case "PONG":
+		default:
+			return fmt.Errorf("Unexpected line %q", line)
+		}
+	}
+}
+
+func TestNatsSink(t *testing.T) {
+	server := newFakeNats(t, "-ERR 'Authorization Violation'", "PONG")
+	defer server.listener.Close()
+	u, _ := url.Parse("nats://user:pass@" + server.listener.Addr().String() + "/mlrun/events")
+	sink := newNatsSink(u, "mlrun/events")
+	events := []*Event{NewEvent(RunCreated, "p1", "u1"), NewEvent(RunUpdated, "p1", "u1")}
+
+	err := sink.Publish(events)
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("Publish error %v, expected the server error", err)
+	}
+	if connect := <-server.connect; !strings.Contains(connect, `"user":"user"`) || !strings.Contains(connect, `"pass":"pass"`) {
+		t.Errorf("CONNECT without credentials: %s", connect)
+	}
+	<-server.published
+
+	// The sink reconnects after an error
+	if err = sink.Publish(events); err != nil {
+		t.Fatal(err)
+	}
+	<-server.connect
+	published := <-server.published
+	if len(published) != len(events) {
+		t.Fatalf("Published %d events, expected %d", len(published), len(events))
+	}
+	for i, event := range published {
+		if event.ID != events[i].ID || event.Type != events[i].Type {
+			t.Errorf("Event %d is %s %s, expected %s %s", i, event.ID, event.Type, events[i].ID, events[i].Type)
+		}
+	}
+}
+
+func TestNatsSinkGreeting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\n")
+			conn.Close()
+		}
+	}()
+	u, _ := url.Parse("nats://" + listener.Addr().String() + "/events")
+	err = newNatsSink(u, "events").Publish([]*Event{NewEvent(RunDeleted, "p1", "u1")})
+	if err == nil || !strings.HasPrefix(err.Error(), "Unexpected NATS greeting") {
+		t.Errorf("Publish error %v, expected a greeting error", err)
+	}
+}
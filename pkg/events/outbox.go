@@ -0,0 +1,130 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// outboxPoll is how often the outbox is checked for events published before
+// a restart or by other servers
+const outboxPoll = 5 * time.Second
+
+// Outbox persists the published events until the sink accepts them, so they
+// survive restarts and sink outages
+type Outbox interface {
+	Add(event *Event) error
+	// Pending returns up to limit events, the oldest first
+	Pending(limit int) ([]*Event, error)
+	Remove(events []*Event) error
+}
+
+// v3ioOutbox keeps an object per event in a container directory, named by
+// the publish time so listings are in publish order. Objects that are not
+// events are moved to the quarantine directory next to it
+type v3ioOutbox struct {
+	container  v3io.Container
+	dir        string
+	quarantine string
+}
+
+// NewV3ioOutbox returns an outbox in the dir directory of container
+func NewV3ioOutbox(container v3io.Container, dir string) Outbox {
+	dir = strings.Trim(path.Clean(dir), "/")
+	return &v3ioOutbox{container: container, dir: "/" + dir + "/", quarantine: "/" + dir + ".quarantine/"}
+}
+
+func (o *v3ioOutbox) name(event *Event) string {
+	return fmt.Sprintf("%020d-%s", event.Time.UnixNano(), event.ID)
+}
+
+func (o *v3ioOutbox) Add(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return o.container.PutObjectSync(&v3io.PutObjectInput{Path: o.dir + o.name(event), Body: body})
+}
+
+func (o *v3ioOutbox) Pending(limit int) ([]*Event, error) {
+	response, err := o.container.GetContainerContentsSync(&v3io.GetContainerContentsInput{Path: o.dir, Limit: limit})
+	if err != nil {
+		if errWithStatus, ok := err.(v3ioerrors.ErrorWithStatusCode); ok && errWithStatus.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, content := range response.Output.(*v3io.GetContainerContentsOutput).Contents {
+		names = append(names, path.Base(content.Key))
+	}
+	response.Release()
+
+	var pending []*Event
+	for _, name := range names {
+		response, err := o.container.GetObjectSync(&v3io.GetObjectInput{Path: o.dir + name})
+		if err != nil {
+			// Delivered and removed by another server
+			if errWithStatus, ok := err.(v3ioerrors.ErrorWithStatusCode); ok && errWithStatus.StatusCode() == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		event := Event{}
+		body := append([]byte(nil), response.Body()...)
+		response.Release()
+		if err = json.Unmarshal(body, &event); err != nil {
+			// Skipped so the events after it are delivered
+			fmt.Fprintf(os.Stderr, "events: moving bad outbox event %s to %s: %s\n", name, o.quarantine, err)
+			o.moveToQuarantine(name, body)
+			continue
+		}
+		pending = append(pending, &event)
+	}
+	return pending, nil
+}
+
+func (o *v3ioOutbox) moveToQuarantine(name string, body []byte) {
+	err := o.container.PutObjectSync(&v3io.PutObjectInput{Path: o.quarantine + name, Body: body})
+	if err == nil {
+		err = o.container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: o.dir + name})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "events: failed to move bad outbox event %s: %s\n", name, err)
+	}
+}
+
+func (o *v3ioOutbox) Remove(events []*Event) error {
+	for _, event := range events {
+		err := o.container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: o.dir + o.name(event)})
+		if errWithStatus, ok := err.(v3ioerrors.ErrorWithStatusCode); err != nil && (!ok || errWithStatus.StatusCode() != http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v3io/v3io-go/pkg/dataplane"
+	"github.com/v3io/v3io-go/pkg/errors"
+	"net/http"
+)
+
+const (
+	streamShards         = 1
+	streamRetentionHours = 24
+)
+
+type v3ioSink struct {
+	container v3io.Container
+	path      string
+}
+
+func newV3ioSink(container v3io.Container, stream string) (Sink, error) {
+	if container == nil {
+		return nil, fmt.Errorf("v3io events sink requires a v3io container")
+	}
+	s := &v3ioSink{container: container, path: "/" + stream + "/"}
+	err := container.CreateStreamSync(&v3io.CreateStreamInput{
+		Path:                 s.path,
+		ShardCount:           streamShards,
+		RetentionPeriodHours: streamRetentionHours,
+	})
+	if err != nil {
+		if errWithStatus, ok := err.(v3ioerrors.ErrorWithStatusCode); !ok || errWithStatus.StatusCode() != http.StatusConflict {
+			return nil, fmt.Errorf("Failed to create events stream %s: %s", s.path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *v3ioSink) Publish(events []*Event) error {
+	input := v3io.PutRecordsInput{Path: s.path}
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		input.Records = append(input.Records, &v3io.StreamRecord{Data: body, PartitionKey: partitionKey(event)})
+	}
+
+	response, err := s.container.PutRecordsSync(&input)
+	if err != nil {
+		return err
+	}
+	defer response.Release()
+	output := response.Output.(*v3io.PutRecordsOutput)
+	if output.FailedRecordCount > 0 {
+		return fmt.Errorf("%d of %d records were rejected by the stream", output.FailedRecordCount, len(events))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+type Resolver func(source interface{}, field *Field) (interface{}, error)
+
+// FieldDef describes a field of an object type, Type is nil for scalar fields
+type FieldDef struct {
+	Type    *Object
+	Resolve Resolver
+}
+
+type Object struct {
+	Name   string
+	Fields map[string]*FieldDef
+}
+
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type Result struct {
+	Data   *orderedMap `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// orderedMap keeps the result keys in selection order, as the spec requires
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: map[string]interface{}{}}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		encodedValue, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type executor struct {
+	errors []Error
+}
+
+func Execute(query *Object, request *Request) *Result {
+	fields, err := Parse(request.Query, request.OperationName, request.Variables)
+	if err != nil {
+		return &Result{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	e := executor{}
+	data := e.executeSelectionSet(query, nil, fields, nil)
+	return &Result{Data: data, Errors: e.errors}
+}
+
+func (e *executor) executeSelectionSet(object *Object, source interface{}, fields []*Field, path []interface{}) *orderedMap {
+	result := newOrderedMap()
+	for _, field := range fields {
+		fieldPath := append(append([]interface{}{}, path...), field.ResultKey())
+		if field.Name == "__typename" {
+			result.set(field.ResultKey(), object.Name)
+			continue
+		}
+
+		def, ok := object.Fields[field.Name]
+		if !ok {
+			e.addError(fieldPath, fmt.Errorf("Cannot query field '%s' on type '%s'", field.Name, object.Name))
+			result.set(field.ResultKey(), nil)
+			continue
+		}
+		if def.Type == nil && len(field.SelectionSet) > 0 {
+			e.addError(fieldPath, fmt.Errorf("Field '%s' of type '%s' must not have a selection", field.Name, object.Name))
+			result.set(field.ResultKey(), nil)
+			continue
+		}
+		if def.Type != nil && len(field.SelectionSet) == 0 {
+			e.addError(fieldPath, fmt.Errorf("Field '%s' of type '%s' must have a selection of subfields", field.Name, def.Type.Name))
+			result.set(field.ResultKey(), nil)
+			continue
+		}
+
+		value, err := def.Resolve(source, field)
+		if err != nil {
+			e.addError(fieldPath, err)
+			result.set(field.ResultKey(), nil)
+			continue
+		}
+		result.set(field.ResultKey(), e.completeValue(def.Type, value, field, fieldPath))
+	}
+	return result
+}
+
+func (e *executor) completeValue(object *Object, value interface{}, field *Field, path []interface{}) interface{} {
+	if object == nil || value == nil {
+		return value
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list[i] = e.completeValue(object, rv.Index(i).Interface(), field, append(path, i))
+		}
+		return list
+	case reflect.Ptr, reflect.Interface, reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	return e.executeSelectionSet(object, value, field.SelectionSet, path)
+}
+
+func (e *executor) addError(path []interface{}, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type testRun struct {
+	name  string
+	state string
+}
+
+func testSchema() *Object {
+	runType := &Object{Name: "Run", Fields: map[string]*FieldDef{
+		"name":  {Resolve: func(source interface{}, field *Field) (interface{}, error) { return source.(*testRun).name, nil }},
+		"state": {Resolve: func(source interface{}, field *Field) (interface{}, error) { return source.(*testRun).state, nil }},
+		"fail": {Resolve: func(source interface{}, field *Field) (interface{}, error) {
+			return nil, fmt.Errorf("Run %s failed", source.(*testRun).name)
+		}},
+	}}
+	runs := []*testRun{{"train", "completed"}, {"serve", "running"}}
+	return &Object{Name: "Query", Fields: map[string]*FieldDef{
+		"runs": {Type: runType, Resolve: func(source interface{}, field *Field) (interface{}, error) {
+			if last := field.IntArg("last", len(runs)); last < len(runs) {
+				return runs[:last], nil
+			}
+			return runs, nil
+		}},
+		"run": {Type: runType, Resolve: func(source interface{}, field *Field) (interface{}, error) {
+			for _, run := range runs {
+				if run.name == field.StringArg("name") {
+					return run, nil
+				}
+			}
+			return (*testRun)(nil), nil
+		}},
+		"version": {Resolve: func(source interface{}, field *Field) (interface{}, error) { return "1", nil }},
+	}}
+}
+
+func TestExecute(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{`{ version runs { name state } }`,
+			`{"data":{"version":"1","runs":[{"name":"train","state":"completed"},{"name":"serve","state":"running"}]}}`},
+		{`{ first: runs(last: 1) { __typename n: name } v: version }`,
+			`{"data":{"first":[{"__typename":"Run","n":"train"}],"v":"1"}}`},
+		{`{ run(name: "missing") { name } run2: run(name: "serve") { state } }`,
+			`{"data":{"run":null,"run2":{"state":"running"}}}`},
+		{`{ runs(last: 1) { name fail } }`,
+			`{"data":{"runs":[{"name":"train","fail":null}]},"errors":[{"message":"Run train failed","path":["runs",0,"fail"]}]}`},
+		{`{ missing }`,
+			`{"data":{"missing":null},"errors":[{"message":"Cannot query field 'missing' on type 'Query'","path":["missing"]}]}`},
+		{`{ runs }`,
+			`{"data":{"runs":null},"errors":[{"message":"Field 'runs' of type 'Run' must have a selection of subfields","path":["runs"]}]}`},
+		{`{ version { name } }`,
+			`{"data":{"version":null},"errors":[{"message":"Field 'version' of type 'Query' must not have a selection","path":["version"]}]}`},
+		{`{ runs { name `, `{"data":null,"errors":[{"message":"Syntax error: expected '}', found '\u003cEOF\u003e' at position 14"}]}`},
+	} {
+		body, err := json.Marshal(Execute(testSchema(), &Request{Query: test.query}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.expected {
+			t.Errorf("Query %s returned\n%s\nexpected\n%s", test.query, body, test.expected)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package graphql
+
+import (
+	"testing"
+)
+
+func TestLexer(t *testing.T) {
+	source := `query Q($id: ID!) { run(uid: "a\"bé\n", n: -12, f: 1.5e3) @skip(if: false) ... on Run # comment
+	{ __typename } }`
+	expected := []token{
+		{kind: tokenName, value: "query"}, {kind: tokenName, value: "Q"}, {kind: tokenPunctuator, value: "("},
+		{kind: tokenPunctuator, value: "$"}, {kind: tokenName, value: "id"}, {kind: tokenPunctuator, value: ":"},
+		{kind: tokenName, value: "ID"}, {kind: tokenPunctuator, value: "!"}, {kind: tokenPunctuator, value: ")"},
+		{kind: tokenPunctuator, value: "{"}, {kind: tokenName, value: "run"}, {kind: tokenPunctuator, value: "("},
+		{kind: tokenName, value: "uid"}, {kind: tokenPunctuator, value: ":"}, {kind: tokenString, value: "a\"bé\n"},
+		{kind: tokenName, value: "n"}, {kind: tokenPunctuator, value: ":"}, {kind: tokenInt, value: "-12"},
+		{kind: tokenName, value: "f"}, {kind: tokenPunctuator, value: ":"}, {kind: tokenFloat, value: "1.5e3"},
+		{kind: tokenPunctuator, value: ")"}, {kind: tokenPunctuator, value: "@"}, {kind: tokenName, value: "skip"},
+		{kind: tokenPunctuator, value: "("}, {kind: tokenName, value: "if"}, {kind: tokenPunctuator, value: ":"},
+		{kind: tokenName, value: "false"}, {kind: tokenPunctuator, value: ")"}, {kind: tokenPunctuator, value: "..."},
+		{kind: tokenName, value: "on"}, {kind: tokenName, value: "Run"}, {kind: tokenPunctuator, value: "{"},
+		{kind: tokenName, value: "__typename"}, {kind: tokenPunctuator, value: "}"}, {kind: tokenPunctuator, value: "}"},
+		{kind: tokenEOF},
+	}
+	l := lexer{source: source}
+	for i, want := range expected {
+		got, err := l.next()
+		if err != nil {
+			t.Fatalf("Token %d: %s", i, err)
+		}
+		if got.kind != want.kind || got.value != want.value {
+			t.Fatalf("Token %d is %d %q, expected %d %q", i, got.kind, got.value, want.kind, want.value)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	for _, source := range []string{
+		`"unterminated`,
+		"\"new\nline\"",
+		`"bad \x escape"`,
+		`"bad \u12G4"`,
+		`"short \u12`,
+		`-`,
+		`..`,
+		`%`,
+	} {
+		l := lexer{source: source}
+		var err error
+		for i := 0; i < 3 && err == nil; i++ {
+			_, err = l.next()
+		}
+		if err == nil {
+			t.Errorf("Lexing %q succeeded", source)
+		}
+	}
+}
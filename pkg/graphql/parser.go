@@ -0,0 +1,654 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Limits of a query, the selections and values may nest maxDepth levels and
+// the query expands to maxFields fields. Spreading a fragment several times in
+// each of a chain of fragments expands a small query exponentially
+const (
+	maxDepth  = 20
+	maxFields = 2000
+)
+
+// Field is a resolved selection: fragments are expanded, directives applied and
+// variables substituted, so resolvers only deal with plain values
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    map[string]interface{}
+	SelectionSet []*Field
+}
+
+func (f *Field) ResultKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Selects reports whether the field selection includes a sub field with the given name
+func (f *Field) Selects(name string) bool {
+	for _, sub := range f.SelectionSet {
+		if sub.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Field) StringArg(name string) string {
+	if value, ok := f.Arguments[name].(string); ok {
+		return value
+	}
+	return ""
+}
+
+func (f *Field) IntArg(name string, defaultValue int) int {
+	switch value := f.Arguments[name].(type) {
+	case int:
+		return value
+	case float64:
+		return int(value)
+	}
+	return defaultValue
+}
+
+func (f *Field) StringListArg(name string) []string {
+	var result []string
+	switch value := f.Arguments[name].(type) {
+	case string:
+		result = append(result, value)
+	case []interface{}:
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+	}
+	return result
+}
+
+type selection struct {
+	field          *rawField
+	fragmentSpread string
+	inlineFragment *rawSelectionSet
+	directives     []directive
+}
+
+type rawSelectionSet []selection
+
+type rawField struct {
+	alias        string
+	name         string
+	arguments    map[string]value
+	selectionSet rawSelectionSet
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+type variableDefinition struct {
+	name         string
+	defaultValue *value
+}
+
+type operation struct {
+	kind         string
+	name         string
+	variables    []variableDefinition
+	selectionSet rawSelectionSet
+}
+
+type value struct {
+	variable string
+	literal  interface{}
+	list     []value
+	object   map[string]value
+	isList   bool
+	isObject bool
+}
+
+type parser struct {
+	lexer     lexer
+	current   token
+	fragments map[string]rawSelectionSet
+	// Nesting of the selection set or value being parsed
+	depth int
+	// Fields resolved so far
+	fields int
+}
+
+// Parse parses a query document and returns the top level selections of the
+// requested operation
+func Parse(query, operationName string, variables map[string]interface{}) ([]*Field, error) {
+	p := parser{lexer: lexer{source: query}, fragments: map[string]rawSelectionSet{}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var operations []*operation
+	for p.current.kind != tokenEOF {
+		if p.current.kind == tokenName && p.current.value == "fragment" {
+			if err := p.parseFragment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	var selected *operation
+	for _, op := range operations {
+		if operationName == "" || op.name == operationName {
+			if selected != nil {
+				return nil, fmt.Errorf("Must provide operation name if query contains multiple operations")
+			}
+			selected = op
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("Unknown operation '%s'", operationName)
+	}
+	if selected.kind != "query" {
+		return nil, fmt.Errorf("Only query operations are supported, got '%s'", selected.kind)
+	}
+
+	resolvedVariables := map[string]interface{}{}
+	for _, definition := range selected.variables {
+		if provided, ok := variables[definition.name]; ok {
+			resolvedVariables[definition.name] = provided
+		} else if definition.defaultValue != nil {
+			resolvedVariables[definition.name] = definition.defaultValue.resolve(nil)
+		}
+	}
+
+	return p.resolveSelectionSet(selected.selectionSet, resolvedVariables, map[string]bool{}, 0)
+}
+
+func (p *parser) advance() error {
+	next, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.current = next
+	return nil
+}
+
+func (p *parser) peek(value string) bool {
+	return p.current.kind == tokenPunctuator && p.current.value == value
+}
+
+func (p *parser) expect(value string) error {
+	if !p.peek(value) {
+		return p.unexpected(fmt.Sprintf("'%s'", value))
+	}
+	return p.advance()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.current.kind != tokenName {
+		return "", p.unexpected("name")
+	}
+	name := p.current.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected(expected string) error {
+	found := p.current.value
+	if p.current.kind == tokenEOF {
+		found = "<EOF>"
+	}
+	return fmt.Errorf("Syntax error: expected %s, found '%s' at position %d", expected, found, p.current.pos)
+}
+
+func (p *parser) parseOperation() (*operation, error) {
+	op := operation{kind: "query"}
+	if p.peek("{") {
+		selectionSet, err := p.parseSelectionSet()
+		op.selectionSet = selectionSet
+		return &op, err
+	}
+
+	kind, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	op.kind = kind
+	if p.current.kind == tokenName {
+		op.name = p.current.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if op.variables, err = p.parseVariableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	op.selectionSet, err = p.parseSelectionSet()
+	return &op, err
+}
+
+func (p *parser) parseVariableDefinitions() ([]variableDefinition, error) {
+	var definitions []variableDefinition
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if err := p.skipType(); err != nil {
+			return nil, err
+		}
+		definition := variableDefinition{name: name}
+		if p.peek("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			defaultValue, err := p.parseValue(true)
+			if err != nil {
+				return nil, err
+			}
+			definition.defaultValue = &defaultValue
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, p.advance()
+}
+
+// skipType consumes a type reference; types are not enforced, resolvers coerce values
+func (p *parser) skipType() error {
+	if p.peek("[") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return err
+	}
+	if p.peek("!") {
+		return p.advance()
+	}
+	return nil
+}
+
+func (p *parser) parseFragment() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return err
+	}
+	if p.current.kind != tokenName || p.current.value != "on" {
+		return p.unexpected("'on'")
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if _, err := p.expectName(); err != nil {
+		return err
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return err
+	}
+	selectionSet, err := p.parseSelectionSet()
+	if err != nil {
+		return err
+	}
+	p.fragments[name] = selectionSet
+	return nil
+}
+
+// nest enters a selection set or a list or object value
+func (p *parser) nest() error {
+	if p.depth++; p.depth > maxDepth {
+		return fmt.Errorf("Query is nested deeper than %d levels at position %d", maxDepth, p.current.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseSelectionSet() (rawSelectionSet, error) {
+	var selections rawSelectionSet
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.peek("}") {
+		if p.current.kind == tokenEOF {
+			return nil, p.unexpected("'}'")
+		}
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) parseSelection() (selection, error) {
+	var sel selection
+	if p.peek("...") {
+		if err := p.advance(); err != nil {
+			return sel, err
+		}
+		if p.current.kind == tokenName && p.current.value != "on" {
+			sel.fragmentSpread = p.current.value
+			if err := p.advance(); err != nil {
+				return sel, err
+			}
+			directives, err := p.parseDirectives()
+			sel.directives = directives
+			return sel, err
+		}
+		if p.current.kind == tokenName && p.current.value == "on" {
+			if err := p.advance(); err != nil {
+				return sel, err
+			}
+			if _, err := p.expectName(); err != nil {
+				return sel, err
+			}
+		}
+		directives, err := p.parseDirectives()
+		if err != nil {
+			return sel, err
+		}
+		sel.directives = directives
+		selectionSet, err := p.parseSelectionSet()
+		sel.inlineFragment = &selectionSet
+		return sel, err
+	}
+
+	field := rawField{}
+	name, err := p.expectName()
+	if err != nil {
+		return sel, err
+	}
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return sel, err
+		}
+		field.alias = name
+		if name, err = p.expectName(); err != nil {
+			return sel, err
+		}
+	}
+	field.name = name
+	if p.peek("(") {
+		if field.arguments, err = p.parseArguments(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.directives, err = p.parseDirectives(); err != nil {
+		return sel, err
+	}
+	if p.peek("{") {
+		if field.selectionSet, err = p.parseSelectionSet(); err != nil {
+			return sel, err
+		}
+	}
+	sel.field = &field
+	return sel, nil
+}
+
+func (p *parser) parseArguments() (map[string]value, error) {
+	arguments := map[string]value{}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.peek(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arguments[name], err = p.parseValue(false); err != nil {
+			return nil, err
+		}
+	}
+	return arguments, p.advance()
+}
+
+func (p *parser) parseDirectives() ([]directive, error) {
+	var directives []directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		d := directive{name: name}
+		if p.peek("(") {
+			if d.arguments, err = p.parseArguments(); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) parseValue(constant bool) (value, error) {
+	tok := p.current
+	switch tok.kind {
+	case tokenPunctuator:
+		if tok.value == "[" || tok.value == "{" {
+			if err := p.nest(); err != nil {
+				return value{}, err
+			}
+			defer func() { p.depth-- }()
+		}
+		switch tok.value {
+		case "$":
+			if constant {
+				return value{}, p.unexpected("constant value")
+			}
+			if err := p.advance(); err != nil {
+				return value{}, err
+			}
+			name, err := p.expectName()
+			return value{variable: name}, err
+		case "[":
+			result := value{isList: true}
+			if err := p.advance(); err != nil {
+				return result, err
+			}
+			for !p.peek("]") {
+				item, err := p.parseValue(constant)
+				if err != nil {
+					return result, err
+				}
+				result.list = append(result.list, item)
+			}
+			return result, p.advance()
+		case "{":
+			result := value{isObject: true, object: map[string]value{}}
+			if err := p.advance(); err != nil {
+				return result, err
+			}
+			for !p.peek("}") {
+				name, err := p.expectName()
+				if err != nil {
+					return result, err
+				}
+				if err := p.expect(":"); err != nil {
+					return result, err
+				}
+				if result.object[name], err = p.parseValue(constant); err != nil {
+					return result, err
+				}
+			}
+			return result, p.advance()
+		}
+	case tokenInt:
+		number, err := strconv.Atoi(tok.value)
+		if err != nil {
+			return value{}, fmt.Errorf("Invalid integer '%s' at position %d", tok.value, tok.pos)
+		}
+		return value{literal: number}, p.advance()
+	case tokenFloat:
+		number, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("Invalid float '%s' at position %d", tok.value, tok.pos)
+		}
+		return value{literal: number}, p.advance()
+	case tokenString:
+		return value{literal: tok.value}, p.advance()
+	case tokenName:
+		switch tok.value {
+		case "true":
+			return value{literal: true}, p.advance()
+		case "false":
+			return value{literal: false}, p.advance()
+		case "null":
+			return value{}, p.advance()
+		}
+		// Enum values are passed to resolvers as strings
+		return value{literal: tok.value}, p.advance()
+	}
+	return value{}, p.unexpected("value")
+}
+
+func (v *value) resolve(variables map[string]interface{}) interface{} {
+	switch {
+	case v.variable != "":
+		return variables[v.variable]
+	case v.isList:
+		list := make([]interface{}, len(v.list))
+		for i := range v.list {
+			list[i] = v.list[i].resolve(variables)
+		}
+		return list
+	case v.isObject:
+		object := map[string]interface{}{}
+		for name, item := range v.object {
+			object[name] = item.resolve(variables)
+		}
+		return object
+	}
+	return v.literal
+}
+
+// resolveSelectionSet expands the fragments of a selection set, depth counts
+// the enclosing fields and fragment spreads
+func (p *parser) resolveSelectionSet(selections rawSelectionSet, variables map[string]interface{}, visiting map[string]bool, depth int) ([]*Field, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("Query is nested deeper than %d levels", maxDepth)
+	}
+	var fields []*Field
+	for _, sel := range selections {
+		include, err := includeSelection(sel.directives, variables)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+
+		switch {
+		case sel.field != nil:
+			if p.fields++; p.fields > maxFields {
+				return nil, fmt.Errorf("Query selects more than %d fields", maxFields)
+			}
+			field := Field{Alias: sel.field.alias, Name: sel.field.name, Arguments: map[string]interface{}{}}
+			for name, arg := range sel.field.arguments {
+				field.Arguments[name] = arg.resolve(variables)
+			}
+			if field.SelectionSet, err = p.resolveSelectionSet(sel.field.selectionSet, variables, visiting, depth+1); err != nil {
+				return nil, err
+			}
+			fields = append(fields, &field)
+		case sel.inlineFragment != nil:
+			inner, err := p.resolveSelectionSet(*sel.inlineFragment, variables, visiting, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inner...)
+		default:
+			fragment, ok := p.fragments[sel.fragmentSpread]
+			if !ok {
+				return nil, fmt.Errorf("Unknown fragment '%s'", sel.fragmentSpread)
+			}
+			if visiting[sel.fragmentSpread] {
+				return nil, fmt.Errorf("Fragment '%s' spreads itself", sel.fragmentSpread)
+			}
+			visiting[sel.fragmentSpread] = true
+			inner, err := p.resolveSelectionSet(fragment, variables, visiting, depth+1)
+			delete(visiting, sel.fragmentSpread)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inner...)
+		}
+	}
+	return fields, nil
+}
+
+func includeSelection(directives []directive, variables map[string]interface{}) (bool, error) {
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			return false, fmt.Errorf("Unknown directive '@%s'", d.name)
+		}
+		condition, ok := d.arguments["if"]
+		if !ok {
+			return false, fmt.Errorf("Directive '@%s' requires an 'if' argument", d.name)
+		}
+		value, _ := condition.resolve(variables).(bool)
+		if (d.name == "include") != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// render formats resolved fields as alias:name(arg=value){sub fields}
+func render(fields []*Field) string {
+	var parts []string
+	for _, field := range fields {
+		part := field.Name
+		if field.Alias != "" {
+			part = field.Alias + ":" + part
+		}
+		if len(field.Arguments) > 0 {
+			var args []string
+			for name, value := range field.Arguments {
+				args = append(args, fmt.Sprintf("%s=%v", name, value))
+			}
+			sort.Strings(args)
+			part += "(" + strings.Join(args, ",") + ")"
+		}
+		if len(field.SelectionSet) > 0 {
+			part += "{" + render(field.SelectionSet) + "}"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		query     string
+		operation string
+		variables map[string]interface{}
+		expected  string
+	}{
+		{"shorthand", `{ runs { name } }`, "", nil, "runs{name}"},
+		{"alias and arguments", `query { a: run(project: "p", uid: "u", last: 3, ratio: 0.5, on: true) { name } }`, "", nil,
+			"a:run(last=3,on=true,project=p,ratio=0.5,uid=u){name}"},
+		{"list and object arguments", `{ runs(labels: ["a=b", "c"], filter: {state: completed, x: null}) { name } }`, "", nil,
+			"runs(filter=map[state:completed x:<nil>],labels=[a=b c]){name}"},
+		{"variables", `query Q($p: String!, $labels: [String] = ["x"]) { runs(project: $p, labels: $labels) { name } }`, "",
+			map[string]interface{}{"p": "p1"}, "runs(labels=[x],project=p1){name}"},
+		{"missing variable", `query Q($p: String) { runs(project: $p) { name } }`, "", nil, "runs(project=<nil>){name}"},
+		{"named fragment", `{ runs { ...F } } fragment F on Run { name state }`, "", nil, "runs{name,state}"},
+		{"nested fragments", `fragment A on Run { name ...B } fragment B on Run { state } { runs { ...A } }`, "", nil,
+			"runs{name,state}"},
+		{"inline fragment", `{ runs { ... on Run { name } ... { state } } }`, "", nil, "runs{name,state}"},
+		{"skip and include", `query Q($yes: Boolean) { runs { a @include(if: $yes) b @skip(if: $yes) c @include(if: false) ...F @skip(if: true) } } fragment F on Run { d }`,
+			"", map[string]interface{}{"yes": true}, "runs{a}"},
+		{"operation name", `query A { a } query B { b }`, "B", nil, "b"},
+	} {
+		fields, err := Parse(test.query, test.operation, test.variables)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := render(fields); got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		query     string
+		operation string
+		expected  string
+	}{
+		{`{ runs { name }`, "", "Syntax error: expected '}', found '<EOF>'"},
+		{`{ runs(project: ) { name } }`, "", "Syntax error: expected value, found ')'"},
+		{`query A { a } query B { b }`, "", "Must provide operation name if query contains multiple operations"},
+		{`query A { a }`, "B", "Unknown operation 'B'"},
+		{`mutation { deleteRun }`, "", "Only query operations are supported, got 'mutation'"},
+		{`{ runs { ...Missing } }`, "", "Unknown fragment 'Missing'"},
+		{`{ runs { ...A } } fragment A on Run { ...B } fragment B on Run { ...A }`, "", "Fragment 'A' spreads itself"},
+		{`{ runs @defer { name } }`, "", "Unknown directive '@defer'"},
+		{`{ runs @skip { name } }`, "", "Directive '@skip' requires an 'if' argument"},
+		{`query ($p: String = $q) { a }`, "", "Syntax error: expected constant value, found '$'"},
+		{`fragment F Run { a }`, "", "Syntax error: expected 'on', found 'Run'"},
+		{`{ a(n: 99999999999999999999) }`, "", "Invalid integer '99999999999999999999'"},
+	} {
+		_, err := Parse(test.query, test.operation, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("Parsing %s: got error %v, expected %s", test.query, err, test.expected)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	// Each fragment spreads the next one twice, expanding to 2^12 fields
+	var query strings.Builder
+	query.WriteString("{ runs { ...F0 } }\n")
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&query, "fragment F%d on Run { ...F%d ...F%d }\n", i, i+1, i+1)
+	}
+	query.WriteString("fragment F12 on Run { name }\n")
+	if _, err := Parse(query.String(), "", nil); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("Exponential fragments: got error %v, expected too many fields", err)
+	}
+
+	// A chain of fragments nests as deep as the fields do
+	query.Reset()
+	query.WriteString("{ runs { ...F0 } }\n")
+	for i := 0; i < maxDepth; i++ {
+		fmt.Fprintf(&query, "fragment F%d on Run { ...F%d }\n", i, i+1)
+	}
+	fmt.Fprintf(&query, "fragment F%d on Run { name }\n", maxDepth)
+	if _, err := Parse(query.String(), "", nil); err == nil || !strings.Contains(err.Error(), "nested deeper") {
+		t.Errorf("Fragment chain: got error %v, expected too deep", err)
+	}
+
+	deep := "{ a " + strings.Repeat("{ a ", maxDepth) + strings.Repeat("}", maxDepth+1)
+	if _, err := Parse(deep, "", nil); err == nil || !strings.Contains(err.Error(), "nested deeper") {
+		t.Errorf("Deep selection: got error %v, expected too deep", err)
+	}
+	deepValue := "{ a(v: " + strings.Repeat("[", maxDepth+1) + strings.Repeat("]", maxDepth+1) + ") }"
+	if _, err := Parse(deepValue, "", nil); err == nil || !strings.Contains(err.Error(), "nested deeper") {
+		t.Errorf("Deep value: got error %v, expected too deep", err)
+	}
+
+	shallow := "{ a " + strings.Repeat("{ a ", maxDepth-2) + strings.Repeat("}", maxDepth-1)
+	if _, err := Parse(shallow, "", nil); err != nil {
+		t.Errorf("Query within the depth limit: %s", err)
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout    = 10 * time.Second
+	requestTimeout = 30 * time.Second
+	clientID       = "mlrun-controller"
+)
+
+type broker struct {
+	addr          string
+	conn          net.Conn
+	reader        *bufio.Reader
+	correlationID int32
+}
+
+func (b *broker) request(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.addr, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+		b.reader = bufio.NewReader(conn)
+	}
+	b.correlationID++
+
+	e := encoder{}
+	e.int32(0) // size, set below
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(b.correlationID)
+	e.string(clientID)
+	e.buf = append(e.buf, body...)
+	e.putInt32At(0, int32(len(e.buf)-4))
+
+	b.conn.SetDeadline(time.Now().Add(requestTimeout))
+	response, err := b.roundTrip(e.buf)
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	return response, nil
+}
+
+func (b *broker) roundTrip(request []byte) ([]byte, error) {
+	if _, err := b.conn.Write(request); err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(b.reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if correlationID := int32(binary.BigEndian.Uint32(header[4:])); correlationID != b.correlationID {
+		return nil, fmt.Errorf("Kafka correlation id mismatch, expected %d got %d", b.correlationID, correlationID)
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("Invalid Kafka response size %d", size)
+	}
+	response := make([]byte, size-4)
+	_, err := io.ReadFull(b.reader, response)
+	return response, err
+}
+
+func (b *broker) close() {
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+type topicMetadata struct {
+	leaders map[int32]int32 // partition -> broker node id
+	count   int
+}
+
+// Producer is a minimal synchronous Kafka producer (acks=all, no compression)
+type Producer struct {
+	lock      sync.Mutex
+	bootstrap []string
+	brokers   map[int32]*broker
+	topics    map[string]*topicMetadata
+}
+
+func NewProducer(bootstrap []string) *Producer {
+	return &Producer{bootstrap: bootstrap, brokers: map[int32]*broker{}, topics: map[string]*topicMetadata{}}
+}
+
+func (p *Producer) refreshMetadata(topic string) (*topicMetadata, error) {
+	body := encoder{}
+	body.int32(1)
+	body.string(topic)
+
+	var lastErr error
+	for _, addr := range p.bootstrap {
+		b := broker{addr: addr}
+		response, err := b.request(apiKeyMetadata, metadataVersion, body.buf)
+		b.close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return p.parseMetadata(topic, response)
+	}
+	return nil, fmt.Errorf("Failed to fetch Kafka metadata for %s: %s", topic, lastErr)
+}
+
+func (p *Producer) parseMetadata(topic string, response []byte) (*topicMetadata, error) {
+	d := decoder{buf: response}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		if rackLength := d.int16(); rackLength > 0 && d.need(int(rackLength)) {
+			d.pos += int(rackLength)
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		if existing, ok := p.brokers[nodeID]; !ok || existing.addr != addr {
+			if ok {
+				existing.close()
+			}
+			p.brokers[nodeID] = &broker{addr: addr}
+		}
+	}
+	d.int32() // controller id
+
+	metadata := topicMetadata{leaders: map[int32]int32{}}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		errorCode := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			d.int16() // partition error code
+			partition := d.int32()
+			leader := d.int32()
+			for k, r := 0, d.arrayLength(); k < r; k++ {
+				d.int32()
+			}
+			for k, r := 0, d.arrayLength(); k < r; k++ {
+				d.int32()
+			}
+			if name == topic && leader >= 0 {
+				metadata.leaders[partition] = leader
+			}
+			if name == topic {
+				metadata.count++
+			}
+		}
+		if name == topic && errorCode != 0 {
+			return nil, kafkaError(errorCode)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if metadata.count == 0 {
+		return nil, fmt.Errorf("Kafka topic %s has no partitions", topic)
+	}
+	p.topics[topic] = &metadata
+	return &metadata, nil
+}
+
+func partitionFor(key []byte, count int) int32 {
+	if len(key) == 0 {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int32(hash.Sum32() % uint32(count))
+}
+
+// Produce writes messages to topic, partitioned by key, and returns once all
+// in-sync replicas acknowledged them
+func (p *Producer) Produce(topic string, messages []Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	metadata, ok := p.topics[topic]
+	if !ok {
+		var err error
+		if metadata, err = p.refreshMetadata(topic); err != nil {
+			return err
+		}
+	}
+
+	byPartition := map[int32][]Message{}
+	for _, message := range messages {
+		partition := partitionFor(message.Key, metadata.count)
+		byPartition[partition] = append(byPartition[partition], message)
+	}
+	for partition, partitionMessages := range byPartition {
+		if err := p.producePartition(topic, partition, metadata, partitionMessages); err != nil {
+			// Leadership may have moved, refresh on the next call
+			delete(p.topics, topic)
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Producer) producePartition(topic string, partition int32, metadata *topicMetadata, messages []Message) error {
+	leader, ok := metadata.leaders[partition]
+	if !ok {
+		return fmt.Errorf("Kafka partition %s/%d has no leader", topic, partition)
+	}
+	b, ok := p.brokers[leader]
+	if !ok {
+		return fmt.Errorf("Unknown Kafka broker %d", leader)
+	}
+
+	body := encoder{}
+	body.nullString() // transactional id
+	body.int16(-1)    // acks=all
+	body.int32(int32(requestTimeout / time.Millisecond))
+	body.int32(1)
+	body.string(topic)
+	body.int32(1)
+	body.int32(partition)
+	body.bytes(encodeRecordBatch(messages, time.Now().UnixNano()/int64(time.Millisecond)))
+
+	response, err := b.request(apiKeyProduce, produceVersion, body.buf)
+	if err != nil {
+		return err
+	}
+	d := decoder{buf: response}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			d.int32()
+			errorCode := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if errorCode != 0 {
+				return kafkaError(errorCode)
+			}
+		}
+	}
+	return d.err
+}
+
+func (p *Producer) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, b := range p.brokers {
+		b.close()
+	}
+}
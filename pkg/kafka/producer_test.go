@@ -0,0 +1,315 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// decodeRecordBatch parses a v2 record batch independently of the encoder
+func decodeRecordBatch(t *testing.T, batch []byte) []Message {
+	t.Helper()
+	if length := int(binary.BigEndian.Uint32(batch[8:])); length != len(batch)-12 {
+		t.Fatalf("Batch length %d, expected %d", length, len(batch)-12)
+	}
+	if magic := batch[16]; magic != 2 {
+		t.Fatalf("Magic %d, expected 2", magic)
+	}
+	if crc := binary.BigEndian.Uint32(batch[17:]); crc != crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) {
+		t.Fatalf("Bad batch CRC %x", crc)
+	}
+	count := int(binary.BigEndian.Uint32(batch[57:]))
+	if lastOffsetDelta := int(binary.BigEndian.Uint32(batch[23:])); lastOffsetDelta != count-1 {
+		t.Fatalf("Last offset delta %d with %d records", lastOffsetDelta, count)
+	}
+	reader := newByteReader(batch[61:])
+	var messages []Message
+	for i := 0; i < count; i++ {
+		length := reader.varint()
+		start := reader.pos
+		reader.pos++ // attributes
+		reader.varint()
+		if offsetDelta := reader.varint(); offsetDelta != int64(i) {
+			t.Fatalf("Record %d has offset delta %d", i, offsetDelta)
+		}
+		message := Message{Key: reader.varintBytes(), Value: reader.varintBytes()}
+		if headers := reader.varint(); headers != 0 {
+			t.Fatalf("Record %d has %d headers", i, headers)
+		}
+		if int64(reader.pos-start) != length {
+			t.Fatalf("Record %d length %d, read %d", i, length, reader.pos-start)
+		}
+		messages = append(messages, message)
+	}
+	if reader.pos != len(reader.buf) {
+		t.Fatalf("%d trailing batch bytes", len(reader.buf)-reader.pos)
+	}
+	return messages
+}
+
+type byteReader struct {
+	buf []byte
+	pos int
+}
+
+func newByteReader(buf []byte) *byteReader {
+	return &byteReader{buf: buf}
+}
+
+func (r *byteReader) varint() int64 {
+	value, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return value
+}
+
+func (r *byteReader) varintBytes() []byte {
+	length := r.varint()
+	if length < 0 {
+		return nil
+	}
+	value := r.buf[r.pos : r.pos+int(length)]
+	r.pos += int(length)
+	return value
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+	messages := []Message{
+		{Key: []byte("project"), Value: []byte(`{"type":"run.created"}`)},
+		{Key: nil, Value: []byte("no key")},
+		{Key: []byte("k"), Value: make([]byte, 300)},
+	}
+	decoded := decodeRecordBatch(t, encodeRecordBatch(messages, 1234))
+	if len(decoded) != len(messages) {
+		t.Fatalf("Decoded %d records, expected %d", len(decoded), len(messages))
+	}
+	for i, message := range messages {
+		if string(decoded[i].Key) != string(message.Key) || (decoded[i].Key == nil) != (message.Key == nil) {
+			t.Errorf("Record %d key %q, expected %q", i, decoded[i].Key, message.Key)
+		}
+		if string(decoded[i].Value) != string(message.Value) {
+			t.Errorf("Record %d value differs", i)
+		}
+	}
+}
+
+func TestPartitionFor(t *testing.T) {
+	if partition := partitionFor(nil, 3); partition != 0 {
+		t.Errorf("Messages without a key go to partition %d, expected 0", partition)
+	}
+	for _, key := range []string{"a", "project", "other"} {
+		first := partitionFor([]byte(key), 4)
+		if first < 0 || first >= 4 || partitionFor([]byte(key), 4) != first {
+			t.Errorf("Key %s partition %d is not stable or out of range", key, first)
+		}
+	}
+}
+
+// fakeBroker is a single node cluster answering metadata and produce requests
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	partitions int32
+	lock       sync.Mutex
+	// Produced messages by partition, and the error code of the next produce
+	produced         map[int32][]Message
+	produceError     int16
+	metadataRequests int
+}
+
+func newFakeBroker(t *testing.T, partitions int32) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, listener: listener, partitions: partitions, produced: map[int32][]Message{}}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(reader, size[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		d := decoder{buf: request}
+		apiKey, apiVersion, correlationID := d.int16(), d.int16(), d.int32()
+		if client := d.string(); client != clientID {
+			b.t.Errorf("Client id %q, expected %q", client, clientID)
+		}
+		response := encoder{}
+		response.int32(0)
+		response.int32(correlationID)
+		switch {
+		case apiKey == apiKeyMetadata && apiVersion == metadataVersion:
+			b.metadata(&d, &response)
+		case apiKey == apiKeyProduce && apiVersion == produceVersion:
+			b.produce(&d, &response)
+		default:
+			b.t.Errorf("Unexpected request %d version %d", apiKey, apiVersion)
+			return
+		}
+		if d.err != nil {
+			b.t.Errorf("Bad request: %s", d.err)
+		}
+		response.putInt32At(0, int32(len(response.buf)-4))
+		if _, err := conn.Write(response.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder, response *encoder) {
+	b.lock.Lock()
+	b.metadataRequests++
+	b.lock.Unlock()
+	topics := d.arrayLength()
+	topic := d.string()
+	host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	response.int32(1)
+	response.int32(7) // node id
+	response.string(host)
+	response.int32(int32(portNumber))
+	response.int16(-1) // rack
+	response.int32(7)  // controller id
+	response.int32(int32(topics))
+	response.int16(0)
+	response.string(topic)
+	response.int8(0)
+	response.int32(b.partitions)
+	for partition := int32(0); partition < b.partitions; partition++ {
+		response.int16(0)
+		response.int32(partition)
+		response.int32(7) // leader
+		response.int32(1)
+		response.int32(7) // replicas
+		response.int32(1)
+		response.int32(7) // isr
+	}
+}
+
+func (b *fakeBroker) produce(d *decoder, response *encoder) {
+	if transactionalID := d.int16(); transactionalID != -1 {
+		b.t.Errorf("Transactional id length %d, expected null", transactionalID)
+	}
+	if acks := d.int16(); acks != -1 {
+		b.t.Errorf("acks %d, expected -1 (all)", acks)
+	}
+	d.int32() // timeout
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	errorCode := b.produceError
+	b.produceError = 0
+	topics := d.arrayLength()
+	response.int32(int32(topics))
+	for i := 0; i < topics; i++ {
+		topic := d.string()
+		response.string(topic)
+		partitions := d.arrayLength()
+		response.int32(int32(partitions))
+		for j := 0; j < partitions; j++ {
+			partition := d.int32()
+			length := int(d.int32())
+			if !d.need(length) {
+				return
+			}
+			batch := d.buf[d.pos : d.pos+length]
+			d.pos += length
+			if errorCode == 0 {
+				b.produced[partition] = append(b.produced[partition], decodeRecordBatch(b.t, batch)...)
+			}
+			response.int32(partition)
+			response.int16(errorCode)
+			response.int64(0)
+			response.int64(-1)
+		}
+	}
+	response.int32(0) // throttle time
+}
+
+func TestProducer(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+	defer broker.listener.Close()
+	producer := NewProducer([]string{broker.listener.Addr().String()})
+	defer producer.Close()
+
+	messages := []Message{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("a"), Value: []byte("3")}}
+	if err := producer.Produce("events", messages); err != nil {
+		t.Fatal(err)
+	}
+	broker.lock.Lock()
+	total := 0
+	for partition, produced := range broker.produced {
+		total += len(produced)
+		for _, message := range produced {
+			if expected := partitionFor(message.Key, 3); expected != partition {
+				t.Errorf("Message %s with key %s produced to partition %d, expected %d", message.Value, message.Key, partition, expected)
+			}
+		}
+	}
+	// Messages of a key keep their order
+	if produced := broker.produced[partitionFor([]byte("a"), 3)]; len(produced) < 2 || string(produced[0].Value) != "1" {
+		t.Errorf("Messages of key a are out of order: %v", produced)
+	}
+	broker.produceError = 6 // NOT_LEADER_FOR_PARTITION
+	broker.lock.Unlock()
+	if total != len(messages) {
+		t.Fatalf("Broker got %d messages, expected %d", total, len(messages))
+	}
+
+	err := producer.Produce("events", messages[:1])
+	if err == nil || err.Error() != "Kafka error 6 (NOT_LEADER_FOR_PARTITION)" {
+		t.Fatalf("Produce error %v, expected NOT_LEADER_FOR_PARTITION", err)
+	}
+	// The metadata is refreshed after a failure
+	if err = producer.Produce("events", messages[:1]); err != nil {
+		t.Fatal(err)
+	}
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	if broker.metadataRequests != 2 {
+		t.Errorf("%d metadata requests, expected 2", broker.metadataRequests)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	apiKeyProduce  = 0
+	apiKeyMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder builds Kafka protocol messages (big endian, length prefixed strings)
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) putInt32At(offset int, v int32) {
+	binary.BigEndian.PutUint32(e.buf[offset:], uint32(v))
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) need(n int) bool {
+	if d.err != nil {
+		return false
+	}
+	if d.pos+n > len(d.buf) {
+		d.err = fmt.Errorf("Kafka response truncated")
+		return false
+	}
+	return true
+}
+
+func (d *decoder) int8() int8 {
+	if !d.need(1) {
+		return 0
+	}
+	d.pos++
+	return int8(d.buf[d.pos-1])
+}
+
+func (d *decoder) int16() int16 {
+	if !d.need(2) {
+		return 0
+	}
+	d.pos += 2
+	return int16(binary.BigEndian.Uint16(d.buf[d.pos-2:]))
+}
+
+func (d *decoder) int32() int32 {
+	if !d.need(4) {
+		return 0
+	}
+	d.pos += 4
+	return int32(binary.BigEndian.Uint32(d.buf[d.pos-4:]))
+}
+
+func (d *decoder) int64() int64 {
+	if !d.need(8) {
+		return 0
+	}
+	d.pos += 8
+	return int64(binary.BigEndian.Uint64(d.buf[d.pos-8:]))
+}
+
+func (d *decoder) string() string {
+	length := int(d.int16())
+	if length < 0 || !d.need(length) {
+		return ""
+	}
+	d.pos += length
+	return string(d.buf[d.pos-length : d.pos])
+}
+
+func (d *decoder) arrayLength() int {
+	length := int(d.int32())
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// encodeRecordBatch encodes messages as a v2 record batch (Kafka 0.11+)
+func encodeRecordBatch(messages []Message, timestamp int64) []byte {
+	e := encoder{}
+	e.int64(0)  // base offset
+	e.int32(0)  // batch length, set below
+	e.int32(-1) // partition leader epoch
+	e.int8(2)   // magic
+	crcOffset := len(e.buf)
+	e.int32(0) // crc, set below
+	e.int16(0) // attributes: no compression
+	e.int32(int32(len(messages) - 1))
+	e.int64(timestamp)
+	e.int64(timestamp)
+	e.int64(-1) // producer id
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(int32(len(messages)))
+	for i, message := range messages {
+		record := encoder{}
+		record.int8(0)   // attributes
+		record.varint(0) // timestamp delta
+		record.varint(int64(i))
+		record.varintBytes(message.Key)
+		record.varintBytes(message.Value)
+		record.varint(0) // headers
+		e.varint(int64(len(record.buf)))
+		e.buf = append(e.buf, record.buf...)
+	}
+	e.putInt32At(8, int32(len(e.buf)-12))
+	e.putInt32At(crcOffset, int32(crc32.Checksum(e.buf[crcOffset+4:], castagnoliTable)))
+	return e.buf
+}
+
+var errorNames = map[int16]string{
+	1:  "OFFSET_OUT_OF_RANGE",
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_FOR_PARTITION",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+}
+
+func kafkaError(code int16) error {
+	if name, ok := errorNames[code]; ok {
+		return fmt.Errorf("Kafka error %d (%s)", code, name)
+	}
+	return fmt.Errorf("Kafka error %d", code)
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	requestTimeout    = 30 * time.Second
+
+	watchTimeoutSeconds = 300
+)
+
+// Config selects the Kubernetes cluster runs are launched on, empty fields are
+// taken from the in-cluster service account
+type Config struct {
+	APIServer string
+	TokenFile string
+	CAFile    string
+	Namespace string
+	Insecure  bool
+
+	// Image used when the function has none
+	DefaultImage string
+	// Passed to the runs as MLRUN_DBPATH so they can report back
+	DBPath string
+	// Secrets the functions of every project may reference (e.g. registry or
+	// v3io credentials), other secrets must be owned by the run project
+	SharedSecrets []string
+	// Service accounts functions may run as, functions with no service account
+	// run as the namespace default
+	ServiceAccounts []string
+	// Node directories (and their subdirectories) functions may mount as
+	// hostPath volumes
+	HostPaths []string
+}
+
+// Client sends the launcher requests through a client-go REST client, which
+// handles the cluster config, token rotation, TLS and rate limiting. The
+// objects are the JSON subsets in types.go, so CRDs need no generated types
+type Client struct {
+	namespace string
+	rest      rest.Interface
+}
+
+// The scheme only decodes the API Status objects of failed requests
+var statusCodecs = serializer.NewCodecFactory(newStatusScheme())
+
+func newStatusScheme() *k8sruntime.Scheme {
+	scheme := k8sruntime.NewScheme()
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
+	return scheme
+}
+
+// IsNotFound returns true if err is a Kubernetes 404 error
+func IsNotFound(err error) bool {
+	return apierrors.IsNotFound(err)
+}
+
+// IsConflict returns true if err is a Kubernetes 409 error (e.g. already exists)
+func IsConflict(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
+
+func NewClient(cfg *Config) (*Client, error) {
+	config, err := restConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config.ContentType = "application/json"
+	config.NegotiatedSerializer = statusCodecs.WithoutConversion()
+	restClient, err := rest.UnversionedRESTClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := Client{namespace: cfg.Namespace, rest: restClient}
+	if client.namespace == "" {
+		client.namespace = "default"
+		if data, err := ioutil.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+			client.namespace = strings.TrimSpace(string(data))
+		}
+	}
+	return &client, nil
+}
+
+// restConfig returns the in-cluster config unless an API server is given,
+// the service account token and CA are the defaults of the given server too
+func restConfig(cfg *Config) (*rest.Config, error) {
+	var config *rest.Config
+	if cfg.APIServer == "" {
+		var err error
+		if config, err = rest.InClusterConfig(); err != nil {
+			if err == rest.ErrNotInCluster {
+				return nil, fmt.Errorf("Not running in a Kubernetes cluster and no API server given")
+			}
+			return nil, err
+		}
+	} else {
+		config = &rest.Config{Host: cfg.APIServer, BearerTokenFile: serviceAccountDir + "/token"}
+		if _, err := os.Stat(config.BearerTokenFile); err != nil {
+			config.BearerTokenFile = ""
+		}
+		if _, err := os.Stat(serviceAccountDir + "/ca.crt"); err == nil {
+			config.TLSClientConfig.CAFile = serviceAccountDir + "/ca.crt"
+		}
+	}
+	if cfg.TokenFile != "" {
+		config.BearerToken = ""
+		config.BearerTokenFile = cfg.TokenFile
+	}
+	if cfg.CAFile != "" {
+		if _, err := os.Stat(cfg.CAFile); err != nil {
+			return nil, err
+		}
+		config.TLSClientConfig.CAFile = cfg.CAFile
+	}
+	if cfg.Insecure {
+		// client-go refuses a CA together with skipping verification
+		config.TLSClientConfig = rest.TLSClientConfig{Insecure: true}
+	}
+	return config, nil
+}
+
+// Namespace returns the namespace runs are launched in
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Do sends a request to the API server, body and out are JSON encoded/decoded
+// when not nil
+func (c *Client) Do(method, path string, query url.Values, body, out interface{}) error {
+	data, err := c.DoRaw(method, path, query, body)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// DoRaw sends a request to the API server and returns the response body
+func (c *Client) DoRaw(method, path string, query url.Values, body interface{}) ([]byte, error) {
+	request, err := c.request(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	result := request.Timeout(requestTimeout).Do()
+	if err := result.Error(); err != nil {
+		return nil, err
+	}
+	return result.Raw()
+}
+
+// Stream sends a GET request and returns the response body as it arrives,
+// for watches and followed logs
+func (c *Client) Stream(path string, query url.Values) (io.ReadCloser, error) {
+	request, err := c.request("GET", path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return request.Stream()
+}
+
+func (c *Client) request(method, path string, query url.Values, body interface{}) (*rest.Request, error) {
+	request := c.rest.Verb(method).AbsPath(path)
+	for key, values := range query {
+		for _, value := range values {
+			request.Param(key, value)
+		}
+	}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		request.Body(data)
+	}
+	return request, nil
+}
+
+// WatchEvent is a change notification of the Kubernetes watch API
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Watch streams the changes of the objects under path from resourceVersion
+// to handle, until the server closes the watch or an error occurs
+func (c *Client) Watch(path string, query url.Values, resourceVersion string, handle func(*WatchEvent) error) error {
+	watchQuery := url.Values{}
+	for key, values := range query {
+		watchQuery[key] = values
+	}
+	watchQuery.Set("watch", "true")
+	watchQuery.Set("resourceVersion", resourceVersion)
+	watchQuery.Set("timeoutSeconds", strconv.Itoa(watchTimeoutSeconds))
+	body, err := c.Stream(path, watchQuery)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	decoder := json.NewDecoder(body)
+	for {
+		event := WatchEvent{}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			status := metav1.Status{}
+			json.Unmarshal(event.Object, &status)
+			return &apierrors.StatusError{ErrStatus: status}
+		}
+		if err := handle(&event); err != nil {
+			return err
+		}
+	}
+}
+
+func setFrom(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/ns/pods/found":
+			if r.URL.Query().Get("labelSelector") != "a=b" {
+				t.Errorf("Query %s is missing the label selector", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"metadata":{"name":"found"}}`)
+		case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/ns/pods":
+			body, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"metadata":{"name":"exists"}`) {
+				t.Errorf("Unexpected body %s", body)
+			}
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"pods \"exists\" already exists","reason":"AlreadyExists","code":409}`)
+		case r.URL.Query().Get("watch") == "true":
+			fmt.Fprint(w, `{"type":"ADDED","object":{"metadata":{"name":"p1"}}}`+"\n")
+			fmt.Fprint(w, `{"type":"ERROR","object":{"kind":"Status","status":"Failure","message":"too old resource version","reason":"Gone","code":410}}`+"\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"not found","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&Config{APIServer: server.URL, Namespace: "ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := Pod{}
+	if err = client.Do("GET", "/api/v1/namespaces/ns/pods/found", url.Values{"labelSelector": {"a=b"}}, nil, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Name != "found" {
+		t.Errorf("Got pod %q, expected found", pod.Metadata.Name)
+	}
+	if err = client.Do("GET", "/api/v1/namespaces/ns/pods/missing", nil, nil, &pod); !IsNotFound(err) {
+		t.Errorf("Missing pod error %v is not a not found error", err)
+	}
+	err = client.Do("POST", "/api/v1/namespaces/ns/pods", nil, &Pod{Metadata: ObjectMeta{Name: "exists"}}, nil)
+	if !IsConflict(err) || IsNotFound(err) {
+		t.Errorf("Existing pod error %v is not a conflict", err)
+	}
+
+	var names []string
+	err = client.Watch("/api/v1/namespaces/ns/pods", nil, "1", func(event *WatchEvent) error {
+		names = append(names, event.Type)
+		return nil
+	})
+	if len(names) != 1 || names[0] != "ADDED" {
+		t.Errorf("Watched events %v, expected ADDED", names)
+	}
+	if err == nil || err.Error() != "too old resource version" {
+		t.Errorf("Watch error %v, expected the status message", err)
+	}
+}
@@ -0,0 +1,449 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	LabelClass   = "mlrun/class"
+	LabelProject = "mlrun/project"
+	LabelUID     = "mlrun/uid"
+	LabelName    = "mlrun/name"
+
+	containerName = "base"
+)
+
+// Secondary pods are labeled with the run uid too, the role tells them apart
+const (
+	LabelRole    = "mlrun/role"
+	RoleWorker   = "worker"
+	RoleExecutor = "executor"
+)
+
+// AnnotationNamespace keeps the storage namespace of the run of a pod
+const AnnotationNamespace = "mlrun/namespace"
+
+// Function kinds the launcher runs
+const (
+	KindJob    = "job"
+	KindMPIJob = "mpijob"
+	KindSpark  = "spark"
+)
+
+// Run states reported by the launcher, these are the mlrun run states
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateError     = "error"
+	StateAborted   = "aborted"
+)
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// Run is a run submitted for execution
+type Run struct {
+	// Namespace is the storage namespace of the run, empty for the default
+	Namespace string
+	Project   string
+	UID       string
+	Name      string
+	Function  *common.Function
+	// The run object, passed to the mlrun CLI in the pod
+	Object json.RawMessage
+}
+
+// RunStatus is the state of a run as seen from its job or pod
+type RunStatus struct {
+	Namespace string
+	Project   string
+	UID       string
+	Job       string
+	Pod       string
+	// Class is the function kind, Role is set for pods that are not the main
+	// pod of the run (e.g. MPI workers, Spark executors)
+	Class   string
+	Role    string
+	State   string
+	Message string
+	// Containers of the pod, for log collection
+	Containers []string
+}
+
+// SecretInjector returns environment variables referencing project secrets
+type SecretInjector interface {
+	EnvVars(project string, keys []string) []EnvVar
+}
+
+// Launcher runs functions as Kubernetes jobs
+type Launcher struct {
+	client  *Client
+	cfg     *Config
+	secrets SecretInjector
+}
+
+func NewLauncher(client *Client, cfg *Config) *Launcher {
+	return &Launcher{client: client, cfg: cfg}
+}
+
+// SetSecrets sets where the project secrets functions reference come from
+func (l *Launcher) SetSecrets(secrets SecretInjector) {
+	l.secrets = secrets
+}
+
+// Client returns the Kubernetes client the launcher uses
+func (l *Launcher) Client() *Client {
+	return l.client
+}
+
+func (l *Launcher) jobsPath() string {
+	return fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", l.client.Namespace())
+}
+
+// sanitize makes value a valid Kubernetes name or label value
+func sanitize(value string, maxLength int) string {
+	value = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(value), "-"), "-")
+	if len(value) > maxLength {
+		value = strings.TrimRight(value[:maxLength], "-")
+	}
+	return value
+}
+
+// SanitizeName makes value a valid Kubernetes label value, e.g. to select the
+// resources of a project
+func SanitizeName(value string) string {
+	return sanitize(value, 63)
+}
+
+// JobName returns the name of the job of a run
+func JobName(name, uid string) string {
+	if len(uid) > 8 {
+		uid = uid[:8]
+	}
+	return sanitize(fmt.Sprintf("mlrun-%s-%s", sanitize(name, 40), uid), 63)
+}
+
+// Launch creates the Kubernetes resource running a run and returns its name,
+// the function kind selects the resource (a job by default)
+func (l *Launcher) Launch(run *Run) (string, error) {
+	if common.IsNuclio(run.Function.Kind) {
+		return "", fmt.Errorf("Function %s of kind %s is deployed, not run", run.Function.Metadata.Name, run.Function.Kind)
+	}
+	switch run.Function.Kind {
+	case KindMPIJob:
+		return l.launchMPIJob(run)
+	case KindSpark:
+		return l.launchSparkApplication(run)
+	}
+	job, err := l.newJob(run)
+	if err != nil {
+		return "", err
+	}
+	if err = l.client.Do("POST", l.jobsPath(), nil, job, nil); err != nil {
+		return "", err
+	}
+	return job.Metadata.Name, nil
+}
+
+// runTemplate returns the pod template running the function of a run, the
+// pods are labeled with the run identity and class
+func (l *Launcher) runTemplate(run *Run, class string) (*PodTemplate, error) {
+	spec := &run.Function.Spec
+	image := spec.Image
+	if image == "" {
+		image = setFrom(spec.Build.Image, l.cfg.DefaultImage)
+	}
+	if image == "" {
+		return nil, fmt.Errorf("Function %s has no image", run.Function.Metadata.Name)
+	}
+
+	if err := run.Function.Validate(); err != nil {
+		return nil, fmt.Errorf("Bad function %s spec: %s", run.Function.Metadata.Name, err)
+	}
+	if err := l.checkSecretRefs(run); err != nil {
+		return nil, err
+	}
+	if err := l.checkNodeAccess(run); err != nil {
+		return nil, err
+	}
+	env := append([]EnvVar{}, spec.Env...)
+	if len(spec.Secrets) > 0 {
+		if l.secrets == nil {
+			return nil, fmt.Errorf("Function %s uses secrets but no secrets provider is configured", run.Function.Metadata.Name)
+		}
+		env = append(env, l.secrets.EnvVars(run.Project, spec.Secrets)...)
+	}
+	env = append(env, EnvVar{Name: "MLRUN_EXEC_CONFIG", Value: string(run.Object)})
+	if l.cfg.DBPath != "" {
+		env = append(env, EnvVar{Name: "MLRUN_DBPATH", Value: l.cfg.DBPath})
+	}
+
+	// "pass" mode runs the command as is, otherwise it is wrapped by the mlrun
+	// CLI. Non python runtimes have no mlrun CLI, with no command their image
+	// entrypoint gets the args
+	var command, args []string
+	python := spec.Runtime == "" || spec.Runtime == "python"
+	if spec.Mode != "pass" && python {
+		command = []string{"mlrun", "run", "--name", run.Name, "--from-env"}
+	}
+	if spec.Command != "" {
+		command = append(command, spec.Command)
+	}
+	if len(command) > 0 || python {
+		command = append(command, spec.Args...)
+	} else {
+		args = spec.Args
+	}
+	if len(command) == 0 && python {
+		return nil, fmt.Errorf("Function %s has no command", run.Function.Metadata.Name)
+	}
+
+	// The registry secret the image was pushed with pulls it too
+	var pullSecrets []LocalObject
+	if spec.Build.Secret != "" {
+		pullSecrets = []LocalObject{{Name: spec.Build.Secret}}
+	}
+
+	labels := map[string]string{
+		LabelClass:   class,
+		LabelProject: sanitize(run.Project, 63),
+		LabelUID:     sanitize(run.UID, 63),
+		LabelName:    sanitize(run.Name, 63),
+	}
+	// Label values are restricted, the annotations keep the exact run identity
+	annotations := map[string]string{LabelProject: run.Project, LabelUID: run.UID}
+	if run.Namespace != "" {
+		annotations[AnnotationNamespace] = run.Namespace
+	}
+	return &PodTemplate{
+		Metadata: ObjectMeta{Labels: labels, Annotations: annotations},
+		Spec: PodSpec{
+			RestartPolicy:      "Never",
+			ServiceAccountName: spec.ServiceAccount,
+			Volumes:            spec.Volumes,
+			Containers: []Container{{
+				Name:            containerName,
+				Image:           image,
+				Command:         command,
+				Args:            args,
+				Env:             env,
+				VolumeMounts:    spec.VolumeMounts,
+				Resources:       spec.Resources,
+				ImagePullPolicy: spec.ImagePullPolicy,
+			}},
+			ImagePullSecrets: pullSecrets,
+		},
+	}, nil
+}
+
+// checkSecretRefs rejects functions referencing the Kubernetes secrets of
+// other projects
+func (l *Launcher) checkSecretRefs(run *Run) error {
+	for _, name := range run.Function.Spec.SecretRefs() {
+		if err := CheckSecretAccess(l.client, l.cfg.SharedSecrets, run.Project, name); err != nil {
+			return fmt.Errorf("Function %s can not use secret %s: %s", run.Function.Metadata.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// checkNodeAccess rejects service accounts and host paths that are not
+// allowed, they give the run the privileges of the account or the node
+func (l *Launcher) checkNodeAccess(run *Run) error {
+	spec := &run.Function.Spec
+	if account := spec.ServiceAccount; account != "" && !contains(l.cfg.ServiceAccounts, account) {
+		return fmt.Errorf("Function %s can not run as service account %s, it is not allowed", run.Function.Metadata.Name, account)
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil && !allowedHostPath(l.cfg.HostPaths, volume.HostPath.Path) {
+			return fmt.Errorf("Function %s can not mount host path %s, it is not allowed", run.Function.Metadata.Name, volume.HostPath.Path)
+		}
+	}
+	return nil
+}
+
+// allowedHostPath returns true if hostPath is one of the allowed directories
+// or under one
+func allowedHostPath(allowed []string, hostPath string) bool {
+	if !path.IsAbs(hostPath) {
+		return false
+	}
+	hostPath = path.Clean(hostPath)
+	for _, dir := range allowed {
+		dir = path.Clean(dir)
+		if hostPath == dir || strings.HasPrefix(hostPath, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSecretAccess fails unless a project may use a Kubernetes secret, the
+// shared secrets or those owned by the project of their mlrun/project
+// annotation (as set by the secrets store)
+func CheckSecretAccess(client *Client, shared []string, project, name string) error {
+	if contains(shared, name) {
+		return nil
+	}
+	secret := struct {
+		Metadata ObjectMeta `json:"metadata"`
+	}{}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", client.Namespace(), url.PathEscape(name))
+	err := client.Do("GET", path, nil, nil, &secret)
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	if err != nil || secret.Metadata.Annotations[LabelProject] != project {
+		return fmt.Errorf("The secret is not a secret of project %s", project)
+	}
+	return nil
+}
+
+func (l *Launcher) newJob(run *Run) (*Job, error) {
+	template, err := l.runTemplate(run, KindJob)
+	if err != nil {
+		return nil, err
+	}
+	backoffLimit := 0
+	return &Job{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: ObjectMeta{
+			Name:        JobName(run.Name, run.UID),
+			Namespace:   l.client.Namespace(),
+			Labels:      template.Metadata.Labels,
+			Annotations: template.Metadata.Annotations,
+		},
+		Spec: JobSpec{BackoffLimit: &backoffLimit, Template: *template},
+	}, nil
+}
+
+// Delete removes the jobs (and pods) of a run
+func (l *Launcher) Delete(uid string) error {
+	query := url.Values{
+		"labelSelector":     {LabelUID + "=" + sanitize(uid, 63)},
+		"propagationPolicy": {"Background"},
+	}
+	if err := l.client.Do("DELETE", l.jobsPath(), query, nil, nil); err != nil {
+		return err
+	}
+	// The MPI and Spark operators may not be installed
+	for _, path := range []string{l.mpiJobsPath(), l.sparkApplicationsPath()} {
+		if err := l.client.Do("DELETE", path, query, nil, nil); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DockerConfig returns the docker config.json held by an image pull secret
+// (of type dockerconfigjson or the legacy dockercfg)
+func DockerConfig(client *Client, secretName string) ([]byte, error) {
+	secret := struct {
+		Data map[string][]byte `json:"data"`
+	}{}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", client.Namespace(), secretName)
+	if err := client.Do("GET", path, nil, nil, &secret); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{".dockerconfigjson", ".dockercfg"} {
+		if data, ok := secret.Data[key]; ok {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("Secret %s holds no docker config", secretName)
+}
+
+// JobState maps the job status to a run state
+func JobState(job *Job) (string, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+		switch condition.Type {
+		case "Failed":
+			return StateError, strings.TrimSpace(condition.Reason + " " + condition.Message)
+		case "Complete":
+			return StateCompleted, ""
+		}
+	}
+	if job.Status.Active > 0 {
+		return StateRunning, ""
+	}
+	return StatePending, ""
+}
+
+func jobStatus(job *Job) RunStatus {
+	state, message := JobState(job)
+	return RunStatus{
+		Project: setFrom(job.Metadata.Annotations[LabelProject], job.Metadata.Labels[LabelProject]),
+		UID:     setFrom(job.Metadata.Annotations[LabelUID], job.Metadata.Labels[LabelUID]),
+		Job:     job.Metadata.Name,
+		State:   state,
+		Message: message,
+	}
+}
+
+// Status returns the status of the resource of a run, by function kind
+func (l *Launcher) Status(kind, name string) (*RunStatus, error) {
+	switch kind {
+	case KindMPIJob:
+		return l.mpiJobStatus(name)
+	case KindSpark:
+		return l.sparkApplicationStatus(name)
+	}
+	job := Job{}
+	if err := l.client.Do("GET", l.jobsPath()+"/"+name, nil, nil, &job); err != nil {
+		return nil, err
+	}
+	status := jobStatus(&job)
+	return &status, nil
+}
+
+// List returns the status of all run jobs
+func (l *Launcher) List() ([]RunStatus, error) {
+	jobs := JobList{}
+	query := url.Values{"labelSelector": {LabelClass + "=" + KindJob}}
+	if err := l.client.Do("GET", l.jobsPath(), query, nil, &jobs); err != nil {
+		return nil, err
+	}
+	var statuses []RunStatus
+	for i := range jobs.Items {
+		statuses = append(statuses, jobStatus(&jobs.Items[i]))
+	}
+	return statuses, nil
+}
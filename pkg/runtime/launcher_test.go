@@ -0,0 +1,55 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"github.com/mlrun/controller/pkg/common"
+	"testing"
+)
+
+func TestCheckNodeAccess(t *testing.T) {
+	launcher := NewLauncher(nil, &Config{ServiceAccounts: []string{"mlrun-runner"}, HostPaths: []string{"/mnt/data/"}})
+	for _, test := range []struct {
+		account  string
+		hostPath string
+		allowed  bool
+	}{
+		{"", "", true},
+		{"mlrun-runner", "", true},
+		{"cluster-admin", "", false},
+		{"", "/mnt/data", true},
+		{"", "/mnt/data/project/files", true},
+		{"", "/mnt/data/../../etc", false},
+		{"", "/mnt/database", false},
+		{"", "/var/run/docker.sock", false},
+		{"", "mnt/data", false},
+	} {
+		function := &common.Function{}
+		function.Metadata.Name = "f1"
+		function.Spec.ServiceAccount = test.account
+		if test.hostPath != "" {
+			function.Spec.Volumes = []common.Volume{{Name: "v", HostPath: &common.HostPathVolumeSource{Path: test.hostPath}}}
+		}
+		err := launcher.checkNodeAccess(&Run{Project: "p1", Function: function})
+		if (err == nil) != test.allowed {
+			t.Errorf("Service account %q and host path %q: got error %v, allowed %v", test.account, test.hostPath, err, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const mpiAPIVersion = "kubeflow.org/v1alpha2"
+
+// MPIJob is the Kubeflow MPI operator resource
+type MPIJob struct {
+	APIVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   ObjectMeta   `json:"metadata"`
+	Spec       MPIJobSpec   `json:"spec"`
+	Status     MPIJobStatus `json:"status,omitempty"`
+}
+
+type MPIJobSpec struct {
+	SlotsPerWorker  *int                    `json:"slotsPerWorker,omitempty"`
+	CleanPodPolicy  string                  `json:"cleanPodPolicy,omitempty"`
+	MPIReplicaSpecs map[string]*ReplicaSpec `json:"mpiReplicaSpecs"`
+}
+
+type ReplicaSpec struct {
+	Replicas *int        `json:"replicas,omitempty"`
+	Template PodTemplate `json:"template"`
+}
+
+type MPIJobStatus struct {
+	Conditions      []JobCondition           `json:"conditions,omitempty"`
+	ReplicaStatuses map[string]ReplicaStatus `json:"replicaStatuses,omitempty"`
+}
+
+type ReplicaStatus struct {
+	Active    int `json:"active,omitempty"`
+	Succeeded int `json:"succeeded,omitempty"`
+	Failed    int `json:"failed,omitempty"`
+}
+
+func (l *Launcher) mpiJobsPath() string {
+	return fmt.Sprintf("/apis/kubeflow.org/v1alpha2/namespaces/%s/mpijobs", l.client.Namespace())
+}
+
+// launchMPIJob runs the function command with mpirun on a launcher pod, over
+// spec.replicas workers which get the function resources (e.g. GPUs)
+func (l *Launcher) launchMPIJob(run *Run) (string, error) {
+	template, err := l.runTemplate(run, KindMPIJob)
+	if err != nil {
+		return "", err
+	}
+	replicas := run.Function.Spec.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	worker := *template
+	worker.Metadata.Labels = copyMap(template.Metadata.Labels)
+	worker.Metadata.Labels[LabelRole] = RoleWorker
+	worker.Spec.Containers = []Container{template.Spec.Containers[0]}
+	worker.Spec.Containers[0].Command = nil
+	worker.Spec.RestartPolicy = ""
+
+	launcher := *template
+	launcher.Spec.Containers = []Container{template.Spec.Containers[0]}
+	container := &launcher.Spec.Containers[0]
+	command := []string{"mpirun", "--allow-run-as-root", "-np", strconv.Itoa(replicas),
+		"-bind-to", "none", "-map-by", "slot", "-x", "NCCL_DEBUG=INFO", "-x", "LD_LIBRARY_PATH", "-x", "PATH"}
+	for _, env := range container.Env {
+		if env.ValueFrom == nil {
+			command = append(command, "-x", env.Name)
+		}
+	}
+	container.Command = append(command, container.Command...)
+	container.Resources = nil
+	launcher.Spec.RestartPolicy = ""
+
+	launcherReplicas := 1
+	slotsPerWorker := 1
+	job := MPIJob{
+		APIVersion: mpiAPIVersion,
+		Kind:       "MPIJob",
+		Metadata: ObjectMeta{
+			Name:        JobName(run.Name, run.UID),
+			Namespace:   l.client.Namespace(),
+			Labels:      template.Metadata.Labels,
+			Annotations: template.Metadata.Annotations,
+		},
+		Spec: MPIJobSpec{
+			SlotsPerWorker: &slotsPerWorker,
+			CleanPodPolicy: "Running",
+			MPIReplicaSpecs: map[string]*ReplicaSpec{
+				"Launcher": {Replicas: &launcherReplicas, Template: launcher},
+				"Worker":   {Replicas: &replicas, Template: worker},
+			},
+		},
+	}
+	if err = l.client.Do("POST", l.mpiJobsPath(), nil, &job, nil); err != nil {
+		return "", err
+	}
+	return job.Metadata.Name, nil
+}
+
+func copyMap(values map[string]string) map[string]string {
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	return result
+}
+
+// MPIJobState aggregates the launcher and worker statuses into a run state,
+// a failed worker fails the run
+func MPIJobState(job *MPIJob) (string, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+		switch condition.Type {
+		case "Failed":
+			return StateError, strings.TrimSpace(condition.Reason + " " + condition.Message)
+		case "Succeeded":
+			return StateCompleted, ""
+		}
+	}
+	launcher := job.Status.ReplicaStatuses["Launcher"]
+	worker := job.Status.ReplicaStatuses["Worker"]
+	if launcher.Failed > 0 {
+		return StateError, "launcher failed"
+	}
+	if worker.Failed > 0 {
+		return StateError, fmt.Sprintf("%d workers failed", worker.Failed)
+	}
+	if launcher.Succeeded > 0 {
+		return StateCompleted, ""
+	}
+	if launcher.Active > 0 || worker.Active > 0 {
+		return StateRunning, fmt.Sprintf("%d workers active", worker.Active)
+	}
+	return StatePending, ""
+}
+
+func (l *Launcher) mpiJobStatus(name string) (*RunStatus, error) {
+	job := MPIJob{}
+	if err := l.client.Do("GET", l.mpiJobsPath()+"/"+name, nil, nil, &job); err != nil {
+		return nil, err
+	}
+	state, message := MPIJobState(&job)
+	return &RunStatus{
+		Project: setFrom(job.Metadata.Annotations[LabelProject], job.Metadata.Labels[LabelProject]),
+		UID:     setFrom(job.Metadata.Annotations[LabelUID], job.Metadata.Labels[LabelUID]),
+		Job:     job.Metadata.Name,
+		State:   state,
+		Message: message,
+	}, nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const watchRetryInterval = 5 * time.Second
+
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Containers []struct {
+			Name string `json:"name"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status PodStatus `json:"status"`
+}
+
+type PodStatus struct {
+	Phase             string            `json:"phase"`
+	Reason            string            `json:"reason,omitempty"`
+	Message           string            `json:"message,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+type ContainerStatus struct {
+	Name  string         `json:"name"`
+	State ContainerState `json:"state"`
+}
+
+type ContainerState struct {
+	Waiting    *ContainerStateReason `json:"waiting,omitempty"`
+	Terminated *ContainerStateReason `json:"terminated,omitempty"`
+}
+
+type ContainerStateReason struct {
+	ExitCode int    `json:"exitCode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type PodList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []Pod `json:"items"`
+}
+
+// PodState maps the pod phase to a run state, failures carry the reason
+func PodState(pod *Pod) (string, string) {
+	switch pod.Status.Phase {
+	case "Running":
+		return StateRunning, ""
+	case "Succeeded":
+		return StateCompleted, ""
+	case "Failed":
+		reasons := []string{}
+		if pod.Status.Reason != "" {
+			reasons = append(reasons, strings.TrimSpace(pod.Status.Reason+" "+pod.Status.Message))
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if terminated := container.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				reasons = append(reasons, fmt.Sprintf("container %s: %s (exit code %d) %s",
+					container.Name, terminated.Reason, terminated.ExitCode, terminated.Message))
+			}
+		}
+		if len(reasons) == 0 {
+			reasons = append(reasons, "pod failed")
+		}
+		return StateError, strings.TrimSpace(strings.Join(reasons, ", "))
+	}
+	return StatePending, ""
+}
+
+func podStatus(pod *Pod) RunStatus {
+	state, message := PodState(pod)
+	status := RunStatus{
+		Namespace: pod.Metadata.Annotations[AnnotationNamespace],
+		Project:   setFrom(pod.Metadata.Annotations[LabelProject], pod.Metadata.Labels[LabelProject]),
+		UID:       setFrom(pod.Metadata.Annotations[LabelUID], pod.Metadata.Labels[LabelUID]),
+		Pod:       pod.Metadata.Name,
+		Class:     pod.Metadata.Labels[LabelClass],
+		Role:      pod.Metadata.Labels[LabelRole],
+		State:     state,
+		Message:   message,
+	}
+	for _, container := range pod.Spec.Containers {
+		status.Containers = append(status.Containers, container.Name)
+	}
+	return status
+}
+
+// PodLogs returns the logs of the pod containers, each preceded by a header
+// line when the pod has more than one container
+func PodLogs(client *Client, pod string, containers []string) ([]byte, error) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", client.Namespace(), pod)
+	var logs []byte
+	for _, container := range containers {
+		data, err := client.DoRaw("GET", path, url.Values{"container": {container}}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(containers) > 1 {
+			logs = append(logs, fmt.Sprintf("==> container %s <==\n", container)...)
+		}
+		logs = append(logs, data...)
+	}
+	return logs, nil
+}
+
+// FollowPodLog copies the log of a single container pod to out until the
+// container terminates
+func FollowPodLog(client *Client, pod string, out io.Writer) error {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", client.Namespace(), pod)
+	body, err := client.Stream(path, url.Values{"follow": {"true"}})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(out, body)
+	return err
+}
+
+// PodWatcher reports state changes of the pods labeled with a run uid
+type PodWatcher struct {
+	client *Client
+	states map[string]string
+}
+
+func NewPodWatcher(client *Client) *PodWatcher {
+	return &PodWatcher{client: client, states: map[string]string{}}
+}
+
+func (w *PodWatcher) podsPath() string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/pods", w.client.Namespace())
+}
+
+// Watch calls update on every run pod state change, it lists the pods and then
+// follows their changes, listing again when the watch expires
+func (w *PodWatcher) Watch(update func(*RunStatus)) {
+	query := url.Values{"labelSelector": {LabelUID}}
+	for {
+		pods := PodList{}
+		if err := w.client.Do("GET", w.podsPath(), query, nil, &pods); err != nil {
+			fmt.Printf("Failed to list run pods: %s\n", err)
+			time.Sleep(watchRetryInterval)
+			continue
+		}
+		for i := range pods.Items {
+			w.observe(&pods.Items[i], false, update)
+		}
+
+		resourceVersion := pods.Metadata.ResourceVersion
+		for {
+			err := w.client.Watch(w.podsPath(), query, resourceVersion, func(event *WatchEvent) error {
+				pod := Pod{}
+				if err := json.Unmarshal(event.Object, &pod); err != nil {
+					return err
+				}
+				resourceVersion = pod.Metadata.ResourceVersion
+				w.observe(&pod, event.Type == "DELETED", update)
+				return nil
+			})
+			if err != nil {
+				// Expired resource versions (410 Gone) and failures restart from a list
+				fmt.Printf("Pod watch ended: %s\n", err)
+				time.Sleep(watchRetryInterval)
+				break
+			}
+		}
+	}
+}
+
+func (w *PodWatcher) observe(pod *Pod, deleted bool, update func(*RunStatus)) {
+	status := podStatus(pod)
+	if deleted {
+		delete(w.states, status.Pod)
+		return
+	}
+	if w.states[status.Pod] == status.State || status.UID == "" {
+		return
+	}
+	w.states[status.Pod] = status.State
+	update(&status)
+}
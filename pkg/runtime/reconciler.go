@@ -0,0 +1,283 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"strings"
+	"time"
+)
+
+const (
+	CRDGroup    = "mlrun.org"
+	CRDVersion  = "v1alpha1"
+	CRDKind     = "Run"
+	CRDResource = "runs"
+
+	runFinalizer = "mlrun.org/run-record"
+)
+
+// RunResource is the Run custom resource, the spec holds the run (task) and
+// function objects as the mlrun SDK submits them
+type RunResource struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Spec       RunResourceSpec   `json:"spec"`
+	Status     RunResourceStatus `json:"status,omitempty"`
+}
+
+type RunResourceSpec struct {
+	Task     json.RawMessage `json:"task,omitempty"`
+	Function json.RawMessage `json:"function"`
+	// Setting abort stops the job and marks the run aborted
+	Abort bool `json:"abort,omitempty"`
+}
+
+type RunResourceStatus struct {
+	Kind    string `json:"kind,omitempty"`
+	Project string `json:"project,omitempty"`
+	UID     string `json:"uid,omitempty"`
+	Job     string `json:"job,omitempty"`
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type RunResourceList struct {
+	Items []RunResource `json:"items"`
+}
+
+// RunStore is the metadata DB the reconciler mirrors runs into
+type RunStore interface {
+	// StoreRun stores a new run object and returns it in JSON form
+	StoreRun(project, uid string, run []byte) ([]byte, error)
+	// SetRunState updates the state of a run unless it already has a final state
+	SetRunState(project, uid, state, message string) error
+}
+
+// Reconciler creates the jobs of Run resources and mirrors their state into
+// the resource status and the run store
+type Reconciler struct {
+	launcher *Launcher
+	store    RunStore
+}
+
+func NewReconciler(launcher *Launcher, store RunStore) *Reconciler {
+	return &Reconciler{launcher: launcher, store: store}
+}
+
+func isFinal(state string) bool {
+	return state == StateCompleted || state == StateError || state == StateAborted
+}
+
+func (r *Reconciler) runsPath() string {
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", CRDGroup, CRDVersion, r.launcher.client.Namespace(), CRDResource)
+}
+
+// Run reconciles all Run resources every interval
+func (r *Reconciler) Run(interval time.Duration) {
+	for {
+		if err := r.reconcileAll(); err != nil {
+			fmt.Printf("Failed to reconcile runs: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (r *Reconciler) reconcileAll() error {
+	runs := RunResourceList{}
+	if err := r.launcher.client.Do("GET", r.runsPath(), nil, nil, &runs); err != nil {
+		return err
+	}
+	for i := range runs.Items {
+		run := &runs.Items[i]
+		if err := r.reconcile(run); err != nil {
+			fmt.Printf("Failed to reconcile run %s: %s\n", run.Metadata.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcile(run *RunResource) error {
+	switch {
+	case run.Metadata.DeletionTimestamp != "":
+		return r.finalize(run)
+	case run.Status.Job == "" && !isFinal(run.Status.State):
+		if run.Spec.Abort {
+			return r.setStatus(run, StateAborted, "aborted before launch")
+		}
+		return r.launch(run)
+	case run.Spec.Abort && !isFinal(run.Status.State):
+		return r.abort(run, "aborted")
+	case !isFinal(run.Status.State):
+		status, err := r.launcher.Status(run.Status.Kind, run.Status.Job)
+		if IsNotFound(err) {
+			return r.setStatus(run, StateError, fmt.Sprintf("job %s not found", run.Status.Job))
+		}
+		if err != nil {
+			return err
+		}
+		if status.State != run.Status.State || status.Message != run.Status.Message {
+			return r.setStatus(run, status.State, status.Message)
+		}
+	}
+	return nil
+}
+
+// launch stores the run record and creates the job, the finalizer keeps the
+// resource until the run record is marked aborted on deletion
+func (r *Reconciler) launch(run *RunResource) error {
+	function := common.Function{}
+	if err := json.Unmarshal(run.Spec.Function, &function); err != nil {
+		return r.setStatus(run, StateError, fmt.Sprintf("bad function: %s", err))
+	}
+	task := struct {
+		Metadata struct {
+			UID     string `json:"uid"`
+			Name    string `json:"name"`
+			Project string `json:"project"`
+		} `json:"metadata"`
+	}{}
+	if len(run.Spec.Task) > 0 {
+		if err := json.Unmarshal(run.Spec.Task, &task); err != nil {
+			return r.setStatus(run, StateError, fmt.Sprintf("bad task: %s", err))
+		}
+	}
+	run.Status.Project = setFrom(task.Metadata.Project, setFrom(function.Metadata.Project, "default"))
+	run.Status.UID = setFrom(task.Metadata.UID, strings.Replace(run.Metadata.UID, "-", "", -1))
+	name := setFrom(task.Metadata.Name, setFrom(function.Metadata.Name, run.Metadata.Name))
+
+	if !hasFinalizer(run) {
+		run.Metadata.Finalizers = append(run.Metadata.Finalizers, runFinalizer)
+		if err := r.update(run); err != nil {
+			return err
+		}
+	}
+
+	taskObject := run.Spec.Task
+	if len(taskObject) == 0 {
+		taskObject = json.RawMessage("{}")
+	}
+	stored, err := r.store.StoreRun(run.Status.Project, run.Status.UID, withRunIdentity(taskObject, run.Status.Project, run.Status.UID, name))
+	if err != nil {
+		return err
+	}
+	job, err := r.launcher.Launch(&Run{Project: run.Status.Project, UID: run.Status.UID, Name: name, Function: &function, Object: stored})
+	if IsConflict(err) {
+		job, err = JobName(name, run.Status.UID), nil
+	}
+	if err != nil {
+		if storeErr := r.store.SetRunState(run.Status.Project, run.Status.UID, StateError, err.Error()); storeErr != nil {
+			fmt.Printf("Failed to update run %s/%s: %s\n", run.Status.Project, run.Status.UID, storeErr)
+		}
+		return r.setStatus(run, StateError, err.Error())
+	}
+	run.Status.Job = job
+	run.Status.Kind = function.Kind
+	return r.setStatus(run, StatePending, "")
+}
+
+// withRunIdentity sets the run metadata and initial state in the task object
+func withRunIdentity(task json.RawMessage, project, uid, name string) []byte {
+	object := map[string]interface{}{}
+	json.Unmarshal(task, &object)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["project"] = project
+	metadata["uid"] = uid
+	metadata["name"] = name
+	object["metadata"] = metadata
+	status, _ := object["status"].(map[string]interface{})
+	if status == nil {
+		status = map[string]interface{}{}
+	}
+	status["state"] = StatePending
+	object["status"] = status
+	data, _ := json.Marshal(object)
+	return data
+}
+
+func (r *Reconciler) abort(run *RunResource, message string) error {
+	if err := r.launcher.Delete(run.Status.UID); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return r.setStatus(run, StateAborted, message)
+}
+
+// finalize aborts runs whose resource was deleted before they finished, and
+// releases the resource
+func (r *Reconciler) finalize(run *RunResource) error {
+	if !hasFinalizer(run) {
+		return nil
+	}
+	if run.Status.Job != "" && !isFinal(run.Status.State) {
+		if err := r.abort(run, "run resource deleted"); err != nil {
+			return err
+		}
+	}
+	var finalizers []string
+	for _, finalizer := range run.Metadata.Finalizers {
+		if finalizer != runFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	run.Metadata.Finalizers = finalizers
+	return r.update(run)
+}
+
+func hasFinalizer(run *RunResource) bool {
+	for _, finalizer := range run.Metadata.Finalizers {
+		if finalizer == runFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// setStatus updates the resource status and mirrors the state to the run store
+func (r *Reconciler) setStatus(run *RunResource, state, message string) error {
+	if state != run.Status.State && run.Status.UID != "" {
+		if err := r.store.SetRunState(run.Status.Project, run.Status.UID, state, message); err != nil {
+			return err
+		}
+	}
+	run.Status.State = state
+	run.Status.Message = message
+	updated := RunResource{}
+	path := fmt.Sprintf("%s/%s/status", r.runsPath(), run.Metadata.Name)
+	if err := r.launcher.client.Do("PUT", path, nil, run, &updated); err != nil {
+		return err
+	}
+	run.Metadata.ResourceVersion = updated.Metadata.ResourceVersion
+	return nil
+}
+
+func (r *Reconciler) update(run *RunResource) error {
+	updated := RunResource{}
+	if err := r.launcher.client.Do("PUT", r.runsPath()+"/"+run.Metadata.Name, nil, run, &updated); err != nil {
+		return err
+	}
+	run.Metadata.ResourceVersion = updated.Metadata.ResourceVersion
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const gpuResource = "nvidia.com/gpu"
+
+// Resources are amounts of CPU (cores), memory (bytes) and GPUs
+type Resources struct {
+	CPU    float64 `json:"cpu"`
+	Memory int64   `json:"memory"`
+	GPU    int     `json:"gpu"`
+}
+
+func (r *Resources) Add(other Resources) {
+	r.CPU += other.CPU
+	r.Memory += other.Memory
+	r.GPU += other.GPU
+}
+
+// ParseCPU parses a Kubernetes CPU quantity (e.g. 500m or 2) into cores
+func ParseCPU(quantity string) (float64, error) {
+	if strings.HasSuffix(quantity, "m") {
+		millis, err := strconv.ParseFloat(strings.TrimSuffix(quantity, "m"), 64)
+		return millis / 1000, err
+	}
+	return strconv.ParseFloat(quantity, 64)
+}
+
+var memorySuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1000}, {"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000}, {"T", 1000 * 1000 * 1000 * 1000},
+}
+
+// ParseMemory parses a Kubernetes memory quantity (e.g. 2Gi or 512M) into bytes
+func ParseMemory(quantity string) (int64, error) {
+	for _, suffix := range memorySuffixes {
+		if strings.HasSuffix(quantity, suffix.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(quantity, suffix.suffix), 64)
+			return int64(value * float64(suffix.multiplier)), err
+		}
+	}
+	value, err := strconv.ParseFloat(quantity, 64)
+	return int64(value), err
+}
+
+// containerResources returns the requested resources of a container, limits
+// stand for missing requests as Kubernetes does
+func containerResources(requirements *common.ResourceRequirements) (Resources, error) {
+	resources := Resources{}
+	if requirements == nil {
+		return resources, nil
+	}
+	var err error
+	if cpu := requirements.Request("cpu"); cpu != "" {
+		if resources.CPU, err = ParseCPU(cpu); err != nil {
+			return resources, fmt.Errorf("Bad cpu quantity %q", cpu)
+		}
+	}
+	if memory := requirements.Request("memory"); memory != "" {
+		if resources.Memory, err = ParseMemory(memory); err != nil {
+			return resources, fmt.Errorf("Bad memory quantity %q", memory)
+		}
+	}
+	if gpu := setFrom(requirements.Limits[gpuResource], requirements.Requests[gpuResource]); gpu != "" {
+		if resources.GPU, err = strconv.Atoi(gpu); err != nil {
+			return resources, fmt.Errorf("Bad gpu quantity %q", gpu)
+		}
+	}
+	return resources, nil
+}
+
+// FunctionResources returns the resources a run of the function requests,
+// the function resources times its pods (MPI workers, Spark driver and executors)
+func FunctionResources(function *common.Function) (Resources, error) {
+	perPod, err := containerResources(function.Spec.Resources)
+	if err != nil {
+		return perPod, err
+	}
+	pods := 1
+	replicas := function.Spec.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+	switch function.Kind {
+	case KindMPIJob:
+		pods = replicas
+	case KindSpark:
+		pods = replicas + 1
+	}
+	total := Resources{}
+	for i := 0; i < pods; i++ {
+		total.Add(perPod)
+	}
+	return total, nil
+}
+
+// UsedResources sums the resources requested by the pending and running pods
+// of a project runs
+func UsedResources(client *Client, project string) (Resources, error) {
+	used := Resources{}
+	pods := struct {
+		Items []struct {
+			Spec struct {
+				Containers []struct {
+					Resources *common.ResourceRequirements `json:"resources"`
+				} `json:"containers"`
+			} `json:"spec"`
+			Status PodStatus `json:"status"`
+		} `json:"items"`
+	}{}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods", client.Namespace())
+	query := url.Values{"labelSelector": {LabelProject + "=" + sanitize(project, 63)}}
+	if err := client.Do("GET", path, query, nil, &pods); err != nil {
+		return used, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Pending" && pod.Status.Phase != "Running" {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			resources, err := containerResources(container.Resources)
+			if err != nil {
+				continue
+			}
+			used.Add(resources)
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	sparkAPIVersion     = "sparkoperator.k8s.io/v1beta2"
+	defaultSparkVersion = "2.4.5"
+)
+
+// SparkApplication is the Spark operator resource
+type SparkApplication struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   ObjectMeta             `json:"metadata"`
+	Spec       SparkApplicationSpec   `json:"spec"`
+	Status     SparkApplicationStatus `json:"status,omitempty"`
+}
+
+type SparkApplicationSpec struct {
+	Type                string            `json:"type"`
+	PythonVersion       string            `json:"pythonVersion,omitempty"`
+	Mode                string            `json:"mode"`
+	Image               string            `json:"image"`
+	ImagePullPolicy     string            `json:"imagePullPolicy,omitempty"`
+	MainApplicationFile string            `json:"mainApplicationFile"`
+	Arguments           []string          `json:"arguments,omitempty"`
+	SparkVersion        string            `json:"sparkVersion"`
+	SparkConf           map[string]string `json:"sparkConf,omitempty"`
+	Deps                json.RawMessage   `json:"deps,omitempty"`
+	RestartPolicy       struct {
+		Type string `json:"type"`
+	} `json:"restartPolicy"`
+	Volumes  []common.Volume `json:"volumes,omitempty"`
+	Driver   SparkPodSpec    `json:"driver"`
+	Executor SparkPodSpec    `json:"executor"`
+}
+
+type SparkPodSpec struct {
+	Cores          *int                 `json:"cores,omitempty"`
+	CoreLimit      string               `json:"coreLimit,omitempty"`
+	Memory         string               `json:"memory,omitempty"`
+	Instances      *int                 `json:"instances,omitempty"`
+	Labels         map[string]string    `json:"labels,omitempty"`
+	Annotations    map[string]string    `json:"annotations,omitempty"`
+	ServiceAccount string               `json:"serviceAccount,omitempty"`
+	Env            []EnvVar             `json:"env,omitempty"`
+	VolumeMounts   []common.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+type SparkApplicationStatus struct {
+	ApplicationState struct {
+		State        string `json:"state"`
+		ErrorMessage string `json:"errorMessage,omitempty"`
+	} `json:"applicationState"`
+	DriverInfo struct {
+		PodName string `json:"podName,omitempty"`
+	} `json:"driverInfo"`
+}
+
+func (l *Launcher) sparkApplicationsPath() string {
+	return fmt.Sprintf("/apis/sparkoperator.k8s.io/v1beta2/namespaces/%s/sparkapplications", l.client.Namespace())
+}
+
+// sparkMemory converts a Kubernetes memory quantity (e.g. 2Gi) to the Spark
+// format (2g)
+func sparkMemory(quantity string) string {
+	quantity = strings.TrimSpace(quantity)
+	for _, suffix := range []string{"Ki", "Mi", "Gi", "Ti"} {
+		if strings.HasSuffix(quantity, suffix) {
+			return strings.TrimSuffix(quantity, suffix) + strings.ToLower(suffix[:1])
+		}
+	}
+	for _, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(quantity, suffix) {
+			return strings.TrimSuffix(quantity, suffix) + strings.ToLower(suffix)
+		}
+	}
+	if bytes, err := strconv.ParseInt(quantity, 10, 64); err == nil {
+		return strconv.FormatInt(int64(math.Ceil(float64(bytes)/(1<<20))), 10) + "m"
+	}
+	return quantity
+}
+
+// sparkCores rounds a Kubernetes CPU quantity (e.g. 500m, 2) up to whole cores
+func sparkCores(quantity string) *int {
+	var cores float64
+	if strings.HasSuffix(quantity, "m") {
+		millis, err := strconv.ParseFloat(strings.TrimSuffix(quantity, "m"), 64)
+		if err != nil {
+			return nil
+		}
+		cores = millis / 1000
+	} else {
+		var err error
+		if cores, err = strconv.ParseFloat(quantity, 64); err != nil {
+			return nil
+		}
+	}
+	value := int(math.Ceil(cores))
+	if value < 1 {
+		value = 1
+	}
+	return &value
+}
+
+// launchSparkApplication runs spec.command as the Spark main application file
+// with spec.replicas executors, the function resources size the driver and
+// the executors
+func (l *Launcher) launchSparkApplication(run *Run) (string, error) {
+	template, err := l.runTemplate(run, KindSpark)
+	if err != nil {
+		return "", err
+	}
+	spec := &run.Function.Spec
+	if spec.Command == "" {
+		return "", fmt.Errorf("Spark function %s has no command (main application file)", run.Function.Metadata.Name)
+	}
+	container := template.Spec.Containers[0]
+	podSpec := SparkPodSpec{
+		Labels:         template.Metadata.Labels,
+		Annotations:    template.Metadata.Annotations,
+		ServiceAccount: spec.ServiceAccount,
+		Env:            container.Env,
+		VolumeMounts:   spec.VolumeMounts,
+	}
+	if resources := spec.Resources; resources != nil {
+		if cpu := resources.Request("cpu"); cpu != "" {
+			podSpec.Cores = sparkCores(cpu)
+		}
+		podSpec.CoreLimit = resources.Limits["cpu"]
+		if memory := resources.Request("memory"); memory != "" {
+			podSpec.Memory = sparkMemory(memory)
+		}
+	}
+
+	driver := podSpec
+	executor := podSpec
+	executor.Labels = copyMap(podSpec.Labels)
+	executor.Labels[LabelRole] = RoleExecutor
+	instances := spec.Replicas
+	if instances <= 0 {
+		instances = 1
+	}
+	executor.Instances = &instances
+
+	application := SparkApplication{
+		APIVersion: sparkAPIVersion,
+		Kind:       "SparkApplication",
+		Metadata: ObjectMeta{
+			Name:        JobName(run.Name, run.UID),
+			Namespace:   l.client.Namespace(),
+			Labels:      template.Metadata.Labels,
+			Annotations: template.Metadata.Annotations,
+		},
+		Spec: SparkApplicationSpec{
+			Type:                "Scala",
+			Mode:                "cluster",
+			Image:               container.Image,
+			ImagePullPolicy:     spec.ImagePullPolicy,
+			MainApplicationFile: spec.Command,
+			Arguments:           spec.Args,
+			SparkVersion:        setFrom(spec.SparkVersion, defaultSparkVersion),
+			SparkConf:           spec.SparkConf,
+			Deps:                spec.Deps,
+			Volumes:             spec.Volumes,
+			Driver:              driver,
+			Executor:            executor,
+		},
+	}
+	if strings.HasSuffix(spec.Command, ".py") {
+		application.Spec.Type = "Python"
+		application.Spec.PythonVersion = "3"
+	}
+	application.Spec.RestartPolicy.Type = "Never"
+	if err = l.client.Do("POST", l.sparkApplicationsPath(), nil, &application, nil); err != nil {
+		return "", err
+	}
+	return application.Metadata.Name, nil
+}
+
+// SparkApplicationState maps the Spark application state to a run state
+func SparkApplicationState(application *SparkApplication) (string, string) {
+	state := application.Status.ApplicationState
+	switch state.State {
+	case "COMPLETED":
+		return StateCompleted, ""
+	case "FAILED", "SUBMISSION_FAILED":
+		return StateError, setFrom(state.ErrorMessage, strings.ToLower(state.State))
+	case "RUNNING", "SUCCEEDING", "FAILING":
+		return StateRunning, ""
+	}
+	return StatePending, ""
+}
+
+func (l *Launcher) sparkApplicationStatus(name string) (*RunStatus, error) {
+	application := SparkApplication{}
+	if err := l.client.Do("GET", l.sparkApplicationsPath()+"/"+name, nil, nil, &application); err != nil {
+		return nil, err
+	}
+	state, message := SparkApplicationState(&application)
+	return &RunStatus{
+		Project: setFrom(application.Metadata.Annotations[LabelProject], application.Metadata.Labels[LabelProject]),
+		UID:     setFrom(application.Metadata.Annotations[LabelUID], application.Metadata.Labels[LabelUID]),
+		Job:     application.Metadata.Name,
+		Pod:     application.Status.DriverInfo.PodName,
+		State:   state,
+		Message: message,
+	}, nil
+}
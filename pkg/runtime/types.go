@@ -0,0 +1,106 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package runtime
+
+import "github.com/mlrun/controller/pkg/common"
+
+// The subset of the Kubernetes batch/v1 and core/v1 objects used by the
+// launcher, the function spec env, volumes and resources are the common types
+
+type ObjectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	GenerateName      string            `json:"generateName,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
+	Finalizers        []string          `json:"finalizers,omitempty"`
+}
+
+type Job struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       JobSpec    `json:"spec"`
+	Status     JobStatus  `json:"status,omitempty"`
+}
+
+type JobSpec struct {
+	BackoffLimit            *int        `json:"backoffLimit,omitempty"`
+	ActiveDeadlineSeconds   *int64      `json:"activeDeadlineSeconds,omitempty"`
+	TTLSecondsAfterFinished *int        `json:"ttlSecondsAfterFinished,omitempty"`
+	Template                PodTemplate `json:"template"`
+}
+
+type JobStatus struct {
+	Active         int            `json:"active,omitempty"`
+	Succeeded      int            `json:"succeeded,omitempty"`
+	Failed         int            `json:"failed,omitempty"`
+	StartTime      string         `json:"startTime,omitempty"`
+	CompletionTime string         `json:"completionTime,omitempty"`
+	Conditions     []JobCondition `json:"conditions,omitempty"`
+}
+
+type JobCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type JobList struct {
+	Items []Job `json:"items"`
+}
+
+type PodTemplate struct {
+	Metadata ObjectMeta `json:"metadata,omitempty"`
+	Spec     PodSpec    `json:"spec"`
+}
+
+type PodSpec struct {
+	RestartPolicy      string          `json:"restartPolicy,omitempty"`
+	ServiceAccountName string          `json:"serviceAccountName,omitempty"`
+	Containers         []Container     `json:"containers"`
+	Volumes            []common.Volume `json:"volumes,omitempty"`
+	ImagePullSecrets   []LocalObject   `json:"imagePullSecrets,omitempty"`
+}
+
+type LocalObject struct {
+	Name string `json:"name"`
+}
+
+type Container struct {
+	Name            string                       `json:"name"`
+	Image           string                       `json:"image"`
+	Command         []string                     `json:"command,omitempty"`
+	Args            []string                     `json:"args,omitempty"`
+	WorkingDir      string                       `json:"workingDir,omitempty"`
+	Env             []EnvVar                     `json:"env,omitempty"`
+	VolumeMounts    []common.VolumeMount         `json:"volumeMounts,omitempty"`
+	Resources       *common.ResourceRequirements `json:"resources,omitempty"`
+	ImagePullPolicy string                       `json:"imagePullPolicy,omitempty"`
+}
+
+// EnvVar is the function spec env var, the same in pods
+type EnvVar = common.EnvVar
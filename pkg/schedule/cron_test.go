@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"a-b * * * *",
+		"@every",
+	} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q parsed without an error", expression)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A Thursday
+	thursday := time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		expression string
+		from       time.Time
+		next       string
+	}{
+		{"* * * * *", thursday, "2019-08-01T00:01:00Z"},
+		{"*/15 * * * *", thursday.Add(16 * time.Minute), "2019-08-01T00:30:00Z"},
+		{"5,10 * * * *", thursday.Add(5 * time.Minute), "2019-08-01T00:10:00Z"},
+		{"10/20 * * * *", thursday.Add(31 * time.Minute), "2019-08-01T00:50:00Z"},
+		{"0 9-17/4 * * *", thursday.Add(10 * time.Hour), "2019-08-01T13:00:00Z"},
+		{"30 13 * * *", thursday.Add(13*time.Hour + 30*time.Minute), "2019-08-02T13:30:00Z"},
+		{"@hourly", thursday, "2019-08-01T01:00:00Z"},
+		{"@daily", thursday, "2019-08-02T00:00:00Z"},
+		// Names and Sunday as 0 and 7
+		{"0 0 * * sun", thursday, "2019-08-04T00:00:00Z"},
+		{"0 0 * * 0", thursday, "2019-08-04T00:00:00Z"},
+		{"0 0 * * 7", thursday, "2019-08-04T00:00:00Z"},
+		{"0 0 * * Mon-Fri", thursday.AddDate(0, 0, 2), "2019-08-05T00:00:00Z"},
+		{"0 0 1 jan *", thursday, "2020-01-01T00:00:00Z"},
+		{"0 0 * FEB-mar *", thursday, "2020-02-01T00:00:00Z"},
+		// Either the day of month or the day of week when both are restricted
+		{"0 0 13 * *", thursday, "2019-08-13T00:00:00Z"},
+		{"0 0 13 * fri", thursday, "2019-08-02T00:00:00Z"},
+		{"0 0 13 * fri", thursday.AddDate(0, 0, 10), "2019-08-13T00:00:00Z"},
+		{"0 0 ? * fri", thursday, "2019-08-02T00:00:00Z"},
+		{"0 0 29 2 *", thursday, "2020-02-29T00:00:00Z"},
+		// Never matching
+		{"0 0 30 2 *", thursday, ""},
+		{"0 0 31 apr,jun,sep,nov *", thursday, ""},
+	} {
+		cron, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%q: %s", test.expression, err)
+			continue
+		}
+		next := cron.Next(test.from)
+		if test.next == "" {
+			if !next.IsZero() {
+				t.Errorf("%q after %s: next is %s, expected never", test.expression, test.from, next)
+			}
+		} else if next.Format(time.RFC3339) != test.next {
+			t.Errorf("%q after %s: next is %s, expected %s", test.expression, test.from, next.Format(time.RFC3339), test.next)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/runtime"
+	"net/url"
+	"sort"
+)
+
+// secret is the part of a Kubernetes Secret the store uses, data values are
+// base64 encoded by the JSON encoding of []byte
+type secret struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   runtime.ObjectMeta `json:"metadata"`
+	Type       string             `json:"type,omitempty"`
+	Data       map[string][]byte  `json:"data"`
+}
+
+// kubernetesStore keeps the secrets of a project in one Secret in the runs
+// namespace
+type kubernetesStore struct {
+	client *runtime.Client
+}
+
+func (s *kubernetesStore) Provider() string {
+	return ProviderKubernetes
+}
+
+// secretName returns the Secret of a project, sanitized names may collide
+// (e.g. my_proj and my-proj) so a hash of the project name is added
+func (s *kubernetesStore) secretName(project string) string {
+	sum := sha256.Sum256([]byte(project))
+	return fmt.Sprintf("mlrun-project-secrets-%s-%s", runtime.SanitizeName(project), hex.EncodeToString(sum[:4]))
+}
+
+// legacySecretName is the Secret of a project before names were hashed
+func (s *kubernetesStore) legacySecretName(project string) string {
+	return "mlrun-project-secrets-" + runtime.SanitizeName(project)
+}
+
+func (s *kubernetesStore) secretsPath() string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/secrets", s.client.Namespace())
+}
+
+func (s *kubernetesStore) read(project string) (*secret, error) {
+	object := secret{}
+	err := s.client.Do("GET", s.secretsPath()+"/"+s.secretName(project), nil, nil, &object)
+	if runtime.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+// migrate moves the project Secrets of legacy names to their hashed names, a
+// legacy Secret belongs to the project of its annotation
+func (s *kubernetesStore) migrate() error {
+	list := struct {
+		Items []secret `json:"items"`
+	}{}
+	query := url.Values{"labelSelector": []string{runtime.LabelProject}}
+	if err := s.client.Do("GET", s.secretsPath(), query, nil, &list); err != nil {
+		return fmt.Errorf("Failed to list the project secrets: %s", err)
+	}
+	for _, legacy := range list.Items {
+		project := legacy.Metadata.Annotations[runtime.LabelProject]
+		if project == "" || legacy.Metadata.Name != s.legacySecretName(project) {
+			continue
+		}
+		if err := s.move(project, &legacy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *kubernetesStore) move(project string, legacy *secret) error {
+	object := secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: runtime.ObjectMeta{
+			Name:        s.secretName(project),
+			Namespace:   s.client.Namespace(),
+			Labels:      legacy.Metadata.Labels,
+			Annotations: legacy.Metadata.Annotations,
+		},
+		Type: legacy.Type,
+		Data: legacy.Data,
+	}
+	// Another server may have moved it first
+	if err := s.client.Do("POST", s.secretsPath(), nil, &object, nil); err != nil && !runtime.IsConflict(err) {
+		return fmt.Errorf("Failed to migrate the secrets of project %s: %s", project, err)
+	}
+	err := s.client.Do("DELETE", s.secretsPath()+"/"+legacy.Metadata.Name, nil, nil, nil)
+	if err != nil && !runtime.IsNotFound(err) {
+		return fmt.Errorf("Failed to delete the legacy secrets of project %s: %s", project, err)
+	}
+	return nil
+}
+
+func (s *kubernetesStore) Set(project string, secrets map[string]string) error {
+	object, err := s.read(project)
+	if err != nil {
+		return err
+	}
+	if object == nil {
+		object = &secret{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata: runtime.ObjectMeta{
+				Name:        s.secretName(project),
+				Namespace:   s.client.Namespace(),
+				Labels:      map[string]string{runtime.LabelProject: runtime.SanitizeName(project)},
+				Annotations: map[string]string{runtime.LabelProject: project},
+			},
+			Type: "Opaque",
+			Data: map[string][]byte{},
+		}
+	}
+	if object.Data == nil {
+		object.Data = map[string][]byte{}
+	}
+	for key, value := range secrets {
+		object.Data[key] = []byte(value)
+	}
+	// The resource version makes concurrent updates fail instead of losing keys
+	if object.Metadata.ResourceVersion == "" {
+		return s.client.Do("POST", s.secretsPath(), nil, object, nil)
+	}
+	return s.client.Do("PUT", s.secretsPath()+"/"+object.Metadata.Name, nil, object, nil)
+}
+
+func (s *kubernetesStore) Keys(project string) ([]string, error) {
+	object, err := s.read(project)
+	if err != nil || object == nil {
+		return []string{}, err
+	}
+	keys := []string{}
+	for key := range object.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *kubernetesStore) Get(project string, keys []string) (map[string]string, error) {
+	object, err := s.read(project)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, key := range keys {
+		if object == nil || object.Data[key] == nil {
+			return nil, fmt.Errorf("Project %s has no secret %s", project, key)
+		}
+		values[key] = string(object.Data[key])
+	}
+	return values, nil
+}
+
+func (s *kubernetesStore) Delete(project string, keys []string) error {
+	object, err := s.read(project)
+	if err != nil || object == nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(object.Data, key)
+	}
+	if len(keys) == 0 || len(object.Data) == 0 {
+		err = s.client.Do("DELETE", s.secretsPath()+"/"+object.Metadata.Name, nil, nil, nil)
+		if runtime.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return s.client.Do("PUT", s.secretsPath()+"/"+object.Metadata.Name, nil, object, nil)
+}
+
+func (s *kubernetesStore) EnvVars(project string, keys []string) []runtime.EnvVar {
+	var env []runtime.EnvVar
+	for _, key := range keys {
+		ref := &common.EnvVarSource{SecretKeyRef: &common.KeySelector{Name: s.secretName(project), Key: key}}
+		env = append(env, runtime.EnvVar{Name: key, ValueFrom: ref})
+	}
+	return env
+}
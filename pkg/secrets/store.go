@@ -0,0 +1,95 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package secrets
+
+import (
+	"fmt"
+	"github.com/mlrun/controller/pkg/common"
+	"github.com/mlrun/controller/pkg/runtime"
+	"regexp"
+)
+
+const (
+	ProviderKubernetes = "kubernetes"
+	ProviderVault      = "vault"
+)
+
+// Secrets are injected as environment variables, so keys must be valid names
+var validKey = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// Store keeps project secrets outside of the metadata DB, values are written
+// and read by the server but only keys are ever returned to clients
+type Store interface {
+	Provider() string
+	// Set adds or replaces secrets, other secrets of the project are kept
+	Set(project string, secrets map[string]string) error
+	Keys(project string) ([]string, error)
+	// Get returns the values of keys, for workloads the server runs itself
+	// (e.g. image builds)
+	Get(project string, keys []string) (map[string]string, error)
+	// Delete removes keys, or all the project secrets when no keys are given
+	Delete(project string, keys []string) error
+	// EnvVars returns environment variables referencing the secrets, so
+	// run pods get them without the values passing through their spec
+	EnvVars(project string, keys []string) []runtime.EnvVar
+}
+
+// Config selects and configures the secret store
+type Config struct {
+	Provider string
+	// Vault address, KV v2 mount and token file
+	VaultURL       string
+	VaultMount     string
+	VaultTokenFile string
+	// Vault role run pods log in with, {project} is replaced by the project
+	VaultRole string
+}
+
+// NewStore returns the store of the configured provider, Kubernetes secrets
+// need a Kubernetes client
+func NewStore(cfg *Config, client *runtime.Client) (Store, error) {
+	switch cfg.Provider {
+	case ProviderKubernetes:
+		if client == nil {
+			return nil, fmt.Errorf("Kubernetes secrets require Kubernetes access")
+		}
+		store := &kubernetesStore{client: client}
+		if err := store.migrate(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	case ProviderVault:
+		return newVaultStore(cfg)
+	}
+	return nil, fmt.Errorf("Unknown secrets provider '%s', use kubernetes or vault", cfg.Provider)
+}
+
+// ValidateKeys checks that secret keys can be used as environment variables
+func ValidateKeys(keys []string) error {
+	for _, key := range keys {
+		if !validKey.MatchString(key) {
+			return fmt.Errorf("Invalid secret key '%s', keys must be valid environment variable names", key)
+		}
+		if common.ProcessEnvVar(key) {
+			return fmt.Errorf("Invalid secret key '%s', the variable controls the processes it is set for", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mlrun/controller/pkg/runtime"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	vaultTimeout      = 10 * time.Second
+	defaultVaultMount = "secret"
+	defaultVaultRole  = "mlrun-project-{project}"
+)
+
+var errVaultNotFound = errors.New("Vault secret not found")
+
+// vaultStore keeps the secrets of a project in one KV version 2 secret at
+// <mount>/mlrun/projects/<project>. Run pods get the Vault address and a role
+// to log in with their service account, never a token
+type vaultStore struct {
+	url       string
+	mount     string
+	tokenFile string
+	role      string
+	client    *http.Client
+}
+
+type vaultSecret struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+func newVaultStore(cfg *Config) (*vaultStore, error) {
+	if cfg.VaultURL == "" || cfg.VaultTokenFile == "" {
+		return nil, fmt.Errorf("Vault secrets require a Vault URL and token file")
+	}
+	store := vaultStore{
+		url:       strings.TrimSuffix(cfg.VaultURL, "/"),
+		mount:     strings.Trim(cfg.VaultMount, "/"),
+		tokenFile: cfg.VaultTokenFile,
+		role:      cfg.VaultRole,
+		client:    &http.Client{Timeout: vaultTimeout},
+	}
+	if store.mount == "" {
+		store.mount = defaultVaultMount
+	}
+	if store.role == "" {
+		store.role = defaultVaultRole
+	}
+	return &store, nil
+}
+
+func (s *vaultStore) Provider() string {
+	return ProviderVault
+}
+
+// do sends a request for the project secret to the KV data or metadata API
+func (s *vaultStore) do(method, api, project string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	requestURL := fmt.Sprintf("%s/v1/%s/%s/mlrun/projects/%s", s.url, s.mount, api, url.PathEscape(project))
+	request, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	// The token may be renewed by an agent, read it on every request
+	token, err := ioutil.ReadFile(s.tokenFile)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return errVaultNotFound
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Vault error %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// read returns the project secrets and their version, 0 when there are none
+func (s *vaultStore) read(project string) (map[string]string, int, error) {
+	secret := vaultSecret{}
+	err := s.do("GET", "data", project, nil, &secret)
+	if err == errVaultNotFound {
+		return map[string]string{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret.Data.Data == nil {
+		secret.Data.Data = map[string]string{}
+	}
+	return secret.Data.Data, secret.Data.Metadata.Version, nil
+}
+
+// write replaces the project secrets, check-and-set on the version read makes
+// concurrent updates fail instead of losing keys
+func (s *vaultStore) write(project string, data map[string]string, version int) error {
+	body := map[string]interface{}{"options": map[string]int{"cas": version}, "data": data}
+	return s.do("POST", "data", project, body, nil)
+}
+
+func (s *vaultStore) Set(project string, secrets map[string]string) error {
+	data, version, err := s.read(project)
+	if err != nil {
+		return err
+	}
+	for key, value := range secrets {
+		data[key] = value
+	}
+	return s.write(project, data, version)
+}
+
+func (s *vaultStore) Keys(project string) ([]string, error) {
+	data, _, err := s.read(project)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *vaultStore) Get(project string, keys []string) (map[string]string, error) {
+	data, _, err := s.read(project)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("Project %s has no secret %s", project, key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (s *vaultStore) Delete(project string, keys []string) error {
+	if len(keys) == 0 {
+		err := s.do("DELETE", "metadata", project, nil, nil)
+		if err == errVaultNotFound {
+			return nil
+		}
+		return err
+	}
+	data, version, err := s.read(project)
+	if err != nil || version == 0 {
+		return err
+	}
+	for _, key := range keys {
+		delete(data, key)
+	}
+	return s.write(project, data, version)
+}
+
+// EnvVars tells the pod where its secrets are, the mlrun SDK logs in to Vault
+// with the pod service account and the project role to read them
+func (s *vaultStore) EnvVars(project string, keys []string) []runtime.EnvVar {
+	return []runtime.EnvVar{
+		{Name: "MLRUN_SECRET_STORES__VAULT__URL", Value: s.url},
+		{Name: "MLRUN_SECRET_STORES__VAULT__ROLE", Value: strings.Replace(s.role, "{project}", project, -1)},
+		{Name: "MLRUN_SECRET_STORES__VAULT__PATH", Value: fmt.Sprintf("%s/data/mlrun/projects/%s", s.mount, project)},
+		{Name: "MLRUN_SECRET_STORES__VAULT__KEYS", Value: strings.Join(keys, ",")},
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package server
+
+import (
+	"fmt"
+	"github.com/buaazp/fasthttprouter"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/builder"
+	"time"
+)
+
+// The builder handlers only queue builds and read their state, the server
+// defaults of the concurrency limit are enough
+const (
+	builderMaxConcurrent = 64
+	builderQueueTimeout  = 2 * time.Second
+)
+
+// StartBuilder serves the builder endpoints behind the same auth, rate and
+// concurrency limits as the DB server
+func StartBuilder(opts *builder.Opts) error {
+	service, err := builder.NewBuildService(opts.ServiceConfig(), opts.ServiceOptions())
+	if err != nil {
+		return err
+	}
+	routes := []api.Route{
+		{Method: "GET", Path: "/healthz", Name: "health", Summary: "Health check", Handler: healthHandler},
+	}
+	concurrency := newConcurrencyLimiter(builderMaxConcurrent, builderQueueTimeout)
+	for _, route := range service.Routes() {
+		route.Handler = concurrency.middleware(route.Handler)
+		routes = append(routes, route)
+	}
+
+	router := fasthttprouter.New()
+	api.Register(router, routes, true)
+	router.GET(openAPIPath, api.SpecHandler(api.NewOpenAPI("MLRun builder", apiVersion, routes)))
+
+	auth := tokenAuth{}
+	limiter := rateLimiter{}
+	watcher, err := newConfigWatcher(opts.ConfigFile, func(config *ReloadableConfig) {
+		limiter.configure(config.RateLimit, config.RateBurst)
+		auth.setTokens(config.AuthTokens, config.AdminTokens)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to load config %s: %s", opts.ConfigFile, err)
+	}
+	go watcher.watch()
+
+	var listeners []listener
+	for _, addr := range opts.Addr {
+		listeners = append(listeners, listener{addr: addr, handler: chain(router.Handler, auth.middleware, limiter.middleware)})
+	}
+	return serve(listeners)
+}
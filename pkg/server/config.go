@@ -0,0 +1,183 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package server
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/mlrun/controller/pkg/db"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+const configPollInterval = 10 * time.Second
+
+// ReloadableConfig holds the settings that can be changed on a live server by
+// editing the config file or sending SIGHUP
+type ReloadableConfig struct {
+	LogLevel    string   `json:"log_level,omitempty"`
+	RateLimit   float64  `json:"rate_limit,omitempty"`
+	RateBurst   int      `json:"rate_burst,omitempty"`
+	AuthTokens  []string `json:"auth_tokens,omitempty"`
+	AdminTokens []string `json:"admin_tokens,omitempty"`
+	Retention   struct {
+		Runs string `json:"runs,omitempty"`
+	} `json:"retention,omitempty"`
+	Heartbeat struct {
+		Timeout string `json:"timeout,omitempty"`
+	} `json:"heartbeat,omitempty"`
+	Redaction struct {
+		Disabled bool     `json:"disabled,omitempty"`
+		Patterns []string `json:"patterns,omitempty"`
+	} `json:"redaction,omitempty"`
+	Notifications db.NotificationConfig `json:"notifications,omitempty"`
+	LogMirror     struct {
+		Brokers  []string          `json:"brokers,omitempty"`
+		Projects map[string]string `json:"projects,omitempty"`
+	} `json:"log_mirror,omitempty"`
+}
+
+func (c *ReloadableConfig) runRetention() (time.Duration, error) {
+	if c.Retention.Runs == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Retention.Runs)
+}
+
+func (c *ReloadableConfig) heartbeatTimeout() (time.Duration, error) {
+	if c.Heartbeat.Timeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Heartbeat.Timeout)
+}
+
+func (c *ReloadableConfig) validate() error {
+	switch c.LogLevel {
+	case "", "info", "debug":
+	default:
+		return fmt.Errorf("Unknown log level '%s', use info or debug", c.LogLevel)
+	}
+	if c.RateLimit < 0 || c.RateBurst < 0 {
+		return fmt.Errorf("Rate limit and burst must not be negative")
+	}
+	if _, err := c.runRetention(); err != nil {
+		return fmt.Errorf("Invalid run retention '%s': %s", c.Retention.Runs, err)
+	}
+	if _, err := c.heartbeatTimeout(); err != nil {
+		return fmt.Errorf("Invalid heartbeat timeout '%s': %s", c.Heartbeat.Timeout, err)
+	}
+	for _, pattern := range c.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid redaction pattern '%s': %s", pattern, err)
+		}
+	}
+	if _, err := template.New("notification").Parse(c.Notifications.Template); err != nil {
+		return fmt.Errorf("Invalid notification template: %s", err)
+	}
+	if len(c.LogMirror.Projects) > 0 && len(c.LogMirror.Brokers) == 0 {
+		return fmt.Errorf("Log mirror projects are set without Kafka brokers")
+	}
+	return nil
+}
+
+func loadConfig(path string) (*ReloadableConfig, error) {
+	config := ReloadableConfig{}
+	if path == "" {
+		return &config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, config.validate()
+}
+
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	apply   func(*ReloadableConfig)
+	lock    sync.Mutex
+}
+
+func newConfigWatcher(path string, apply func(*ReloadableConfig)) (*configWatcher, error) {
+	w := configWatcher{path: path, apply: apply}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *configWatcher) reload() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.path != "" {
+		info, err := os.Stat(w.path)
+		if err != nil {
+			return err
+		}
+		w.modTime = info.ModTime()
+	}
+	config, err := loadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	w.apply(config)
+	return nil
+}
+
+func (w *configWatcher) changed() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	info, err := os.Stat(w.path)
+	return err == nil && !info.ModTime().Equal(w.modTime)
+}
+
+// watch reloads the config on SIGHUP and whenever the config file changes, a
+// config that fails to load is reported and the previous one stays in effect
+func (w *configWatcher) watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+		case <-ticker.C:
+			if w.path == "" || !w.changed() {
+				continue
+			}
+		}
+		if err := w.reload(); err != nil {
+			fmt.Printf("Failed to reload config %s: %s\n", w.path, err)
+			continue
+		}
+		fmt.Printf("Reloaded config %s\n", w.path)
+	}
+}
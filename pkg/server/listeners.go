@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package server
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixScheme = "unix://"
+
+type listener struct {
+	addr    string
+	handler fasthttp.RequestHandler
+}
+
+// listen accepts host:port or unix:///path/to/socket addresses
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixScheme) {
+		path := strings.TrimPrefix(addr, unixScheme)
+		// A socket left behind by a previous run would fail the bind
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return ln, os.Chmod(path, 0660)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serve starts all listeners and returns when the first of them fails
+func serve(listeners []listener) error {
+	errors := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln, err := listen(l.addr)
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s: %s", l.addr, err)
+		}
+		fmt.Printf("Listening on %s\n", l.addr)
+		go func(l listener, ln net.Listener) {
+			server := fasthttp.Server{Handler: l.handler}
+			errors <- fmt.Errorf("Error serving %s: %s", l.addr, server.Serve(ln))
+		}(l, ln)
+	}
+	return <-errors
+}
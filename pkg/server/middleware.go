@@ -0,0 +1,248 @@
+/*
+Copyright 2019 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/db"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type middleware func(fasthttp.RequestHandler) fasthttp.RequestHandler
+
+func chain(handler fasthttp.RequestHandler, middlewares ...middleware) fasthttp.RequestHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+var unauthenticatedPaths = map[string]bool{"/healthz": true}
+
+type authTokens struct {
+	user  []string
+	admin []string
+}
+
+type tokenAuth struct {
+	tokens atomic.Value
+}
+
+func (a *tokenAuth) setTokens(tokens, adminTokens []string) {
+	a.tokens.Store(&authTokens{user: tokens, admin: adminTokens})
+}
+
+func matchToken(provided []byte, tokens []string) bool {
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(provided, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns the caller role, or an empty string when the request is
+// not authorized
+func (a *tokenAuth) authorize(ctx *fasthttp.RequestCtx) string {
+	tokens, _ := a.tokens.Load().(*authTokens)
+	header := ctx.Request.Header.Peek("Authorization")
+	provided := bytes.TrimPrefix(header, []byte("Bearer "))
+	hasBearer := bytes.HasPrefix(header, []byte("Bearer "))
+	if tokens != nil && hasBearer && matchToken(provided, tokens.admin) {
+		return api.AdminRole
+	}
+	if tokens == nil || len(tokens.user)+len(tokens.admin) == 0 || unauthenticatedPaths[string(ctx.Path())] {
+		return api.UserRole
+	}
+	if hasBearer && matchToken(provided, tokens.user) {
+		return api.UserRole
+	}
+	return ""
+}
+
+func (a *tokenAuth) middleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		role := a.authorize(ctx)
+		if role == "" {
+			ctx.Response.SetStatusCode(http.StatusUnauthorized)
+			return
+		}
+		api.SetRole(ctx, role)
+		next(ctx)
+	}
+}
+
+// adminRole marks requests on the admin listeners as made by an admin
+func adminRole(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		api.SetRole(ctx, api.AdminRole)
+		next(ctx)
+	}
+}
+
+// rateLimiter is a token bucket shared by all requests, a zero rate disables it
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) configure(rate float64, burst int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if burst == 0 {
+		burst = int(rate) + 1
+	}
+	l.rate = rate
+	l.burst = float64(burst)
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+func (l *rateLimiter) allow() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.rate == 0 {
+		return true
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+func (l *rateLimiter) middleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !l.allow() {
+			ctx.Response.SetStatusCode(http.StatusTooManyRequests)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// namespacesPath prefixes the paths of requests naming their namespace in
+// the path, e.g. /namespaces/<namespace>/runs
+const namespacesPath = "/namespaces/"
+
+// namespaceFilter takes the namespace of a request from the namespace header,
+// the namespace query parameter or the path, and rejects requests for
+// namespaces the server does not serve, e.g. misrouted by an ingress in front
+// of the servers of several namespaces. Requests naming none are for the
+// default namespace
+func namespaceFilter(mldb *db.MLRunDB) middleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			requested := string(ctx.Request.Header.Peek(db.NamespaceHeader))
+			if requested == "" {
+				requested = string(ctx.QueryArgs().Peek("namespace"))
+			}
+			if path := string(ctx.Path()); strings.HasPrefix(path, namespacesPath) {
+				parts := strings.SplitN(path[len(namespacesPath):], "/", 2)
+				if requested != "" && requested != parts[0] {
+					api.WriteError(ctx, http.StatusBadRequest, fmt.Errorf("Request names namespaces %s and %s", parts[0], requested))
+					return
+				}
+				requested = parts[0]
+				if len(parts) == 2 {
+					ctx.URI().SetPath("/" + parts[1])
+				} else {
+					ctx.URI().SetPath("/")
+				}
+				ctx.Request.Header.Set(db.NamespaceHeader, requested)
+			}
+			if requested == "" {
+				requested = mldb.Namespace()
+			} else if !mldb.ServesNamespace(requested) {
+				api.WriteError(ctx, http.StatusMisdirectedRequest, fmt.Errorf("This server does not serve namespace %s", requested))
+				return
+			}
+			if requested != "" {
+				ctx.Response.Header.Set(db.NamespaceHeader, requested)
+			}
+			next(ctx)
+		}
+	}
+}
+
+// concurrencyLimiter bounds the number of requests in flight, excess requests
+// wait up to queueTimeout for a slot and are then rejected with 503
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+func newConcurrencyLimiter(maxConcurrent int, queueTimeout time.Duration) *concurrencyLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &concurrencyLimiter{slots: make(chan struct{}, maxConcurrent), queueTimeout: queueTimeout}
+}
+
+func (l *concurrencyLimiter) acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) middleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if l == nil {
+		return next
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		if !l.acquire() {
+			ctx.Response.Header.Set("Retry-After", "1")
+			ctx.Response.SetStatusCode(http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-l.slots }()
+		next(ctx)
+	}
+}
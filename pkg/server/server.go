@@ -22,56 +22,276 @@ package server
 import (
 	"fmt"
 	"github.com/buaazp/fasthttprouter"
+	"github.com/mlrun/controller/pkg/api"
+	"github.com/mlrun/controller/pkg/builder"
 	"github.com/mlrun/controller/pkg/db"
+	"github.com/mlrun/controller/pkg/runtime"
+	"github.com/mlrun/controller/pkg/secrets"
 	"github.com/valyala/fasthttp"
 	"log"
-	"os"
+	"strings"
+	"time"
 )
 
-// TODO: specify port vs server addr:port
 type ServerOpts struct {
-	Addr          string
-	V3ioEndpoint  string
-	ContainerName string
-	AccessKey     string
+	Addr               []string      `short:"a" long:"addr" env:"MLRUN_V3IO_DB_URL" env-delim:"," default:":8080" description:"Address (host:port or unix:///path) to serve the API on, may be repeated"`
+	AdminAddr          []string      `long:"admin-addr" env:"MLRUN_ADMIN_ADDR" env-delim:"," description:"Address to serve the API on without auth and rate limiting (e.g. localhost or a sidecar socket), may be repeated"`
+	V3ioEndpoint       string        `short:"e" long:"v3io-endpoint" env:"V3IO_API" description:"v3io web API endpoint, http:// is assumed when no scheme is given"`
+	ContainerName      string        `short:"c" long:"container" env:"MLRUN_V3IO_DB_CONTAINER" description:"v3io container holding the DB objects"`
+	AccessKey          string        `short:"k" long:"access-key" env:"V3IO_ACCESS_KEY" description:"v3io access key"`
+	SwaggerUI          bool          `long:"swagger-ui" env:"MLRUN_SWAGGER_UI" description:"Serve Swagger UI at /api/docs"`
+	ConnectTimeout     time.Duration `long:"connect-timeout" env:"MLRUN_V3IO_CONNECT_TIMEOUT" default:"1m" description:"How long to keep retrying the initial v3io connection"`
+	V3ioRequestTimeout time.Duration `long:"v3io-request-timeout" env:"MLRUN_V3IO_REQUEST_TIMEOUT" description:"Timeout of a single v3io request (default: library default)"`
+	V3ioDialTimeout    time.Duration `long:"v3io-dial-timeout" env:"MLRUN_V3IO_DIAL_TIMEOUT" description:"Timeout for opening a connection to v3io (default: library default)"`
+	V3ioMaxConns       int           `long:"v3io-max-conns" env:"MLRUN_V3IO_MAX_CONNS" description:"Maximal number of connections per v3io host (default: library default)"`
+	V3ioWorkers        int           `long:"v3io-workers" env:"MLRUN_V3IO_WORKERS" description:"Number of v3io request workers (default: library default)"`
+	V3ioRetries        int           `long:"v3io-retries" env:"MLRUN_V3IO_RETRIES" default:"3" description:"Retries of idempotent v3io calls on backend failures"`
+	BreakerThreshold   int           `long:"breaker-threshold" env:"MLRUN_BREAKER_THRESHOLD" default:"5" description:"Consecutive v3io failures that open the circuit breaker"`
+	BreakerCooldown    time.Duration `long:"breaker-cooldown" env:"MLRUN_BREAKER_COOLDOWN" default:"10s" description:"How long the circuit breaker fails calls with 503 before retrying v3io"`
+	MaxConcurrent      int           `long:"max-concurrent" env:"MLRUN_MAX_CONCURRENT" default:"64" description:"Maximal number of concurrent DB requests, 0 for unlimited"`
+	QueueTimeout       time.Duration `long:"queue-timeout" env:"MLRUN_QUEUE_TIMEOUT" default:"2s" description:"How long a DB request waits for a free slot before failing with 503"`
+	EncryptionKey      string        `long:"encryption-key" env:"MLRUN_ENCRYPTION_KEY" description:"Hex or base64 AES key to encrypt run/artifact bodies and logs at rest"`
+	EncryptionKeyFile  string        `long:"encryption-key-file" env:"MLRUN_ENCRYPTION_KEY_FILE" description:"File holding the encryption key, e.g. mounted from a KMS or secrets manager"`
+	S3AccessKey        string        `long:"s3-access-key" env:"AWS_ACCESS_KEY_ID" description:"S3 access key used to presign artifact download URLs"`
+	S3SecretKey        string        `long:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY" description:"S3 secret key used to presign artifact download URLs"`
+	S3SessionToken     string        `long:"s3-session-token" env:"AWS_SESSION_TOKEN" description:"S3 session token for temporary credentials"`
+	S3Region           string        `long:"s3-region" env:"AWS_DEFAULT_REGION" default:"us-east-1" description:"S3 region"`
+	S3Endpoint         string        `long:"s3-endpoint" env:"S3_ENDPOINT_URL" description:"Endpoint of an S3 compatible store (default: AWS)"`
+	DownloadTargets    []string      `long:"download-target" env:"MLRUN_DOWNLOAD_TARGETS" env-delim:"," description:"Store prefix artifacts are downloaded from, e.g. s3://bucket/{project}/ or v3io://users/{project}/, may be repeated (default: downloads disabled)"`
+	BuildExecutor      string        `long:"build-executor" env:"MLRUN_BUILD_EXECUTOR" default:"/kaniko/executor" description:"Image build command for /build/function, called with kaniko style flags"`
+	ImageTemplate      string        `long:"image-template" env:"MLRUN_IMAGE_TEMPLATE" description:"Image name of functions with no build.image, from {registry}, {project}, {name}, {tag} and {hash} (default: {registry}/mlrun/func-{project}-{name}:{tag})"`
+	DockerRegistry     string        `long:"docker-registry" env:"DEFAULT_DOCKER_REGISTRY" description:"Registry for function images that do not name one"`
+	BuildEngine        string        `long:"build-engine" env:"MLRUN_BUILD_ENGINE" choice:"kaniko" choice:"docker" choice:"buildkit" default:"kaniko" description:"Build images with the kaniko executor, the Docker Engine API or a buildkitd"`
+	DockerHost         string        `long:"docker-host" env:"DOCKER_HOST" description:"Docker daemon for --build-engine docker (default: unix:///var/run/docker.sock)"`
+	BuildkitAddr       string        `long:"buildkit-addr" env:"BUILDKIT_HOST" description:"buildkitd address for --build-engine buildkit, e.g. tcp://buildkitd:1234"`
+	BuildkitPlatforms  []string      `long:"buildkit-platform" description:"Target platform of buildkit builds, repeat for multi-platform images"`
+	BuildPlatforms     []string      `long:"build-platforms" env:"MLRUN_BUILD_PLATFORMS" env-delim:"," description:"Default platforms of function images, e.g. linux/amd64,linux/arm64 builds multi-arch images with --build-engine buildkit"`
+	BuildkitOpts       []string      `long:"buildkit-opt" description:"Dockerfile frontend attribute key=value of buildkit builds, e.g. build-arg:PIP_INDEX_URL=..."`
+	ImageScanner       string        `long:"image-scanner" env:"MLRUN_IMAGE_SCANNER" default:"trivy" description:"Trivy command scanning the images of functions with a build.scan spec"`
+	ImageScannerServer string        `long:"image-scanner-server" env:"MLRUN_IMAGE_SCANNER_SERVER" description:"Trivy server the image scanner runs as a client of (default: standalone scans)"`
+	ImageSigner        string        `long:"image-signer" env:"MLRUN_IMAGE_SIGNER" default:"cosign" description:"Cosign command signing the built images with --image-signing-key"`
+	ImageSigningKey    string        `long:"image-signing-key" env:"MLRUN_IMAGE_SIGNING_KEY" description:"Cosign key (file, KMS URI or env://VAR) signing the built images, the password is read from COSIGN_PASSWORD"`
+	SourceRetries      int           `long:"source-retries" env:"MLRUN_SOURCE_RETRIES" default:"3" description:"Retries of failed build source downloads, with exponential backoff"`
+	SourceRetryDelay   time.Duration `long:"source-retry-delay" env:"MLRUN_SOURCE_RETRY_DELAY" default:"1s" description:"Delay before the first source download retry, doubled on each retry"`
+	SourceCacheDir     string        `long:"source-cache-dir" env:"MLRUN_SOURCE_CACHE_DIR" description:"Directory caching build source git clones and s3/v3io copies, updated incrementally"`
+	InsecureGit        bool          `long:"insecure-git" env:"MLRUN_INSECURE_GIT" description:"Skip the certificate verification of https git and http(s) build source servers"`
+	GitCAFile          string        `long:"git-ca-file" env:"MLRUN_GIT_CA_FILE" description:"PEM CA bundle trusted for https git and http(s) build source servers, on top of the system roots"`
+	BuildWorkDir       string        `long:"build-workdir" env:"MLRUN_BUILD_WORKDIR" description:"Directory for temporary build contexts (default: system temp dir)"`
+	LaunchRuns         bool          `long:"launch-runs" env:"MLRUN_LAUNCH_RUNS" description:"Run submitted functions as Kubernetes jobs"`
+	WatchPods          bool          `long:"watch-pods" env:"MLRUN_WATCH_PODS" description:"Update run states from the pods labeled with mlrun/uid, implied by --launch-runs"`
+	ReconcileRuns      bool          `long:"reconcile-runs" env:"MLRUN_RECONCILE_RUNS" description:"Launch and track Run custom resources (needs --launch-runs and the CRD from hack/crd.yaml)"`
+	K8sAPIServer       string        `long:"k8s-api-server" env:"MLRUN_K8S_API_SERVER" description:"Kubernetes API server (default: in cluster)"`
+	K8sTokenFile       string        `long:"k8s-token-file" env:"MLRUN_K8S_TOKEN_FILE" description:"Kubernetes bearer token file (default: service account token)"`
+	K8sCAFile          string        `long:"k8s-ca-file" env:"MLRUN_K8S_CA_FILE" description:"Kubernetes API server CA file (default: service account CA)"`
+	K8sInsecure        bool          `long:"k8s-insecure" env:"MLRUN_K8S_INSECURE" description:"Skip verification of the Kubernetes API server certificate"`
+	K8sSharedSecrets   []string      `long:"k8s-shared-secret" env:"MLRUN_K8S_SHARED_SECRETS" env-delim:"," description:"Kubernetes secret the functions of every project may reference, e.g. registry credentials, may be repeated"`
+	K8sServiceAccounts []string      `long:"k8s-service-account" env:"MLRUN_K8S_SERVICE_ACCOUNTS" env-delim:"," description:"Service account functions may run as, may be repeated (default: only the namespace default)"`
+	K8sHostPaths       []string      `long:"k8s-host-path" env:"MLRUN_K8S_HOST_PATHS" env-delim:"," description:"Node directory functions may mount as a hostPath volume, may be repeated (default: none)"`
+	Namespace          string        `long:"namespace" env:"MLRUN_NAMESPACE" description:"Namespace to run functions in (default: the service account namespace)"`
+	DefaultImage       string        `long:"default-image" env:"MLRUN_DEFAULT_IMAGE" default:"mlrun/mlrun" description:"Image for functions that do not name one"`
+	DBPath             string        `long:"dbpath" env:"MLRUN_DBPATH" description:"URL of this server as seen from function pods, passed to them as MLRUN_DBPATH"`
+	SecretsProvider    string        `long:"secrets-provider" env:"MLRUN_SECRETS_PROVIDER" choice:"kubernetes" choice:"vault" description:"Store project secrets in Kubernetes secrets or Vault (default: secrets disabled)"`
+	VaultURL           string        `long:"vault-url" env:"MLRUN_VAULT_URL" description:"Vault address for --secrets-provider vault"`
+	VaultMount         string        `long:"vault-mount" env:"MLRUN_VAULT_MOUNT" default:"secret" description:"Vault KV version 2 mount of project secrets"`
+	VaultTokenFile     string        `long:"vault-token-file" env:"MLRUN_VAULT_TOKEN_FILE" description:"File holding the Vault token of the server"`
+	VaultRole          string        `long:"vault-role" env:"MLRUN_VAULT_ROLE" default:"mlrun-project-{project}" description:"Vault role run pods log in with, {project} is replaced by the project name"`
+	KFPURL             string        `long:"kfp-url" env:"MLRUN_KFP_URL" description:"Kubeflow Pipelines API server for /pipelines/kfp, e.g. http://ml-pipeline.kubeflow:8888"`
+	StorageSoftQuota   string        `long:"storage-soft-quota" env:"MLRUN_STORAGE_SOFT_QUOTA" description:"Default bytes a project stores in runs, artifacts and logs before its summary warns, e.g. 50Gi"`
+	StorageHardQuota   string        `long:"storage-hard-quota" env:"MLRUN_STORAGE_HARD_QUOTA" description:"Default bytes a project stores in runs, artifacts and logs before stores fail with 507, e.g. 100Gi"`
+	IndexLabels        []string      `long:"index-labels" env:"MLRUN_INDEX_LABELS" env-delim:"," description:"Run and artifact label keys indexed for filtering, as patterns such as mlrun/* (default: all)"`
+	IndexDenyLabels    []string      `long:"index-deny-labels" env:"MLRUN_INDEX_DENY_LABELS" env-delim:"," description:"Run and artifact label keys not indexed, as patterns"`
+	IndexDenyFields    []string      `long:"index-deny-fields" env:"MLRUN_INDEX_DENY_FIELDS" env-delim:"," description:"Dot separated run and artifact fields not indexed, e.g. metadata.iteration (the name, uid, state and time fields stay)"`
+	IndexMaxLabels     int           `long:"index-max-labels" env:"MLRUN_INDEX_MAX_LABELS" default:"200" description:"Labels indexed per run or artifact, the first ones by key"`
+	IndexMaxValue      int           `long:"index-max-value" env:"MLRUN_INDEX_MAX_VALUE" default:"4096" description:"Bytes of the indexed string values"`
+	IndexOverflow      string        `long:"index-overflow" env:"MLRUN_INDEX_OVERFLOW" choice:"truncate" choice:"skip" choice:"reject" default:"truncate" description:"Longer values are cut, not indexed or rejected with 400, reject also rejects runs and artifacts with too many labels"`
+	MockV3io           bool          `long:"mock-v3io" env:"MLRUN_MOCK_V3IO" description:"Keep the DB objects in memory instead of v3io (lost on exit), to run the API locally"`
+	StorageNamespace   string        `long:"storage-namespace" env:"MLRUN_STORAGE_NAMESPACE" description:"Keep runs, artifacts and logs under /<kind>/<namespace>/, so the servers of several namespaces can share a container"`
+	StorageNamespaces  []string      `long:"serve-namespace" env:"MLRUN_SERVE_NAMESPACES" env-delim:"," description:"Other storage namespace requests may name, may be repeated (needs a storage namespace)"`
+	EventsSink         string        `long:"events-sink" env:"MLRUN_EVENTS_SINK" description:"Publish run/artifact change events to v3io:///stream/path, kafka://broker:9092/topic or nats://host:4222/subject"`
+	ConfigFile         string        `long:"config" env:"MLRUN_CONFIG" description:"YAML/JSON file with log level, rate limit, auth tokens and retention, reloaded on change or SIGHUP"`
 }
 
-func getEnvironmentVariables(cfg *ServerOpts) {
-	if val, ok := os.LookupEnv("MLRUN_V3IO_DB_URL"); ok {
-		cfg.Addr = val
+const (
+	apiTitle    = "MLRun controller"
+	apiVersion  = "v1"
+	openAPIPath = "/api/openapi.json"
+)
+
+func normalizeEndpoint(endpoint string) string {
+	if endpoint != "" && !strings.Contains(endpoint, "://") {
+		return "http://" + endpoint
 	}
-	if val, ok := os.LookupEnv("MLRUN_V3IO_DB_CONTAINER"); ok {
-		cfg.ContainerName = val
+	return endpoint
+}
+
+func StartServer(cfg *ServerOpts) error {
+	if err := builder.InstallSourceTLS(cfg.InsecureGit, cfg.GitCAFile); err != nil {
+		return err
 	}
-	if val, ok := os.LookupEnv("V3IO_ACCESS_KEY"); ok {
-		cfg.AccessKey = val
+	if cfg.ImageTemplate != "" {
+		if err := builder.ValidateImageTemplate(cfg.ImageTemplate); err != nil {
+			return err
+		}
 	}
-	if val, ok := os.LookupEnv("V3IO_API"); ok {
-		cfg.V3ioEndpoint = fmt.Sprintf("http://%s", val)
+	cfg.V3ioEndpoint = normalizeEndpoint(cfg.V3ioEndpoint)
+	if cfg.MockV3io {
+		fmt.Println("Keeping the DB objects in memory (--mock-v3io), they are lost on exit")
+	} else {
+		fmt.Printf("Location of the v3io WebAPI: %s/%s\n", cfg.V3ioEndpoint, cfg.ContainerName)
 	}
-}
+	var runtimeConfig *runtime.Config
+	if cfg.LaunchRuns || cfg.WatchPods || cfg.SecretsProvider == secrets.ProviderKubernetes {
+		runtimeConfig = &runtime.Config{
+			APIServer:     cfg.K8sAPIServer,
+			TokenFile:     cfg.K8sTokenFile,
+			CAFile:        cfg.K8sCAFile,
+			Insecure:      cfg.K8sInsecure,
+			Namespace:     cfg.Namespace,
+			DefaultImage:  cfg.DefaultImage,
+			DBPath:        cfg.DBPath,
+			SharedSecrets: cfg.K8sSharedSecrets,
 
-func StartServer(cfg *ServerOpts) error {
+			ServiceAccounts: cfg.K8sServiceAccounts,
+			HostPaths:       cfg.K8sHostPaths,
+		}
+	}
+	mldb, err := db.InitDB(&db.DBConfig{
+		Endpoint:       cfg.V3ioEndpoint,
+		Container:      cfg.ContainerName,
+		AccessKey:      cfg.AccessKey,
+		ConnectTimeout: cfg.ConnectTimeout,
+
+		RequestTimeout:  cfg.V3ioRequestTimeout,
+		DialTimeout:     cfg.V3ioDialTimeout,
+		MaxConnsPerHost: cfg.V3ioMaxConns,
+		NumWorkers:      cfg.V3ioWorkers,
 
-	getEnvironmentVariables(cfg)
-	fmt.Printf("Address of the mlrun HTTP server : https://%s\n", cfg.Addr)
-	fmt.Printf("Location of the v3io WebAPI: %s/%s\n", cfg.V3ioEndpoint, cfg.ContainerName)
-	fmt.Printf("v3io WebAPI access key: %s\n", cfg.AccessKey)
-	mldb, err := db.InitDB(&db.DBConfig{Endpoint: cfg.V3ioEndpoint, Container: cfg.ContainerName, AccessKey: cfg.AccessKey})
+		Retries:          cfg.V3ioRetries,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+
+		EncryptionKey:     cfg.EncryptionKey,
+		EncryptionKeyFile: cfg.EncryptionKeyFile,
+		EventsSink:        cfg.EventsSink,
+		StorageNamespace:  cfg.StorageNamespace,
+		Namespaces:        cfg.StorageNamespaces,
+		MockV3io:          cfg.MockV3io,
+		StorageQuota:      db.StorageQuota{Soft: cfg.StorageSoftQuota, Hard: cfg.StorageHardQuota},
+		Indexing: db.IndexConfig{AllowLabels: cfg.IndexLabels, DenyLabels: cfg.IndexDenyLabels, DenyFields: cfg.IndexDenyFields,
+			MaxLabels: cfg.IndexMaxLabels, MaxValueLength: cfg.IndexMaxValue, Overflow: cfg.IndexOverflow},
+		KFPURL: cfg.KFPURL,
+		Builder: builder.Config{
+			Executor: cfg.BuildExecutor,
+			Registry: cfg.DockerRegistry,
+
+			ImageTemplate: cfg.ImageTemplate,
+			WorkDir:       cfg.BuildWorkDir,
+			Engine:        cfg.BuildEngine,
+			DockerHost:    cfg.DockerHost,
+			Buildkit: builder.BuildkitOptions{
+				Addr:          cfg.BuildkitAddr,
+				Platforms:     cfg.BuildkitPlatforms,
+				FrontendAttrs: cfg.BuildkitOpts,
+			},
+			Platforms:        cfg.BuildPlatforms,
+			Scanner:          cfg.ImageScanner,
+			ScannerServer:    cfg.ImageScannerServer,
+			Signer:           cfg.ImageSigner,
+			SigningKey:       cfg.ImageSigningKey,
+			SourceRetries:    cfg.SourceRetries,
+			SourceRetryDelay: cfg.SourceRetryDelay,
+			SourceCacheDir:   cfg.SourceCacheDir,
+		},
+		Runtime:    runtimeConfig,
+		LaunchRuns: cfg.LaunchRuns,
+		Secrets: secrets.Config{
+			Provider:       cfg.SecretsProvider,
+			VaultURL:       cfg.VaultURL,
+			VaultMount:     cfg.VaultMount,
+			VaultTokenFile: cfg.VaultTokenFile,
+			VaultRole:      cfg.VaultRole,
+		},
+		S3: db.S3Config{
+			AccessKey:    cfg.S3AccessKey,
+			SecretKey:    cfg.S3SecretKey,
+			SessionToken: cfg.S3SessionToken,
+			Region:       cfg.S3Region,
+			Endpoint:     cfg.S3Endpoint,
+		},
+		DownloadTargets: cfg.DownloadTargets,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to initialize DB: %s", err)
+	}
+
+	routes := []api.Route{
+		{Method: "GET", Path: "/healthz", Name: "health", Summary: "Health check", Handler: healthHandler},
+	}
+	concurrency := newConcurrencyLimiter(cfg.MaxConcurrent, cfg.QueueTimeout)
+	for _, route := range mldb.Routes() {
+		route.Handler = concurrency.middleware(route.Handler)
+		routes = append(routes, route)
+	}
 
 	router := fasthttprouter.New()
-	router.GET("/healthz", healthHandler)
+	api.Register(router, routes, true)
 
-	mldb.RegisterHandlers(router)
+	spec := api.NewOpenAPI(apiTitle, apiVersion, routes)
+	router.GET(openAPIPath, api.SpecHandler(spec))
+	if cfg.SwaggerUI {
+		router.GET("/api/docs", api.SwaggerUIHandler(apiTitle, openAPIPath))
+	}
 
-	err = fasthttp.ListenAndServe(cfg.Addr, router.Handler)
+	auth := tokenAuth{}
+	limiter := rateLimiter{}
+	watcher, err := newConfigWatcher(cfg.ConfigFile, func(config *ReloadableConfig) {
+		retention, _ := config.runRetention()
+		mldb.SetVerbose(config.LogLevel == "debug")
+		mldb.SetRunRetention(retention)
+		heartbeatTimeout, _ := config.heartbeatTimeout()
+		mldb.SetHeartbeatTimeout(heartbeatTimeout)
+		limiter.configure(config.RateLimit, config.RateBurst)
+		auth.setTokens(config.AuthTokens, config.AdminTokens)
+		// Without tokens every caller is a user, redaction would hide the
+		// values from everyone
+		authEnabled := len(config.AuthTokens)+len(config.AdminTokens) > 0
+		mldb.SetRedaction(authEnabled && !config.Redaction.Disabled, config.Redaction.Patterns)
+		mldb.SetNotificationConfig(config.Notifications)
+		mldb.SetLogMirror(config.LogMirror.Brokers, config.LogMirror.Projects)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to load config %s: %s", cfg.ConfigFile, err)
+	}
+	go watcher.watch()
+	mldb.StartRetention()
+	mldb.StartSummaries()
+	mldb.StartStorageQuotas()
+	mldb.StartTagJournal()
+	mldb.StartWatchdog()
+	mldb.StartBuildWatchdog()
+	mldb.StartPodWatcher()
+	mldb.StartScheduler()
+	if cfg.ReconcileRuns {
+		mldb.StartRunReconciler()
+	}
+
+	publicHandler := chain(router.Handler, namespaceFilter(mldb), auth.middleware, limiter.middleware)
+	var listeners []listener
+	for _, addr := range cfg.Addr {
+		listeners = append(listeners, listener{addr: addr, handler: publicHandler})
+	}
+	for _, addr := range cfg.AdminAddr {
+		listeners = append(listeners, listener{addr: addr, handler: chain(router.Handler, namespaceFilter(mldb), adminRole)})
+	}
 
+	err = serve(listeners)
 	if err != nil {
-		log.Fatalf("Error in ListenAndServe: %s", err)
+		log.Fatalf("Error in serve: %s", err)
 	}
 	return err
 }
 
+// healthHandler answers 200 while the server is up
 func healthHandler(ctx *fasthttp.RequestCtx) {
-	fmt.Println("healthHandler\n")
+	ctx.SetStatusCode(fasthttp.StatusOK)
 }